@@ -1,13 +1,17 @@
 package api
 
 import (
-	"io"
+	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/jcdotter/go/buffer"
 	"github.com/jcdotter/go/data"
 	"github.com/jcdotter/go/encoder"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/reflect/protoregistry"
 )
 
 // ----------------------------------------------------------------------------
@@ -24,6 +28,13 @@ const (
 	SOAP
 )
 
+var protocols = map[string]Protocol{
+	"rest":      REST,
+	"grpc":      GRPC,
+	"websocket": WEBSOCKET,
+	"soap":      SOAP,
+}
+
 // ----------------------------------------------------------------------------
 // METHOD TYPES
 
@@ -39,7 +50,7 @@ const (
 )
 
 var (
-	methodIndex  = []int{0, 4, 9, 13, 20}
+	methodIndex  = []int{0, 4, 9, 13, 20, 26}
 	methodString = "GET POST PUT DELETE PATCH "
 	methodType   = map[string]MethodType{
 		"GET":    GET,
@@ -55,7 +66,7 @@ func Methodtype(s string) MethodType {
 }
 
 func (t MethodType) String() string {
-	return methodString[methodIndex[t]:methodIndex[t+1]]
+	return methodString[methodIndex[t] : methodIndex[t+1]-1]
 }
 
 // ----------------------------------------------------------------------------
@@ -156,14 +167,34 @@ func (d DataType) String() string {
 // api.resource.method.request
 
 type Api struct {
-	Protocol  Protocol
-	Auth      *Api
+	Name     string
+	Url      *url.URL
+	Protocol Protocol
+	Auth     *Api
+	// Authenticator authenticates the requests of the api,
+	// as configured in the auth section of the api spec
+	Authenticator Authenticator
+	// Retry and Limiter are the retry policy and rate
+	// limiter of the calls to the api
+	Retry     *Retry
+	Limiter   *Limiter
+	Client    *http.Client
 	Resources *data.Data
+	// Conn is the connection of a grpc api, dialed on
+	// the first call when not set
+	Conn grpc.ClientConnInterface
+	// Descriptors are the descriptors of the services of a
+	// grpc api, loaded from the DescriptorSet file when set,
+	// otherwise requested with server reflection
+	Descriptors   *protoregistry.Files
+	DescriptorSet string
+	mu            sync.Mutex
 }
 
 func New() *Api {
 	return &Api{
 		Protocol:  REST,
+		Client:    http.DefaultClient,
 		Resources: data.Make[*Resource](4),
 	}
 }
@@ -176,9 +207,22 @@ func FromMap(m map[string]any) (api *Api) {
 	if u, ok := m["url"]; ok {
 		if url, e := url.Parse(u.(string)); e == nil {
 			api = New()
-			if p, ok := m["auth"]; ok {
-				api.Auth = FromMap(p.(map[string]any))
+			api.Url = url
+			if n, ok := m["name"].(string); ok {
+				api.Name = n
+			}
+			if p, ok := m["protocol"].(string); ok {
+				api.Protocol = protocols[strings.ToLower(p)]
 			}
+			if d, ok := m["descriptors"].(string); ok {
+				api.DescriptorSet = d
+			}
+			if p, ok := m["auth"].(map[string]any); ok {
+				api.Auth = FromMap(p)
+				api.Authenticator = AuthMap(p, api.Auth)
+			}
+			api.Retry = RetryMap(m["retry"])
+			api.Limiter = LimiterMap(m["rate"])
 			if r, ok := m["resources"]; ok {
 				for k, v := range r.(map[string]any) {
 					api.ResourceMap(k, v.(map[string]any), url)
@@ -190,13 +234,21 @@ func FromMap(m map[string]any) (api *Api) {
 }
 
 func (a *Api) ResourceMap(k string, m map[string]any, u *url.URL) {
-	if uri, ok := m["uri"]; ok {
-		u.Path = uri.(string)
-		r := NewResource(a, k, u)
+	if uri, ok := m["uri"]; ok || a.Protocol == GRPC {
+		ru := *u
+		if a.Protocol == GRPC {
+			// grpc resources are services named by their full name
+			ru.Path = "/" + k
+		} else {
+			ru.Path = strings.TrimSuffix(u.Path, "/") + uri.(string)
+		}
+		r := NewResource(a, k, &ru)
+		r.Channel = ChannelMap(r, m["channel"])
 		a.Resources.Add(r)
 		if ms, ok := m["methods"]; ok {
 			for k, v := range ms.(map[string]any) {
-				r.MethodMap(k, v.(map[string]any))
+				v, _ := v.(map[string]any)
+				r.MethodMap(k, v)
 			}
 		}
 	}
@@ -218,6 +270,8 @@ type Resource struct {
 	Name    string
 	Url     *url.URL
 	Methods *data.Data
+	// Channel is the message channel of a websocket resource
+	Channel *Channel
 }
 
 func NewResource(api *Api, name string, url *url.URL) *Resource {
@@ -231,6 +285,9 @@ func NewResource(api *Api, name string, url *url.URL) *Resource {
 
 func (r *Resource) MethodMap(k string, m map[string]any) {
 	me := NewMethod(r, k)
+	me.Retry = RetryMap(m["retry"])
+	me.Limiter = LimiterMap(m["rate"])
+	me.Page = PaginationMap(m["page"])
 	if r, ok := m["request"]; ok {
 		me.Request = RequestMap(r.(map[string]any))
 	}
@@ -244,6 +301,14 @@ func (r *Resource) Key() string {
 	return r.Name
 }
 
+func (r *Resource) Val() any {
+	return r.Url
+}
+
+func (r *Resource) String() string {
+	return r.Url.String()
+}
+
 func (r *Resource) Method(key string) *Method {
 	var el any
 	if el = r.Methods.Get(key); el == nil {
@@ -265,10 +330,18 @@ type Method struct {
 	Name     string
 	Request  *Request
 	Response *Response
+	// Retry and Limiter are the retry policy and rate limiter
+	// of the method, where the retry policy overrides the
+	// retry policy of the api
+	Retry   *Retry
+	Limiter *Limiter
+	// Page is the pagination of the method response
+	Page *Pagination
 }
 
 func NewMethod(resource *Resource, name string) *Method {
 	return &Method{
+		Resource: resource,
 		Name:     name,
 		Request:  &Request{},
 		Response: &Response{},
@@ -279,18 +352,26 @@ func (m *Method) Key() string {
 	return m.Name
 }
 
-func (m *Method) Call() {
-	// use http client to build and make request
-	/* c := &http.Client{}
-	r, _ := http.NewRequest(m.Name, m.Resource.Url.String(), nil) */
+func (m *Method) Val() any {
+	return m.Type()
+}
 
+func (m *Method) String() string {
+	return m.Name
+}
+
+// Type returns the HTTP method type of the method
+func (m *Method) Type() MethodType {
+	return Methodtype(m.Name)
 }
 
 type Request struct {
 	Params Params
 	Header Params
 	Body   Params
-	// TODO: add webhooks
+	// Webhook declares the webhook events received
+	// by the method, when the method is a webhook
+	Webhook *Webhook
 }
 
 func NewRequest() *Request {
@@ -316,16 +397,13 @@ func RequestMap(m map[string]any) *Request {
 			case []any:
 				_, r.Body = ParamList(v)
 			}
+		case "webhook":
+			r.Webhook = WebhookMap(v)
 		}
 	}
 	return r
 }
 
-func (r *Request) Reader() io.Reader {
-	//b :=
-	return nil
-}
-
 type Response struct {
 	Header Params
 	Body   Params
@@ -383,6 +461,52 @@ func (p Params) IsNil() bool {
 	return p.Data == nil
 }
 
+// Map returns a map of the params which have
+// a value set, or nil if no values are set
+func (p Params) Map() (m map[string]any) {
+	for i := 0; i < p.Len(); i++ {
+		e := p.Index(i)
+		if e == nil {
+			continue
+		}
+		if v := e.Value(); v != nil {
+			if m == nil {
+				m = map[string]any{}
+			}
+			m[e.key] = v
+		}
+	}
+	return
+}
+
+// Slice returns a list of the param values which
+// have been set, or nil if no values are set
+func (p Params) Slice() (l []any) {
+	for i := 0; i < p.Len(); i++ {
+		if e := p.Index(i); e != nil {
+			if v := e.Value(); v != nil {
+				l = append(l, v)
+			}
+		}
+	}
+	return
+}
+
+// Value returns the values of the params as a list
+// if the params are a list, otherwise as a map
+func (p Params) Value() any {
+	if p.Data != nil && p.IsSlice() {
+		if l := p.Slice(); l != nil {
+			return l
+		}
+		return nil
+	}
+	if m := p.Map(); m != nil {
+		return m
+	}
+	return nil
+}
+
 func (p *Params) Set(k string, v any) *Params {
 	if p.Data == nil {
 		p.Data = data.Make[*Param](4)
@@ -433,7 +557,7 @@ func ParamMap(m map[string]any) (e DataType, d Params) {
 }
 
 func ParamList(l []any) (e DataType, d Params) {
-	d = Params{data.Make[*Param](len(l))}
+	d = Params{data.Make[*Param](len(l)).AsSlice()}
 	for i, v := range l {
 		p := ParamElem(strconv.Itoa(i), v)
 		if i == 0 {
@@ -505,6 +629,29 @@ func (p *Param) Val() any {
 	return p.val
 }
 
+func (p *Param) String() string {
+	return string(p.Json())
+}
+
+// Value returns the value of the param, building
+// the values of objects and lists from their elems.
+// Returns nil if no value has been set.
+func (p *Param) Value() any {
+	switch p.typ {
+	case OBJECT:
+		if v := p.els.Map(); v != nil {
+			return v
+		}
+		return nil
+	case LIST:
+		if v := p.els.Slice(); v != nil {
+			return v
+		}
+		return nil
+	}
+	return p.val
+}
+
 func (p *Param) Set(a any) bool {
 	switch a := a.(type) {
 	case bool:
@@ -576,7 +723,7 @@ func (p *Params) Json(list ...bool) []byte {
 		}
 		v := p.Index(i)
 		b.WriteString(strconv.Quote(v.Key()))
-		b.WriteByte(',')
+		b.WriteByte(':')
 		b.Write(v.Json())
 	}
 	b.WriteByte('}')
@@ -596,7 +743,7 @@ func (p *Param) Json() []byte {
 			case BOOL:
 				return []byte(strconv.FormatBool(p.val.(bool)))
 			case INT:
-				return []byte(strconv.FormatInt(p.val.(int64), 10))
+				return []byte(strconv.Itoa(p.val.(int)))
 			case FLOAT:
 				return []byte(strconv.FormatFloat(p.val.(float64), 'f', -1, 64))
 			case STRING:
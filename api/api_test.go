@@ -1,20 +1,55 @@
 package api
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
 	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"math"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/jcdotter/go/encoder"
+	"github.com/jcdotter/go/errors"
+	"github.com/jcdotter/go/logger"
 	"github.com/jcdotter/go/test"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
 )
 
 func TestApi(t *testing.T) {
 	path := "https://api.sampleapis.com/csscolornames/colors"
 	r, err := http.Get(path + "/1")
 	if err != nil {
-		t.Errorf("Error: %v", err)
+		t.Skipf("sample api unavailable: %v", err)
 	}
 	if r.StatusCode != http.StatusOK {
 		t.Errorf("Expected status code %v, got %v", http.StatusOK, r.StatusCode)
@@ -109,19 +144,1266 @@ func TestYaml(t *testing.T) {
 }
 
 func TestCall(t *testing.T) {
-	/* yml, _ := os.ReadFile("api.yml")
-	api := FromYaml(yml)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "application/json")
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/csscolornames/colors/1":
+			w.Write([]byte(`{"id":1,"name":"AliceBlue","hex":"#f0f8ff"}`))
+		case r.Method == "GET" && r.URL.Path == "/csscolornames/colors":
+			w.Write([]byte(`[{"id":1,"name":"` + r.URL.Query().Get("name") + `"}]`))
+		case r.Method == "POST" && r.URL.Path == "/csscolornames/colors":
+			b, _ := io.ReadAll(r.Body)
+			w.WriteHeader(http.StatusCreated)
+			w.Write(b)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	gt := test.New(t)
+	yml, _ := os.ReadFile("api.yml")
+	api := FromYaml(bytes.Replace(yml, []byte("https://api.sampleapis.com"), []byte(srv.URL), 1))
+
+	// path params
+	gt.Msg = "Call.Path.%s"
+	m := api.Resource("color").Method("GET")
+	_, err := m.Call()
+	gt.Error(err, "missing")
+	m.Request = NewRequest()
+	m.Request.Params.Set("id", 1)
+	r, err := m.Call()
+	gt.NoError(err, "err")
+	gt.Equal(http.StatusOK, r.StatusCode, "StatusCode")
+	gt.Equal("application/json", r.Header.Get("content-type"), "Header")
+	gt.Equal(map[string]any{"id": 1, "name": "AliceBlue", "hex": "#f0f8ff"}, r.Body, "Body")
+
+	// query params
+	gt.Msg = "Call.Query.%s"
+	m = api.Resource("colors").Method("GET")
+	m.Request = NewRequest()
+	m.Request.Params.Set("name", "Red")
+	r, err = m.Call()
+	gt.NoError(err, "err")
+	gt.Equal([]any{map[string]any{"id": 1, "name": "Red"}}, r.Body, "Body")
+
+	// json body
+	gt.Msg = "Call.Body.%s"
+	me := NewMethod(api.Resource("colors"), "POST")
+	me.Request = NewRequest()
+	me.Request.Header.Set("content-type", "application/json")
+	me.Request.Body.Set("name", "Blue").Set("hex", "#0000ff")
+	r, err = me.Call()
+	gt.NoError(err, "err")
+	gt.Equal(http.StatusCreated, r.StatusCode, "StatusCode")
+	gt.Equal(map[string]any{"name": "Blue", "hex": "#0000ff"}, r.Body, "Body")
+}
+
+func TestValidate(t *testing.T) {
+	gt := test.New(t)
+	gt.Msg = "Validate.%s"
+	r := RequestMap(map[string]any{
+		"body": map[string]any{
+			"id":   "int",
+			"name": "string",
+			"items": []any{
+				map[string]any{
+					"sku":   "string",
+					"price": "float",
+					"tags":  []any{"string"},
+				},
+			},
+		},
+	})
+
+	body := encoder.Json.New().Decode([]byte(`{"id":1,"name":"order","items":[{"sku":"a","price":1.5,"tags":["x"]}]}`)).Map()
+	gt.Equal(0, len(r.Validate(body)), "valid")
+
+	body = map[string]any{
+		"id":   "one",
+		"name": 2,
+		"items": []any{
+			map[string]any{"sku": "a", "price": 1.5},
+			"item",
+			map[string]any{"sku": "c", "price": "free", "tags": []any{"x", 3}},
+		},
+	}
+	errs := r.Validate(body)
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Error()
+		gt.Equal(errors.INVALID, e.(*errors.Status).Code(), "Code")
+	}
+	sort.Strings(msgs)
+	gt.Equal([]string{
+		"body.id: expected int",
+		"body.items[1]: expected object",
+		"body.items[2].price: expected float",
+		"body.items[2].tags[1]: expected string",
+		"body.name: expected string",
+	}, msgs, "errors")
+
+	errs = r.Validate([]any{})
+	gt.Equal(1, len(errs), "list")
+	gt.Equal("body: expected object", errs[0].Error(), "list")
+}
+
+func TestOpenAPI(t *testing.T) {
+	gt := test.New(t)
+	gt.Msg = "FromOpenAPI.%s"
+	doc, _ := os.ReadFile("openapi.yml")
+	api, err := FromOpenAPI(doc)
+	gt.NoError(err, "err")
+	gt.Equal(2, api.Resources.Len(), "Resources")
+
+	pets := api.Resource("/pets")
+	gt.Equal("https://petstore.example.com/v1/pets", pets.Url.String(), "Url")
+	gt.Equal(2, pets.Methods.Len(), "Methods")
+	get := pets.Method("GET")
+	gt.Equal(INT, get.Request.Params.Get("limit").Type(), "query param")
+	gt.Equal(STRING, get.Request.Header.Get("x-request-id").Type(), "header param")
+	gt.True(get.Response.Body.IsSlice(), "list body")
+	pet := get.Response.Body.Index(0)
+	gt.Equal(OBJECT, pet.Type(), "$ref")
+	gt.Equal(STRING, pet.Elem("name").Type(), "allOf")
+	gt.Equal(INT, pet.Elem("id").Type(), "integer")
+	gt.Equal(FLOAT, pet.Elem("weight").Type(), "number")
+	gt.Equal(BOOL, pet.Elem("vaccinated").Type(), "boolean")
+	gt.Equal(LIST, pet.Elem("owner").Elem("pets").Type(), "array")
+	gt.Equal(OBJECT, pet.Elem("owner").Elem("pets").Index(0).Type(), "recursive $ref")
+
+	post := pets.Method("POST")
+	gt.Equal("application/json", post.Request.Header.Get("content-type").Val(), "requestBody content-type")
+	gt.Equal(STRING, post.Request.Body.Get("tag").Type(), "requestBody")
+	gt.Equal(INT, post.Response.Body.Get("id").Type(), "201 response")
+
+	pet1 := api.Resource("/pets/{petId}")
+	gt.Equal("https://petstore.example.com/v1/pets/:petId", pet1.Url.String(), "path params")
+	gt.Equal(STRING, pet1.Method("GET").Request.Params.Get("petId").Type(), "path item params")
+	gt.NotNil(pet1.Method("DELETE"), "DELETE")
+
+	gt.Msg = "FromOpenAPI.Json.%s"
+	api, err = FromOpenAPI([]byte(`{
+		"openapi": "3.1.0",
+		"paths": {"/things": {"get": {"responses": {"200": {"content": {"application/json": {
+			"schema": {"$ref": "#/components/schemas/Thing"}}}}}}}},
+		"components": {"schemas": {"Thing": {"properties": {"size": {"type": "integer"}}}}}
+	}`))
+	gt.NoError(err, "err")
+	gt.Equal(INT, api.Resource("/things").Method("GET").Response.Body.Get("size").Type(), "$ref")
+
+	gt.Msg = "FromOpenAPI.Errors.%s"
+	_, err = FromOpenAPI([]byte("swagger: '2.0'"))
+	gt.Error(err, "version")
+	_, err = FromOpenAPI([]byte(`{"openapi":"3.0.0","paths":{"/x":{"get":{"responses":{"200":{"$ref":"#/components/responses/Missing"}}}}}}`))
+	gt.Error(err, "missing $ref")
+	_, err = FromOpenAPI([]byte(`{"openapi":"3.0.0","paths":{"/x":{"$ref":"#/paths/~1x"}}}`))
+	gt.Error(err, "circular $ref")
+	_, err = FromOpenAPI([]byte(`{"openapi":"3.0.0","paths":{"/x":[}`))
+	gt.Error(err, "malformed")
+	_, err = FromOpenAPI([]byte(`["openapi"]`))
+	gt.Error(err, "not an object")
+}
+
+func TestOpenAPIExport(t *testing.T) {
+	gt := test.New(t)
+	yml, _ := os.ReadFile("api.yml")
+	a := FromYaml(yml)
+	post := NewMethod(a.Resource("colors"), "POST")
+	post.Request = RequestMap(map[string]any{
+		"header": map[string]any{"content-type": "application/json", "x-trace": "string"},
+		"body":   map[string]any{"name": "string", "hex": "string", "rgb": []any{"int", "int", "int"}},
+	})
+	a.Resource("colors").Methods.Add(post)
+	a.Resource("colors").Method("GET").Request = RequestMap(map[string]any{
+		"params": map[string]any{"limit": "int"},
+	})
+
+	for _, enc := range []*encoder.Encoder{encoder.Yaml, encoder.Json} {
+		gt.Msg = "OpenAPI." + enc.Type + ".%s"
+		doc := a.OpenAPI(enc)
+		b, err := FromOpenAPI(doc)
+		if !gt.NoError(err, "FromOpenAPI") {
+			continue
+		}
+		gt.Equal("sampleapi", b.Name, "Name")
+		gt.Equal(2, b.Resources.Len(), "Resources")
+
+		color := b.Resource("/csscolornames/colors/{id}")
+		gt.Equal("https://api.sampleapis.com/csscolornames/colors/:id", color.Url.String(), "Url")
+		get := color.Method("GET")
+		gt.Equal("application/json", get.Response.Header.Get("content-type").Val(), "response content-type")
+		gt.Equal(INT, get.Response.Body.Get("id").Type(), "response body")
+		gt.Equal(STRING, get.Request.Params.Get("id").Type(), "path param")
+
+		colors := b.Resource("/csscolornames/colors")
+		gt.Equal(INT, colors.Method("GET").Request.Params.Get("limit").Type(), "query param")
+		gt.Equal(STRING, colors.Method("GET").Response.Body.Index(0).Elem("hex").Type(), "list body")
+		post := colors.Method("POST")
+		gt.Equal(STRING, post.Request.Header.Get("x-trace").Type(), "header param")
+		gt.Equal(STRING, post.Request.Body.Get("name").Type(), "request body")
+		gt.Equal(3, post.Request.Body.Get("rgb").Len(), "prefixItems")
+	}
+}
+
+var petsYaml = []byte(`name: pets
+url: http://localhost/v1
+resources:
+  pets:
+    uri: /pets
+    methods:
+      GET:
+        request:
+          params:
+            limit: int
+        response:
+          header:
+            content-type: application/json
+          body:
+            - id: int
+              name: string
+      POST:
+        request:
+          header:
+            content-type: application/json
+          body:
+            name: string
+            age: int
+        response:
+          body:
+            id: int
+            name: string
+  pet:
+    uri: /pets/:id
+    methods:
+      GET:
+        request:
+          params:
+            id: int
+        response:
+          body:
+            id: int
+            name: string
+  search:
+    uri: /pets/search
+    methods:
+      GET:
+        response:
+          body:
+            - string`)
+
+func TestServer(t *testing.T) {
+	gt := test.New(t)
+	s := NewServer(FromYaml(petsYaml))
+	gt.Msg = "Server.Handle.%s"
+	gt.Error(s.Handle("owners", "GET", nil), "resource not found")
+	gt.Error(s.Handle("pets", "PUT", nil), "method not found")
+	gt.NoError(s.Handle("pets", "GET", func(c *Call) (*Result, error) {
+		return &Result{Body: []any{map[string]any{"id": 1, "name": "rex"}, c.Params["limit"]}}, nil
+	}), "pets GET")
+	gt.NoError(s.Handle("pets", "POST", func(c *Call) (*Result, error) {
+		b := c.Body.(map[string]any)
+		if b["name"] == "taken" {
+			return nil, errors.Exists("pet exists")
+		}
+		return &Result{StatusCode: http.StatusCreated, Body: map[string]any{"id": 2, "name": b["name"]}}, nil
+	}), "pets POST")
+	gt.NoError(s.Handle("pet", "GET", func(c *Call) (*Result, error) {
+		if c.Params["id"] != 1 {
+			return nil, errors.NotFound("pet not found")
+		}
+		return &Result{Body: map[string]any{"id": 1, "name": "rex"}}, nil
+	}), "pet GET")
+	gt.NoError(s.Handle("search", "GET", func(c *Call) (*Result, error) {
+		return &Result{Body: []any{"static"}}, nil
+	}), "search GET")
+
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	a := FromYaml(bytes.Replace(petsYaml, []byte("http://localhost"), []byte(srv.URL), 1))
+
+	gt.Msg = "Server.Call.%s"
+	m := a.Resource("pets").Method("GET")
+	m.Request.Params.Get("limit").Set(5)
+	r, err := m.Call()
+	gt.NoError(err, "err")
+	gt.Equal(http.StatusOK, r.StatusCode, "StatusCode")
+	gt.Equal("application/json", r.Header.Get("Content-Type"), "Content-Type")
+	gt.Equal([]any{map[string]any{"id": 1, "name": "rex"}, 5}, r.Body, "typed params")
+
+	m = a.Resource("pets").Method("POST")
+	m.Request.Body.Get("name").Set("max")
+	r, _ = m.Call()
+	gt.Equal(http.StatusCreated, r.StatusCode, "StatusCode")
+	gt.Equal(map[string]any{"id": 2, "name": "max"}, r.Body, "body")
+	m.Request.Body.Get("name").Set("taken")
+	r, _ = m.Call()
+	gt.Equal(http.StatusConflict, r.StatusCode, "errors.Status")
+
+	m = a.Resource("pet").Method("GET")
+	m.Request.Params.Get("id").Set(1)
+	r, _ = m.Call()
+	gt.Equal(http.StatusOK, r.StatusCode, "path params")
+	m.Request.Params.Get("id").Set(2)
+	r, _ = m.Call()
+	gt.Equal(http.StatusNotFound, r.StatusCode, "errors.NotFound")
+
+	r, _ = a.Resource("search").Method("GET").Call()
+	gt.Equal([]any{"static"}, r.Body, "static route")
+
+	gt.Msg = "Server.Validate.%s"
+	get := func(path string) *http.Response {
+		res, err := http.Get(srv.URL + path)
+		gt.NoError(err, path)
+		return res
+	}
+	gt.Equal(http.StatusBadRequest, get("/v1/pets/abc").StatusCode, "path param")
+	gt.Equal(http.StatusBadRequest, get("/v1/pets?limit=x").StatusCode, "query param")
+	gt.Equal(http.StatusNotFound, get("/v1/owners").StatusCode, "no resource")
+	post := func(ct, body string) *http.Response {
+		res, err := http.Post(srv.URL+"/v1/pets", ct, strings.NewReader(body))
+		gt.NoError(err, body)
+		return res
+	}
+	gt.Equal(http.StatusBadRequest, post("application/json", `{"name":1,"age":"old"}`).StatusCode, "body")
+	gt.Equal(http.StatusBadRequest, post("text/plain", `{"name":"a"}`).StatusCode, "content-type header")
+	gt.Equal(http.StatusCreated, post("application/json", `{"name":"a","age":3}`).StatusCode, "valid body")
+	s.MaxBody = 16
+	gt.Equal(http.StatusRequestEntityTooLarge, post("application/json", `{"name":"abcdefghijklmnop","age":3}`).StatusCode, "body too large")
+	s.MaxBody = 1 << 20
+	res, _ := http.Head(srv.URL + "/v1/pets")
+	gt.Equal(http.StatusMethodNotAllowed, res.StatusCode, "method not allowed")
+	req, _ := http.NewRequest("DELETE", srv.URL+"/v1/pets/1", nil)
+	res, _ = http.DefaultClient.Do(req)
+	gt.Equal(http.StatusMethodNotAllowed, res.StatusCode, "method not declared")
+}
+
+func TestMock(t *testing.T) {
+	gt := test.New(t)
+	a := FromYaml(petsYaml)
+	mock := NewMock(a)
+	defer mock.Close()
+	a.Client = mock.Client()
+
+	gt.Msg = "Mock.Synthesized.%s"
+	r, err := a.Resource("pets").Method("GET").Call()
+	gt.NoError(err, "err")
+	gt.Equal(http.StatusOK, r.StatusCode, "StatusCode")
+	gt.Equal("application/json", r.Header.Get("Content-Type"), "Header")
+	gt.Equal([]any{map[string]any{"id": 1, "name": "name"}}, r.Body, "Body")
+	gt.Equal(0, len(a.Resource("pets").Method("GET").Response.Validate(r.Body)), "Validate")
+	r, _ = a.Resource("search").Method("GET").Call()
+	gt.Equal([]any{"string"}, r.Body, "list Body")
+
+	gt.Msg = "Mock.Override.%s"
+	mock.On("pet", "GET").Status(http.StatusTeapot).Body(map[string]any{"id": 7}).Header("X-Mock", "yes")
+	m := a.Resource("pet").Method("GET")
+	m.Request.Params.Get("id").Set(7)
+	r, _ = m.Call()
+	gt.Equal(http.StatusTeapot, r.StatusCode, "Status")
+	gt.Equal(map[string]any{"id": 7}, r.Body, "Body")
+	gt.Equal("yes", r.Header.Get("X-Mock"), "Header")
+
+	mock.On("pets", "GET").Latency(100 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = a.Resource("pets").Method("GET").CallContext(ctx)
+	gt.Equal(errors.DEADLINE, err.(*errors.Status).Code(), "Latency")
+
+	gt.Msg = "Mock.Calls.%s"
+	p := a.Resource("pets").Method("POST")
+	p.Request.Body.Get("name").Set("rex")
+	p.Request.Body.Get("age").Set(3)
+	p.Call()
+	calls := mock.Calls()
+	gt.Equal(5, len(calls), "len")
+	gt.Equal("pet", calls[2].Resource, "Resource")
+	gt.Equal(map[string]any{"id": 7}, calls[2].Params, "Params")
+	gt.Equal("POST", calls[4].Method, "Method")
+	gt.Equal(map[string]any{"name": "rex", "age": 3}, calls[4].Body, "Body")
+
+	mock.Reset()
+	gt.Equal(0, len(mock.Calls()), "Reset")
+	r, _ = m.Call()
+	gt.Equal(http.StatusOK, r.StatusCode, "Reset")
+}
+
+func TestAuth(t *testing.T) {
+	gt := test.New(t)
+	tokens := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/oauth/token" {
+			r.ParseForm()
+			if r.Form.Get("grant_type") != "client_credentials" || r.Form.Get("client_secret") != "s3cret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			tokens++
+			fmt.Fprintf(w, `{"access_token":"tok%d","token_type":"bearer","expires_in":3600}`, tokens)
+			return
+		}
+		u, p, _ := r.BasicAuth()
+		fmt.Fprintf(w, `{"auth":"%s","key":"%s","user":"%s:%s"}`,
+			r.Header.Get("Authorization"), r.Header.Get("X-Api-Key")+r.URL.Query().Get("api_key"), u, p)
+	}))
+	defer srv.Close()
+	os.Setenv("TEST_CLIENT_SECRET", "s3cret")
+
+	call := func(auth string) map[string]any {
+		a := FromYaml([]byte(fmt.Sprintf("url: %s\nauth:\n%s\nresources:\n  me:\n    uri: /me\n    methods:\n      GET:\n", srv.URL, auth)))
+		r, err := a.Resource("me").Method("GET").Call()
+		gt.NoError(err, auth)
+		return r.Body.(map[string]any)
+	}
+	gt.Msg = "Auth.%s"
+	gt.Equal("abc", call("  type: apikey\n  name: X-Api-Key\n  key: abc")["key"], "apikey header")
+	gt.Equal("abc", call("  type: apikey\n  in: query\n  name: api_key\n  key: abc")["key"], "apikey query")
+	gt.Equal("me:s3cret", call("  type: basic\n  username: me\n  password: $TEST_CLIENT_SECRET")["user"], "basic")
+	gt.Equal("Bearer xyz", call("  type: bearer\n  token: xyz")["auth"], "bearer")
+
+	gt.Msg = "Auth.OAuth2.%s"
+	a := FromYaml([]byte(fmt.Sprintf(`url: %[1]s
+auth:
+  type: oauth2
+  url: %[1]s/oauth/token
+  client_id: id
+  client_secret: ${TEST_CLIENT_SECRET}
+resources:
+  me:
+    uri: /me
+    methods:
+      GET:
+`, srv.URL)))
+	m := a.Resource("me").Method("GET")
+	r, err := m.Call()
+	gt.NoError(err, "err")
+	gt.Equal("Bearer tok1", r.Body.(map[string]any)["auth"], "token")
+	r, _ = m.Call()
+	gt.Equal("Bearer tok1", r.Body.(map[string]any)["auth"], "cached")
+	gt.Equal(1, tokens, "requests")
+	delta := TokenExpiryDelta
+	TokenExpiryDelta = 2 * time.Hour
+	r, _ = m.Call()
+	TokenExpiryDelta = delta
+	gt.Equal("Bearer tok2", r.Body.(map[string]any)["auth"], "refreshed")
+	a.Authenticator.(*ClientCredentials).ClientSecret = "wrong"
+	a.Authenticator.(*ClientCredentials).Invalidate()
+	_, err = m.Call()
+	gt.Equal(errors.UNAUTHENTICATED, err.(*errors.Status).Code(), "rejected")
+}
+
+func TestRetry(t *testing.T) {
+	gt := test.New(t)
+	var codes []int
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		code := http.StatusOK
+		if len(codes) > 0 {
+			code, codes = codes[0], codes[1:]
+		}
+		if code == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", r.URL.Query().Get("after"))
+		}
+		w.WriteHeader(code)
+	}))
+	defer srv.Close()
+	a := FromYaml([]byte(fmt.Sprintf(`url: %s
+retry:
+  attempts: 3
+  backoff: 1ms
+rate:
+  limit: 50
+  burst: 2
+resources:
+  status:
+    uri: /status
+    methods:
+      GET:
+        retry:
+          attempts: 2
+          backoff: 1ms
+        request:
+          params:
+            after: string
+`, srv.URL)))
+	gt.Msg = "Retry.%s"
+	gt.Equal(3, a.Retry.Attempts, "api policy")
+	gt.Equal(time.Millisecond, a.Retry.Backoff, "api backoff")
+	gt.Equal(float64(50), a.Limiter.Rate, "api limiter")
+	m := a.Resource("status").Method("GET")
+	gt.Equal(2, m.Retry.Attempts, "method policy")
+	m.Request.Params.Get("after").Set("0")
+
+	codes = []int{503}
+	r, err := m.Call()
+	gt.NoError(err, "recovered")
+	gt.Equal(http.StatusOK, r.StatusCode, "recovered status")
+	gt.Equal(2, calls, "recovered attempts")
+
+	codes, calls = []int{502, 500}, 0
+	r, err = m.Call()
+	gt.Equal(errors.UNAVAILABLE, err.(*errors.Status).Code(), "unavailable")
+	gt.Equal(http.StatusInternalServerError, r.StatusCode, "unavailable result")
+	gt.Equal(2, calls, "unavailable attempts")
+
+	codes, calls = []int{429, 429}, 0
+	_, err = m.Call()
+	gt.Equal(errors.EXHAUSTED, err.(*errors.Status).Code(), "exhausted")
+
+	codes = []int{429}
+	m.Request.Params.Get("after").Set("5")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	_, err = m.CallContext(ctx)
+	gt.Equal(errors.DEADLINE, err.(*errors.Status).Code(), "retry-after deadline")
+	gt.True(time.Since(start) < time.Second, "retry-after gives up without waiting")
+
+	gt.Msg = "Limiter.%s"
+	m.Retry, a.Retry, codes = nil, nil, nil
+	start = time.Now()
+	for i := 0; i < 5; i++ {
+		m.Call()
+	}
+	gt.True(time.Since(start) >= 40*time.Millisecond, "rate limited")
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	_, err = m.CallContext(ctx)
+	gt.Equal(errors.DEADLINE, err.(*errors.Status).Code(), "deadline")
 
-	r, err := api.Resource("color").Method("GET").Call()
+	gt.Msg = "Retry.Network.%s"
+	a.Limiter, m.Retry = nil, &Retry{Attempts: 2, Backoff: time.Millisecond}
+	srv.Close()
+	_, err = m.Call()
+	gt.Equal(errors.UNAVAILABLE, err.(*errors.Status).Code(), "unavailable")
+	gt.True(strings.Contains(err.Error(), "after 2 attempts"), "attempts")
+
+	gt.Msg = "Retry.NoResource.%s"
+	_, err = NewMethod(nil, "GET").Call()
+	gt.Equal(errors.INVALID, err.(*errors.Status).Code(), "invalid")
+}
+
+func TestPagination(t *testing.T) {
+	gt := test.New(t)
+	items := make([]any, 25)
+	for i := range items {
+		items[i] = i + 1
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		size, _ := strconv.Atoi(q.Get("size"))
+		if size == 0 {
+			size = 10
+		}
+		start, _ := strconv.Atoi(q.Get("offset") + q.Get("cursor"))
+		if p, err := strconv.Atoi(q.Get("page")); err == nil {
+			start = (p - 1) * size
+		}
+		end := start + size
+		if end > len(items) {
+			end = len(items)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		b := encoder.Json.New().Encode(items[start:end]).Bytes()
+		switch r.URL.Path {
+		case "/cursor":
+			next := ""
+			if end < len(items) {
+				next = strconv.Itoa(end)
+			}
+			fmt.Fprintf(w, `{"data":%s,"meta":{"next":"%s"}}`, b, next)
+			return
+		case "/link":
+			if end < len(items) {
+				w.Header().Set("Link", fmt.Sprintf(`</link?offset=%d>; rel="next", </link>; rel="first"`, end))
+			}
+		}
+		w.Write(b)
+	}))
+	defer srv.Close()
+	a := FromYaml([]byte(fmt.Sprintf(`url: %s
+resources:
+  page:
+    uri: /page
+    methods:
+      GET:
+        page:
+          type: page
+          param: page
+          size: size
+          limit: 10
+  offset:
+    uri: /offset
+    methods:
+      GET:
+        page:
+          type: offset
+          param: offset
+  cursor:
+    uri: /cursor
+    methods:
+      GET:
+        page:
+          type: cursor
+          items: data
+          param: cursor
+          next: meta.next
+  link:
+    uri: /link
+    methods:
+      GET:
+        page:
+          type: link
+`, srv.URL)))
+	all := func(it *Iterator) (l []any) {
+		for it.Next() {
+			l = append(l, it.Value())
+		}
+		gt.NoError(it.Err(), "Err")
+		return
+	}
+	ctx := context.Background()
+	for _, r := range []string{"page", "offset", "cursor", "link"} {
+		gt.Msg = "Pagination." + r + ".%s"
+		m := a.Resource(r).Method("GET")
+		gt.Equal(len(items), len(all(m.Iterate(ctx))), "all")
+		it := m.Iterate(ctx).MaxItems(12)
+		l := all(it)
+		gt.Equal(12, len(l), "MaxItems")
+		gt.Equal(12, l[11], "MaxItems last")
+		gt.Equal(2, it.Pages(), "MaxItems pages")
+		gt.Equal(20, len(all(m.Iterate(ctx).MaxPages(2))), "MaxPages")
+	}
+	gt.Msg = "Pagination.%s"
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	it := a.Resource("page").Method("GET").Iterate(ctx)
+	for it.Next() {
+		if it.Value() == 4 {
+			cancel()
+		}
+	}
+	gt.Equal(errors.CANCELLED, it.Err().(*errors.Status).Code(), "cancelled")
+	gt.Equal(1, it.Pages(), "cancelled pages")
+	it = FromYaml(petsYaml).Resource("pets").Method("GET").Iterate(context.Background())
+	gt.True(!it.Next(), "no pagination")
+	gt.Equal(errors.FAILED, it.Err().(*errors.Status).Code(), "no pagination err")
+}
+
+func TestGenerate(t *testing.T) {
+	gt := test.New(t)
+	gt.Msg = "Generate.%s"
+	src, err := Generate(FromYaml(petsYaml), "")
+	gt.NoError(err, "err")
+	again, _ := Generate(FromYaml(petsYaml), "")
+	gt.Equal(string(src), string(again), "deterministic")
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "client.go", src, 0)
+	gt.NoError(err, "parse")
+	_, err = (&types.Config{Importer: importer.ForCompiler(fset, "gc", exportData)}).Check("pets", fset, []*ast.File{f}, nil)
+	gt.NoError(err, "type check")
+	gt.Equal("pets", f.Name.Name, "package")
+	decls := map[string]bool{}
+	for _, d := range f.Decls {
+		switch d := d.(type) {
+		case *ast.FuncDecl:
+			decls[d.Name.Name] = true
+		case *ast.GenDecl:
+			for _, s := range d.Specs {
+				if s, ok := s.(*ast.TypeSpec); ok {
+					decls[s.Name.Name] = true
+				}
+			}
+		}
+	}
+	for _, d := range []string{
+		"Client", "New", "PetsGet", "PetsPost", "PetGet", "SearchGet",
+		"PetsGetParams", "PetsGetResponse", "PetsGetResponseItem",
+		"PetsPostRequest", "PetsPostResponse", "PetGetParams", "SearchGetResponse",
+	} {
+		gt.True(decls[d], d)
+	}
+	for _, s := range []string{
+		"func (c *Client) PetsPost(ctx context.Context, body *PetsPostRequest) (*PetsPostResponse, error)",
+		"type SearchGetResponse []string",
+		"Name string `json:\"name\"`",
+	} {
+		gt.True(bytes.Contains(src, []byte(s)), s)
+	}
+
+	gt.Msg = "Generate.Command.%s"
+	dir := t.TempDir()
+	os.WriteFile(dir+"/pets.yml", petsYaml, 0644)
+	cmd := GenerateCommand()
+	cmd.Flags().Get("spec").SetValue(dir + "/pets.yml")
+	cmd.Flags().Get("out").SetValue(dir + "/client.go")
+	gt.NoError(cmd.Run(cmd, cmd.Flags()), "Run")
+	out, _ := os.ReadFile(dir + "/client.go")
+	gt.Equal(string(src), string(out), "out")
+	for n, doc := range map[string]string{
+		"malformed":     "a: \"b",
+		"not an object": "- a",
+		"no url":        "name: x",
+		"resources":     "url: http://x\nresources: 1",
+	} {
+		_, err = specApi([]byte(doc))
+		st, ok := err.(*errors.Status)
+		gt.True(ok && st.Code() == errors.INVALID, n)
+	}
+}
+
+// exportData opens the export data of the package provided
+// from the build cache, to type check generated code
+func exportData(path string) (io.ReadCloser, error) {
+	out, err := exec.Command("go", "list", "-export", "-f", "{{.Export}}", path).Output()
 	if err != nil {
-		t.Errorf("Error: %v", err)
+		return nil, err
 	}
-	if r.StatusCode != http.StatusOK {
-		t.Errorf("Expected status code %v, got %v", http.StatusOK, r.StatusCode)
+	return os.Open(strings.TrimSpace(string(out)))
+}
+
+func TestCassette(t *testing.T) {
+	gt := test.New(t)
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		b, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"path":"%s","call":%d,"body":%q}`, r.URL.Path, calls, b)
+	}))
+	defer srv.Close()
+	spec := []byte(fmt.Sprintf(`url: %s
+auth:
+  type: apikey
+  in: query
+  name: api_key
+  key: secret-key
+resources:
+  echo:
+    uri: /echo
+    methods:
+      POST:
+        request:
+          header:
+            content-type: application/json
+          body:
+            name: string
+            tags: list
+`, srv.URL))
+	path := t.TempDir() + "/cassette.yml"
+	call := func(a *Api, name string) (*Result, error) {
+		m := a.Resource("echo").Method("POST")
+		r := m.Request.Copy()
+		r.Body.Set("name", name).Set("tags", []any{"a", "b"})
+		r.Header.Set("Authorization", "Bearer token")
+		r.Header.Set("X-Trace", name)
+		return m.Call(r)
 	}
-	fmt.Println(r.Status)
-	b := make([]byte, 100)
-	r.Body.Read(b)
-	fmt.Println(string(b))
-	r.Body.Close() */
+
+	gt.Msg = "Cassette.Record.%s"
+	a := FromYaml(spec)
+	c, err := NewCassette(path, AUTO)
+	gt.NoError(err, "err")
+	gt.Equal(RECORD, c.Mode, "Mode")
+	c.Match, c.Redact = []string{"x-trace"}, append(c.Redact, "api_key")
+	a.Client = c.Client()
+	r1, err := call(a, "rex")
+	gt.NoError(err, "err")
+	r2, _ := call(a, "max")
+	gt.Equal(2, calls, "calls")
+	_, err = os.Stat(path)
+	gt.Error(err, "written on close")
+	gt.NoError(c.Close(), "Close")
+	b, _ := os.ReadFile(path)
+	gt.True(!bytes.Contains(b, []byte("secret-key")), "query redacted")
+	gt.True(!bytes.Contains(b, []byte("Bearer token")), "header redacted")
+	gt.True(bytes.Contains(b, []byte("api_key="+Redacted)), "query redaction")
+
+	gt.Msg = "Cassette.Replay.%s"
+	srv.Close()
+	a = FromYaml(spec)
+	c, err = NewCassette(path, AUTO)
+	gt.NoError(err, "err")
+	gt.Equal(REPLAY, c.Mode, "Mode")
+	gt.Equal(2, len(c.Interactions()), "Interactions")
+	c.Match, c.Redact = []string{"x-trace"}, append(c.Redact, "api_key")
+	a.Client = c.Client()
+	r, err := call(a, "max")
+	gt.NoError(err, "err")
+	gt.Equal(r2.Body, r.Body, "matched body")
+	gt.Equal(http.StatusOK, r.StatusCode, "StatusCode")
+	gt.Equal("application/json", r.Header.Get("Content-Type"), "Header")
+	r, _ = call(a, "rex")
+	gt.Equal(r1.Body, r.Body, "matched header")
+	_, err = call(a, "bob")
+	gt.Error(err, "unmatched")
+	gt.Equal(2, calls, "no network")
+	gt.NoError(c.Close(), "Close.Replay")
+	b2, _ := os.ReadFile(path)
+	gt.Equal(string(b), string(b2), "Close.Replay unchanged")
+
+	_, err = NewCassette(t.TempDir()+"/missing.yml", REPLAY)
+	gt.Equal(errors.NOTFOUND, err.(*errors.Status).Code(), "missing")
+	for n, b := range map[string]string{"malformed": "a: \"b", "not an object": "- a"} {
+		p := t.TempDir() + "/bad.yml"
+		os.WriteFile(p, []byte(b), 0644)
+		_, err = NewCassette(p, REPLAY)
+		st, ok := err.(*errors.Status)
+		gt.True(ok && st.Code() == errors.INVALID, n)
+	}
+}
+
+func TestGrpc(t *testing.T) {
+	gt := test.New(t)
+	gt.Msg = "Grpc.%s"
+	str, num := proto.String, proto.Int32
+	field := func(name string, n int32, typ descriptorpb.FieldDescriptorProto_Type, label descriptorpb.FieldDescriptorProto_Label, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{Name: str(name), Number: num(n), Type: typ.Enum(), Label: label.Enum()}
+		if typeName != "" {
+			f.TypeName = str(typeName)
+		}
+		return f
+	}
+	opt, rep := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL, descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+	fd, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:    str("pets.proto"),
+		Package: str("test.pets"),
+		Syntax:  str("proto3"),
+		EnumType: []*descriptorpb.EnumDescriptorProto{{
+			Name: str("Kind"),
+			Value: []*descriptorpb.EnumValueDescriptorProto{
+				{Name: str("UNKNOWN"), Number: num(0)},
+				{Name: str("DOG"), Number: num(1)},
+			},
+		}},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: str("GetRequest"), Field: []*descriptorpb.FieldDescriptorProto{
+				field("id", 1, descriptorpb.FieldDescriptorProto_TYPE_INT64, opt, ""),
+			}},
+			{Name: str("Owner"), Field: []*descriptorpb.FieldDescriptorProto{
+				field("name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, opt, ""),
+			}},
+			{Name: str("Pet"), Field: []*descriptorpb.FieldDescriptorProto{
+				field("id", 1, descriptorpb.FieldDescriptorProto_TYPE_INT64, opt, ""),
+				field("name", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING, opt, ""),
+				field("kind", 3, descriptorpb.FieldDescriptorProto_TYPE_ENUM, opt, ".test.pets.Kind"),
+				field("tags", 4, descriptorpb.FieldDescriptorProto_TYPE_STRING, rep, ""),
+				field("owner", 5, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, opt, ".test.pets.Owner"),
+				field("chip", 6, descriptorpb.FieldDescriptorProto_TYPE_UINT64, opt, ""),
+			}},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: str("Pets"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				{Name: str("Get"), InputType: str(".test.pets.GetRequest"), OutputType: str(".test.pets.Pet")},
+				{Name: str("Watch"), InputType: str(".test.pets.GetRequest"), OutputType: str(".test.pets.Pet"), ServerStreaming: proto.Bool(true)},
+			},
+		}},
+	}, nil)
+	gt.NoError(err, "descriptor")
+	files := &protoregistry.Files{}
+	gt.NoError(files.RegisterFile(fd), "register")
+	pet, req := fd.Messages().ByName("Pet"), fd.Messages().ByName("GetRequest")
+	chip := pet.Fields().ByName("chip")
+	for _, in := range []any{uint64(math.MaxUint64), "18446744073709551615"} {
+		v, err := protoValue(chip, in, nil)
+		gt.NoError(err, "uint64")
+		gt.Equal(uint64(math.MaxUint64), fieldValue(chip, v), "uint64 value")
+	}
+
+	// in process pets server
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	srv.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "test.pets.Pets",
+		HandlerType: (*any)(nil),
+		Methods: []grpc.MethodDesc{{
+			MethodName: "Get",
+			Handler: func(_ any, ctx context.Context, dec func(any) error, _ grpc.UnaryServerInterceptor) (any, error) {
+				in := dynamicpb.NewMessage(req)
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				md, _ := metadata.FromIncomingContext(ctx)
+				if a := md.Get("authorization"); len(a) == 0 || a[0] != "Bearer token" {
+					return nil, status.Error(codes.Unauthenticated, "bad token")
+				}
+				id := in.Get(req.Fields().ByName("id")).Int()
+				if id == 0 {
+					return nil, status.Error(codes.NotFound, "pet not found")
+				}
+				grpc.SetHeader(ctx, metadata.Pairs("x-trace", strings.Join(md.Get("x-trace"), "")))
+				out := dynamicpb.NewMessage(pet)
+				return out, protoMessage(out, map[string]any{
+					"id":    int(id),
+					"name":  "rex",
+					"kind":  "DOG",
+					"tags":  []any{"good", "boy"},
+					"owner": map[string]any{"name": "bob"},
+				})
+			},
+		}},
+	}, nil)
+	reflectionpb.RegisterServerReflectionServer(srv, reflection.NewServerV1(reflection.ServerOptions{
+		Services:           srv,
+		DescriptorResolver: files,
+	}))
+	go srv.Serve(lis)
+	defer srv.Stop()
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	gt.NoError(err, "dial")
+	defer conn.Close()
+
+	a := FromYaml([]byte(`name: pets
+protocol: grpc
+url: grpc://bufnet
+auth:
+  type: bearer
+  token: token
+resources:
+  test.pets.Pets:
+    methods:
+      Get:
+        request:
+          header:
+            x-trace: string
+          body:
+            id: int
+      Watch:
+        request:
+          body:
+            id: int
+`))
+	gt.Equal(GRPC, a.Protocol, "Protocol")
+	a.Conn = conn
+	m := a.Resource("test.pets.Pets").Method("Get")
+	rq := m.Request.Copy()
+	rq.Body.Set("id", 7)
+	rq.Header.Set("x-trace", "abc")
+	r, err := m.Call(rq)
+	gt.NoError(err, "err")
+	gt.Equal(map[string]any{
+		"id":    7,
+		"name":  "rex",
+		"kind":  "DOG",
+		"tags":  []any{"good", "boy"},
+		"owner": map[string]any{"name": "bob"},
+	}, r.Body, "Body")
+	gt.Equal("abc", r.Header.Get("x-trace"), "Header")
+	gt.True(findDescriptor(a.Descriptors, "test.pets.Pet") != nil, "reflected")
+
+	rq = m.Request.Copy()
+	rq.Body.Set("id", 0)
+	_, err = m.Call(rq)
+	gt.Equal(errors.NOTFOUND, err.(*errors.Status).Code(), "NotFound")
+	a.Authenticator = &Bearer{Token: "wrong"}
+	_, err = m.Call()
+	gt.Equal(errors.UNAUTHENTICATED, err.(*errors.Status).Code(), "Unauthenticated")
+	_, err = a.Resource("test.pets.Pets").Method("Watch").Call()
+	gt.Equal(errors.UNIMPLEMENTED, err.(*errors.Status).Code(), "Streaming")
+
+	rq = m.Request.Copy()
+	rq.Body.Set("nope", 1)
+	_, err = m.Call(rq)
+	gt.Equal(errors.INVALID, err.(*errors.Status).Code(), "unknown field")
+}
+
+func TestWebsocket(t *testing.T) {
+	gt := test.New(t)
+	gt.Msg = "Websocket.%s"
+	var conns atomic.Int32
+	spec := `name: chat
+protocol: websocket
+url: %s
+auth:
+  type: bearer
+  token: token
+resources:
+  rooms:
+    uri: /rooms
+    channel:
+      in:
+        user: string
+        text: string
+        tags: [string]
+      out:
+        text: string
+      ping: 100ms
+      reconnect:
+        attempts: 3
+        backoff: 10ms
+`
+	a := FromYaml([]byte(fmt.Sprintf(spec, "ws://localhost")))
+	gt.Equal(WEBSOCKET, a.Protocol, "Protocol")
+	ch := a.Resource("rooms").Channel
+	gt.Equal(100*time.Millisecond, ch.Ping, "Ping")
+	gt.Equal(3, ch.Reconnect.Attempts, "Reconnect")
+	h := ch.Handler(func(s *Stream) {
+		conns.Add(1)
+		for {
+			v, err := s.Receive()
+			if err != nil {
+				return
+			}
+			switch text := v.(map[string]any)["text"]; text {
+			case "bye":
+				s.socket.CloseCode(CloseGoingAway, "restarting")
+				return
+			case "bad":
+				s.socket.WriteMessage(false, []byte(`{"user":1}`))
+			default:
+				s.Send(map[string]any{"user": "bob", "text": text, "tags": []any{"a"}})
+			}
+		}
+	})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	a = FromYaml([]byte(fmt.Sprintf(spec, strings.Replace(srv.URL, "http", "ws", 1))))
+	ch = a.Resource("rooms").Channel
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s, err := ch.Connect(ctx)
+	gt.NoError(err, "Connect")
+	gt.NoError(s.Send(map[string]any{"text": "hello"}), "Send")
+	v, err := s.Receive()
+	gt.NoError(err, "Receive")
+	gt.Equal(map[string]any{"user": "bob", "text": "hello", "tags": []any{"a"}}, v, "message")
+	gt.Error(s.Send(map[string]any{"text": 1}), "Send invalid")
+	s.Send(map[string]any{"text": "bad"})
+	v, err = s.Receive()
+	gt.Equal(errors.INVALID, err.(*errors.Status).Code(), "Receive invalid")
+	gt.Equal(map[string]any{"user": 1}, v, "invalid message")
+
+	// long message over two byte length and reconnect after going away
+	long := strings.Repeat("x", 70000)
+	gt.NoError(s.Send(map[string]any{"text": "bye"}), "Send bye")
+	received := make(chan error)
+	go func() {
+		v, err = s.Receive()
+		received <- err
+	}()
+	for conns.Load() < 2 && ctx.Err() == nil {
+		time.Sleep(5 * time.Millisecond)
+	}
+	gt.NoError(s.Send(map[string]any{"text": long}), "Send reconnected")
+	gt.NoError(<-received, "Receive reconnected")
+	gt.Equal(long, v.(map[string]any)["text"], "long message")
+	gt.Equal(int32(2), conns.Load(), "reconnects")
+	gt.NoError(s.Close(), "Close")
+	_, err = s.Receive()
+	gt.Equal(errors.CANCELLED, err.(*errors.Status).Code(), "closed")
+
+	// close while reconnecting to an unavailable server
+	var down atomic.Bool
+	var dials atomic.Int32
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			dials.Add(1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		h.ServeHTTP(w, r)
+	}))
+	defer flaky.Close()
+	patient := *ch
+	patient.Reconnect = &Retry{Attempts: 10, Backoff: time.Minute}
+	patient.Resource = &Resource{Api: ch.Resource.Api}
+	patient.Resource.Url, _ = url.Parse(strings.Replace(flaky.URL, "http", "ws", 1))
+	s, err = patient.Connect(context.Background())
+	gt.NoError(err, "Connect patient")
+	down.Store(true)
+	gt.NoError(s.Send(map[string]any{"text": "bye"}), "Send patient bye")
+	go func() {
+		_, err := s.Receive()
+		received <- err
+	}()
+	for dials.Load() < 1 && ctx.Err() == nil {
+		time.Sleep(5 * time.Millisecond)
+	}
+	start := time.Now()
+	gt.NoError(s.Close(), "Close reconnecting")
+	gt.True(time.Since(start) < time.Second, "Close reconnecting wait")
+	err = <-received
+	gt.Equal(errors.CANCELLED, err.(*errors.Status).Code(), "reconnect cancelled")
+
+	// close replies to empty, invalid and reserved close codes
+	for i, c := range []struct {
+		payload []byte
+		reply   int
+	}{
+		{nil, CloseNormal},
+		{[]byte{3}, CloseProtocol},
+		{closePayload(CloseGoingAway, "bye"), CloseGoingAway},
+		{closePayload(4000, ""), 4000},
+		{closePayload(1005, ""), CloseProtocol},
+		{closePayload(1006, ""), CloseProtocol},
+		{closePayload(1015, ""), CloseProtocol},
+		{closePayload(5000, ""), CloseProtocol},
+	} {
+		pc, lc := net.Pipe()
+		peer, local := newSocket(pc, bufio.NewReader(pc), true), newSocket(lc, bufio.NewReader(lc), false)
+		go peer.write(opClose, c.payload)
+		go local.ReadMessage()
+		_, op, p, err := peer.readFrame()
+		gt.NoError(err, "close reply "+strconv.Itoa(i))
+		gt.Equal(opClose, op, "close reply op "+strconv.Itoa(i))
+		gt.Equal(c.reply, int(binary.BigEndian.Uint16(p)), "close reply code "+strconv.Itoa(i))
+		pc.Close()
+	}
+
+	// keepalive timeout of an unresponsive server
+	block := make(chan struct{})
+	quiet := *ch
+	quiet.Ping = 0
+	slow := httptest.NewServer(quiet.Handler(func(s *Stream) { <-block }))
+	defer slow.Close()
+	defer close(block)
+	ch.Reconnect = nil
+	ch.Resource.Url, _ = url.Parse(strings.Replace(slow.URL, "http", "ws", 1))
+	s, err = ch.Connect(ctx)
+	gt.NoError(err, "Connect slow")
+	_, err = s.Receive()
+	gt.Equal(errors.UNAVAILABLE, err.(*errors.Status).Code(), "keepalive")
+
+	// unauthorized handshake and plain http requests
+	a.Authenticator = &Bearer{Token: "wrong"}
+	ch.Resource.Url, _ = url.Parse(strings.Replace(srv.URL, "http", "ws", 1) + "/rooms")
+	_, err = ch.Connect(ctx)
+	gt.Equal(errors.UNAUTHENTICATED, err.(*errors.Status).Code(), "unauthorized")
+	res, err := http.Get(slow.URL)
+	gt.NoError(err, "http")
+	gt.Equal(http.StatusBadRequest, res.StatusCode, "not a handshake")
+}
+
+func TestWebhook(t *testing.T) {
+	gt := test.New(t)
+	gt.Msg = "Webhook.%s"
+	os.Setenv("TEST_WEBHOOK_SECRET", "shh")
+	a := FromYaml([]byte(`name: pets
+url: http://localhost
+resources:
+  hooks:
+    uri: /hooks
+    methods:
+      POST:
+        request:
+          webhook:
+            event: type
+            signature:
+              secret: $TEST_WEBHOOK_SECRET
+              tolerance: 1m
+            events:
+              pet.created:
+                type: string
+                data:
+                  id: int
+                  name: string
+              pet.deleted:
+                type: string
+                data:
+                  id: int
+`))
+	wh := a.Resource("hooks").Method("POST").Request.Webhook
+	gt.Equal("shh", wh.Signature.Secret, "Secret")
+	gt.Equal(time.Minute, wh.Signature.Tolerance, "Tolerance")
+	gt.Equal(2, len(wh.Events), "Events")
+
+	s, err := NewWebhookServer(wh)
+	gt.NoError(err, "NewWebhookServer")
+	var got []*Event
+	gt.NoError(s.Handle("pet.created", func(e *Event) error {
+		got = append(got, e)
+		return nil
+	}), "Handle")
+	gt.Error(s.Handle("pet.sold", nil), "Handle undeclared")
+	deliver := func(payload string, t time.Time, sign func(h http.Header, b []byte)) int {
+		r := httptest.NewRequest(http.MethodPost, "/hooks", strings.NewReader(payload))
+		r.Header.Set("Content-Type", "application/json")
+		if sign != nil {
+			sign(r.Header, []byte(payload))
+		} else {
+			wh.Signature.Sign(r.Header, []byte(payload), t)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w.Code
+	}
+	now := time.Now()
+	created := `{"type":"pet.created","data":{"id":1,"name":"rex"}}`
+	gt.Equal(http.StatusNoContent, deliver(created, now, nil), "delivered")
+	gt.Equal(1, len(got), "dispatched")
+	gt.Equal("pet.created", got[0].Name, "Name")
+	gt.Equal(now.Unix(), got[0].Time.Unix(), "Time")
+	gt.Equal(map[string]any{"id": 1, "name": "rex"}, got[0].Payload.(map[string]any)["data"], "Payload")
+	gt.Equal(http.StatusNoContent, deliver(`{"type":"pet.deleted","data":{"id":1}}`, now, nil), "unhandled")
+	gt.Equal(1, len(got), "not dispatched")
+
+	gt.Equal(http.StatusUnauthorized, deliver(created, now.Add(-2*time.Minute), nil), "replay")
+	gt.Equal(http.StatusUnauthorized, deliver(created, now.Add(2*time.Minute), nil), "future")
+	gt.Equal(http.StatusUnauthorized, deliver(created, now, func(h http.Header, b []byte) {}), "unsigned")
+	gt.Equal(http.StatusUnauthorized, deliver(created, now, func(h http.Header, b []byte) {
+		wh.Signature.Sign(h, []byte(`{"type":"pet.created"}`), now)
+	}), "tampered")
+	gt.Equal(http.StatusNoContent, deliver(created, now, func(h http.Header, b []byte) {
+		// rotated secrets send multiple signatures
+		ts := strconv.FormatInt(now.Unix(), 10)
+		old := &Signature{Secret: "old"}
+		h.Set("Webhook-Signature", "t="+ts+",v1="+old.sign(ts, b)+",v1="+wh.Signature.sign(ts, b))
+	}), "rotated")
+	gt.Equal(http.StatusBadRequest, deliver(`{"type":"pet.created","data":{"id":"one"}}`, now, nil), "invalid payload")
+	gt.Equal(http.StatusBadRequest, deliver(`{"type":"pet.sold"}`, now, nil), "unknown event")
+
+	// signatures with a timestamp header
+	wh.Signature.Timestamp = "Webhook-Time"
+	gt.Equal(http.StatusNoContent, deliver(created, now, nil), "timestamp header")
+	gt.Equal(3, len(got), "dispatched")
+	s.Handle("pet.created", func(e *Event) error { return errors.Unavailable("busy") })
+	gt.Equal(http.StatusServiceUnavailable, deliver(created, now, nil), "handler error")
+
+	// unset secrets reject every delivery, since anyone may sign with an empty secret
+	os.Unsetenv("TEST_WEBHOOK_SECRET")
+	unset := FromYaml([]byte(`url: http://localhost
+resources:
+  hooks:
+    uri: /hooks
+    methods:
+      POST:
+        request:
+          webhook:
+            signature:
+              secret: $TEST_WEBHOOK_SECRET
+`)).Resource("hooks").Method("POST").Request.Webhook
+	gt.Equal("", unset.Signature.Secret, "unset Secret")
+	_, err = NewWebhookServer(unset)
+	gt.Equal(errors.INTERNAL, err.(*errors.Status).Code(), "unset NewWebhookServer")
+	forge := func(h http.Header, b []byte) {
+		ts := strconv.FormatInt(now.Unix(), 10)
+		h.Set("Webhook-Signature", "t="+ts+",v1="+(&Signature{}).sign(ts, b))
+	}
+	h := http.Header{}
+	forge(h, []byte(created))
+	gt.Equal(errors.INTERNAL, unset.Signature.Verify(h, []byte(created), now).(*errors.Status).Code(), "unset Verify")
+	wh.Signature.Secret, wh.Signature.Timestamp = "", ""
+	s.Handle("pet.created", func(e *Event) error { return nil })
+	var logs bytes.Buffer
+	s.Logger = logger.New().Writers(&logs)
+	gt.Equal(http.StatusInternalServerError, deliver(created, now, forge), "unset forged")
+	gt.Equal(http.StatusInternalServerError, deliver(created, now, nil), "unset signed")
+	gt.True(strings.Contains(logs.String(), "webhook signature has no secret"), "unset logged")
+
+	// oversized payloads
+	wh.Signature = nil
+	s.MaxBody = 16
+	gt.Equal(http.StatusRequestEntityTooLarge, deliver(created, now, func(h http.Header, b []byte) {}), "too large")
 }
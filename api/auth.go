@@ -0,0 +1,244 @@
+// Copyright 2023 james dotter.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://github.com/jcdotter/go/LICENSE
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jcdotter/go/env"
+	"github.com/jcdotter/go/errors"
+)
+
+// ----------------------------------------------------------------------------
+// AUTHENTICATION
+// strategies for authenticating the requests of an api,
+// configured in the auth section of the api spec:
+//
+//	auth:
+//	  type: apikey | basic | bearer | oauth2
+//
+// Credentials may reference environment variables, such
+// as 'password: $API_PASSWORD', which are expanded when
+// the spec is loaded. The oauth2 client credentials flow
+// requests tokens from the token endpoint described by the
+// auth section as an api, using the first resource of the
+// auth api, or the auth url when no resources are declared.
+
+// Authenticator authenticates the requests of an api
+type Authenticator interface {
+	Authorize(r *http.Request) error
+}
+
+// AuthMap returns the authenticator described by the auth
+// section of an api spec, where auth is the api built from
+// the section. Returns nil if the auth type is not known.
+func AuthMap(m map[string]any, auth *Api) Authenticator {
+	s := func(k string) string {
+		v, _ := m[k].(string)
+		return os.Expand(v, env.Get)
+	}
+	switch s("type") {
+	case "apikey":
+		return &ApiKey{In: s("in"), Name: s("name"), Key: s("key")}
+	case "basic":
+		return &Basic{Username: s("username"), Password: s("password")}
+	case "bearer":
+		return &Bearer{Token: s("token")}
+	case "oauth2":
+		if auth == nil {
+			return nil
+		}
+		return NewClientCredentials(auth, s("client_id"), s("client_secret"), s("scope"))
+	}
+	return nil
+}
+
+func (m *Method) authorize(r *http.Request) error {
+	if a := m.Resource.Api; a != nil && a.Authenticator != nil {
+		return a.Authenticator.Authorize(r)
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+// API KEY
+
+// ApiKey authenticates requests with a static key
+// in a header, or in the query when In is 'query'
+type ApiKey struct {
+	In   string
+	Name string
+	Key  string
+}
+
+func (a *ApiKey) Authorize(r *http.Request) error {
+	if a.In == "query" {
+		q := r.URL.Query()
+		q.Set(a.Name, a.Key)
+		r.URL.RawQuery = q.Encode()
+		return nil
+	}
+	r.Header.Set(a.Name, a.Key)
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+// BASIC
+
+// Basic authenticates requests with http basic auth
+type Basic struct {
+	Username string
+	Password string
+}
+
+func (a *Basic) Authorize(r *http.Request) error {
+	r.SetBasicAuth(a.Username, a.Password)
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+// BEARER
+
+// Bearer authenticates requests with a static bearer token
+type Bearer struct {
+	Token string
+}
+
+func (a *Bearer) Authorize(r *http.Request) error {
+	r.Header.Set("Authorization", "Bearer "+a.Token)
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+// OAUTH2 CLIENT CREDENTIALS
+
+// TokenExpiryDelta is the time before a token expires
+// at which the token is refreshed
+var TokenExpiryDelta = 10 * time.Second
+
+// ClientCredentials authenticates requests with a bearer
+// token requested from the token endpoint of the auth api
+// using the oauth2 client credentials grant. Tokens are
+// cached until they expire.
+type ClientCredentials struct {
+	sync.Mutex
+	Auth         *Api
+	ClientId     string
+	ClientSecret string
+	Scope        string
+	token        string
+	expiry       time.Time
+}
+
+// NewClientCredentials returns a client credentials
+// authenticator of the token endpoint of the auth api
+func NewClientCredentials(auth *Api, id, secret, scope string) *ClientCredentials {
+	if auth.Resources.Len() == 0 && auth.Url != nil {
+		u := *auth.Url
+		r := NewResource(auth, "token", &u)
+		r.Methods.Add(NewMethod(r, POST.String()))
+		auth.Resources.Add(r)
+	}
+	return &ClientCredentials{Auth: auth, ClientId: id, ClientSecret: secret, Scope: scope}
+}
+
+func (a *ClientCredentials) Authorize(r *http.Request) error {
+	t, err := a.Token(r)
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Authorization", "Bearer "+t)
+	return nil
+}
+
+// Token returns the cached token, requesting a new
+// token if the cached token is expired
+func (a *ClientCredentials) Token(r *http.Request) (string, error) {
+	a.Lock()
+	defer a.Unlock()
+	if a.token != "" && (a.expiry.IsZero() || time.Now().Add(TokenExpiryDelta).Before(a.expiry)) {
+		return a.token, nil
+	}
+	m := a.method()
+	if m == nil {
+		return "", errors.Failed("api: oauth2 token endpoint not found")
+	}
+	req := NewRequest()
+	req.Header.Set("content-type", FORM.String())
+	req.Header.Set("accept", JSON.String())
+	req.Body.Set("grant_type", "client_credentials")
+	req.Body.Set("client_id", a.ClientId)
+	req.Body.Set("client_secret", a.ClientSecret)
+	if a.Scope != "" {
+		req.Body.Set("scope", a.Scope)
+	}
+	res, err := m.CallContext(r.Context(), req)
+	if err != nil {
+		return "", err
+	}
+	body, _ := res.Body.(map[string]any)
+	if !res.Ok() || body == nil {
+		return "", errors.Unauthenticated("api: oauth2 token request failed: " + res.Status)
+	}
+	t, _ := body["access_token"].(string)
+	if t == "" {
+		return "", errors.Unauthenticated("api: oauth2 token response has no access_token")
+	}
+	a.token, a.expiry = t, time.Time{}
+	if exp := tokenExpiry(body["expires_in"]); exp > 0 {
+		a.expiry = time.Now().Add(exp)
+	}
+	return a.token, nil
+}
+
+// Invalidate clears the cached token so that
+// a new token is requested by the next call
+func (a *ClientCredentials) Invalidate() {
+	a.Lock()
+	defer a.Unlock()
+	a.token, a.expiry = "", time.Time{}
+}
+
+func (a *ClientCredentials) method() *Method {
+	if a.Auth.Resources.Len() == 0 {
+		return nil
+	}
+	r := a.Auth.Resources.Index(0).(*Resource)
+	if m := r.Method(POST.String()); m != nil {
+		return m
+	}
+	if r.Methods.Len() > 0 {
+		return r.Methods.Index(0).(*Method)
+	}
+	return nil
+}
+
+func tokenExpiry(v any) time.Duration {
+	switch v := v.(type) {
+	case int:
+		return time.Duration(v) * time.Second
+	case float64:
+		return time.Duration(v * float64(time.Second))
+	case string:
+		if i, err := strconv.Atoi(v); err == nil {
+			return time.Duration(i) * time.Second
+		}
+	}
+	return 0
+}
@@ -0,0 +1,380 @@
+// Copyright 2023 james dotter.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://github.com/jcdotter/go/LICENSE
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jcdotter/go/encoder"
+	"github.com/jcdotter/go/errors"
+)
+
+// ----------------------------------------------------------------------------
+// CASSETTES
+// records the requests and responses of api calls to a
+// yaml cassette file, and replays them without the network.
+// Setting the client of an api to the cassette client
+// records or replays the calls of the api, where the calls
+// recorded are written to the file when it is closed:
+//
+//	c, err := NewCassette("testdata/pets.yml", AUTO)
+//	defer c.Close()
+//	api.Client = c.Client()
+//
+// Requests are matched on the method, url, body and the
+// headers listed in the Match field of the cassette.
+// Headers and query params listed in the Redact field are
+// replaced with REDACTED before the cassette is written,
+// and are matched as redacted.
+
+// CassetteMode is the mode of a cassette
+type CassetteMode byte
+
+const (
+	// REPLAY serves recorded responses, failing requests
+	// which do not match a recorded request
+	REPLAY CassetteMode = iota
+	// RECORD sends requests to the api and records them
+	RECORD
+	// AUTO replays if the cassette file exists,
+	// otherwise records
+	AUTO
+)
+
+// Redacted replaces the values of redacted fields
+const Redacted = "REDACTED"
+
+// Cassette is a recording of the calls to an api
+type Cassette struct {
+	sync.Mutex
+	Path string
+	Mode CassetteMode
+	// Match are the request headers matched when replaying
+	Match []string
+	// Redact are the header and query params redacted
+	// from the cassette, defaulting to Authorization
+	Redact []string
+	// Filter modifies each interaction before it is written
+	Filter func(i *Interaction)
+	// Transport sends the requests recorded,
+	// defaulting to http.DefaultTransport
+	Transport    http.RoundTripper
+	interactions []*Interaction
+	played       []bool
+}
+
+// Interaction is a request and response of a cassette
+type Interaction struct {
+	Request  CassetteRequest
+	Response CassetteResponse
+}
+
+// CassetteRequest is a request recorded in a cassette
+type CassetteRequest struct {
+	Method string
+	Url    string
+	Header map[string]string
+	Body   string
+}
+
+// CassetteResponse is a response recorded in a cassette
+type CassetteResponse struct {
+	Status int
+	Header map[string]string
+	Body   string
+}
+
+// NewCassette returns the cassette of the file and mode
+// provided, loading the interactions of the file unless
+// recording. Returns an error if replaying and the file
+// cannot be read.
+func NewCassette(path string, mode CassetteMode) (*Cassette, error) {
+	c := &Cassette{Path: path, Mode: mode, Redact: []string{"Authorization"}}
+	if mode == AUTO {
+		if _, err := os.Stat(path); err == nil {
+			c.Mode = REPLAY
+		} else {
+			c.Mode = RECORD
+		}
+	}
+	if c.Mode == REPLAY {
+		if err := c.Load(); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Client returns an http client which records
+// or replays the requests sent
+func (c *Cassette) Client() *http.Client {
+	return &http.Client{Transport: c}
+}
+
+// Interactions returns the interactions of the cassette
+func (c *Cassette) Interactions() []*Interaction {
+	c.Lock()
+	defer c.Unlock()
+	return append([]*Interaction(nil), c.interactions...)
+}
+
+// RoundTrip records or replays the request
+func (c *Cassette) RoundTrip(r *http.Request) (*http.Response, error) {
+	var body []byte
+	if r.Body != nil {
+		b, err := io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = b
+	}
+	req := c.request(r, body)
+	if c.Mode == REPLAY {
+		i := c.find(req)
+		if i == nil {
+			return nil, errors.NotFound("api: no cassette interaction matches " + req.Method + " " + req.Url)
+		}
+		return i.Response.response(r), nil
+	}
+	rt := c.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	r = r.Clone(r.Context())
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	res, err := rt.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	b, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = io.NopCloser(bytes.NewReader(b))
+	i := &Interaction{Request: req, Response: CassetteResponse{
+		Status: res.StatusCode,
+		Header: c.header(res.Header, nil),
+		Body:   string(b),
+	}}
+	if c.Filter != nil {
+		c.Filter(i)
+	}
+	c.Lock()
+	c.interactions = append(c.interactions, i)
+	c.Unlock()
+	return res, nil
+}
+
+// Close writes the interactions recorded to the cassette
+// file when recording, and does nothing when replaying
+func (c *Cassette) Close() error {
+	if c.Mode != RECORD {
+		return nil
+	}
+	return c.Save()
+}
+
+// Load reads the interactions of the cassette file
+func (c *Cassette) Load() error {
+	b, err := os.ReadFile(c.Path)
+	if err != nil {
+		return errors.NotFound("api: cassette '" + c.Path + "' not found")
+	}
+	e := encoder.Yaml.New()
+	if err = e.TryDecode(b); err != nil {
+		return errors.Invalid("api: invalid cassette '" + c.Path + "': " + err.Error())
+	}
+	m, ok := e.Value().(map[string]any)
+	if !ok {
+		return errors.Invalid("api: invalid cassette '" + c.Path + "': expected an object")
+	}
+	l, _ := m["Interactions"].([]any)
+	is := make([]*Interaction, 0, len(l))
+	for _, v := range l {
+		i, _ := v.(map[string]any)
+		rq, _ := i["Request"].(map[string]any)
+		rs, _ := i["Response"].(map[string]any)
+		status, _ := strconv.Atoi(formatValue(rs["Status"]))
+		is = append(is, &Interaction{
+			Request: CassetteRequest{
+				Method: str(rq["Method"]),
+				Url:    str(rq["Url"]),
+				Header: strMap(rq["Header"]),
+				Body:   str(rq["Body"]),
+			},
+			Response: CassetteResponse{
+				Status: status,
+				Header: strMap(rs["Header"]),
+				Body:   str(rs["Body"]),
+			},
+		})
+	}
+	c.Lock()
+	c.interactions, c.played = is, make([]bool, len(is))
+	c.Unlock()
+	return nil
+}
+
+// Save writes the interactions to the cassette file
+func (c *Cassette) Save() error {
+	c.Lock()
+	f := struct {
+		Interactions []*Interaction
+	}{c.interactions}
+	b := encoder.Yaml.New().Encode(f).Bytes()
+	c.Unlock()
+	if err := os.WriteFile(c.Path, append(b, '\n'), 0644); err != nil {
+		return errors.Internal("api: failed to write cassette '" + c.Path + "'")
+	}
+	return nil
+}
+
+// find returns the first unplayed interaction matching
+// the request, or the last played if all are played
+func (c *Cassette) find(req CassetteRequest) *Interaction {
+	c.Lock()
+	defer c.Unlock()
+	var played *Interaction
+	for n, i := range c.interactions {
+		if !c.match(i.Request, req) {
+			continue
+		}
+		if !c.played[n] {
+			c.played[n] = true
+			return i
+		}
+		played = i
+	}
+	return played
+}
+
+func (c *Cassette) match(a, b CassetteRequest) bool {
+	if a.Method != b.Method || a.Url != b.Url {
+		return false
+	}
+	for _, h := range c.Match {
+		h = http.CanonicalHeaderKey(h)
+		if a.Header[h] != b.Header[h] {
+			return false
+		}
+	}
+	if a.Body == b.Body {
+		return true
+	}
+	// json bodies match regardless of key order
+	if !json([]byte(a.Body)) || !json([]byte(b.Body)) {
+		return false
+	}
+	av, err := decodeBody(contentString[JSON], []byte(a.Body))
+	if err != nil {
+		return false
+	}
+	bv, err := decodeBody(contentString[JSON], []byte(b.Body))
+	return err == nil && reflect.DeepEqual(av, bv)
+}
+
+// request returns the redacted cassette request of r
+func (c *Cassette) request(r *http.Request, body []byte) CassetteRequest {
+	u := *r.URL
+	if q := u.Query(); len(q) > 0 {
+		for _, k := range c.Redact {
+			if _, ok := q[k]; ok {
+				q.Set(k, Redacted)
+			}
+		}
+		u.RawQuery = q.Encode()
+	}
+	return CassetteRequest{
+		Method: r.Method,
+		Url:    u.String(),
+		Header: c.header(r.Header, c.Match),
+		Body:   string(body),
+	}
+}
+
+// header returns the redacted headers of h, limited
+// to the keys provided when not nil
+func (c *Cassette) header(h http.Header, keys []string) map[string]string {
+	m := map[string]string{}
+	if keys == nil {
+		for k := range h {
+			keys = append(keys, k)
+		}
+	}
+	for _, k := range keys {
+		k = http.CanonicalHeaderKey(k)
+		if v := h.Get(k); v != "" {
+			m[k] = v
+		}
+	}
+	for _, k := range c.Redact {
+		k = http.CanonicalHeaderKey(k)
+		if _, ok := m[k]; ok {
+			m[k] = Redacted
+		}
+	}
+	if len(m) == 0 {
+		return nil
+	}
+	return m
+}
+
+// response returns the http response of the
+// recorded response to the request provided
+func (r CassetteResponse) response(req *http.Request) *http.Response {
+	h := http.Header{}
+	for k, v := range r.Header {
+		h.Set(k, v)
+	}
+	return &http.Response{
+		Status:        strconv.Itoa(r.Status) + " " + http.StatusText(r.Status),
+		StatusCode:    r.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        h,
+		Body:          io.NopCloser(strings.NewReader(r.Body)),
+		ContentLength: int64(len(r.Body)),
+		Request:       req,
+	}
+}
+
+func str(v any) string {
+	if v == nil {
+		return ""
+	}
+	return formatValue(v)
+}
+
+func strMap(v any) map[string]string {
+	m, _ := v.(map[string]any)
+	if len(m) == 0 {
+		return nil
+	}
+	s := make(map[string]string, len(m))
+	for k, v := range m {
+		s[k] = str(v)
+	}
+	return s
+}
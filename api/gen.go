@@ -0,0 +1,530 @@
+// Copyright 2023 james dotter.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://github.com/jcdotter/go/LICENSE
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/jcdotter/go/cli"
+	"github.com/jcdotter/go/data"
+	"github.com/jcdotter/go/encoder"
+	"github.com/jcdotter/go/errors"
+)
+
+// ----------------------------------------------------------------------------
+// CODE GENERATION
+// generates a typed go client package of an api, with a
+// struct of each request and response body and a method
+// of each resource method which calls the api using the
+// runtime of this package:
+//
+//	func (c *Client) PetsPost(ctx context.Context, body *PetsPostRequest) (*PetsPostResponse, error)
+//
+// Objects of the params are generated as structs, lists
+// with a single element schema as slices, and lists of
+// multiple element schemas (tuples) as []any.
+
+// Generate returns the source of a go package of the name
+// provided with a typed client of the api, formatted
+// with go/format
+func Generate(a *Api, pkg string) ([]byte, error) {
+	if a == nil {
+		return nil, errors.Invalid("api: no api to generate")
+	}
+	if pkg == "" {
+		pkg = strings.ToLower(ident(a.Name))
+	}
+	if pkg == "" {
+		return nil, errors.Invalid("api: package name required")
+	}
+	name := a.Name
+	if name == "" {
+		name = pkg
+	}
+	g := &generator{types: map[string]bool{"Client": true}}
+	for _, r := range sortedResources(a) {
+		for _, m := range sortedMethods(r) {
+			g.method(m)
+		}
+	}
+	if g.methods.Len() == 0 {
+		return nil, errors.Invalid("api: no methods to generate")
+	}
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "// Code generated by api.Generate from the %s api. DO NOT EDIT.\n\n", name)
+	fmt.Fprintf(b, "package %s\n\n", pkg)
+	fmt.Fprintf(b, "import (\n\"context\"\n\"strconv\"\n\n\"github.com/jcdotter/go/api\"\n\"github.com/jcdotter/go/errors\"\n)\n\n")
+	fmt.Fprintf(b, "// Client is a typed client of the %s api\n", name)
+	fmt.Fprintf(b, "type Client struct {\nApi *api.Api\n}\n\n")
+	fmt.Fprintf(b, "// New returns a client of the api provided\n")
+	fmt.Fprintf(b, "func New(a *api.Api) *Client {\nreturn &Client{Api: a}\n}\n\n")
+	fmt.Fprintf(b, "func (c *Client) method(resource, method string) (*api.Method, error) {\n")
+	fmt.Fprintf(b, "if r := c.Api.Resource(resource); r != nil {\nif m := r.Method(method); m != nil {\nreturn m, nil\n}\n}\n")
+	fmt.Fprintf(b, "return nil, errors.NotFound(\"%s: method '\" + method + \"' of resource '\" + resource + \"' not found\")\n}\n\n", pkg)
+	b.Write(g.methods.Bytes())
+	b.Write(g.decls.Bytes())
+	b.WriteString(genHelpers)
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return nil, errors.Internal("api: failed to format generated code: " + err.Error())
+	}
+	return src, nil
+}
+
+// GenerateCommand returns a cli command which generates
+// a typed client package of an api spec or openapi
+// document, written to the output file or stdout
+func GenerateCommand() *cli.Command {
+	c := &cli.Command{
+		Name:    "generate",
+		Short:   cli.Msg("generate a typed go client of an api"),
+		Use:     cli.Msg("generate --spec <file> [--package <name>] [--out <file>]"),
+		Example: cli.Msg("generate --spec pets.yml --package pets --out pets/client.go"),
+		Run: func(cmd *cli.Command, args *cli.FlagSet) error {
+			spec := args.Get("spec").Text()
+			if spec == "" {
+				return errors.Invalid("api: --spec required")
+			}
+			doc, err := os.ReadFile(spec)
+			if err != nil {
+				return errors.NotFound("api: failed to read spec '" + spec + "'")
+			}
+			a, err := specApi(doc)
+			if err != nil {
+				return err
+			}
+			src, err := Generate(a, args.Get("package").Text())
+			if err != nil {
+				return err
+			}
+			if out := args.Get("out").Text(); out != "" {
+				return os.WriteFile(out, src, 0644)
+			}
+			_, err = cli.Stdout.Write(src)
+			return err
+		},
+	}
+	c.Flags().AddText("spec", "s", "the api spec or openapi document", "")
+	c.Flags().AddText("package", "p", "the name of the generated package", "")
+	c.Flags().AddText("out", "o", "the file of the generated package", "")
+	return c
+}
+
+// specApi returns the api of an openapi document
+// or an api spec
+func specApi(doc []byte) (*Api, error) {
+	if a, err := FromOpenAPI(doc); err == nil {
+		return a, nil
+	}
+	e := encoder.Yaml.New()
+	if err := e.TryDecode(doc); err != nil {
+		return nil, errors.Invalid("api: invalid api spec: " + err.Error())
+	}
+	m, ok := e.Value().(map[string]any)
+	if !ok {
+		return nil, errors.Invalid("api: invalid api spec: expected an object")
+	}
+	if _, ok = m["url"].(string); !ok {
+		return nil, errors.Invalid("api: invalid api spec: expected a url")
+	}
+	if r, ok := m["resources"]; ok {
+		rs, ok := r.(map[string]any)
+		for _, v := range rs {
+			if _, ok = v.(map[string]any); !ok {
+				break
+			}
+		}
+		if !ok {
+			return nil, errors.Invalid("api: invalid api spec: expected a map of resources")
+		}
+	}
+	if a := FromMap(m); a != nil {
+		return a, nil
+	}
+	return nil, errors.Invalid("api: invalid api spec")
+}
+
+// ----------------------------------------------------------------------------
+// GENERATOR
+
+type generator struct {
+	types   map[string]bool
+	methods bytes.Buffer
+	decls   bytes.Buffer
+}
+
+// gotype is the go type of a param
+type gotype struct {
+	name string // the go type expression
+	kind DataType
+	elem *gotype // the element type of a slice
+}
+
+func (g *generator) method(m *Method) {
+	name := ident(m.Resource.Name) + ident(strings.ToLower(m.Name))
+	var args, sets []string
+	if p := m.Request.Params; isObject(p) {
+		t := g.params(name+"Params", p)
+		args = append(args, "params *"+t)
+		sets = append(sets, "if params != nil {\nfor k, v := range params.value() {\nr.Params.Set(k, v)\n}\n}")
+	}
+	if p := unset(m.Request.Header); isObject(p) {
+		t := g.params(name+"Header", p)
+		args = append(args, "header *"+t)
+		sets = append(sets, "if header != nil {\nfor k, v := range header.value() {\nr.Header.Set(k, v)\n}\n}")
+	}
+	if body := m.Request.Body; body.Data != nil && body.Len() > 0 {
+		t := g.body(name+"Request", body)
+		args = append(args, "body *"+t.name)
+		if t.kind == LIST {
+			sets = append(sets, "if body != nil {\n_, r.Body = api.ParamList(body.value())\n}")
+		} else {
+			sets = append(sets, "if body != nil {\n_, r.Body = api.ParamMap(body.value())\n}")
+		}
+	}
+	res := "*api.Result"
+	body := m.Response.Body
+	if body.Data != nil && body.Len() > 0 {
+		res = "*" + g.body(name+"Response", body).name
+	}
+	b := &g.methods
+	fmt.Fprintf(b, "// %s calls %s %s\n", name, m.Type().String(), m.Resource.Url.Path)
+	fmt.Fprintf(b, "func (c *Client) %s(%s) (%s, error) {\n", name, strings.Join(append([]string{"ctx context.Context"}, args...), ", "), res)
+	fmt.Fprintf(b, "m, err := c.method(%q, %q)\nif err != nil {\nreturn nil, err\n}\n", m.Resource.Name, m.Name)
+	fmt.Fprintf(b, "r := m.Request.Copy()\n")
+	for _, s := range sets {
+		fmt.Fprintf(b, "%s\n", s)
+	}
+	fmt.Fprintf(b, "res, err := m.CallContext(ctx, r)\nif err != nil {\nreturn nil, err\n}\n")
+	fmt.Fprintf(b, "if !res.Ok() {\nreturn nil, errors.Http(res.StatusCode, %q + res.Status)\n}\n", m.Name+" "+m.Resource.Name+": ")
+	if res == "*api.Result" {
+		fmt.Fprintf(b, "return res, nil\n}\n\n")
+		return
+	}
+	fmt.Fprintf(b, "v := new(%s)\nv.decode(res.Body)\nreturn v, nil\n}\n\n", res[1:])
+}
+
+// params declares a struct of the params of a request,
+// which values omit fields with zero values
+func (g *generator) params(name string, p Params) string {
+	return g.object(name, p, true).name
+}
+
+// body declares the type of a request or response body
+func (g *generator) body(name string, p Params) *gotype {
+	if !p.IsSlice() {
+		return g.object(name, p, false)
+	}
+	name = g.name(name)
+	e := g.list(name, p)
+	fmt.Fprintf(&g.decls, "type %s []%s\n\n", name, e.elem.name)
+	fmt.Fprintf(&g.decls, "func (t %s) value() []any {\nreturn %s\n}\n\n", name, valueExpr("[]"+e.elem.name+"(t)", e))
+	fmt.Fprintf(&g.decls, "func (t *%s) decode(v any) {\n*t = %s\n}\n\n", name, decodeExpr("v", e))
+	return &gotype{name: name, kind: LIST, elem: e.elem}
+}
+
+// object declares a struct of the object params
+func (g *generator) object(name string, p Params, omit bool) *gotype {
+	name = g.name(name)
+	var fields, values, omits, decodes []string
+	used := map[string]bool{}
+	for _, e := range sortedParams(p) {
+		f := ident(e.key)
+		if f == "" || used[f] {
+			f = fmt.Sprintf("Field%d", len(used))
+		}
+		used[f] = true
+		t := g.param(name+f, e)
+		fields = append(fields, fmt.Sprintf("%s %s `json:%q`", f, t.name, e.key))
+		values = append(values, fmt.Sprintf("%q: %s,", e.key, valueExpr("t."+f, t)))
+		omits = append(omits, fmt.Sprintf("if %s {\nm[%q] = %s\n}", nonZero("t."+f, t), e.key, valueExpr("t."+f, t)))
+		decodes = append(decodes, fmt.Sprintf("t.%s = %s", f, decodeExpr(fmt.Sprintf("m[%q]", e.key), t)))
+	}
+	d := &g.decls
+	fmt.Fprintf(d, "type %s struct {\n%s\n}\n\n", name, strings.Join(fields, "\n"))
+	if omit {
+		fmt.Fprintf(d, "func (t %s) value() map[string]any {\nm := map[string]any{}\n%s\nreturn m\n}\n\n", name, strings.Join(omits, "\n"))
+	} else {
+		fmt.Fprintf(d, "func (t %s) value() map[string]any {\nreturn map[string]any{\n%s\n}\n}\n\n", name, strings.Join(values, "\n"))
+	}
+	if len(decodes) == 0 {
+		fmt.Fprintf(d, "func (t *%s) decode(v any) {}\n\n", name)
+	} else {
+		fmt.Fprintf(d, "func (t *%s) decode(v any) {\nm, _ := v.(map[string]any)\n%s\n}\n\n", name, strings.Join(decodes, "\n"))
+	}
+	return &gotype{name: name, kind: OBJECT}
+}
+
+// list returns the slice type of the list params
+func (g *generator) list(name string, p Params) *gotype {
+	if p.Len() != 1 {
+		return &gotype{name: "[]any", kind: LIST, elem: &gotype{name: "any", kind: ANY}}
+	}
+	e := g.param(name+"Item", p.Index(0))
+	return &gotype{name: "[]" + e.name, kind: LIST, elem: e}
+}
+
+// param returns the go type of the param,
+// declaring the structs of objects
+func (g *generator) param(name string, p *Param) *gotype {
+	switch p.typ {
+	case BOOL:
+		return &gotype{name: "bool", kind: BOOL}
+	case INT:
+		return &gotype{name: "int", kind: INT}
+	case FLOAT:
+		return &gotype{name: "float64", kind: FLOAT}
+	case STRING:
+		return &gotype{name: "string", kind: STRING}
+	case OBJECT:
+		if p.els.Data == nil || p.els.Len() == 0 {
+			return &gotype{name: "map[string]any", kind: ANY}
+		}
+		return g.object(name, p.els, false)
+	case LIST:
+		if p.els.Data == nil {
+			return g.list(name, Params{})
+		}
+		return g.list(name, p.els)
+	}
+	return &gotype{name: "any", kind: ANY}
+}
+
+// name returns a unique type name of the name provided
+func (g *generator) name(name string) string {
+	n := name
+	for i := 2; g.types[n]; i++ {
+		n = fmt.Sprintf("%s%d", name, i)
+	}
+	g.types[n] = true
+	return n
+}
+
+// valueExpr returns the expression converting the go
+// value of the expression provided to a param value
+func valueExpr(x string, t *gotype) string {
+	switch {
+	case t.kind == OBJECT:
+		return x + ".value()"
+	case t.kind == LIST && t.elem.kind != ANY:
+		return fmt.Sprintf("listValue(%s, func(e %s) any { return %s })", x, t.elem.name, valueExpr("e", t.elem))
+	case t.kind == LIST:
+		return x
+	}
+	return x
+}
+
+// nonZero returns the expression testing if the
+// go value of the expression provided is not zero
+func nonZero(x string, t *gotype) string {
+	switch t.kind {
+	case BOOL:
+		return x
+	case INT, FLOAT:
+		return x + " != 0"
+	case STRING:
+		return x + ` != ""`
+	case LIST:
+		return "len(" + x + ") > 0"
+	case OBJECT:
+		return "true"
+	}
+	return x + " != nil"
+}
+
+// decodeExpr returns the expression converting the
+// decoded value of the expression provided to go
+func decodeExpr(x string, t *gotype) string {
+	switch t.kind {
+	case BOOL:
+		return "asBool(" + x + ")"
+	case INT:
+		return "asInt(" + x + ")"
+	case FLOAT:
+		return "asFloat(" + x + ")"
+	case STRING:
+		return "asString(" + x + ")"
+	case OBJECT:
+		return "decode[" + t.name + "](" + x + ")"
+	case LIST:
+		if t.elem.kind == ANY && t.elem.name == "any" {
+			return "asList(" + x + ")"
+		}
+		return fmt.Sprintf("listDecode(%s, func(e any) %s { return %s })", x, t.elem.name, decodeExpr("e", t.elem))
+	}
+	if t.name == "map[string]any" {
+		return "asMap(" + x + ")"
+	}
+	return x
+}
+
+// ident returns the exported go identifier of a key
+func ident(s string) string {
+	var b strings.Builder
+	up := true
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			up = true
+			continue
+		}
+		if b.Len() == 0 && unicode.IsDigit(r) {
+			b.WriteByte('X')
+		}
+		if up {
+			r, up = unicode.ToUpper(r), false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// isObject returns true if the params are an object
+// with elements
+func isObject(p Params) bool {
+	return p.Data != nil && !p.IsSlice() && p.Len() > 0
+}
+
+// unset returns the params without a value set
+func unset(p Params) Params {
+	u := Params{data.Make[*Param](4)}
+	for i := 0; p.Data != nil && i < p.Len(); i++ {
+		if e := p.Index(i); e != nil && e.val == nil {
+			u.Add(e)
+		}
+	}
+	return u
+}
+
+func sortedResources(a *Api) (rs []*Resource) {
+	for i := 0; i < a.Resources.Len(); i++ {
+		if r, ok := a.Resources.Index(i).(*Resource); ok {
+			rs = append(rs, r)
+		}
+	}
+	sort.Slice(rs, func(i, j int) bool { return rs[i].Name < rs[j].Name })
+	return
+}
+
+func sortedMethods(r *Resource) (ms []*Method) {
+	for i := 0; i < r.Methods.Len(); i++ {
+		if m, ok := r.Methods.Index(i).(*Method); ok {
+			ms = append(ms, m)
+		}
+	}
+	sort.Slice(ms, func(i, j int) bool { return ms[i].Type() < ms[j].Type() })
+	return
+}
+
+func sortedParams(p Params) (ps []*Param) {
+	for i := 0; i < p.Len(); i++ {
+		if e := p.Index(i); e != nil {
+			ps = append(ps, e)
+		}
+	}
+	sort.Slice(ps, func(i, j int) bool { return ps[i].key < ps[j].key })
+	return
+}
+
+// genHelpers are the helpers of the generated package
+// converting between go and decoded values
+const genHelpers = `
+func decode[T any, P interface {
+	*T
+	decode(any)
+}](v any) (t T) {
+	P(&t).decode(v)
+	return
+}
+
+func listValue[T any](l []T, f func(T) any) []any {
+	if l == nil {
+		return nil
+	}
+	v := make([]any, len(l))
+	for i, e := range l {
+		v[i] = f(e)
+	}
+	return v
+}
+
+func listDecode[T any](v any, f func(any) T) []T {
+	l, _ := v.([]any)
+	if l == nil {
+		return nil
+	}
+	t := make([]T, len(l))
+	for i, e := range l {
+		t[i] = f(e)
+	}
+	return t
+}
+
+func asBool(v any) bool {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
+
+func asInt(v any) int {
+	switch v := v.(type) {
+	case int:
+		return v
+	case float64:
+		return int(v)
+	case string:
+		i, _ := strconv.Atoi(v)
+		return i
+	}
+	return 0
+}
+
+func asFloat(v any) float64 {
+	switch v := v.(type) {
+	case int:
+		return float64(v)
+	case float64:
+		return v
+	case string:
+		f, _ := strconv.ParseFloat(v, 64)
+		return f
+	}
+	return 0
+}
+
+func asString(v any) string {
+	s, _ := v.(string)
+	return s
+}
+
+func asList(v any) []any {
+	l, _ := v.([]any)
+	return l
+}
+
+func asMap(v any) map[string]any {
+	m, _ := v.(map[string]any)
+	return m
+}
+`
@@ -0,0 +1,577 @@
+// Copyright 2023 james dotter.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://github.com/jcdotter/go/LICENSE
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/jcdotter/go/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// ----------------------------------------------------------------------------
+// GRPC
+// calls the unary methods of a grpc api, declared in the api
+// spec with the grpc protocol, where the resources are the
+// services of the api, named by their full name:
+//
+//	name: greeter
+//	protocol: grpc
+//	url: grpc://localhost:50051   # grpcs for tls
+//	descriptors: greeter.protoset # descriptor set of the services,
+//	                              # requested with server reflection
+//	                              # when not provided
+//	resources:
+//	  helloworld.Greeter:
+//	    methods:
+//	      SayHello:
+//	        request:
+//	          header:
+//	            x-request-id: string
+//	          body:
+//	            name: string
+//	        response:
+//	          body:
+//	            message: string
+//
+// The request message is built from the body and params of the
+// request, keyed by the proto or json names of its fields, and
+// the request header is sent as metadata along with the header
+// of the api authenticator. The response message is decoded to
+// the body of the result, keyed by the proto names of its fields,
+// where 64 bit integers are ints, enums are the names of their
+// values and bytes are base64 strings. Errors returned by the
+// api are mapped to an errors.Status of the same code.
+
+// Dial connects a grpc api to the host of its url with the
+// options provided, using tls when the url scheme is grpcs
+// or https. Apis are dialed on their first call when not
+// connected.
+func (a *Api) Dial(opts ...grpc.DialOption) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.dial(opts...)
+}
+
+func (a *Api) dial(opts ...grpc.DialOption) error {
+	if a.Conn != nil {
+		return nil
+	}
+	if a.Url == nil {
+		return errors.Failed("api: grpc api '" + a.Name + "' has no url")
+	}
+	creds := insecure.NewCredentials()
+	if a.Url.Scheme == "grpcs" || a.Url.Scheme == "https" {
+		creds = credentials.NewTLS(&tls.Config{ServerName: a.Url.Hostname()})
+	}
+	opts = append([]grpc.DialOption{grpc.WithTransportCredentials(creds)}, opts...)
+	c, err := grpc.Dial(a.Url.Host, opts...)
+	if err != nil {
+		return errors.Unavailable("api: grpc dial failed: " + err.Error())
+	}
+	a.Conn = c
+	return nil
+}
+
+// Close closes the grpc connection of the api, if any
+func (a *Api) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	c, ok := a.Conn.(io.Closer)
+	if !ok {
+		return nil
+	}
+	a.Conn = nil
+	return c.Close()
+}
+
+// LoadDescriptors returns the descriptors of the files
+// in the binary descriptor set file provided, as written
+// by protoc --descriptor_set_out --include_imports
+func LoadDescriptors(path string) (*protoregistry.Files, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.NotFound("api: descriptor set '" + path + "' not found")
+	}
+	s := &descriptorpb.FileDescriptorSet{}
+	if err = proto.Unmarshal(b, s); err != nil {
+		return nil, errors.Invalid("api: invalid descriptor set '" + path + "': " + err.Error())
+	}
+	f, err := protodesc.NewFiles(s)
+	if err != nil {
+		return nil, errors.Invalid("api: invalid descriptor set '" + path + "': " + err.Error())
+	}
+	return f, nil
+}
+
+// Rpc returns the descriptor of the grpc method, loading
+// the descriptors of the api when not loaded. Returns an
+// errors.Unimplemented status for streaming methods.
+func (m *Method) Rpc(ctx context.Context) (protoreflect.MethodDescriptor, error) {
+	a := m.Resource.Api
+	if a == nil || a.Protocol != GRPC {
+		return nil, errors.Failed("api: method '" + m.Name + "' is not a grpc method")
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.Descriptors == nil && a.DescriptorSet != "" {
+		f, err := LoadDescriptors(a.DescriptorSet)
+		if err != nil {
+			return nil, err
+		}
+		a.Descriptors = f
+	}
+	name := protoreflect.FullName(m.Resource.Name)
+	d := findDescriptor(a.Descriptors, name)
+	if d == nil && a.DescriptorSet == "" {
+		if err := a.dial(); err != nil {
+			return nil, err
+		}
+		if err := a.reflect(ctx, name); err != nil {
+			return nil, err
+		}
+		d = findDescriptor(a.Descriptors, name)
+	}
+	s, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, errors.NotFound("api: grpc service '" + m.Resource.Name + "' not found")
+	}
+	md := s.Methods().ByName(protoreflect.Name(m.Name))
+	if md == nil {
+		return nil, errors.NotFound("api: grpc method '" + m.Resource.Name + "/" + m.Name + "' not found")
+	}
+	if md.IsStreamingClient() || md.IsStreamingServer() {
+		return nil, errors.Unimplemented("api: grpc streaming method '" + m.Resource.Name + "/" + m.Name + "' not supported")
+	}
+	return md, nil
+}
+
+// invoke calls the grpc method with the request provided
+func (m *Method) invoke(ctx context.Context, rq *Request) (*Result, error) {
+	md, err := m.Rpc(ctx)
+	if err != nil {
+		return nil, err
+	}
+	a := m.Resource.Api
+	a.mu.Lock()
+	err = a.dial()
+	conn := a.Conn
+	a.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	in := dynamicpb.NewMessage(md.Input())
+	if err = protoMessage(in, rq.message()); err != nil {
+		return nil, err
+	}
+	meta, err := m.metadata(ctx, rq)
+	if err != nil {
+		return nil, err
+	}
+	if err = m.limit(ctx); err != nil {
+		return nil, err
+	}
+	out := dynamicpb.NewMessage(md.Output())
+	var header, trailer metadata.MD
+	path := "/" + string(md.Parent().FullName()) + "/" + string(md.Name())
+	err = conn.Invoke(metadata.NewOutgoingContext(ctx, meta), path, in, out, grpc.Header(&header), grpc.Trailer(&trailer))
+	if err != nil {
+		return nil, errors.Grpc(err)
+	}
+	res := &Result{
+		StatusCode: http.StatusOK,
+		Status:     codes.OK.String(),
+		Header:     http.Header{},
+		Body:       messageMap(out),
+	}
+	for _, md := range []metadata.MD{header, trailer} {
+		for k, v := range md {
+			for _, v := range v {
+				res.Header.Add(k, v)
+			}
+		}
+	}
+	return res, nil
+}
+
+// metadata returns the metadata of the request header
+// and the header set by the api authenticator
+func (m *Method) metadata(ctx context.Context, rq *Request) (metadata.MD, error) {
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, m.Resource.Url.String(), nil)
+	if err != nil {
+		return nil, errors.Invalid(err.Error())
+	}
+	for k, v := range rq.Header.Map() {
+		r.Header.Set(k, formatValue(v))
+	}
+	if err = m.authorize(r); err != nil {
+		return nil, err
+	}
+	md := metadata.MD{}
+	for k, v := range r.Header {
+		md.Append(k, v...)
+	}
+	return md, nil
+}
+
+// message returns the values of the request params
+// and body as the fields of a grpc request message
+func (r *Request) message() map[string]any {
+	m := map[string]any{}
+	for k, v := range r.Params.Map() {
+		m[k] = v
+	}
+	if b, ok := r.Body.Value().(map[string]any); ok {
+		for k, v := range b {
+			m[k] = v
+		}
+	}
+	return m
+}
+
+// ----------------------------------------------------------------------------
+// REFLECTION
+
+// reflect requests the file descriptors of the symbol provided
+// from the reflection service of the api, and the files it
+// depends on, adding them to the descriptors of the api
+func (a *Api) reflect(ctx context.Context, symbol protoreflect.FullName) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := rpb.NewServerReflectionClient(a.Conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return errors.Grpc(err)
+	}
+	defer stream.CloseSend()
+	files := map[string]*descriptorpb.FileDescriptorProto{}
+	req := &rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: string(symbol)},
+	}
+	for req != nil {
+		if err = stream.Send(req); err != nil {
+			return errors.Grpc(err)
+		}
+		res, err := stream.Recv()
+		if err != nil {
+			return errors.Grpc(err)
+		}
+		if e := res.GetErrorResponse(); e != nil {
+			return errors.NewStatus(errors.Code(e.ErrorCode), "api: grpc reflection of '"+string(symbol)+"' failed: "+e.ErrorMessage)
+		}
+		for _, b := range res.GetFileDescriptorResponse().GetFileDescriptorProto() {
+			f := &descriptorpb.FileDescriptorProto{}
+			if err = proto.Unmarshal(b, f); err != nil {
+				return errors.Invalid("api: invalid grpc reflection response: " + err.Error())
+			}
+			files[f.GetName()] = f
+		}
+		// request the dependencies not yet received
+		req = nil
+		for _, f := range files {
+			for _, d := range f.GetDependency() {
+				if _, ok := files[d]; ok {
+					continue
+				}
+				if g, err := protoregistry.GlobalFiles.FindFileByPath(d); err == nil {
+					files[d] = protodesc.ToFileDescriptorProto(g)
+					continue
+				}
+				req = &rpb.ServerReflectionRequest{
+					MessageRequest: &rpb.ServerReflectionRequest_FileByFilename{FileByFilename: d},
+				}
+			}
+		}
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	for _, f := range files {
+		set.File = append(set.File, f)
+	}
+	fs, err := protodesc.NewFiles(set)
+	if err != nil {
+		return errors.Invalid("api: invalid grpc reflection response: " + err.Error())
+	}
+	if a.Descriptors == nil {
+		a.Descriptors = &protoregistry.Files{}
+	}
+	fs.RangeFiles(func(f protoreflect.FileDescriptor) bool {
+		if _, e := a.Descriptors.FindFileByPath(f.Path()); e != nil {
+			if e = a.Descriptors.RegisterFile(f); e != nil {
+				err = errors.Invalid("api: invalid grpc reflection response: " + e.Error())
+			}
+		}
+		return err == nil
+	})
+	return err
+}
+
+func findDescriptor(f *protoregistry.Files, name protoreflect.FullName) protoreflect.Descriptor {
+	if f == nil {
+		return nil
+	}
+	d, _ := f.FindDescriptorByName(name)
+	return d
+}
+
+// ----------------------------------------------------------------------------
+// MESSAGES
+
+// protoMessage sets the fields of the message to the values of
+// the map provided, keyed by the proto or json field names
+func protoMessage(msg protoreflect.Message, m map[string]any) error {
+	fields := msg.Descriptor().Fields()
+	for k, v := range m {
+		fd := fields.ByName(protoreflect.Name(k))
+		if fd == nil {
+			fd = fields.ByJSONName(k)
+		}
+		if fd == nil {
+			return errors.Invalid("api: unknown field '" + k + "' of message '" + string(msg.Descriptor().FullName()) + "'")
+		}
+		if v == nil {
+			continue
+		}
+		switch {
+		case fd.IsList():
+			l, ok := v.([]any)
+			if !ok {
+				return invalidField(fd, v)
+			}
+			list := msg.Mutable(fd).List()
+			for _, e := range l {
+				pv, err := protoValue(fd, e, list.NewElement)
+				if err != nil {
+					return err
+				}
+				list.Append(pv)
+			}
+		case fd.IsMap():
+			mv, ok := v.(map[string]any)
+			if !ok {
+				return invalidField(fd, v)
+			}
+			pm := msg.Mutable(fd).Map()
+			for k, e := range mv {
+				pk, err := protoValue(fd.MapKey(), k, nil)
+				if err != nil {
+					return err
+				}
+				pv, err := protoValue(fd.MapValue(), e, pm.NewValue)
+				if err != nil {
+					return err
+				}
+				pm.Set(pk.MapKey(), pv)
+			}
+		default:
+			pv, err := protoValue(fd, v, func() protoreflect.Value { return msg.NewField(fd) })
+			if err != nil {
+				return err
+			}
+			msg.Set(fd, pv)
+		}
+	}
+	return nil
+}
+
+// protoValue returns the proto value of a single value of the
+// field provided, using newValue to create message values
+func protoValue(fd protoreflect.FieldDescriptor, v any, newValue func() protoreflect.Value) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		if m, ok := v.(map[string]any); ok {
+			pv := newValue()
+			return pv, protoMessage(pv.Message(), m)
+		}
+	case protoreflect.EnumKind:
+		if s, ok := v.(string); ok {
+			if e := fd.Enum().Values().ByName(protoreflect.Name(s)); e != nil {
+				return protoreflect.ValueOfEnum(e.Number()), nil
+			}
+		}
+		if n, ok := integer(v); ok && n >= math.MinInt32 && n <= math.MaxInt32 {
+			return protoreflect.ValueOfEnum(protoreflect.EnumNumber(n)), nil
+		}
+	case protoreflect.BoolKind:
+		switch v := v.(type) {
+		case bool:
+			return protoreflect.ValueOfBool(v), nil
+		case string:
+			if b, err := strconv.ParseBool(v); err == nil {
+				return protoreflect.ValueOfBool(b), nil
+			}
+		}
+	case protoreflect.StringKind:
+		if s, ok := v.(string); ok {
+			return protoreflect.ValueOfString(s), nil
+		}
+	case protoreflect.BytesKind:
+		switch v := v.(type) {
+		case []byte:
+			return protoreflect.ValueOfBytes(v), nil
+		case string:
+			if b, err := base64.StdEncoding.DecodeString(v); err == nil {
+				return protoreflect.ValueOfBytes(b), nil
+			}
+		}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		if n, ok := integer(v); ok && n >= math.MinInt32 && n <= math.MaxInt32 {
+			return protoreflect.ValueOfInt32(int32(n)), nil
+		}
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		if n, ok := integer(v); ok {
+			return protoreflect.ValueOfInt64(n), nil
+		}
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		if n, ok := unsigned(v); ok && n <= math.MaxUint32 {
+			return protoreflect.ValueOfUint32(uint32(n)), nil
+		}
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		if n, ok := unsigned(v); ok {
+			return protoreflect.ValueOfUint64(n), nil
+		}
+	case protoreflect.FloatKind:
+		if f, ok := float(v); ok {
+			return protoreflect.ValueOfFloat32(float32(f)), nil
+		}
+	case protoreflect.DoubleKind:
+		if f, ok := float(v); ok {
+			return protoreflect.ValueOfFloat64(f), nil
+		}
+	}
+	return protoreflect.Value{}, invalidField(fd, v)
+}
+
+// messageMap returns the populated fields of the
+// message as a map keyed by the proto field names
+func messageMap(msg protoreflect.Message) map[string]any {
+	m := map[string]any{}
+	msg.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.IsList():
+			l := make([]any, v.List().Len())
+			for i := range l {
+				l[i] = fieldValue(fd, v.List().Get(i))
+			}
+			m[string(fd.Name())] = l
+		case fd.IsMap():
+			mv := map[string]any{}
+			v.Map().Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+				mv[k.String()] = fieldValue(fd.MapValue(), v)
+				return true
+			})
+			m[string(fd.Name())] = mv
+		default:
+			m[string(fd.Name())] = fieldValue(fd, v)
+		}
+		return true
+	})
+	return m
+}
+
+// fieldValue returns a single value of the field provided
+func fieldValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) any {
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return messageMap(v.Message())
+	case protoreflect.EnumKind:
+		if e := fd.Enum().Values().ByNumber(v.Enum()); e != nil {
+			return string(e.Name())
+		}
+		return int(v.Enum())
+	case protoreflect.BoolKind:
+		return v.Bool()
+	case protoreflect.StringKind:
+		return v.String()
+	case protoreflect.BytesKind:
+		return base64.StdEncoding.EncodeToString(v.Bytes())
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return int(v.Int())
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return int(v.Uint())
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		// values above the max int are not representable as ints
+		return v.Uint()
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return v.Float()
+	}
+	return v.Interface()
+}
+
+func invalidField(fd protoreflect.FieldDescriptor, v any) error {
+	return errors.Invalid(fmt.Sprintf("api: invalid value '%v' of field '%s'", v, fd.FullName()))
+}
+
+func integer(v any) (int64, bool) {
+	switch v := v.(type) {
+	case int:
+		return int64(v), true
+	case int64:
+		return v, true
+	case float64:
+		if v == math.Trunc(v) {
+			return int64(v), true
+		}
+	case string:
+		n, err := strconv.ParseInt(v, 10, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+func unsigned(v any) (uint64, bool) {
+	switch v := v.(type) {
+	case uint64:
+		return v, true
+	case string:
+		n, err := strconv.ParseUint(v, 10, 64)
+		return n, err == nil
+	}
+	if n, ok := integer(v); ok && n >= 0 {
+		return uint64(n), true
+	}
+	return 0, false
+}
+
+func float(v any) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case float64:
+		return v, true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,256 @@
+// Copyright 2023 james dotter.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://github.com/jcdotter/go/LICENSE
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ----------------------------------------------------------------------------
+// MOCK SERVER
+// an httptest server of an api which answers every method
+// with a response synthesized from the method response
+// params, unless overridden, and records the calls received
+
+// Mock is an httptest server of an api
+type Mock struct {
+	*httptest.Server
+	server    *Server
+	mu        sync.Mutex
+	calls     []MockCall
+	overrides map[*Method]*Override
+}
+
+// MockCall is a call received by a mock server
+type MockCall struct {
+	Resource string
+	Method   string
+	Params   map[string]any
+	Header   http.Header
+	Body     any
+	Time     time.Time
+}
+
+// Override is the response of a mock method which
+// replaces the synthesized response
+type Override struct {
+	status  int
+	body    any
+	header  http.Header
+	latency time.Duration
+}
+
+// NewMock starts and returns a mock server of the api.
+// The mock should be closed when no longer needed.
+func NewMock(a *Api) *Mock {
+	m := &Mock{server: NewServer(a), overrides: map[*Method]*Override{}}
+	for i := 0; i < a.Resources.Len(); i++ {
+		r, ok := a.Resources.Index(i).(*Resource)
+		if !ok {
+			continue
+		}
+		for j := 0; j < r.Methods.Len(); j++ {
+			if me, ok := r.Methods.Index(j).(*Method); ok {
+				m.server.handlers[me] = m.handler(me)
+			}
+		}
+	}
+	m.Server = httptest.NewServer(m.server)
+	return m
+}
+
+// Client returns an http client which sends all requests
+// to the mock server. Setting the client of the api
+// directs the calls of the api to the mock server.
+func (m *Mock) Client() *http.Client {
+	u, _ := url.Parse(m.URL)
+	return &http.Client{Transport: &mockTransport{u, m.Server.Client().Transport}}
+}
+
+// On returns the override of the resource method provided,
+// which replaces the synthesized response of the method.
+// Panics if the resource method is not declared in the api.
+func (m *Mock) On(resource, method string) *Override {
+	r := m.server.Api.Resource(resource)
+	if r == nil {
+		panic("api: mock resource '" + resource + "' not found")
+	}
+	me := r.Method(method)
+	if me == nil {
+		panic("api: mock method '" + method + "' of resource '" + resource + "' not found")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if o, ok := m.overrides[me]; ok {
+		return o
+	}
+	o := &Override{header: http.Header{}}
+	m.overrides[me] = o
+	return o
+}
+
+// Calls returns the calls received by the mock server
+func (m *Mock) Calls() []MockCall {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	calls := make([]MockCall, len(m.calls))
+	copy(calls, m.calls)
+	return calls
+}
+
+// Reset clears the overrides and calls of the mock server
+func (m *Mock) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = nil
+	m.overrides = map[*Method]*Override{}
+}
+
+func (m *Mock) handler(me *Method) Handler {
+	return func(c *Call) (*Result, error) {
+		m.mu.Lock()
+		m.calls = append(m.calls, MockCall{
+			Resource: me.Resource.Name,
+			Method:   me.Name,
+			Params:   c.Params,
+			Header:   c.Header,
+			Body:     c.Body,
+			Time:     time.Now(),
+		})
+		var o Override
+		if ov, ok := m.overrides[me]; ok {
+			o = *ov
+		}
+		m.mu.Unlock()
+		if o.latency > 0 {
+			select {
+			case <-time.After(o.latency):
+			case <-c.Request.Context().Done():
+				return nil, c.Request.Context().Err()
+			}
+		}
+		res := &Result{StatusCode: o.status, Header: http.Header{}, Body: o.body}
+		for i := 0; i < me.Response.Header.Len(); i++ {
+			if p := me.Response.Header.Index(i); p != nil {
+				res.Header.Set(p.key, formatValue(p.Sample()))
+			}
+		}
+		for k, v := range o.header {
+			res.Header[k] = v
+		}
+		if res.Body == nil {
+			res.Body = me.Response.Body.Sample()
+		}
+		return res, nil
+	}
+}
+
+// Status sets the status code of the response
+func (o *Override) Status(code int) *Override {
+	o.status = code
+	return o
+}
+
+// Body sets the body of the response, which is encoded
+// according to the response content type, unless a
+// string or []byte is provided
+func (o *Override) Body(body any) *Override {
+	o.body = body
+	return o
+}
+
+// Header sets a header of the response
+func (o *Override) Header(key, value string) *Override {
+	o.header.Set(key, value)
+	return o
+}
+
+// Latency sets the delay before the response is written
+func (o *Override) Latency(d time.Duration) *Override {
+	o.latency = d
+	return o
+}
+
+type mockTransport struct {
+	url *url.URL
+	rt  http.RoundTripper
+}
+
+func (t *mockTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme, r.URL.Host, r.Host = t.url.Scheme, t.url.Host, t.url.Host
+	return t.rt.RoundTrip(r)
+}
+
+// ----------------------------------------------------------------------------
+// SAMPLES
+
+// Sample returns a sample value of the params, as a list
+// if the params are a list, otherwise as an object
+func (p Params) Sample() any {
+	if p.Data == nil {
+		return nil
+	}
+	if p.IsSlice() {
+		l := make([]any, 0, p.Len())
+		for i := 0; i < p.Len(); i++ {
+			l = append(l, p.Index(i).Sample())
+		}
+		return l
+	}
+	m := make(map[string]any, p.Len())
+	for i := 0; i < p.Len(); i++ {
+		e := p.Index(i)
+		m[e.key] = e.Sample()
+	}
+	return m
+}
+
+// Sample returns the value of the param if set, otherwise
+// a sample value of the param datatype
+func (p *Param) Sample() any {
+	if p.val != nil {
+		return p.val
+	}
+	switch p.typ {
+	case BOOL:
+		return true
+	case INT:
+		return 1
+	case FLOAT:
+		return 1.5
+	case STRING:
+		if _, err := strconv.Atoi(p.key); err == nil {
+			return STRING.String()
+		}
+		return p.key
+	case LIST:
+		if l := p.els.Sample(); l != nil {
+			return l
+		}
+		return []any{}
+	case OBJECT:
+		if m := p.els.Sample(); m != nil {
+			return m
+		}
+		return map[string]any{}
+	}
+	return nil
+}
@@ -0,0 +1,576 @@
+// Copyright 2023 james dotter.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://github.com/jcdotter/go/LICENSE
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/jcdotter/go/encoder"
+	"github.com/jcdotter/go/errors"
+)
+
+// ----------------------------------------------------------------------------
+// OPENAPI IMPORT
+// converts an OpenAPI 3.x document into the map structure
+// read by FromMap (url/resources/uri/methods/request/response),
+// where each path is a resource keyed by the path, each
+// operation is a method and each schema is converted to
+// the datatype names used to build params
+
+// FromOpenAPI returns an Api from an OpenAPI 3.x document
+// in yaml or json format
+func FromOpenAPI(doc []byte) (*Api, error) {
+	e := encoder.Yaml.New()
+	if json(doc) {
+		e = encoder.Json.New()
+	}
+	if err := e.TryDecode(doc); err != nil {
+		return nil, errors.Invalid("api: invalid openapi document: " + err.Error())
+	}
+	m, ok := e.Value().(map[string]any)
+	if !ok {
+		return nil, errors.Invalid("api: invalid openapi document: expected an object")
+	}
+	return OpenAPIMap(m)
+}
+
+// OpenAPIMap returns an Api from a decoded OpenAPI 3.x document
+func OpenAPIMap(m map[string]any) (*Api, error) {
+	if v, _ := m["openapi"].(string); !strings.HasPrefix(v, "3.") {
+		return nil, errors.Invalid("api: unsupported openapi version '" + v + "'")
+	}
+	o := &openapi{doc: m}
+	spec, err := o.spec()
+	if err != nil {
+		return nil, err
+	}
+	api := FromMap(spec)
+	if api == nil {
+		return nil, errors.Invalid("api: invalid openapi server url")
+	}
+	return api, nil
+}
+
+type openapi struct {
+	doc  map[string]any
+	refs []string // refs being resolved, to stop recursion
+}
+
+func (o *openapi) spec() (map[string]any, error) {
+	u := "/"
+	if l, ok := o.doc["servers"].([]any); ok && len(l) > 0 {
+		if s, ok := l[0].(map[string]any); ok {
+			if v, ok := s["url"].(string); ok {
+				u = v
+			}
+		}
+	}
+	resources := map[string]any{}
+	paths, _ := o.doc["paths"].(map[string]any)
+	for p, v := range paths {
+		item, err := o.deref(v)
+		if err != nil {
+			return nil, err
+		}
+		r, err := o.resource(p, item)
+		if err != nil {
+			return nil, err
+		}
+		resources[p] = r
+	}
+	spec := map[string]any{"url": u, "resources": resources}
+	if info, ok := o.doc["info"].(map[string]any); ok {
+		if t, ok := info["title"].(string); ok {
+			spec["name"] = t
+		}
+	}
+	return spec, nil
+}
+
+func (o *openapi) resource(p string, item map[string]any) (map[string]any, error) {
+	methods := map[string]any{}
+	for k, v := range item {
+		name := strings.ToUpper(k)
+		if _, ok := methodType[name]; !ok {
+			continue
+		}
+		op, err := o.deref(v)
+		if err != nil {
+			return nil, err
+		}
+		params := append(append([]any{}, anyList(item["parameters"])...), anyList(op["parameters"])...)
+		req, err := o.request(params, op["requestBody"])
+		if err != nil {
+			return nil, err
+		}
+		res, err := o.response(op["responses"])
+		if err != nil {
+			return nil, err
+		}
+		methods[name] = map[string]any{"request": req, "response": res}
+	}
+	return map[string]any{"uri": openapiUri(p), "methods": methods}, nil
+}
+
+func (o *openapi) request(params []any, body any) (map[string]any, error) {
+	query, header := map[string]any{}, map[string]any{}
+	for _, v := range params {
+		p, err := o.deref(v)
+		if err != nil {
+			return nil, err
+		}
+		name, _ := p["name"].(string)
+		s, err := o.schema(p["schema"])
+		if err != nil {
+			return nil, err
+		}
+		switch p["in"] {
+		case "path", "query":
+			query[name] = s
+		case "header":
+			header[strings.ToLower(name)] = s
+		}
+	}
+	req := map[string]any{"params": query, "header": header}
+	if body != nil {
+		b, err := o.deref(body)
+		if err != nil {
+			return nil, err
+		}
+		t, s, err := o.content(b["content"])
+		if err != nil {
+			return nil, err
+		}
+		if t != "" {
+			header["content-type"] = t
+		}
+		if s != nil {
+			req["body"] = s
+		}
+	}
+	return req, nil
+}
+
+func (o *openapi) response(responses any) (map[string]any, error) {
+	rs, _ := responses.(map[string]any)
+	var code string
+	for k := range rs {
+		if len(k) == 3 && k[0] == '2' && (code == "" || k < code) {
+			code = k
+		}
+	}
+	if code == "" {
+		code = "default"
+	}
+	res := map[string]any{}
+	v, ok := rs[code]
+	if !ok {
+		return res, nil
+	}
+	r, err := o.deref(v)
+	if err != nil {
+		return nil, err
+	}
+	header := map[string]any{}
+	if hs, ok := r["headers"].(map[string]any); ok {
+		for k, v := range hs {
+			h, err := o.deref(v)
+			if err != nil {
+				return nil, err
+			}
+			if header[strings.ToLower(k)], err = o.schema(h["schema"]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	t, s, err := o.content(r["content"])
+	if err != nil {
+		return nil, err
+	}
+	if t != "" {
+		header["content-type"] = t
+	}
+	res["header"] = header
+	if s != nil {
+		res["body"] = s
+	}
+	return res, nil
+}
+
+// content returns the media type and schema of the json
+// content, or of the first media type if not json
+func (o *openapi) content(content any) (string, any, error) {
+	c, _ := content.(map[string]any)
+	if len(c) == 0 {
+		return "", nil, nil
+	}
+	t := contentString[JSON]
+	if _, ok := c[t]; !ok {
+		keys := make([]string, 0, len(c))
+		for k := range c {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		t = keys[0]
+	}
+	m, _ := c[t].(map[string]any)
+	if m["schema"] == nil {
+		return t, nil, nil
+	}
+	s, err := o.schema(m["schema"])
+	return t, s, err
+}
+
+// schema converts the schema provided to a datatype name,
+// or a map or list of datatype names for objects and arrays
+func (o *openapi) schema(v any) (any, error) {
+	if v == nil {
+		return ANY.String(), nil
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		return ANY.String(), nil
+	}
+	if ref, ok := m["$ref"].(string); ok {
+		for _, r := range o.refs {
+			if r == ref {
+				return OBJECT.String(), nil
+			}
+		}
+		s, err := o.resolve(ref)
+		if err != nil {
+			return nil, err
+		}
+		o.refs = append(o.refs, ref)
+		defer func() { o.refs = o.refs[:len(o.refs)-1] }()
+		return o.schema(s)
+	}
+	if all, ok := m["allOf"].([]any); ok {
+		return o.allOf(all)
+	}
+	if m["oneOf"] != nil || m["anyOf"] != nil {
+		return ANY.String(), nil
+	}
+	t, _ := m["type"].(string)
+	if t == "" && m["properties"] != nil {
+		t = "object"
+	}
+	switch t {
+	case "object":
+		props, _ := m["properties"].(map[string]any)
+		if len(props) == 0 {
+			return OBJECT.String(), nil
+		}
+		obj := make(map[string]any, len(props))
+		for k, p := range props {
+			s, err := o.schema(p)
+			if err != nil {
+				return nil, err
+			}
+			obj[k] = s
+		}
+		return obj, nil
+	case "array":
+		if l, ok := m["prefixItems"].([]any); ok && len(l) > 0 {
+			items := make([]any, len(l))
+			for i, v := range l {
+				s, err := o.schema(v)
+				if err != nil {
+					return nil, err
+				}
+				items[i] = s
+			}
+			return items, nil
+		}
+		if m["items"] == nil {
+			return LIST.String(), nil
+		}
+		s, err := o.schema(m["items"])
+		if err != nil {
+			return nil, err
+		}
+		return []any{s}, nil
+	case "integer":
+		return INT.String(), nil
+	case "number":
+		return FLOAT.String(), nil
+	case "string":
+		return STRING.String(), nil
+	case "boolean":
+		return BOOL.String(), nil
+	}
+	return ANY.String(), nil
+}
+
+// allOf merges the properties of the object schemas provided
+func (o *openapi) allOf(all []any) (any, error) {
+	obj := map[string]any{}
+	for _, v := range all {
+		s, err := o.schema(v)
+		if err != nil {
+			return nil, err
+		}
+		if m, ok := s.(map[string]any); ok {
+			for k, v := range m {
+				obj[k] = v
+			}
+		}
+	}
+	if len(obj) == 0 {
+		return OBJECT.String(), nil
+	}
+	return obj, nil
+}
+
+// deref returns the object provided as a map,
+// resolving the object if it is a $ref
+func (o *openapi) deref(v any) (map[string]any, error) {
+	m, _ := v.(map[string]any)
+	if ref, ok := m["$ref"].(string); ok {
+		for _, r := range o.refs {
+			if r == ref {
+				return nil, errors.Invalid("api: circular openapi $ref '" + ref + "'")
+			}
+		}
+		r, err := o.resolve(ref)
+		if err != nil {
+			return nil, err
+		}
+		o.refs = append(o.refs, ref)
+		defer func() { o.refs = o.refs[:len(o.refs)-1] }()
+		return o.deref(r)
+	}
+	return m, nil
+}
+
+// resolve returns the object at the local json pointer
+// ref provided, such as '#/components/schemas/Pet'
+func (o *openapi) resolve(ref string) (any, error) {
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, errors.Unimplemented("api: unsupported openapi $ref '" + ref + "'")
+	}
+	var v any = o.doc
+	for _, k := range strings.Split(ref[2:], "/") {
+		k = strings.ReplaceAll(strings.ReplaceAll(k, "~1", "/"), "~0", "~")
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil, errors.NotFound("api: openapi $ref '" + ref + "' not found")
+		}
+		if v, ok = m[k]; !ok {
+			return nil, errors.NotFound("api: openapi $ref '" + ref + "' not found")
+		}
+	}
+	return v, nil
+}
+
+// openapiUri converts the path params of an openapi
+// path (/pets/{id}) to the uri form (/pets/:id)
+func openapiUri(p string) string {
+	segs := strings.Split(p, "/")
+	for i, s := range segs {
+		if len(s) > 2 && s[0] == '{' && s[len(s)-1] == '}' {
+			segs[i] = ":" + s[1:len(s)-1]
+		}
+	}
+	return strings.Join(segs, "/")
+}
+
+func anyList(v any) []any {
+	l, _ := v.([]any)
+	return l
+}
+
+// ----------------------------------------------------------------------------
+// OPENAPI EXPORT
+// converts an Api into an OpenAPI 3.1 document, where each
+// resource is a path and each method is an operation. The
+// ANY datatype is exported as the boolean schema 'true',
+// which accepts any value.
+
+// OpenAPI returns the api as an OpenAPI 3.1 document, formatted
+// using the encoder provided, or encoder.Yaml if none is provided
+func (a *Api) OpenAPI(e ...*encoder.Encoder) []byte {
+	enc := encoder.Yaml
+	if len(e) > 0 && e[0] != nil {
+		enc = e[0]
+	}
+	return enc.New().Encode(a.OpenAPIMap()).Bytes()
+}
+
+// OpenAPIMap returns the api as an OpenAPI 3.1 document map
+func (a *Api) OpenAPIMap() map[string]any {
+	name := a.Name
+	if name == "" {
+		name = "api"
+	}
+	doc := map[string]any{
+		"openapi": "3.1.0",
+		"info":    map[string]any{"title": name, "version": "1.0.0"},
+	}
+	base := ""
+	if a.Url != nil {
+		doc["servers"] = []any{map[string]any{"url": a.Url.String()}}
+		base = strings.TrimSuffix(a.Url.Path, "/")
+	}
+	paths := map[string]any{}
+	for i := 0; i < a.Resources.Len(); i++ {
+		r, ok := a.Resources.Index(i).(*Resource)
+		if !ok || r.Url == nil {
+			continue
+		}
+		item := map[string]any{}
+		for j := 0; j < r.Methods.Len(); j++ {
+			if m, ok := r.Methods.Index(j).(*Method); ok {
+				item[strings.ToLower(m.Type().String())] = m.openapi(r.Url.Path)
+			}
+		}
+		paths[openapiPath(strings.TrimPrefix(r.Url.Path, base))] = item
+	}
+	if len(paths) > 0 {
+		doc["paths"] = paths
+	}
+	return doc
+}
+
+func (m *Method) openapi(uri string) map[string]any {
+	op := map[string]any{}
+	var params []any
+	// path params are required in openapi, so path params
+	// without a request param are declared as strings
+	for _, s := range strings.Split(uri, "/") {
+		if len(s) > 1 && s[0] == ':' && (m.Request == nil || m.Request.Params.Get(s[1:]) == nil) {
+			params = append(params, map[string]any{
+				"name": s[1:], "in": "path", "required": true,
+				"schema": map[string]any{"type": "string"},
+			})
+		}
+	}
+	if m.Request != nil {
+		for i := 0; i < m.Request.Params.Len(); i++ {
+			p := m.Request.Params.Index(i)
+			param := map[string]any{"name": p.key, "in": "query", "schema": p.openapi()}
+			if strings.Contains(uri+"/", "/:"+p.key+"/") {
+				param["in"], param["required"] = "path", true
+			}
+			params = append(params, param)
+		}
+		for i := 0; i < m.Request.Header.Len(); i++ {
+			if p := m.Request.Header.Index(i); p.key != "content-type" {
+				params = append(params, map[string]any{"name": p.key, "in": "header", "schema": p.openapi()})
+			}
+		}
+		if b := m.Request.Body; b.Len() > 0 {
+			op["requestBody"] = map[string]any{
+				"content": map[string]any{m.Request.ContentType().String(): map[string]any{"schema": b.openapi()}},
+			}
+		}
+	}
+	if params != nil {
+		op["parameters"] = params
+	}
+	res := map[string]any{"description": "OK"}
+	if m.Response != nil {
+		headers := map[string]any{}
+		for i := 0; i < m.Response.Header.Len(); i++ {
+			if p := m.Response.Header.Index(i); p.key != "content-type" {
+				headers[p.key] = map[string]any{"schema": p.openapi()}
+			}
+		}
+		if len(headers) > 0 {
+			res["headers"] = headers
+		}
+		if b := m.Response.Body; b.Len() > 0 {
+			ct := contentString[JSON]
+			if p := m.Response.Header.Get("content-type"); p != nil {
+				if v, ok := p.Val().(string); ok {
+					ct = v
+				}
+			}
+			res["content"] = map[string]any{ct: map[string]any{"schema": b.openapi()}}
+		}
+	}
+	op["responses"] = map[string]any{"200": res}
+	return op
+}
+
+// openapi returns the schema of the params as
+// an array if the params are a list, otherwise
+// as an object
+func (p Params) openapi() any {
+	if p.IsSlice() {
+		return listSchema(p)
+	}
+	return objectSchema(p)
+}
+
+// openapi returns the schema of the param
+func (p *Param) openapi() any {
+	switch p.typ {
+	case BOOL:
+		return map[string]any{"type": "boolean"}
+	case INT:
+		return map[string]any{"type": "integer"}
+	case FLOAT:
+		return map[string]any{"type": "number"}
+	case STRING:
+		return map[string]any{"type": "string"}
+	case LIST:
+		return listSchema(p.els)
+	case OBJECT:
+		return objectSchema(p.els)
+	}
+	return true
+}
+
+func objectSchema(p Params) any {
+	s := map[string]any{"type": "object"}
+	if p.Len() > 0 {
+		props := make(map[string]any, p.Len())
+		for i := 0; i < p.Len(); i++ {
+			e := p.Index(i)
+			props[e.key] = e.openapi()
+		}
+		s["properties"] = props
+	}
+	return s
+}
+
+func listSchema(p Params) any {
+	s := map[string]any{"type": "array", "items": true}
+	switch n := p.Len(); {
+	case n == 1:
+		s["items"] = p.Index(0).openapi()
+	case n > 1:
+		items := make([]any, n)
+		for i := range items {
+			items[i] = p.Index(i).openapi()
+		}
+		s["prefixItems"] = items
+	}
+	return s
+}
+
+// openapiPath converts the path params of a uri
+// (/pets/:id) to the openapi path form (/pets/{id})
+func openapiPath(uri string) string {
+	segs := strings.Split(uri, "/")
+	for i, s := range segs {
+		if len(s) > 1 && s[0] == ':' {
+			segs[i] = "{" + s[1:] + "}"
+		}
+	}
+	return strings.Join(segs, "/")
+}
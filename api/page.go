@@ -0,0 +1,306 @@
+// Copyright 2023 james dotter.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://github.com/jcdotter/go/LICENSE
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"github.com/jcdotter/go/errors"
+)
+
+// ----------------------------------------------------------------------------
+// PAGINATION
+// the pagination of a method which returns a list of
+// elements across pages, declared in the api spec:
+//
+//	page:
+//	  type: cursor | page | offset | link
+//	  items: data        # path of the elements in the response body,
+//	                     # the body itself when not provided
+//	  param: cursor      # request param of the cursor, page or offset
+//	  next: meta.next    # path of the next cursor in the response
+//	                     # body, or the name of a response header
+//	  size: per_page     # request param of the page size
+//	  limit: 100         # page size requested
+//	  start: 1           # first page or offset, defaulting to 1 or 0
+//
+// Cursor pagination ends when no next cursor is returned,
+// page and offset pagination when a page returns fewer
+// elements than the page size, or none when no size is
+// requested, and link pagination when the Link header
+// of the response has no rel="next" link.
+
+// Paging is the type of pagination of a method
+type Paging byte
+
+const (
+	NOPAGING Paging = iota
+	CURSOR
+	PAGE
+	OFFSET
+	LINK
+)
+
+var paging = map[string]Paging{
+	"cursor": CURSOR,
+	"page":   PAGE,
+	"offset": OFFSET,
+	"link":   LINK,
+}
+
+// Pagination is the pagination of a method
+type Pagination struct {
+	Type  Paging
+	Items string
+	Param string
+	Next  string
+	Size  string
+	Limit int
+	Start int
+}
+
+// PaginationMap returns the pagination declared in the page
+// section of a method spec, or nil if not declared
+func PaginationMap(v any) *Pagination {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil
+	}
+	s := func(k string) string {
+		v, _ := m[k].(string)
+		return v
+	}
+	p := &Pagination{
+		Type:  paging[s("type")],
+		Items: s("items"),
+		Param: s("param"),
+		Next:  s("next"),
+		Size:  s("size"),
+		Limit: int(number(m["limit"], 0)),
+	}
+	if p.Type == NOPAGING {
+		return nil
+	}
+	if p.Type == PAGE {
+		p.Start = 1
+	}
+	p.Start = int(number(m["start"], float64(p.Start)))
+	return p
+}
+
+// ----------------------------------------------------------------------------
+// ITERATOR
+
+// Iterator calls a method repeatedly, yielding the elements
+// of each page of the method response. Use Next to advance
+// to each element and Err to check for an error once
+// Next returns false:
+//
+//	it := m.Iterate(ctx).MaxItems(500)
+//	for it.Next() {
+//		v := it.Value()
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type Iterator struct {
+	ctx      context.Context
+	method   *Method
+	req      *Request
+	maxItems int
+	maxPages int
+	items    int
+	pages    int
+	elems    []any
+	value    any
+	next     any
+	url      *url.URL
+	done     bool
+	err      error
+	result   *Result
+}
+
+// Iterate returns an iterator of the elements of the pages
+// returned by the method, using the values set in the
+// request provided, or in the method request when none
+// is provided. The method must declare its pagination.
+func (m *Method) Iterate(ctx context.Context, req ...*Request) *Iterator {
+	it := &Iterator{ctx: ctx, method: m, req: m.Request}
+	if len(req) > 0 && req[0] != nil {
+		it.req = req[0]
+	}
+	if m.Page == nil {
+		it.err = errors.Failed("api: method '" + m.Name + "' has no pagination")
+		return it
+	}
+	if m.Page.Type == PAGE || m.Page.Type == OFFSET {
+		it.next = m.Page.Start
+	}
+	return it
+}
+
+// MaxItems limits the number of elements yielded
+func (it *Iterator) MaxItems(n int) *Iterator {
+	it.maxItems = n
+	return it
+}
+
+// MaxPages limits the number of pages requested
+func (it *Iterator) MaxPages(n int) *Iterator {
+	it.maxPages = n
+	return it
+}
+
+// Next advances the iterator to the next element,
+// requesting the next page when needed. Returns false
+// when no elements remain, a limit is reached, the
+// context is done or an error occurs.
+func (it *Iterator) Next() bool {
+	if it.err != nil || (it.maxItems > 0 && it.items >= it.maxItems) {
+		return false
+	}
+	for len(it.elems) == 0 {
+		if it.done || (it.maxPages > 0 && it.pages >= it.maxPages) {
+			return false
+		}
+		if err := it.ctx.Err(); err != nil {
+			if err == context.DeadlineExceeded {
+				it.err = errors.Deadline("api: pagination " + err.Error())
+			} else {
+				it.err = errors.Cancelled("api: pagination " + err.Error())
+			}
+			return false
+		}
+		if it.err = it.page(); it.err != nil {
+			return false
+		}
+	}
+	it.value, it.elems = it.elems[0], it.elems[1:]
+	it.items++
+	return true
+}
+
+// Value returns the current element of the iterator
+func (it *Iterator) Value() any {
+	return it.value
+}
+
+// Err returns the error which stopped the iterator, if any
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Result returns the result of the last page requested
+func (it *Iterator) Result() *Result {
+	return it.result
+}
+
+// Pages returns the number of pages requested
+func (it *Iterator) Pages() int {
+	return it.pages
+}
+
+// page requests the next page of the method
+func (it *Iterator) page() error {
+	p, rq := it.method.Page, it.req.Copy()
+	if p.Size != "" && p.Limit > 0 {
+		rq.Params.Set(p.Size, p.Limit)
+	}
+	if p.Type != LINK && it.next != nil && p.Param != "" {
+		rq.Params.Set(p.Param, it.next)
+	}
+	res, err := it.method.exec(it.ctx, rq, it.url)
+	if err != nil {
+		return err
+	}
+	if !res.Ok() {
+		return errors.Http(res.StatusCode, "api: page request failed: "+res.Status)
+	}
+	it.result = res
+	it.pages++
+	l, ok := lookup(res.Body, p.Items).([]any)
+	if !ok && lookup(res.Body, p.Items) != nil {
+		return errors.Invalid("api: page items '" + p.Items + "' is not a list")
+	}
+	it.elems = l
+	switch p.Type {
+	case CURSOR:
+		it.next = lookup(res.Body, p.Next)
+		if it.next == nil && p.Next != "" {
+			if h := res.Header.Get(p.Next); h != "" {
+				it.next = h
+			}
+		}
+		it.done = it.next == nil || it.next == ""
+	case PAGE, OFFSET:
+		n, _ := it.next.(int)
+		if p.Type == PAGE {
+			it.next = n + 1
+		} else {
+			it.next = n + len(l)
+		}
+		it.done = len(l) == 0 || (p.Limit > 0 && len(l) < p.Limit)
+	case LINK:
+		it.url = nextLink(res.Header.Values("Link"))
+		if it.done = it.url == nil; !it.done {
+			it.url = it.method.Resource.Url.ResolveReference(it.url)
+		}
+	}
+	if it.maxItems > 0 && len(it.elems) > it.maxItems-it.items {
+		it.elems = it.elems[:it.maxItems-it.items]
+	}
+	return nil
+}
+
+// lookup returns the value at the dotted path of
+// the object provided, or the object if no path
+func lookup(v any, path string) any {
+	if path == "" {
+		return v
+	}
+	for _, k := range strings.Split(path, ".") {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil
+		}
+		v = m[k]
+	}
+	return v
+}
+
+// nextLink returns the url of the rel="next" link
+// of the Link headers provided, if any
+func nextLink(links []string) *url.URL {
+	for _, h := range links {
+		for _, l := range strings.Split(h, ",") {
+			parts := strings.Split(l, ";")
+			ref := strings.TrimSpace(parts[0])
+			if len(ref) < 2 || ref[0] != '<' || ref[len(ref)-1] != '>' {
+				continue
+			}
+			for _, a := range parts[1:] {
+				a = strings.ReplaceAll(strings.TrimSpace(a), " ", "")
+				if a == `rel="next"` || a == "rel=next" {
+					if u, err := url.Parse(ref[1 : len(ref)-1]); err == nil {
+						return u
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -14,6 +14,334 @@
 
 package api
 
-func init() {
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
 
+	"github.com/jcdotter/go/encoder"
+	"github.com/jcdotter/go/errors"
+)
+
+// ----------------------------------------------------------------------------
+// REST RESULT
+
+// Result is the outcome of a method call
+type Result struct {
+	StatusCode int
+	Status     string
+	Header     http.Header
+	// Body is the decoded body of the response. Json
+	// bodies are decoded to map[string]any or []any,
+	// text bodies to a string, and other content
+	// types are left as the raw []byte
+	Body any
+}
+
+// Ok returns true if the result status code is 2xx
+func (r *Result) Ok() bool {
+	return r.StatusCode >= 200 && r.StatusCode < 300
+}
+
+// ----------------------------------------------------------------------------
+// REST CALL
+
+// Call executes the method as an http request using the
+// values set in the request provided, or in the method
+// request when none is provided
+func (m *Method) Call(req ...*Request) (*Result, error) {
+	return m.CallContext(context.Background(), req...)
+}
+
+// CallContext executes the method as an http request,
+// or as a grpc call of a grpc api, with the context
+// provided, waiting for the rate limiters
+// and retrying according to the retry policy of the
+// method or api. See Call.
+func (m *Method) CallContext(ctx context.Context, req ...*Request) (*Result, error) {
+	rq := m.Request
+	if len(req) > 0 && req[0] != nil {
+		rq = req[0]
+	}
+	return m.exec(ctx, rq, nil)
+}
+
+// exec executes the request of the method, sent to the
+// url provided rather than the request url when not nil
+func (m *Method) exec(ctx context.Context, rq *Request, u *url.URL) (*Result, error) {
+	if m.Resource == nil {
+		return nil, errors.Invalid("api: method '" + m.Name + "' has no resource")
+	}
+	for attempt := 1; ; attempt++ {
+		var res *Result
+		var err error
+		if a := m.Resource.Api; a != nil && a.Protocol == GRPC {
+			res, err = m.invoke(ctx, rq)
+		} else {
+			res, err = m.send(ctx, rq, u)
+		}
+		p := m.retry()
+		if p == nil || !retryable(res, err) {
+			return res, err
+		}
+		if attempt >= p.Attempts {
+			return res, exhausted(res, err, attempt)
+		}
+		d, ok := retryAfter(res)
+		if !ok {
+			d = p.Delay(attempt + 1)
+		}
+		if err = sleep(ctx, d, "api: retry"); err != nil {
+			return res, err
+		}
+	}
+}
+
+// send sends the http request of the method
+func (m *Method) send(ctx context.Context, rq *Request, u *url.URL) (*Result, error) {
+	r, err := m.httpRequest(ctx, rq, u)
+	if err != nil {
+		return nil, err
+	}
+	if err = m.limit(ctx); err != nil {
+		return nil, err
+	}
+	res, err := m.do(r)
+	if err == nil && res.StatusCode == http.StatusUnauthorized && m.Resource.Api != nil {
+		// clear cached credentials rejected by the api
+		if a, ok := m.Resource.Api.Authenticator.(interface{ Invalidate() }); ok {
+			a.Invalidate()
+		}
+	}
+	return res, err
+}
+
+// HttpRequest builds the http request of the method from
+// the values set in the request provided, or in the
+// method request when none is provided
+func (m *Method) HttpRequest(ctx context.Context, req ...*Request) (*http.Request, error) {
+	rq := m.Request
+	if len(req) > 0 && req[0] != nil {
+		rq = req[0]
+	}
+	return m.httpRequest(ctx, rq, nil)
+}
+
+func (m *Method) httpRequest(ctx context.Context, rq *Request, u *url.URL) (*http.Request, error) {
+	if m.Resource == nil || m.Resource.Url == nil {
+		return nil, errors.Failed("api: method '" + m.Name + "' has no resource url")
+	}
+	var err error
+	if u == nil {
+		if u, err = rq.Url(m.Resource.Url); err != nil {
+			return nil, err
+		}
+	}
+	body, err := rq.Reader()
+	if err != nil {
+		return nil, err
+	}
+	r, err := http.NewRequestWithContext(ctx, m.Type().String(), u.String(), body)
+	if err != nil {
+		return nil, errors.Invalid(err.Error())
+	}
+	rq.setHeader(r.Header)
+	if err = m.authorize(r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (m *Method) do(r *http.Request) (*Result, error) {
+	c := http.DefaultClient
+	if a := m.Resource.Api; a != nil && a.Client != nil {
+		c = a.Client
+	}
+	res, err := c.Do(r)
+	if err != nil {
+		if e := r.Context().Err(); e != nil {
+			if e == context.DeadlineExceeded {
+				return nil, errors.Deadline(err.Error())
+			}
+			return nil, errors.Cancelled(err.Error())
+		}
+		return nil, errors.Unavailable(err.Error())
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, errors.Unavailable(err.Error())
+	}
+	body, err := decodeBody(res.Header.Get("Content-Type"), b)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{
+		StatusCode: res.StatusCode,
+		Status:     res.Status,
+		Header:     res.Header,
+		Body:       body,
+	}, nil
+}
+
+// ----------------------------------------------------------------------------
+// REST REQUEST
+
+// ContentType returns the content type set in the
+// request header, defaulting to JSON when not set
+func (r *Request) ContentType() ContentType {
+	if p := r.Header.Get("content-type"); p != nil {
+		if v, ok := p.Val().(string); ok {
+			if t, _, err := mime.ParseMediaType(v); err == nil {
+				return Contenttype(t)
+			}
+		}
+	}
+	return JSON
+}
+
+// Copy returns a copy of the request which
+// params may be set without altering the request
+func (r *Request) Copy() *Request {
+	c := NewRequest()
+	c.Webhook = r.Webhook
+	for _, ps := range [][2]*Params{{&r.Params, &c.Params}, {&r.Header, &c.Header}, {&r.Body, &c.Body}} {
+		if ps[0].Data == nil {
+			continue
+		}
+		if ps[0].IsSlice() {
+			ps[1].AsSlice()
+		}
+		for i := 0; i < ps[0].Len(); i++ {
+			if e := ps[0].Index(i); e != nil {
+				ps[1].Add(e)
+			}
+		}
+	}
+	return c
+}
+
+// Url returns the url of the request by replacing the
+// path params (eg. /colors/:id) in the url provided with
+// the values of the request params. Params with values
+// that are not in the path are added to the url query.
+func (r *Request) Url(base *url.URL) (*url.URL, error) {
+	u := *base
+	vals := r.Params.Map()
+	segs := strings.Split(u.Path, "/")
+	for i, s := range segs {
+		if len(s) > 1 && s[0] == ':' {
+			v, ok := vals[s[1:]]
+			if !ok {
+				return nil, errors.Invalid("api: missing path param '" + s[1:] + "'")
+			}
+			segs[i] = url.PathEscape(formatValue(v))
+			delete(vals, s[1:])
+		}
+	}
+	u.Path = strings.Join(segs, "/")
+	u.RawPath = ""
+	if len(vals) > 0 {
+		q := u.Query()
+		for k, v := range vals {
+			if l, ok := v.([]any); ok {
+				for _, e := range l {
+					q.Add(k, formatValue(e))
+				}
+				continue
+			}
+			q.Set(k, formatValue(v))
+		}
+		u.RawQuery = q.Encode()
+	}
+	return &u, nil
+}
+
+// Bytes returns the request body serialized according
+// to the content type of the request, or nil if the
+// request body has no values set
+func (r *Request) Bytes() ([]byte, error) {
+	v := r.Body.Value()
+	if v == nil {
+		return nil, nil
+	}
+	switch r.ContentType() {
+	case JSON:
+		return encoder.Json.New().Encode(v).Bytes(), nil
+	case FORM:
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil, errors.Invalid("api: form body must be an object")
+		}
+		q := url.Values{}
+		for k, v := range m {
+			q.Set(k, formatValue(v))
+		}
+		return []byte(q.Encode()), nil
+	case TEXT, HTML, CSV:
+		return []byte(formatValue(v)), nil
+	}
+	return nil, errors.Unimplemented("api: cannot encode body as '" + r.ContentType().String() + "'")
+}
+
+// Reader returns a reader of the serialized request body,
+// or nil if the request body has no values set
+func (r *Request) Reader() (io.Reader, error) {
+	b, err := r.Bytes()
+	if b == nil || err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(b), nil
+}
+
+func (r *Request) setHeader(h http.Header) {
+	for k, v := range r.Header.Map() {
+		h.Set(k, formatValue(v))
+	}
+	if h.Get("Content-Type") == "" && r.Body.Value() != nil {
+		h.Set("Content-Type", r.ContentType().String())
+	}
+}
+
+// ----------------------------------------------------------------------------
+// REST UTILITIES
+
+func decodeBody(ct string, b []byte) (v any, err error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+	t, _, _ := mime.ParseMediaType(ct)
+	switch c, ok := contentType[t]; {
+	case ok && c == JSON, strings.HasSuffix(t, "+json"), t == "" && json(b):
+		d := encoder.Json.New()
+		d.DecodeTyped = true
+		if err = d.TryDecode(b); err != nil {
+			return nil, errors.Invalid("api: invalid json response body: " + err.Error())
+		}
+		return d.Value(), nil
+	case strings.HasPrefix(t, "text/"):
+		return string(b), nil
+	}
+	return b, nil
+}
+
+// json reports whether b appears to be a json object or list
+func json(b []byte) bool {
+	b = bytes.TrimSpace(b)
+	return len(b) > 0 && (b[0] == '{' || b[0] == '[')
+}
+
+func formatValue(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case map[string]any, []any:
+		return encoder.Json.New().Encode(v).String()
+	}
+	return fmt.Sprint(v)
 }
@@ -0,0 +1,287 @@
+// Copyright 2023 james dotter.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://github.com/jcdotter/go/LICENSE
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jcdotter/go/errors"
+)
+
+// ----------------------------------------------------------------------------
+// RETRY AND RATE LIMITING
+// policies for calling an api, declared in the api spec for
+// the api, or for a method, which overrides the api retry
+// policy and is rate limited by both the api and method:
+//
+//	retry:
+//	  attempts: 3        # total attempts, including the first
+//	  backoff: 100ms     # delay before the first retry
+//	  max_backoff: 10s   # max delay between attempts
+//	  jitter: 0.5        # fraction of the delay randomized
+//	rate:
+//	  limit: 10          # requests per period
+//	  per: 1s            # period of the limit, defaulting to 1s
+//	  burst: 5           # max requests at once, defaulting to 1
+//
+// Calls are retried on network errors, 429 and 5xx responses,
+// waiting for the Retry-After header of the response when
+// provided. When a policy gives up, the call returns an
+// errors.Exhausted status if rate limited by the api, an
+// errors.Unavailable status if the api failed, or an
+// errors.Deadline status if the context deadline would
+// pass before the next attempt.
+
+// Retry is the retry policy of an api or method
+type Retry struct {
+	// Attempts is the max number of attempts of a call,
+	// including the first attempt
+	Attempts int
+	// Backoff is the delay before the first retry,
+	// which doubles on each following retry
+	Backoff time.Duration
+	// MaxBackoff is the max delay between attempts,
+	// excluding delays requested with Retry-After
+	MaxBackoff time.Duration
+	// Jitter is the fraction of the delay which
+	// is randomized, between 0 and 1
+	Jitter float64
+}
+
+// NewRetry returns a retry policy of the attempts provided
+// with a backoff of 100ms, doubling to a max of 10s
+func NewRetry(attempts int) *Retry {
+	return &Retry{
+		Attempts:   attempts,
+		Backoff:    100 * time.Millisecond,
+		MaxBackoff: 10 * time.Second,
+		Jitter:     0.5,
+	}
+}
+
+// RetryMap returns the retry policy declared in the
+// retry section of an api spec, or nil if not declared
+func RetryMap(v any) *Retry {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil
+	}
+	r := NewRetry(int(number(m["attempts"], 3)))
+	r.Backoff = duration(m["backoff"], r.Backoff)
+	r.MaxBackoff = duration(m["max_backoff"], r.MaxBackoff)
+	r.Jitter = math.Min(math.Max(number(m["jitter"], r.Jitter), 0), 1)
+	return r
+}
+
+// Delay returns the delay before the attempt provided,
+// where the first retry is attempt 2
+func (r *Retry) Delay(attempt int) time.Duration {
+	d := float64(r.Backoff) * math.Pow(2, float64(attempt-2))
+	if r.MaxBackoff > 0 && d > float64(r.MaxBackoff) {
+		d = float64(r.MaxBackoff)
+	}
+	return time.Duration(d * (1 - r.Jitter*rand.Float64()))
+}
+
+// retryable returns true if the result or error of
+// an attempt should be retried
+func retryable(res *Result, err error) bool {
+	if err != nil {
+		s, ok := err.(*errors.Status)
+		return ok && s.Code() == errors.UNAVAILABLE
+	}
+	return res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+}
+
+// exhausted returns the error of a call which
+// failed after the attempts provided
+func exhausted(res *Result, err error, attempts int) error {
+	n := " after " + strconv.Itoa(attempts) + " attempts"
+	switch {
+	case err != nil:
+		return errors.Unavailable(err.Error() + n)
+	case res.StatusCode == http.StatusTooManyRequests:
+		return errors.Exhausted("api: rate limited" + n)
+	}
+	return errors.Unavailable("api: " + res.Status + n)
+}
+
+// retryAfter returns the delay requested by the
+// Retry-After header of the result, if any
+func retryAfter(res *Result) (time.Duration, bool) {
+	if res == nil {
+		return 0, false
+	}
+	h := res.Header.Get("Retry-After")
+	if h == "" {
+		return 0, false
+	}
+	if s, err := strconv.Atoi(h); err == nil {
+		return time.Duration(s) * time.Second, true
+	}
+	if t, err := http.ParseTime(h); err == nil {
+		return time.Until(t), true
+	}
+	return 0, false
+}
+
+// ----------------------------------------------------------------------------
+// LIMITER
+
+// Limiter is a token bucket rate limiter which
+// allows Burst requests at once, refilled at the
+// Rate of requests per second
+type Limiter struct {
+	sync.Mutex
+	Rate   float64
+	Burst  int
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter returns a limiter of the rate per second
+// and burst provided, starting with a full bucket
+func NewLimiter(rate float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{Rate: rate, Burst: burst, tokens: float64(burst)}
+}
+
+// LimiterMap returns the limiter declared in the rate
+// section of an api spec, or nil if not declared
+func LimiterMap(v any) *Limiter {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil
+	}
+	limit := number(m["limit"], 0)
+	per := duration(m["per"], time.Second)
+	if limit <= 0 || per <= 0 {
+		return nil
+	}
+	return NewLimiter(limit/per.Seconds(), int(number(m["burst"], 1)))
+}
+
+// Wait waits until a request is allowed by the limiter,
+// returning an errors.Deadline status without waiting
+// if the context deadline would pass before then
+func (l *Limiter) Wait(ctx context.Context) error {
+	l.Lock()
+	now := time.Now()
+	if !l.last.IsZero() {
+		l.tokens = math.Min(float64(l.Burst), l.tokens+now.Sub(l.last).Seconds()*l.Rate)
+	}
+	l.last = now
+	l.tokens--
+	wait := time.Duration(-l.tokens / l.Rate * float64(time.Second))
+	l.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	if err := sleep(ctx, wait, "api: rate limit"); err != nil {
+		// return the token reserved
+		l.Lock()
+		l.tokens++
+		l.Unlock()
+		return err
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+// HELPERS
+
+// limit waits for the api and method limiters
+func (m *Method) limit(ctx context.Context) error {
+	if a := m.Resource.Api; a != nil && a.Limiter != nil {
+		if err := a.Limiter.Wait(ctx); err != nil {
+			return err
+		}
+	}
+	if m.Limiter != nil {
+		return m.Limiter.Wait(ctx)
+	}
+	return nil
+}
+
+// retry returns the retry policy of the method,
+// or of the api if the method has none
+func (m *Method) retry() *Retry {
+	if m.Retry != nil {
+		return m.Retry
+	}
+	if a := m.Resource.Api; a != nil {
+		return a.Retry
+	}
+	return nil
+}
+
+// sleep waits for the duration provided, returning an
+// errors.Deadline status without waiting if the context
+// deadline would pass first, or if the context is done
+func sleep(ctx context.Context, d time.Duration, msg string) error {
+	if dl, ok := ctx.Deadline(); ok && time.Until(dl) < d {
+		return errors.Deadline(msg + " wait exceeds deadline")
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			return errors.Deadline(msg + " wait exceeds deadline")
+		}
+		return errors.Cancelled(ctx.Err().Error())
+	}
+}
+
+func number(v any, def float64) float64 {
+	switch v := v.(type) {
+	case int:
+		return float64(v)
+	case float64:
+		return v
+	case string:
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+	}
+	return def
+}
+
+func duration(v any, def time.Duration) time.Duration {
+	switch v := v.(type) {
+	case int:
+		return time.Duration(v) * time.Second
+	case float64:
+		return time.Duration(v * float64(time.Second))
+	case string:
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return time.Duration(f * float64(time.Second))
+		}
+	}
+	return def
+}
@@ -0,0 +1,334 @@
+// Copyright 2023 james dotter.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://github.com/jcdotter/go/LICENSE
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jcdotter/go/encoder"
+	"github.com/jcdotter/go/errors"
+)
+
+// ----------------------------------------------------------------------------
+// SERVER
+// serves an api as an http.Handler, routing each resource
+// uri and method to a registered handler after validating
+// the request against the method request params
+
+// Handler handles a validated call to a method of the api.
+// The result returned is written as the response, where
+// the body is encoded according to the response content
+// type. Errors returned as an *errors.Status are written
+// with the http code of the status, and other errors are
+// written as internal errors.
+type Handler func(c *Call) (*Result, error)
+
+// Call is a request received by the server for a method
+type Call struct {
+	Method  *Method
+	Request *http.Request
+	// Params are the path and query params of the request,
+	// converted to the datatypes of the request params
+	Params map[string]any
+	Header http.Header
+	// Body is the decoded body of the request. Json bodies
+	// are decoded to map[string]any or []any, form bodies
+	// to map[string]any and text bodies to a string
+	Body any
+}
+
+// Server is an http.Handler of an api
+type Server struct {
+	sync.RWMutex
+	Api *Api
+	// MaxBody is the max size of a request body, defaulting to 1MB
+	MaxBody  int64
+	routes   []route
+	handlers map[*Method]Handler
+}
+
+type route struct {
+	segs     []string
+	resource *Resource
+}
+
+// NewServer returns a server of the api provided
+func NewServer(a *Api) *Server {
+	s := &Server{Api: a, MaxBody: 1 << 20, handlers: map[*Method]Handler{}}
+	for i := 0; i < a.Resources.Len(); i++ {
+		if r, ok := a.Resources.Index(i).(*Resource); ok && r.Url != nil {
+			s.routes = append(s.routes, route{strings.Split(r.Url.Path, "/"), r})
+		}
+	}
+	return s
+}
+
+// Handle registers the handler for the resource and method
+// provided, returning an error if not declared in the api
+func (s *Server) Handle(resource, method string, h Handler) error {
+	r := s.Api.Resource(resource)
+	if r == nil {
+		return errors.NotFound("api: resource '" + resource + "' not found")
+	}
+	m := r.Method(method)
+	if m == nil {
+		return errors.NotFound("api: method '" + method + "' of resource '" + resource + "' not found")
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.handlers[m] = h
+	return nil
+}
+
+// ServeHTTP routes the request to the handler of the resource
+// and method, validating the request before handling it
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	res, params := s.route(r.URL.Path)
+	if res == nil {
+		writeError(w, errors.NotFound("api: no resource at '"+r.URL.Path+"'"))
+		return
+	}
+	m := res.Method(r.Method)
+	if m == nil {
+		w.Header().Set("Allow", strings.Join(res.Methods.Keys(), ", "))
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.RLock()
+	h := s.handlers[m]
+	s.RUnlock()
+	if h == nil {
+		writeError(w, errors.Unimplemented("api: no handler for "+r.Method+" "+res.Name))
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, s.MaxBody)
+	c, err := m.call(r, params)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	result, err := h(c)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	m.write(w, result)
+}
+
+// route returns the resource matching the path and the path
+// params of the resource uri, preferring static segments
+// over params when multiple resources match
+func (s *Server) route(path string) (res *Resource, params map[string]string) {
+	segs, best := strings.Split(path, "/"), -1
+	for _, rt := range s.routes {
+		if len(rt.segs) != len(segs) {
+			continue
+		}
+		score, p := 0, map[string]string{}
+		for i, seg := range rt.segs {
+			switch {
+			case len(seg) > 1 && seg[0] == ':':
+				v, err := url.PathUnescape(segs[i])
+				if err != nil || v == "" {
+					score = -1
+				}
+				p[seg[1:]] = v
+			case seg == segs[i]:
+				score++
+			default:
+				score = -1
+			}
+			if score < 0 {
+				break
+			}
+		}
+		if score > best {
+			best, res, params = score, rt.resource, p
+		}
+	}
+	return
+}
+
+// call builds the call of the request, validating the path
+// and query params, headers and body of the request
+func (m *Method) call(r *http.Request, path map[string]string) (*Call, error) {
+	c := &Call{Method: m, Request: r, Params: map[string]any{}, Header: r.Header}
+	var errs []error
+	query := r.URL.Query()
+	for k, v := range path {
+		c.Params[k] = v
+	}
+	for k, v := range query {
+		if _, ok := path[k]; !ok {
+			c.Params[k] = v[0]
+		}
+	}
+	for i := 0; i < m.Request.Params.Len(); i++ {
+		p := m.Request.Params.Index(i)
+		v, ok := c.Params[p.key]
+		if !ok {
+			continue
+		}
+		if p.typ == LIST {
+			l := make([]any, len(query[p.key]))
+			for i, s := range query[p.key] {
+				l[i] = s
+			}
+			v = l
+		}
+		if e := p.validate(p.key, v, nil); e != nil {
+			errs = append(errs, e...)
+			continue
+		}
+		c.Params[p.key] = p.convert(v)
+	}
+	for i := 0; i < m.Request.Header.Len(); i++ {
+		p := m.Request.Header.Index(i)
+		h := r.Header.Get(p.key)
+		if h == "" {
+			continue
+		}
+		if v, ok := p.val.(string); ok && p.key == "content-type" {
+			ht, _, _ := mime.ParseMediaType(h)
+			if vt, _, _ := mime.ParseMediaType(v); ht != vt {
+				errs = append(errs, errors.Invalid("header."+p.key+": expected "+v))
+			}
+			continue
+		}
+		errs = append(errs, p.validate("header."+p.key, h, nil)...)
+	}
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		if mb, ok := err.(*http.MaxBytesError); ok {
+			return nil, mb
+		}
+		return nil, errors.Invalid("api: failed to read request body")
+	}
+	if c.Body, err = decodeRequestBody(r.Header.Get("Content-Type"), b); err != nil {
+		return nil, err
+	}
+	errs = append(errs, m.Request.Validate(c.Body)...)
+	if err = validationError(errs); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// write writes the result as the response of the method
+func (m *Method) write(w http.ResponseWriter, res *Result) {
+	if res == nil {
+		res = &Result{}
+	}
+	for k, v := range res.Header {
+		w.Header()[k] = v
+	}
+	code := res.StatusCode
+	if code == 0 {
+		code = http.StatusOK
+	}
+	var b []byte
+	switch v := res.Body.(type) {
+	case nil:
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", contentString[TEXT])
+		}
+	default:
+		ct := contentString[JSON]
+		if p := m.Response.Header.Get("content-type"); p != nil {
+			if s, ok := p.val.(string); ok {
+				ct = s
+			}
+		}
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", ct)
+		}
+		b = encoder.Json.New().Encode(v).Bytes()
+	}
+	if b != nil {
+		w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	}
+	w.WriteHeader(code)
+	w.Write(b)
+}
+
+// convert converts a string value to the datatype of the param
+func (p *Param) convert(v any) any {
+	s, ok := v.(string)
+	if !ok {
+		return v
+	}
+	switch p.typ {
+	case BOOL:
+		if b, err := strconv.ParseBool(s); err == nil {
+			return b
+		}
+	case INT:
+		if i, err := strconv.Atoi(s); err == nil {
+			return i
+		}
+	case FLOAT:
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	}
+	return v
+}
+
+func decodeRequestBody(ct string, b []byte) (any, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+	t, _, _ := mime.ParseMediaType(ct)
+	if t == contentString[FORM] {
+		q, err := url.ParseQuery(string(b))
+		if err != nil {
+			return nil, errors.Invalid("api: invalid form body")
+		}
+		m := make(map[string]any, len(q))
+		for k, v := range q {
+			m[k] = v[0]
+		}
+		return m, nil
+	}
+	v, err := decodeBody(ct, b)
+	if err != nil {
+		return nil, errors.Invalid("api: invalid request body")
+	}
+	return v, nil
+}
+
+// writeError writes the error to the response with the
+// http code of the error status
+func writeError(w http.ResponseWriter, err error) {
+	if _, ok := err.(*http.MaxBytesError); ok {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	s, ok := err.(*errors.Status)
+	if !ok {
+		s = errors.NewStatus(errors.INTERNAL, err.Error())
+	}
+	s.HttpErr(w)
+}
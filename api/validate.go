@@ -0,0 +1,202 @@
+// Copyright 2023 james dotter.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://github.com/jcdotter/go/LICENSE
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/jcdotter/go/errors"
+)
+
+// ----------------------------------------------------------------------------
+// VALIDATION
+// validates decoded data (map[string]any, []any and scalars)
+// against the datatypes of a param schema. Fields that are
+// absent from the data or are null are not validated.
+// As encoder.Json decodes numbers and bools as strings
+// unless DecodeTyped is set, strings which parse as the
+// expected number or bool are considered valid.
+
+// Validate validates the body provided against the request
+// body schema, returning an errors.Invalid status for each
+// mismatch found
+func (r *Request) Validate(body any) []error {
+	return r.Body.Validate("body", body)
+}
+
+// Validate validates the body provided against the response
+// body schema, returning an errors.Invalid status for each
+// mismatch found
+func (r *Response) Validate(body any) []error {
+	return r.Body.Validate("body", body)
+}
+
+// Validate validates the value provided against the params,
+// which are treated as a list if created from a list, and
+// otherwise as an object. Each mismatch is returned as an
+// errors.Invalid status with the path of the value, such
+// as 'body.items[3].price: expected float'
+func (p Params) Validate(path string, v any) []error {
+	if p.Data == nil {
+		return nil
+	}
+	if p.IsSlice() {
+		return p.validateList(path, v, nil)
+	}
+	return p.validateObject(path, v, nil)
+}
+
+// Validate validates the value provided against the param,
+// returning an errors.Invalid status for each mismatch found
+func (p *Param) Validate(path string, v any) []error {
+	return p.validate(path, v, nil)
+}
+
+func (p *Param) validate(path string, v any, errs []error) []error {
+	if v == nil {
+		return errs
+	}
+	switch p.typ {
+	case NONE, ANY:
+		return errs
+	case OBJECT:
+		return p.els.validateObject(path, v, errs)
+	case LIST:
+		return p.els.validateList(path, v, errs)
+	}
+	if !p.typ.Match(v) {
+		errs = append(errs, invalid(path, p.typ))
+	}
+	return errs
+}
+
+func (p Params) validateObject(path string, v any, errs []error) []error {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return append(errs, invalid(path, OBJECT))
+	}
+	for i := 0; i < p.Len(); i++ {
+		if e := p.Index(i); e != nil {
+			if x, ok := m[e.key]; ok {
+				errs = e.validate(fieldPath(path, e.key), x, errs)
+			}
+		}
+	}
+	return errs
+}
+
+func (p Params) validateList(path string, v any, errs []error) []error {
+	l, ok := v.([]any)
+	if !ok {
+		return append(errs, invalid(path, LIST))
+	}
+	switch n := p.Len(); {
+	case n == 1:
+		// a single elem is the schema of every list elem
+		e := p.Index(0)
+		for i, x := range l {
+			errs = e.validate(indexPath(path, i), x, errs)
+		}
+	case n > 1:
+		// multiple elems are the schema of each list elem
+		for i, x := range l {
+			if i >= n {
+				errs = append(errs, errors.Invalid(indexPath(path, i)+": unexpected element"))
+				continue
+			}
+			errs = p.Index(i).validate(indexPath(path, i), x, errs)
+		}
+	}
+	return errs
+}
+
+// Match returns true if the value provided is of the datatype
+func (d DataType) Match(v any) bool {
+	switch d {
+	case NONE, ANY:
+		return true
+	case BOOL:
+		switch v := v.(type) {
+		case bool:
+			return true
+		case string:
+			_, err := strconv.ParseBool(v)
+			return err == nil
+		}
+	case INT:
+		switch v := v.(type) {
+		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+			return true
+		case float32:
+			return float64(v) == math.Trunc(float64(v))
+		case float64:
+			return v == math.Trunc(v)
+		case string:
+			_, err := strconv.ParseInt(v, 10, 64)
+			return err == nil
+		}
+	case FLOAT:
+		switch v := v.(type) {
+		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+			return true
+		case string:
+			_, err := strconv.ParseFloat(v, 64)
+			return err == nil
+		}
+	case STRING:
+		_, ok := v.(string)
+		return ok
+	case LIST:
+		_, ok := v.([]any)
+		return ok
+	case OBJECT:
+		_, ok := v.(map[string]any)
+		return ok
+	}
+	return false
+}
+
+// validationError returns the errors provided as a
+// single errors.Invalid status, or nil if none
+func validationError(errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Error()
+	}
+	return errors.Invalid(strings.Join(msgs, "; "))
+}
+
+func invalid(path string, d DataType) error {
+	if path == "" {
+		return errors.Invalid("expected " + d.String())
+	}
+	return errors.Invalid(path + ": expected " + d.String())
+}
+
+func fieldPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func indexPath(path string, i int) string {
+	return path + "[" + strconv.Itoa(i) + "]"
+}
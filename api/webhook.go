@@ -0,0 +1,345 @@
+// Copyright 2023 james dotter.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://github.com/jcdotter/go/LICENSE
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jcdotter/go/env"
+	"github.com/jcdotter/go/errors"
+	"github.com/jcdotter/go/logger"
+)
+
+// ----------------------------------------------------------------------------
+// WEBHOOKS
+// the webhook events received by a method, declared in the
+// webhook section of the method request spec:
+//
+//	request:
+//	  webhook:
+//	    event: type                 # path of the event name in the payload
+//	    event_header: X-Event       # or the header of the event name
+//	    signature:
+//	      header: Webhook-Signature # header of the signature
+//	      timestamp: Webhook-Time   # header of the timestamp, when not
+//	                                # in the signature header
+//	      secret: $WEBHOOK_SECRET
+//	      tolerance: 5m             # max age of a delivery
+//	    events:
+//	      pet.created:              # payload params of each event
+//	        type: string
+//	        data:
+//	          id: int
+//	          name: string
+//
+// Deliveries are signed with the hex HMAC-SHA256 of the unix
+// timestamp of the delivery and the payload, joined by a '.',
+// using the secret of the signature. The signature header is
+// either 't=<timestamp>,v1=<signature>', where multiple v1
+// signatures may be provided while rotating secrets, or the
+// signature alone, optionally prefixed by 'sha256=', with the
+// timestamp in the timestamp header.
+
+// Webhook is the declaration of the webhook events of a method
+type Webhook struct {
+	// Event is the dotted path of the event name in the payload
+	Event string
+	// EventHeader is the header of the event name, used
+	// instead of the payload when set
+	EventHeader string
+	// Events are the payload params of each event. Any
+	// event is accepted without validation when empty.
+	Events    map[string]Params
+	Signature *Signature
+}
+
+// WebhookMap returns the webhook declared in the webhook
+// section of a request spec, or nil if not declared
+func WebhookMap(v any) *Webhook {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil
+	}
+	w := &Webhook{Events: map[string]Params{}}
+	w.Event, _ = m["event"].(string)
+	w.EventHeader, _ = m["event_header"].(string)
+	w.Signature = SignatureMap(m["signature"])
+	if es, ok := m["events"].(map[string]any); ok {
+		for k, v := range es {
+			switch v := v.(type) {
+			case map[string]any:
+				_, w.Events[k] = ParamMap(v)
+			case []any:
+				_, w.Events[k] = ParamList(v)
+			default:
+				w.Events[k] = Params{}
+			}
+		}
+	}
+	return w
+}
+
+// Signature is the signature scheme of a webhook
+type Signature struct {
+	// Header is the header of the signature,
+	// defaulting to Webhook-Signature
+	Header string
+	// Timestamp is the header of the timestamp, when
+	// not provided in the signature header
+	Timestamp string
+	Secret    string
+	// Tolerance is the max age of a delivery,
+	// defaulting to 5m
+	Tolerance time.Duration
+}
+
+// SignatureMap returns the signature declared in the
+// signature section of a webhook spec, or nil if not declared
+func SignatureMap(v any) *Signature {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil
+	}
+	s := func(k string) string {
+		v, _ := m[k].(string)
+		return os.Expand(v, env.Get)
+	}
+	sig := &Signature{
+		Header:    s("header"),
+		Timestamp: s("timestamp"),
+		Secret:    s("secret"),
+		Tolerance: duration(m["tolerance"], 5*time.Minute),
+	}
+	if sig.Header == "" {
+		sig.Header = "Webhook-Signature"
+	}
+	return sig
+}
+
+// Sign sets the signature headers of a delivery
+// of the payload provided at the time provided
+func (s *Signature) Sign(h http.Header, payload []byte, t time.Time) {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	sig := s.sign(ts, payload)
+	if s.Timestamp != "" {
+		h.Set(s.Timestamp, ts)
+		h.Set(s.Header, "sha256="+sig)
+		return
+	}
+	h.Set(s.Header, "t="+ts+",v1="+sig)
+}
+
+// Verify verifies the signature headers of a delivery of
+// the payload provided, received at the time provided.
+// Returns an errors.Unauthenticated status if the signature
+// is missing or invalid, or the delivery is older, or newer,
+// than the tolerance of the signature, and an errors.Internal
+// status if the secret is empty, such as when its env var is
+// unset, since anyone may sign with an empty secret.
+func (s *Signature) Verify(h http.Header, payload []byte, now time.Time) error {
+	if s.Secret == "" {
+		return errors.Internal("api: webhook signature has no secret")
+	}
+	ts, sigs := s.parse(h)
+	if len(sigs) == 0 {
+		return errors.Unauthenticated("api: missing webhook signature")
+	}
+	t, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errors.Unauthenticated("api: missing webhook timestamp")
+	}
+	if d := now.Sub(time.Unix(t, 0)); d > s.Tolerance || d < -s.Tolerance {
+		return errors.Unauthenticated("api: webhook timestamp outside tolerance")
+	}
+	want := []byte(s.sign(ts, payload))
+	for _, sig := range sigs {
+		if hmac.Equal([]byte(strings.ToLower(sig)), want) {
+			return nil
+		}
+	}
+	return errors.Unauthenticated("api: invalid webhook signature")
+}
+
+// parse returns the timestamp and signatures of the headers
+func (s *Signature) parse(h http.Header) (ts string, sigs []string) {
+	for _, p := range strings.Split(h.Get(s.Header), ",") {
+		p = strings.TrimSpace(p)
+		switch {
+		case strings.HasPrefix(p, "t="):
+			ts = p[2:]
+		case strings.HasPrefix(p, "v1="):
+			sigs = append(sigs, p[3:])
+		case p != "":
+			sigs = append(sigs, strings.TrimPrefix(p, "sha256="))
+		}
+	}
+	if s.Timestamp != "" && ts == "" {
+		ts = h.Get(s.Timestamp)
+	}
+	return
+}
+
+func (s *Signature) sign(ts string, payload []byte) string {
+	m := hmac.New(sha256.New, []byte(s.Secret))
+	m.Write([]byte(ts))
+	m.Write([]byte{'.'})
+	m.Write(payload)
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// ----------------------------------------------------------------------------
+// WEBHOOK SERVER
+
+// EventHandler handles a verified and validated webhook event.
+// Errors returned as an *errors.Status are written with the
+// http code of the status, and other errors are written as
+// internal errors.
+type EventHandler func(e *Event) error
+
+// Event is a webhook event received by a webhook server
+type Event struct {
+	Name string
+	// Payload is the decoded payload of the event
+	Payload any
+	// Time is the signed time of the delivery, or
+	// the time received when not signed
+	Time    time.Time
+	Request *http.Request
+}
+
+// WebhookServer is an http.Handler of the events of a webhook
+type WebhookServer struct {
+	sync.RWMutex
+	Webhook *Webhook
+	// MaxBody is the max size of a payload, defaulting to 1MB
+	MaxBody int64
+	// Now returns the time deliveries are received,
+	// defaulting to time.Now
+	Now func() time.Time
+	// Logger logs the internal errors of deliveries, such
+	// as a missing secret, defaulting to stdout
+	Logger   *logger.Logger
+	handlers map[string]EventHandler
+}
+
+// NewWebhookServer returns a server of the webhook provided,
+// returning an error if the webhook is signed without a secret
+func NewWebhookServer(w *Webhook) (*WebhookServer, error) {
+	if w.Signature != nil && w.Signature.Secret == "" {
+		return nil, errors.Internal("api: webhook signature has no secret")
+	}
+	return &WebhookServer{
+		Webhook:  w,
+		MaxBody:  1 << 20,
+		Now:      time.Now,
+		Logger:   logger.New(),
+		handlers: map[string]EventHandler{},
+	}, nil
+}
+
+// Handle registers the handler of the event provided, or of
+// any event without a handler when the event is "*". Returns
+// an error if the event is not declared in the webhook.
+func (s *WebhookServer) Handle(event string, h EventHandler) error {
+	if _, ok := s.Webhook.Events[event]; !ok && event != "*" && len(s.Webhook.Events) > 0 {
+		return errors.NotFound("api: webhook event '" + event + "' not found")
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.handlers[event] = h
+	return nil
+}
+
+// ServeHTTP verifies the signature of the delivery, validates
+// the payload against the params of the event and dispatches
+// the event to its handler. Events without a handler are
+// acknowledged without being handled.
+func (s *WebhookServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	b, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.MaxBody))
+	if err != nil {
+		if _, ok := err.(*http.MaxBytesError); !ok {
+			err = errors.Invalid("api: failed to read webhook payload")
+		}
+		writeError(w, err)
+		return
+	}
+	e, err := s.event(r, b)
+	if err != nil {
+		if st, ok := err.(*errors.Status); ok && st.Code() == errors.INTERNAL && s.Logger != nil {
+			s.Logger.Errorw(st.Error(), "path", r.URL.Path)
+		}
+		writeError(w, err)
+		return
+	}
+	s.RLock()
+	h, ok := s.handlers[e.Name]
+	if !ok {
+		h = s.handlers["*"]
+	}
+	s.RUnlock()
+	if h != nil {
+		if err = h(e); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// event returns the verified and validated event of the
+// delivery of the payload provided
+func (s *WebhookServer) event(r *http.Request, b []byte) (*Event, error) {
+	wh := s.Webhook
+	e := &Event{Time: s.Now(), Request: r}
+	if wh.Signature != nil {
+		if err := wh.Signature.Verify(r.Header, b, e.Time); err != nil {
+			return nil, err
+		}
+		ts, _ := wh.Signature.parse(r.Header)
+		t, _ := strconv.ParseInt(ts, 10, 64)
+		e.Time = time.Unix(t, 0)
+	}
+	var err error
+	if e.Payload, err = decodeRequestBody(r.Header.Get("Content-Type"), b); err != nil {
+		return nil, err
+	}
+	if wh.EventHeader != "" {
+		e.Name = r.Header.Get(wh.EventHeader)
+	} else if n, ok := lookup(e.Payload, wh.Event).(string); ok && wh.Event != "" {
+		e.Name = n
+	}
+	if len(wh.Events) == 0 {
+		return e, nil
+	}
+	ps, ok := wh.Events[e.Name]
+	if !ok {
+		return nil, errors.Invalid("api: unknown webhook event '" + e.Name + "'")
+	}
+	return e, validationError(ps.Validate("payload", e.Payload))
+}
@@ -0,0 +1,773 @@
+// Copyright 2023 james dotter.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://github.com/jcdotter/go/LICENSE
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jcdotter/go/encoder"
+	"github.com/jcdotter/go/errors"
+)
+
+// ----------------------------------------------------------------------------
+// WEBSOCKET CHANNELS
+// the message channel of a resource of a websocket api,
+// declared in the channel section of the resource spec:
+//
+//	name: chat
+//	protocol: websocket
+//	url: ws://localhost:8080   # wss for tls
+//	resources:
+//	  rooms:
+//	    uri: /rooms
+//	    channel:
+//	      in:                  # messages received by clients
+//	        user: string
+//	        text: string
+//	      out:                 # messages sent by clients
+//	        text: string
+//	      ping: 30s            # interval of ping keepalives
+//	      reconnect:           # reconnect policy of clients
+//	        attempts: 5
+//	        backoff: 100ms
+//	        max_backoff: 10s
+//
+// Messages are sent as json text frames and validated against
+// the params of the channel, where clients send out messages
+// and receive in messages, and servers do the opposite. Binary
+// messages are received as the raw []byte and not validated.
+// Clients reconnect with the backoff of the reconnect policy
+// when the connection is lost, or closed by the server going
+// away, and fail reads when no frame is received for two ping
+// intervals.
+
+// Channel is the message channel of a websocket resource
+type Channel struct {
+	Resource *Resource
+	// In are the messages received by clients
+	In Params
+	// Out are the messages sent by clients
+	Out Params
+	// Ping is the interval of the ping keepalives
+	Ping time.Duration
+	// Reconnect is the reconnect policy of clients
+	Reconnect *Retry
+}
+
+// ChannelMap returns the channel declared in the channel
+// section of a resource spec, or nil if not declared
+func ChannelMap(r *Resource, v any) *Channel {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil
+	}
+	c := &Channel{
+		Resource:  r,
+		Ping:      duration(m["ping"], 0),
+		Reconnect: RetryMap(m["reconnect"]),
+	}
+	for k, p := range map[string]*Params{"in": &c.In, "out": &c.Out} {
+		switch v := m[k].(type) {
+		case map[string]any:
+			_, *p = ParamMap(v)
+		case []any:
+			_, *p = ParamList(v)
+		}
+	}
+	return c
+}
+
+// Connect opens a client stream of the channel, retrying
+// with the backoff of the reconnect policy of the channel
+func (c *Channel) Connect(ctx context.Context) (*Stream, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	s := &Stream{ctx: ctx, cancel: cancel, channel: c, client: true}
+	socket, err := s.connect()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	s.socket = socket
+	s.stop = context.AfterFunc(ctx, func() { s.Close() })
+	return s, nil
+}
+
+// Handler returns an http handler which accepts the
+// websocket connections of the channel, calling fn with
+// the stream of each connection, which is closed when
+// fn returns
+func (c *Channel) Handler(fn func(s *Stream)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		socket, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		socket.KeepAlive(c.Ping)
+		s := &Stream{ctx: r.Context(), channel: c, socket: socket}
+		defer s.Close()
+		fn(s)
+	})
+}
+
+// dial opens a socket to the resource url, sending the
+// header of the api authenticator with the handshake
+func (c *Channel) dial(ctx context.Context) (*Socket, error) {
+	if c.Resource == nil || c.Resource.Url == nil {
+		return nil, errors.Failed("api: channel has no resource url")
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, c.Resource.Url.String(), nil)
+	if err != nil {
+		return nil, errors.Invalid(err.Error())
+	}
+	if a := c.Resource.Api; a != nil && a.Authenticator != nil {
+		if err = a.Authenticator.Authorize(r); err != nil {
+			return nil, err
+		}
+	}
+	socket, _, err := DialSocket(ctx, r.URL, r.Header)
+	return socket, err
+}
+
+// ----------------------------------------------------------------------------
+// STREAM
+
+// Stream is a client or server connection of a channel
+type Stream struct {
+	sync.Mutex
+	ctx     context.Context
+	cancel  context.CancelFunc
+	channel *Channel
+	socket  *Socket
+	client  bool
+	closed  bool
+	stop    func() bool
+	// dialing is closed when the reconnect in progress ends
+	dialing chan struct{}
+}
+
+// Send validates the message provided and sends it to
+// the peer as json, or as binary when a []byte
+func (s *Stream) Send(v any) error {
+	if b, ok := v.([]byte); ok {
+		return s.send(true, b)
+	}
+	if err := validationError(s.params(true).Validate("message", v)); err != nil {
+		return err
+	}
+	return s.send(false, encoder.Json.New().Encode(v).Bytes())
+}
+
+func (s *Stream) send(binary bool, b []byte) error {
+	for {
+		socket, err := s.current()
+		if err != nil {
+			return err
+		}
+		if err = socket.WriteMessage(binary, b); err == nil || !s.reconnectable(err) {
+			return err
+		}
+		if err = s.reconnect(socket); err != nil {
+			return err
+		}
+	}
+}
+
+// Receive returns the next message received from the peer,
+// reconnecting clients when the connection is lost. Returns
+// io.EOF when the peer closes the stream, and an errors.Invalid
+// status along with the message if it does not match the
+// params of the channel.
+func (s *Stream) Receive() (any, error) {
+	for {
+		socket, err := s.current()
+		if err != nil {
+			return nil, err
+		}
+		binary, b, err := socket.ReadMessage()
+		if err != nil {
+			if !s.reconnectable(err) {
+				return nil, err
+			}
+			if err = s.reconnect(socket); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if binary {
+			return b, nil
+		}
+		v, err := decodeBody(contentString[JSON], b)
+		if err != nil {
+			return nil, err
+		}
+		return v, validationError(s.params(false).Validate("message", v))
+	}
+}
+
+// Close closes the stream with a normal closure
+func (s *Stream) Close() error {
+	// cancel any reconnect before waiting for the lock
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if s.stop != nil {
+		s.stop()
+	}
+	if s.socket == nil {
+		return nil
+	}
+	return s.socket.Close()
+}
+
+// params returns the params of the messages
+// sent, or received, by the side of the stream
+func (s *Stream) params(sent bool) Params {
+	if s.client == sent {
+		return s.channel.Out
+	}
+	return s.channel.In
+}
+
+func (s *Stream) current() (*Socket, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.closed || s.socket == nil {
+		return nil, errors.Cancelled("api: websocket stream closed")
+	}
+	return s.socket, nil
+}
+
+func (s *Stream) reconnectable(err error) bool {
+	return s.client && s.channel.Reconnect != nil && retryable(nil, err)
+}
+
+// reconnect replaces the socket provided with a new
+// connection, unless already replaced, dialing without
+// the lock so the stream can be closed meanwhile
+func (s *Stream) reconnect(socket *Socket) error {
+	s.Lock()
+	if s.closed {
+		s.Unlock()
+		return errors.Cancelled("api: websocket stream closed")
+	}
+	if s.socket != socket {
+		s.Unlock()
+		return nil
+	}
+	if d := s.dialing; d != nil {
+		// wait for the reconnect of another caller
+		s.Unlock()
+		<-d
+		if next, err := s.current(); err != nil || next == socket {
+			return errors.Unavailable("api: websocket reconnect failed")
+		}
+		return nil
+	}
+	d := make(chan struct{})
+	s.dialing = d
+	s.Unlock()
+	socket.Close()
+	next, err := s.connect()
+	s.Lock()
+	defer s.Unlock()
+	s.dialing = nil
+	close(d)
+	if err != nil {
+		return err
+	}
+	if s.closed {
+		next.Close()
+		return errors.Cancelled("api: websocket stream closed")
+	}
+	s.socket = next
+	return nil
+}
+
+// connect dials the channel, retrying with the backoff
+// of the reconnect policy of the channel
+func (s *Stream) connect() (*Socket, error) {
+	p := s.channel.Reconnect
+	for attempt := 1; ; attempt++ {
+		socket, err := s.channel.dial(s.ctx)
+		if err == nil {
+			socket.KeepAlive(s.channel.Ping)
+			return socket, nil
+		}
+		if p == nil || !retryable(nil, err) {
+			return nil, err
+		}
+		if attempt >= p.Attempts {
+			return nil, exhausted(nil, err, attempt)
+		}
+		if err = sleep(s.ctx, p.Delay(attempt+1), "api: websocket reconnect"); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// ----------------------------------------------------------------------------
+// SOCKET
+// a websocket connection, framed as specified by RFC 6455
+
+const (
+	opContinuation byte = 0x0
+	opText         byte = 0x1
+	opBinary       byte = 0x2
+	opClose        byte = 0x8
+	opPing         byte = 0x9
+	opPong         byte = 0xa
+)
+
+// close codes of RFC 6455
+const (
+	CloseNormal      = 1000
+	CloseGoingAway   = 1001
+	CloseProtocol    = 1002
+	CloseInvalidData = 1007
+	CloseTooBig      = 1009
+)
+
+// websocketGuid is appended to the handshake key
+// to compute the accept header of the handshake
+const websocketGuid = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// MaxMessage is the default max size of a message
+var MaxMessage = 32 << 20
+
+// Socket is a websocket connection. Messages may be
+// written concurrently with a single reader.
+type Socket struct {
+	// MaxMessage is the max size of a message received
+	MaxMessage int
+	conn       net.Conn
+	br         *bufio.Reader
+	client     bool
+	wmu        sync.Mutex
+	once       sync.Once
+	closed     atomic.Bool
+	interval   atomic.Int64
+	done       chan struct{}
+}
+
+func newSocket(conn net.Conn, br *bufio.Reader, client bool) *Socket {
+	return &Socket{
+		MaxMessage: MaxMessage,
+		conn:       conn,
+		br:         br,
+		client:     client,
+		done:       make(chan struct{}),
+	}
+}
+
+// DialSocket opens a client websocket connection to the ws
+// or wss url provided, sending the header provided with the
+// handshake. Returns the handshake response along with an
+// error status if the server refuses the connection.
+func DialSocket(ctx context.Context, u *url.URL, header http.Header) (*Socket, *http.Response, error) {
+	secure := u.Scheme == "wss" || u.Scheme == "https"
+	host, ru := u.Host, *u
+	ru.Scheme = "http"
+	if secure {
+		ru.Scheme = "https"
+	}
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), map[bool]string{false: "80", true: "443"}[secure])
+	}
+	var conn net.Conn
+	var err error
+	d := &net.Dialer{}
+	if secure {
+		conn, err = (&tls.Dialer{NetDialer: d, Config: &tls.Config{ServerName: u.Hostname()}}).DialContext(ctx, "tcp", host)
+	} else {
+		conn, err = d.DialContext(ctx, "tcp", host)
+	}
+	if err != nil {
+		return nil, nil, errors.Unavailable("api: websocket dial failed: " + err.Error())
+	}
+	key := make([]byte, 16)
+	rand.Read(key)
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, ru.String(), nil)
+	if err != nil {
+		conn.Close()
+		return nil, nil, errors.Invalid(err.Error())
+	}
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	r.Header.Set("Upgrade", "websocket")
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Sec-WebSocket-Key", base64.StdEncoding.EncodeToString(key))
+	r.Header.Set("Sec-WebSocket-Version", "13")
+	if dl, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(dl)
+	}
+	fail := func(res *http.Response, err error) (*Socket, *http.Response, error) {
+		conn.Close()
+		return nil, res, err
+	}
+	if err = r.Write(conn); err != nil {
+		return fail(nil, errors.Unavailable("api: websocket handshake failed: "+err.Error()))
+	}
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, r)
+	if err != nil {
+		return fail(nil, errors.Unavailable("api: websocket handshake failed: "+err.Error()))
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		return fail(res, errors.Http(res.StatusCode, "api: websocket handshake failed: "+res.Status))
+	}
+	if !strings.EqualFold(res.Header.Get("Upgrade"), "websocket") ||
+		res.Header.Get("Sec-WebSocket-Accept") != acceptKey(r.Header.Get("Sec-WebSocket-Key")) {
+		return fail(res, errors.Invalid("api: websocket handshake failed: invalid accept header"))
+	}
+	conn.SetDeadline(time.Time{})
+	return newSocket(conn, br, true), res, nil
+}
+
+// Upgrade upgrades the http request to a server websocket
+// connection, responding with an error status when the
+// request is not a websocket handshake
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Socket, error) {
+	var err error
+	key := r.Header.Get("Sec-WebSocket-Key")
+	switch {
+	case r.Method != http.MethodGet,
+		!strings.EqualFold(r.Header.Get("Upgrade"), "websocket"),
+		!headerContains(r.Header, "Connection", "upgrade"),
+		key == "":
+		err = errors.Invalid("api: not a websocket handshake")
+	case r.Header.Get("Sec-WebSocket-Version") != "13":
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		err = errors.Invalid("api: unsupported websocket version")
+	}
+	h, ok := w.(http.Hijacker)
+	if err == nil && !ok {
+		err = errors.Internal("api: websocket connection cannot be hijacked")
+	}
+	if err != nil {
+		err.(*errors.Status).HttpErr(w)
+		return nil, err
+	}
+	conn, rw, err := h.Hijack()
+	if err != nil {
+		return nil, errors.Internal("api: websocket hijack failed: " + err.Error())
+	}
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n")
+	if err = rw.Flush(); err != nil {
+		conn.Close()
+		return nil, errors.Unavailable("api: websocket handshake failed: " + err.Error())
+	}
+	return newSocket(conn, rw.Reader, false), nil
+}
+
+// KeepAlive pings the peer at the interval provided,
+// failing reads when no frame is received for two intervals
+func (s *Socket) KeepAlive(interval time.Duration) {
+	if interval <= 0 || s.interval.Swap(int64(interval)) > 0 {
+		return
+	}
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-t.C:
+				if s.write(opPing, nil) != nil {
+					return
+				}
+			}
+		}
+	}()
+}
+
+// ReadMessage returns the next text or binary message of the
+// connection, replying to pings and close frames. Returns io.EOF
+// on a normal closure by the peer, an errors.Unavailable status
+// when the connection is lost or the peer is going away, and an
+// errors.Aborted status when the peer closes with an error.
+func (s *Socket) ReadMessage() (binary bool, p []byte, err error) {
+	var op byte
+	started := false
+	for {
+		fin, fop, b, err := s.readFrame()
+		if err != nil {
+			return false, nil, err
+		}
+		switch fop {
+		case opPing:
+			if err = s.write(opPong, b); err != nil {
+				return false, nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			return false, nil, s.closeFrame(b)
+		case opText, opBinary:
+			if started {
+				return false, nil, s.fail(CloseProtocol, "unfinished message")
+			}
+			started, op, p = true, fop, b
+		case opContinuation:
+			if !started {
+				return false, nil, s.fail(CloseProtocol, "unexpected continuation")
+			}
+			p = append(p, b...)
+		default:
+			return false, nil, s.fail(CloseProtocol, "unknown opcode "+strconv.Itoa(int(fop)))
+		}
+		if len(p) > s.MaxMessage {
+			return false, nil, s.fail(CloseTooBig, "message too big")
+		}
+		if fin {
+			if op == opText && !utf8.Valid(p) {
+				return false, nil, s.fail(CloseInvalidData, "invalid utf8 text")
+			}
+			return op == opBinary, p, nil
+		}
+	}
+}
+
+// WriteMessage writes a text or binary message
+func (s *Socket) WriteMessage(binary bool, p []byte) error {
+	if binary {
+		return s.write(opBinary, p)
+	}
+	return s.write(opText, p)
+}
+
+// Ping writes a ping frame of the payload provided
+func (s *Socket) Ping(p []byte) error {
+	return s.write(opPing, p)
+}
+
+// Close closes the connection with a normal closure
+func (s *Socket) Close() error {
+	return s.CloseCode(CloseNormal, "")
+}
+
+// CloseCode sends a close frame of the code and reason
+// provided and closes the connection
+func (s *Socket) CloseCode(code int, reason string) (err error) {
+	s.once.Do(func() {
+		s.write(opClose, closePayload(code, reason))
+		s.closed.Store(true)
+		close(s.done)
+		err = s.conn.Close()
+	})
+	return
+}
+
+// readFrame reads the next frame of the connection
+func (s *Socket) readFrame() (fin bool, op byte, p []byte, err error) {
+	if d := s.interval.Load(); d > 0 {
+		s.conn.SetReadDeadline(time.Now().Add(2 * time.Duration(d)))
+	}
+	var h [8]byte
+	if _, err = io.ReadFull(s.br, h[:2]); err != nil {
+		return false, 0, nil, s.readErr(err)
+	}
+	fin, op = h[0]&0x80 != 0, h[0]&0x0f
+	masked, n := h[1]&0x80 != 0, uint64(h[1]&0x7f)
+	switch {
+	case h[0]&0x70 != 0:
+		return false, 0, nil, s.fail(CloseProtocol, "reserved bits set")
+	case masked == s.client:
+		return false, 0, nil, s.fail(CloseProtocol, "invalid frame mask")
+	case op >= opClose && (!fin || n > 125):
+		return false, 0, nil, s.fail(CloseProtocol, "invalid control frame")
+	}
+	switch n {
+	case 126:
+		if _, err = io.ReadFull(s.br, h[:2]); err != nil {
+			return false, 0, nil, s.readErr(err)
+		}
+		n = uint64(binary.BigEndian.Uint16(h[:2]))
+	case 127:
+		if _, err = io.ReadFull(s.br, h[:8]); err != nil {
+			return false, 0, nil, s.readErr(err)
+		}
+		n = binary.BigEndian.Uint64(h[:8])
+	}
+	if n > uint64(s.MaxMessage) {
+		return false, 0, nil, s.fail(CloseTooBig, "message too big")
+	}
+	var key [4]byte
+	if masked {
+		if _, err = io.ReadFull(s.br, key[:]); err != nil {
+			return false, 0, nil, s.readErr(err)
+		}
+	}
+	p = make([]byte, n)
+	if _, err = io.ReadFull(s.br, p); err != nil {
+		return false, 0, nil, s.readErr(err)
+	}
+	if masked {
+		mask(p, key)
+	}
+	return fin, op, p, nil
+}
+
+// write writes a single frame, masked if a client
+func (s *Socket) write(op byte, p []byte) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	if s.closed.Load() {
+		return errors.Cancelled("api: websocket closed")
+	}
+	b := make([]byte, 0, 14+len(p))
+	b = append(b, 0x80|op)
+	var m byte
+	if s.client {
+		m = 0x80
+	}
+	switch n := len(p); {
+	case n < 126:
+		b = append(b, m|byte(n))
+	case n <= 0xffff:
+		b = binary.BigEndian.AppendUint16(append(b, m|126), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint64(append(b, m|127), uint64(n))
+	}
+	if s.client {
+		var key [4]byte
+		rand.Read(key[:])
+		b = append(b, key[:]...)
+		i := len(b)
+		b = append(b, p...)
+		mask(b[i:], key)
+	} else {
+		b = append(b, p...)
+	}
+	if _, err := s.conn.Write(b); err != nil {
+		return errors.Unavailable("api: websocket write failed: " + err.Error())
+	}
+	return nil
+}
+
+// closeFrame replies to the close frame provided, closing
+// the connection, and returns the error of the close code.
+// Replies with a normal closure to frames without a code,
+// and with a protocol error to invalid or reserved codes.
+func (s *Socket) closeFrame(b []byte) error {
+	code, reason, reply := CloseNormal, "", CloseNormal
+	switch {
+	case len(b) == 1:
+		reply = CloseProtocol
+	case len(b) >= 2:
+		code, reason = int(binary.BigEndian.Uint16(b)), string(b[2:])
+		if reply = code; !validClose(code) {
+			reply = CloseProtocol
+		}
+	}
+	s.CloseCode(reply, "")
+	msg := "api: websocket closed with code " + strconv.Itoa(code)
+	if reason != "" {
+		msg += ": " + reason
+	}
+	switch code {
+	case CloseNormal:
+		return io.EOF
+	case CloseGoingAway, 1006, 1011, 1012, 1013, 1014:
+		return errors.Unavailable(msg)
+	}
+	return errors.Aborted(msg)
+}
+
+// fail closes the connection with the code and reason
+// provided, returning an errors.Invalid status
+func (s *Socket) fail(code int, reason string) error {
+	s.CloseCode(code, reason)
+	return errors.Invalid("api: websocket " + reason)
+}
+
+func (s *Socket) readErr(err error) error {
+	if s.closed.Load() {
+		return errors.Cancelled("api: websocket closed")
+	}
+	if e, ok := err.(net.Error); ok && e.Timeout() {
+		return errors.Unavailable("api: websocket keepalive timeout")
+	}
+	return errors.Unavailable("api: websocket connection lost: " + err.Error())
+}
+
+// validClose reports whether the close code provided may be
+// sent by a peer, excluding codes reserved by RFC 6455 for
+// local use (1004-1006, 1015) and unassigned codes
+func validClose(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1014:
+		return true
+	}
+	return code >= 3000 && code <= 4999
+}
+
+func closePayload(code int, reason string) []byte {
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	return append(binary.BigEndian.AppendUint16(nil, uint16(code)), reason...)
+}
+
+func mask(b []byte, key [4]byte) {
+	for i := range b {
+		b[i] ^= key[i&3]
+	}
+}
+
+func acceptKey(key string) string {
+	h := sha1.Sum([]byte(key + websocketGuid))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// headerContains reports whether the comma separated
+// values of the header contain the token provided
+func headerContains(h http.Header, key, token string) bool {
+	for _, v := range h.Values(key) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
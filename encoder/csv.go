@@ -0,0 +1,324 @@
+// Copyright 2023 james dotter.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://github.com/jcdotter/go/LICENSE
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encoder
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/jcdotter/go/errors"
+	t "github.com/jcdotter/go/typ"
+)
+
+// ----------------------------------------------------------------------------
+// ROWS
+// encodes slices of maps and structs as rows of values delimited
+// by ValEnd under a header of their keys, as in CSV, where nested
+// maps and structs are flattened to columns of their dotted keys
+// and values with delimiters, quotes or line breaks are quoted, as
+// in RFC 4180. Rows are encoded and decoded by Tabular encoders,
+// such as the Csv and Tsv presets, and decoded to slices of maps
+// which may be decoded into slices of structs with DecodeInto:
+//
+//	name,address.city,age
+//	admin,"Austin, TX",42
+//
+// The delimiter of an encoder may be configured with its ValEnd:
+//
+//	e := Csv.New()
+//	e.ValEnd = []byte(";")
+//	e.Init()
+
+// rowColumns are the columns of rows in the order first encoded
+type rowColumns struct {
+	keys []string
+	has  map[string]bool
+}
+
+func (c *rowColumns) add(k string) {
+	if !c.has[k] {
+		c.has[k] = true
+		c.keys = append(c.keys, k)
+	}
+}
+
+// encodeRows encodes the slice of maps or structs provided as rows,
+// or the map or struct provided as a single row, after a header
+// of the columns of the struct type and of all rows
+func (m *Encoder) encodeRows(v t.Value) {
+	if v.Value.IsValid() {
+		v = tableValue(v)
+	}
+	if !v.Value.IsValid() || v.IsNil() {
+		return
+	}
+	var rows []t.Value
+	rowType := v.Type()
+	switch v.KindX() {
+	case t.SLICE, t.ARRAY:
+		rowType = rowType.Elem()
+		v.Slice().ForEach(func(i int, v t.Value) (brake bool) {
+			rows = append(rows, tableValue(v))
+			return
+		})
+	case t.MAP, t.STRUCT:
+		rows = append(rows, v)
+	default:
+		panic(errors.Invalid("encoder: cannot encode type '" + v.Type().String() + "' as rows"))
+	}
+	cols := &rowColumns{has: map[string]bool{}}
+	for rowType.Kind() == t.POINTER {
+		rowType = rowType.Elem()
+	}
+	if rowType.KindX() == t.STRUCT {
+		m.typeColumns(rowType, "", cols)
+	}
+	cells := make([]map[string]t.Value, len(rows))
+	for i, r := range rows {
+		if !isTable(r) {
+			panic(errors.Invalid("encoder: cannot encode type '" + r.Type().String() + "' as a row"))
+		}
+		cells[i] = map[string]t.Value{}
+		m.rowCells(r, "", cells[i], cols)
+	}
+	if len(cols.keys) == 0 {
+		return
+	}
+	for i, k := range cols.keys {
+		if i > 0 {
+			m.write(m.ValEnd)
+		}
+		m.encodeCell(k)
+	}
+	m.write(m.LineBreak)
+	for _, row := range cells {
+		for i, k := range cols.keys {
+			if i > 0 {
+				m.write(m.ValEnd)
+			}
+			if v, ok := row[k]; ok {
+				m.encode(v)
+			}
+		}
+		m.write(m.LineBreak)
+	}
+}
+
+// rowCells sets the cells of the row provided to the values of the
+// map or struct provided, keyed by their columns, flattening nested
+// maps and structs to the columns of their dotted keys. Nil values
+// are excluded, encoding empty cells in the columns of other rows
+// or of the struct type.
+func (m *Encoder) rowCells(v t.Value, prefix string, row map[string]t.Value, cols *rowColumns) {
+	for _, e := range m.tableEntries(v) {
+		k := prefix + e.key
+		switch {
+		case e.v.IsNil():
+		case isTable(e.v):
+			m.rowCells(e.v, k+string(m.KeySep), row, cols)
+		case e.v.KindX() == t.SLICE || e.v.KindX() == t.ARRAY:
+			panic(errors.Invalid("encoder: cannot encode type '" + e.v.Type().String() + "' in column '" + k + "'"))
+		default:
+			cols.add(k)
+			row[k] = e.v
+		}
+	}
+}
+
+// typeColumns adds the columns of the fields of the struct type
+// provided, flattening nested structs to the columns of their dotted
+// keys, so that columns are encoded for structs without rows or with
+// nil values. The columns of maps and interfaces are added by rows.
+func (m *Encoder) typeColumns(typ *t.Type, prefix string, cols *rowColumns) {
+	keys := m.structKeys(typ)
+	typ.ForFields(func(i int, f *t.FieldType) (brake bool) {
+		if keys[i] == "-" {
+			return
+		}
+		k, ft := prefix+keys[i], f.Type()
+		for ft.Kind() == t.POINTER {
+			ft = ft.Elem()
+		}
+		switch ft.KindX() {
+		case t.STRUCT:
+			m.typeColumns(ft, k+string(m.KeySep), cols)
+		case t.MAP, t.INTERFACE, t.SLICE, t.ARRAY:
+		default:
+			cols.add(k)
+		}
+		return
+	})
+}
+
+// encodeCell encodes a string, quoted if it contains
+// delimiters, quotes or line breaks
+func (m *Encoder) encodeCell(s string) {
+	if !strings.Contains(s, string(m.ValEnd)) && !strings.ContainsAny(s, string(m.quote)+"\r\n") {
+		m.writeString(s)
+		return
+	}
+	m.buffer.WriteByte(m.quote)
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c == m.quote || c == m.escape {
+			m.buffer.WriteByte(m.escape)
+		}
+		m.buffer.WriteByte(s[i])
+	}
+	m.buffer.WriteByte(m.quote)
+}
+
+// ----------------------------------------------------------------------------
+// ROW DECODING
+
+// decodeRows decodes the rows of the buffer to a slice of maps keyed
+// by the columns of the header, where dotted columns are decoded
+// to nested maps and empty cells, which are not quoted, to nil
+func (m *Encoder) decodeRows() {
+	rows := []any{}
+	var header [][]string
+	for m.cursor < m.Len() {
+		at := m.cursor
+		cells := m.decodeRecord()
+		if cells == nil {
+			continue
+		}
+		if header == nil {
+			header = make([][]string, len(cells))
+			trial := map[string]any{}
+			for i, c := range cells {
+				k, _ := c.(string)
+				if header[i] = strings.Split(k, string(m.KeySep)); !setCell(trial, header[i], true) {
+					m.cursor = at
+					m.decodeError("unique columns")
+				}
+			}
+			continue
+		}
+		if len(cells) != len(header) {
+			m.cursor = at
+			m.decodeError(strconv.Itoa(len(header)) + " values")
+		}
+		row := map[string]any{}
+		for i, c := range cells {
+			setCell(row, header[i], c)
+		}
+		rows = append(rows, row)
+	}
+	m.value = rows
+}
+
+// setCell sets the value of the key path provided in the row,
+// creating the maps missing, and reports whether the key path
+// is not set in, or the parent of a value of, the row
+func setCell(row map[string]any, keys []string, v any) bool {
+	for _, k := range keys[:len(keys)-1] {
+		switch h := row[k].(type) {
+		case nil:
+			if _, ok := row[k]; ok {
+				return false
+			}
+			n := map[string]any{}
+			row[k], row = n, n
+		case map[string]any:
+			row = h
+		default:
+			return false
+		}
+	}
+	k := keys[len(keys)-1]
+	if _, ok := row[k]; ok {
+		return false
+	}
+	row[k] = v
+	return true
+}
+
+// decodeRecord decodes the cells of a line,
+// returning nil when the line is empty
+func (m *Encoder) decodeRecord() (cells []any) {
+	if m.decodeRecordEnd() {
+		return nil
+	}
+	for {
+		cells = append(cells, m.decodeCell())
+		if m.decodeRecordEnd() {
+			return
+		}
+		if !m.isMatch(m.ValEnd) {
+			m.decodeError(expectedTokens(m.ValEnd, m.LineBreak))
+		}
+		m.Inc(len(m.ValEnd))
+	}
+}
+
+// decodeRecordEnd decodes the line break at the end of a record,
+// reporting whether the cursor was at the end of a record
+func (m *Encoder) decodeRecordEnd() bool {
+	switch {
+	case m.cursor >= m.Len():
+	case m.isMatch(m.LineBreak):
+		m.Inc(len(m.LineBreak))
+	case m.isMatch([]byte("\r\n")):
+		m.Inc(2)
+	case m.Byte() == '\n':
+		m.Inc()
+	default:
+		return false
+	}
+	return true
+}
+
+// decodeCell decodes a quoted or unquoted cell, where unquoted cells
+// are typed when DecodeTyped and empty unquoted cells are nil
+func (m *Encoder) decodeCell() any {
+	if m.cursor < m.Len() && m.Byte() == m.quote {
+		return m.decodeQuotedCell()
+	}
+	at := m.cursor
+	for m.cursor < m.Len() && !m.isMatch(m.ValEnd) && m.Byte() != '\n' && m.Byte() != '\r' {
+		m.Inc()
+	}
+	if m.cursor == at {
+		return nil
+	}
+	s := string(m.Buffer()[at:m.cursor])
+	if m.DecodeTyped {
+		return m.decodeTyped(s)
+	}
+	return s
+}
+
+// decodeQuotedCell decodes a quoted cell, where quotes are escaped
+// by the escape character, which is a quote in RFC 4180
+func (m *Encoder) decodeQuotedCell() string {
+	m.Inc()
+	var b []byte
+	for {
+		if m.cursor >= m.Len() {
+			m.decodeError(expectedTokens([]byte{m.quote}))
+		}
+		c := m.Byte()
+		m.Inc()
+		switch {
+		case c == m.escape && m.cursor < m.Len() && (m.escape != m.quote || m.Byte() == m.quote):
+			b = append(b, m.Byte())
+			m.Inc()
+		case c == m.quote:
+			return string(b)
+		default:
+			b = append(b, c)
+		}
+	}
+}
@@ -17,14 +17,16 @@ package encoder
 import (
 	"bytes"
 	"fmt"
-	"math"
 	"reflect"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf16"
+	"unicode/utf8"
 	"unsafe"
 
 	"github.com/jcdotter/go/buffer"
+	"github.com/jcdotter/go/errors"
 	t "github.com/jcdotter/go/typ"
 	"github.com/jcdotter/go/uuid"
 )
@@ -67,12 +69,21 @@ type Encoder struct {
 	SliceItem         []byte // the characters before each slice element
 	MapStart          []byte // the characters that start a hash map
 	MapEnd            []byte // the characters that end a hash map
+	KeySep            []byte // the characters that separate the keys of dotted keys
+	TableStart        []byte // the characters that start a table header, encoding maps as tables when set
+	TableEnd          []byte // the characters that end a table header
+	TableArrayStart   []byte // the characters that start the header of a table in an array of tables
+	TableArrayEnd     []byte // the characters that end the header of a table in an array of tables
+	LiteralQuote      []byte // the quote characters of literal strings, without escapes
+	MultiLineQuote    []byte // the quote characters which start and end multi-line strings when tripled
+	TimeLayout        string // the layout of times encoded unquoted and decoded when typed, otherwise times are strings
 	InlineSyntax      *InlineSyntax
 	// encoding flags
 	Format           bool // when true, encode with formatting, indentation, and line breaks
 	FormatWithSpaces bool // when true, encode with space between keys and values
 	CascadeOnlyDeep  bool // when true, encode single-depth slices and maps with inline syntax
 	QuotedKey        bool // when true, encode map keys with quotes
+	QuotedSpecialKey bool // when true, encode map keys with quotes if they contain chars other than letters, digits, '_' and '-'
 	QuotedString     bool // when true, encode strings with quotes
 	QuotedSpecial    bool // when true, encode strings with quotes if they contain special characters
 	QuotedNum        bool // when true, encode numbers with quotes
@@ -82,6 +93,9 @@ type Encoder struct {
 	DecodeTyped      bool // when true, decode to typed values (int, float64, bool, string) instead of just strings
 	EncodeMethods    bool // when true, encode structs with a Encode method by calling the method
 	ExcludeZeros     bool // when true, exclude zero and nil values from encoding
+	Tabular          bool // when true, encode slices of maps and structs as rows of values delimited by ValEnd, headed by their keys
+	// decoding limits
+	MaxDepth int // the max nesting depth of the slices and maps decoded, defaultMaxDepth when zero
 	// encoder cache
 	space      byte
 	quote      byte
@@ -116,7 +130,7 @@ var (
 
 // ----------------------------------------------------------------------------
 // PRESET ENCODERS
-// JSON, YAML...
+// JSON, YAML, TOML, CSV, TSV...
 
 var (
 	Json = &Encoder{
@@ -163,6 +177,55 @@ var (
 			MapEnd:     []byte("}"),
 		},
 	}
+	Toml = &Encoder{
+		Type:             "toml",
+		QuotedString:     true,
+		QuotedSpecialKey: true,
+		DecodeTyped:      true,
+		Space:            []byte(" \t"),
+		LineBreak:        []byte("\n"),
+		Quote:            []byte(`"'`),
+		LiteralQuote:     []byte(`'`),
+		MultiLineQuote:   []byte(`"'`),
+		Escape:           []byte(`\`),
+		ValEnd:           []byte(","),
+		KeyEnd:           []byte("="),
+		KeySep:           []byte("."),
+		LineCommentStart: []byte("#"),
+		LineCommentEnd:   []byte("\n"),
+		SliceStart:       []byte("["),
+		SliceEnd:         []byte("]"),
+		MapStart:         []byte("{"),
+		MapEnd:           []byte("}"),
+		TableStart:       []byte("["),
+		TableEnd:         []byte("]"),
+		TableArrayStart:  []byte("[["),
+		TableArrayEnd:    []byte("]]"),
+		TimeLayout:       time.RFC3339Nano,
+	}
+	Csv = &Encoder{
+		Type:       "csv",
+		Tabular:    true,
+		Null:       []byte{},
+		Quote:      []byte(`"`),
+		Escape:     []byte(`"`),
+		ValEnd:     []byte(","),
+		LineBreak:  []byte("\r\n"),
+		KeySep:     []byte("."),
+		TimeLayout: time.RFC3339Nano,
+	}
+	// Tsv encodes structs with their csv tags
+	Tsv = &Encoder{
+		Type:       "csv",
+		Tabular:    true,
+		Null:       []byte{},
+		Quote:      []byte(`"`),
+		Escape:     []byte(`"`),
+		ValEnd:     []byte("\t"),
+		LineBreak:  []byte("\n"),
+		KeySep:     []byte("."),
+		TimeLayout: time.RFC3339Nano,
+	}
 )
 
 // ----------------------------------------------------------------------------
@@ -172,9 +235,22 @@ var (
 func init() {
 	Json.Init()
 	Yaml.Init()
+	Toml.Init()
+	Csv.Init()
+	Tsv.Init()
 }
 
+// Init initializes the encoder with its syntax,
+// panicking if the syntax is invalid. See TryInit.
 func (m *Encoder) Init() {
+	if err := m.TryInit(); err != nil {
+		panic(err)
+	}
+}
+
+// TryInit initializes the encoder with its syntax,
+// returning an error if the syntax is invalid
+func (m *Encoder) TryInit() error {
 	m.Reset()
 	if m.Null == nil {
 		m.Null = []byte("null")
@@ -189,23 +265,37 @@ func (m *Encoder) Init() {
 		m.Escape = []byte(`\`)
 	}
 	m.hasBrackets = !(m.MapStart == nil || m.MapEnd == nil || m.SliceStart == nil || m.SliceEnd == nil)
-	if !m.hasBrackets && !m.Format {
-		panic("cannot encode without brackets or formatting: unable to determine data structure")
+	switch {
+	case m.Tabular:
+		if len(m.ValEnd) == 0 || m.LineBreak == nil || m.KeySep == nil || len(m.Quote) == 0 || m.hasBrackets {
+			return errors.Invalid("encoder: tabular encoding requires value ends, line breaks, key separators and quotes, without brackets")
+		}
+	case !m.hasBrackets && !m.Format:
+		return errors.Invalid("encoder: cannot encode without brackets or formatting: unable to determine data structure")
+	case m.SliceItem != nil && m.hasBrackets:
+		return errors.Invalid("encoder: slice item reserved for bracketless encoding")
+	case !m.hasBrackets && InBytes('\n', m.Space):
+		return errors.Invalid("encoder: cannot encode without brackets when line breaks in space characters")
+	case len(m.Space) == 0:
+		return errors.Invalid("encoder: space characters required")
+	case len(m.KeyEnd) == 0:
+		return errors.Invalid("encoder: key end characters required")
+	case m.TableStart != nil && (m.TableEnd == nil || m.TableArrayStart == nil || m.TableArrayEnd == nil || m.KeySep == nil || m.LineBreak == nil || !m.hasBrackets):
+		return errors.Invalid("encoder: tables require table ends, array of tables, key separators, line breaks and brackets")
 	}
-	if m.SliceItem != nil && m.hasBrackets {
-		panic("slice item reserved for bracketless encoding")
+	m.space = m.Space[0]
+	if len(m.Quote) > 0 {
+		m.quote = m.Quote[0]
 	}
-	if !m.hasBrackets && InBytes('\n', m.Space) {
-		panic("cannot encode without brackets when line breaks in space characters")
+	if len(m.Escape) > 0 {
+		m.escape = m.Escape[0]
 	}
-	m.space = m.Space[0]
-	m.quote = m.Quote[0]
-	m.escape = m.Escape[0]
 	m.keyEnd = m.KeyEnd
 	m.valEnd = m.ValEnd
 	m.sliceParts = map[string][3][]byte{}
 	m.mapParts = map[string][3][]byte{}
 	m.initFormat()
+	return nil
 }
 
 func (m *Encoder) initFormat() {
@@ -235,6 +325,7 @@ func (m *Encoder) initFormat() {
 
 func (m *Encoder) New() *Encoder {
 	n := *m
+	n.buffer = buffer.New()
 	if m.InlineSyntax != nil {
 		s := *m.InlineSyntax
 		n.InlineSyntax = &s
@@ -363,7 +454,29 @@ func (m *Encoder) writeString(s string) {
 
 func (m *Encoder) writeQuotedString(s string) {
 	m.buffer.WriteByte(m.quote)
-	m.buffer.Write(EscapeString(s, m.quote, m.escape))
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case m.quote, m.escape:
+			m.buffer.WriteByte(m.escape)
+			m.buffer.WriteByte(c)
+		case '\n':
+			m.buffer.WriteByte(m.escape)
+			m.buffer.WriteByte('n')
+		case '\r':
+			m.buffer.WriteByte(m.escape)
+			m.buffer.WriteByte('r')
+		case '\t':
+			m.buffer.WriteByte(m.escape)
+			m.buffer.WriteByte('t')
+		default:
+			if c < 0x20 || c == 0x7f {
+				m.buffer.WriteByte(m.escape)
+				m.buffer.WriteString(fmt.Sprintf("u%04x", c))
+				continue
+			}
+			m.buffer.WriteByte(c)
+		}
+	}
 	m.buffer.WriteByte(m.quote)
 }
 
@@ -373,10 +486,27 @@ func (m *Encoder) writeQuotedString(s string) {
 
 func (m *Encoder) Encode(a any) *Encoder {
 	m.Reset()
-	m.encode(t.ValueOf(a))
+	switch {
+	case m.TableStart != nil:
+		m.encodeTables(t.ValueOf(a))
+	case m.Tabular:
+		m.encodeRows(t.ValueOf(a))
+	case a == nil:
+		m.write(m.Null)
+	default:
+		m.encode(t.ValueOf(a))
+	}
 	return m
 }
 
+// TryEncode encodes the value provided to the buffer of the
+// encoder, returning an error if the value cannot be encoded
+func (m *Encoder) TryEncode(a any) (err error) {
+	defer m.recoverError(&err)
+	m.Encode(a)
+	return
+}
+
 func (m *Encoder) encode(v t.Value, ancestry ...ancestor) {
 	if v.IsNil() {
 		m.write(m.Null)
@@ -412,7 +542,7 @@ func (m *Encoder) encode(v t.Value, ancestry ...ancestor) {
 	case t.TYPE:
 		m.encodeString((*t.Type)(v.Pointer()).String())
 	default:
-		panic("cannot encode type '" + v.Type().String() + "'")
+		panic(errors.Invalid("encoder: cannot encode type '" + v.Type().String() + "'"))
 	}
 }
 
@@ -456,15 +586,15 @@ func (m *Encoder) encodeNum(v t.Value) {
 	case t.UINTPTR:
 		bytes = []byte(strconv.FormatUint(*(*uint64)(v.Pointer()), 10))
 	case t.FLOAT32:
-		bytes = []byte(strconv.FormatFloat(float64(*(*float32)(v.Pointer())), 'f', -1, 64))
+		bytes = m.formatFloat(float64(*(*float32)(v.Pointer())))
 	case t.FLOAT64:
-		bytes = []byte(strconv.FormatFloat(*(*float64)(v.Pointer()), 'f', -1, 64))
+		bytes = m.formatFloat(*(*float64)(v.Pointer()))
 	case t.COMPLEX64:
 		bytes = []byte(strconv.FormatComplex(complex128(*(*complex64)(v.Pointer())), 'f', -1, 128))
 	case t.COMPLEX128:
 		bytes = []byte(strconv.FormatComplex(*(*complex128)(v.Pointer()), 'f', -1, 128))
 	default:
-		panic("cannot encode type '" + v.Type().String() + "'")
+		panic(errors.Invalid("encoder: cannot encode type '" + v.Type().String() + "'"))
 	}
 	if m.QuotedNum {
 		m.writeQuoted(bytes)
@@ -520,6 +650,10 @@ func (m *Encoder) encodeSlice(s t.Slice, ancestry ...ancestor) {
 }
 
 func (m *Encoder) encodeString(s string) {
+	if m.Tabular {
+		m.encodeCell(s)
+		return
+	}
 	quoted := m.QuotedString
 	if !quoted && m.QuotedSpecial {
 		if ContainsSpecial(s) {
@@ -542,15 +676,11 @@ func (m *Encoder) encodeStruct(s t.Struct, ancestry ...ancestor) {
 		return
 	}
 	delim, end, ancestry := m.encodeMapStart(s.Value, ancestry)
-	m.encodeStructTag(s)
-	j, k, has, keys := 0, "", m.hasTag[s.Type()], m.tagKeys[s.Type()]
+	j, keys := 0, m.structKeys(s.Type())
 	s.ForEach(func(i int, f *t.FieldType, v t.Value) (brake bool) {
-		if has {
-			k = keys[i]
-		} else {
-			k = f.Name()
+		if keys[i] != "-" {
+			j = m.encodeElem(j, delim, []byte(keys[i]), v, ancestry)
 		}
-		j = m.encodeElem(j, delim, []byte(k), v, ancestry)
 		return
 	})
 	m.encodeEnd(end)
@@ -589,14 +719,27 @@ func (m *Encoder) encodetStructByMethod(s t.Struct) bool {
 	return false
 }
 
-func (m *Encoder) encodeStructTag(s t.Struct) {
+// structKeys returns the keys of the fields of a struct type, which are
+// the names of their tags of the encoder type without options, or their
+// field names when untagged, where fields tagged "-" are excluded
+func (m *Encoder) structKeys(typ *t.Type) []string {
 	if m.hasTag == nil {
-		vals, has := s.Type().TagValues(m.Type)
-		m.hasTag = map[*t.Type]bool{s.Type(): has}
-		m.tagKeys = map[*t.Type][]string{s.Type(): vals}
-	} else if _, ok := m.hasTag[s.Type()]; !ok {
-		m.tagKeys[s.Type()], m.hasTag[s.Type()] = s.Type().TagValues(m.Type)
-	}
+		m.hasTag = map[*t.Type]bool{}
+		m.tagKeys = map[*t.Type][]string{}
+	} else if keys, ok := m.tagKeys[typ]; ok {
+		return keys
+	}
+	keys, has := make([]string, typ.NumField()), false
+	typ.ForFields(func(i int, f *t.FieldType) (brake bool) {
+		if keys[i], _, _ = strings.Cut(f.TagValue(m.Type), ","); keys[i] != "" {
+			has = true
+		} else {
+			keys[i] = f.Name()
+		}
+		return
+	})
+	m.hasTag[typ], m.tagKeys[typ] = has, keys
+	return keys
 }
 
 func (m *Encoder) encodeUnsafePointer(p unsafe.Pointer) {
@@ -604,6 +747,10 @@ func (m *Encoder) encodeUnsafePointer(p unsafe.Pointer) {
 }
 
 func (m *Encoder) encodeTime(t time.Time) {
+	if m.TimeLayout != "" {
+		m.writeString(t.Format(m.TimeLayout))
+		return
+	}
 	m.encodeString(t.String())
 }
 
@@ -755,8 +902,17 @@ func (m *Encoder) encodeElem(i int, delim, k []byte, v t.Value, ancestry []ances
 	if i == 0 {
 		delim = nil
 	}
+	// table encoders exclude nil keys and cannot encode nil
+	// elements, as TOML has no null, and exclude the zeros of
+	// keys, not of elements, when ExcludeZeros
 	if v.IsZero() {
-		if !m.ExcludeZeros {
+		if m.TableStart != nil && v.IsNil() {
+			if k == nil {
+				panic(errors.Invalid("encoder: cannot encode nil array elements in a table"))
+			}
+			return i
+		}
+		if !m.ExcludeZeros || k == nil && m.TableStart != nil {
 			i++
 			if v.IsNil() {
 				m.bufferElem(delim, k, m.Null)
@@ -788,8 +944,8 @@ func (m *Encoder) encodeElem(i int, delim, k []byte, v t.Value, ancestry []ances
 func (m *Encoder) bufferElem(del, key, val []byte) {
 	m.write(del)
 	if key != nil {
-		if m.QuotedKey {
-			m.writeQuoted(key)
+		if m.QuotedKey || m.QuotedSpecialKey && !isBareKey(string(key)) {
+			m.writeQuotedString(string(key))
 		} else {
 			m.write(key)
 		}
@@ -946,33 +1102,103 @@ func (m *Encoder) encodeKind(typ *t.Type) byte {
 // Decode Utilities
 // methods for decoding from type
 
-func (m *Encoder) decodeError(err string) {
-	var start, mid, end int
-	switch {
-	case m.cursor == 0:
-		start = 0
-		mid = 0
-		end = int(math.Min(float64(m.Len()-1), 25))
-	case m.cursor >= m.Len():
-		start = int(math.Max(0, float64(m.Len()-1-25)))
-		mid = m.Len()
-		end = m.Len()
+// SyntaxError is an error decoding malformed bytes, which
+// is returned by TryDecode wrapped as an invalid error
+type SyntaxError struct {
+	Offset   int    // the byte offset of the error
+	Line     int    // the line of the error, starting at 1
+	Column   int    // the byte column of the error, starting at 1
+	Expected string // a description of the tokens expected
+	Found    string // the character found or "end of input"
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("encoder: syntax error at line %d, column %d (offset %d): expected %s, found %s",
+		e.Line, e.Column, e.Offset, e.Expected, e.Found)
+}
+
+// decodeError panics with a syntax error at the cursor,
+// which is recovered and returned by TryDecode
+func (m *Encoder) decodeError(expected string) {
+	b := m.Buffer()
+	o := min(m.cursor, len(b))
+	e := &SyntaxError{
+		Offset:   o,
+		Line:     1 + bytes.Count(b[:o], []byte{'\n'}),
+		Column:   o - bytes.LastIndexByte(b[:o], '\n'),
+		Expected: expected,
+		Found:    "end of input",
+	}
+	if o < len(b) {
+		r, _ := utf8.DecodeRune(b[o:])
+		e.Found = strconv.QuoteRune(r)
+	}
+	panic(e)
+}
+
+// expectedTokens returns a description of the tokens provided
+func expectedTokens(tokens ...[]byte) string {
+	var s []string
+	for _, t := range tokens {
+		if t != nil {
+			s = append(s, strconv.Quote(string(t)))
+		}
+	}
+	return strings.Join(s, " or ")
+}
+
+// recoverError recovers the errors of encoding and decoding,
+// setting err to the error recovered
+func (m *Encoder) recoverError(err *error) {
+	switch r := recover().(type) {
+	case nil:
+	case *SyntaxError:
+		*err = errors.Wrap(errors.INVALID, r)
+	case *errors.Status:
+		*err = r
 	default:
-		start = int(math.Max(0, float64(m.cursor-25)))
-		mid = m.cursor
-		end = int(math.Min(float64(m.Len()-1), float64(m.cursor+25)))
+		panic(r)
 	}
-	panic("decode error at position " + strconv.Itoa(m.cursor) + " of " + strconv.Itoa(m.Len()) + "\n" +
-		"tried to decode:\n" + string(m.Buffer()[start:mid]) + "<ERROR>" + string(m.Buffer()[mid:end]) + "\n" +
-		"error: " + err)
 }
 
+// Decode decodes the bytes provided, or the buffer of the encoder
+// when none are provided, to the value of the encoder, panicking
+// if the bytes are malformed. See TryDecode.
 func (m *Encoder) Decode(bytes ...[]byte) *Encoder {
-	m.ResetCursor()
+	if err := m.TryDecode(bytes...); err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// TryDecode decodes the bytes provided, or the buffer of the encoder
+// when none are provided, to the value of the encoder, returning a
+// SyntaxError wrapped as an invalid error if the bytes are malformed
+func (m *Encoder) TryDecode(bytes ...[]byte) (err error) {
 	if len(bytes) > 0 {
 		m.buffer.Set(bytes[0])
 	}
 	m.value = nil
+	defer func() {
+		if m.ResetCursor(); err != nil {
+			m.value = nil
+		}
+	}()
+	defer m.recoverError(&err)
+	m.ResetCursor()
+	m.decode()
+	return
+}
+
+func (m *Encoder) decode() {
+	if m.TableStart != nil {
+		m.decodeTables()
+		return
+	}
+	if m.Tabular {
+		m.decodeRows()
+		return
+	}
 	var slice []any
 	var hmap map[string]any
 	var value any
@@ -980,38 +1206,71 @@ func (m *Encoder) Decode(bytes ...[]byte) *Encoder {
 		slice, hmap = m.decodeObject()
 		if slice != nil {
 			m.value = slice
-			m.ResetCursor()
-			return m
+			m.decodeEnd()
+			return
 		}
 		if hmap != nil {
 			m.value = hmap
-			m.ResetCursor()
-			return m
+			m.decodeEnd()
+			return
 		}
 		value = m.decodeItem(nil)
 		if value != any(nil) {
 			m.value = value
-			m.ResetCursor()
-			return m
+			m.decodeEnd()
+			return
 		}
 		m.Inc()
 	}
-	m.ResetCursor()
-	return m
+}
+
+// decodeEnd checks that only non data follows the value decoded
+func (m *Encoder) decodeEnd() {
+	m.decodeNonData()
+	if m.cursor < m.Len() {
+		m.decodeError("end of input")
+	}
 }
 
 func (m *Encoder) decodeObject(ancestry ...ancestor) (slice []any, hmap map[string]any) {
+	at := m.cursor
 	if delim, end, isSlice := m.decodeSliceStart(ancestry); isSlice {
+		m.decodeDepth(at, ancestry)
 		return m.decodeSlice(delim, end, ancestry...), nil
 	}
 	if delim, end, isMap := m.decodeMapStart(ancestry); isMap {
+		m.decodeDepth(at, ancestry)
 		return nil, m.decodeMap(delim, end, ancestry...)
 	}
 	return nil, nil
 }
 
+// defaultMaxDepth is the max nesting depth of the
+// slices and maps decoded when MaxDepth is zero
+const defaultMaxDepth = 1000
+
+// decodeDepth fails with a syntax error at the start of the
+// slice or map provided if nested deeper than the max depth
+func (m *Encoder) decodeDepth(at int, ancestry []ancestor) {
+	if max := m.maxDepth(); len(ancestry) >= max {
+		m.cursor = at
+		m.decodeError("a nesting depth of at most " + strconv.Itoa(max))
+	}
+}
+
+// maxDepth returns the max nesting depth of the encoder
+func (m *Encoder) maxDepth() int {
+	if m.MaxDepth <= 0 {
+		return defaultMaxDepth
+	}
+	return m.MaxDepth
+}
+
 func (m *Encoder) decodeSlice(delim, end []byte, ancestry ...ancestor) (slice []any) {
 	ancestry = append([]ancestor{{sliceType, 0}}, ancestry...)
+	if m.decodeEmpty(end) {
+		return []any{}
+	}
 	for m.cursor < m.Len() {
 		slice = append(slice, m.decodeItem([][]byte{delim, end}, ancestry...))
 		m.decodeNonData()
@@ -1022,9 +1281,12 @@ func (m *Encoder) decodeSlice(delim, end []byte, ancestry ...ancestor) (slice []
 		if m.isMatch(end) || end == nil {
 			m.Inc(len(end))
 			m.decDepth()
-			break
+			return
 		}
-		m.decodeError("failed to find end of slice element")
+		m.decodeError(expectedTokens(delim, end))
+	}
+	if end != nil {
+		m.decodeError(expectedTokens(end))
 	}
 	return
 }
@@ -1032,6 +1294,9 @@ func (m *Encoder) decodeSlice(delim, end []byte, ancestry ...ancestor) (slice []
 func (m *Encoder) decodeMap(delim, end []byte, ancestry ...ancestor) map[string]any {
 	ancestry = append([]ancestor{{mapType, 0}}, ancestry...)
 	hmap := map[string]any{}
+	if m.decodeEmpty(end) {
+		return hmap
+	}
 	for m.cursor < m.Len() {
 		hmap[m.decodeKey()] = m.decodeItem([][]byte{delim, end}, ancestry...)
 		m.decodeNonData()
@@ -1041,15 +1306,42 @@ func (m *Encoder) decodeMap(delim, end []byte, ancestry ...ancestor) map[string]
 		} else if m.isMatch(end) || end == nil {
 			m.Inc(len(end))
 			m.decDepth()
-			break
+			return hmap
 		}
-		m.decodeError("failed to find end of map element")
+		m.decodeError(expectedTokens(delim, end))
+	}
+	if end != nil {
+		m.decodeError(expectedTokens(end))
 	}
 	return hmap
 }
 
+// decodeEmpty returns true if the bracketed slice or
+// map being decoded has no elements, moving the cursor
+// past the end of the slice or map
+func (m *Encoder) decodeEmpty(end []byte) bool {
+	if end == nil {
+		return false
+	}
+	c := m.cursor
+	m.decodeNonData()
+	if !m.isMatch(end) {
+		m.cursor = c
+		return false
+	}
+	m.Inc(len(end))
+	m.decDepth()
+	return true
+}
+
 func (m *Encoder) decodeItem(endings [][]byte, ancestry ...ancestor) any {
 	m.decodeNonData()
+	if m.cursor >= m.Len() {
+		if m.hasBrackets {
+			m.decodeError("value")
+		}
+		return nil
+	}
 	switch {
 	case m.isQuote():
 		return m.decodeQuote()
@@ -1074,13 +1366,13 @@ func (m *Encoder) decodeKey() (key string) {
 		key = m.decodeQuote()
 	}
 	s := m.cursor
-	for m.cursor < m.Len() {
-		if m.isKeyEnd() {
-			m.Inc()
-			break
-		}
+	for m.cursor < m.Len() && !m.isKeyEnd() {
 		m.Inc()
 	}
+	if m.cursor >= m.Len() {
+		m.decodeError(expectedTokens(m.KeyEnd))
+	}
+	m.Inc()
 	if key == "" {
 		key = string(m.Buffer()[s : m.cursor-1])
 	}
@@ -1100,20 +1392,32 @@ func (m *Encoder) decodeAny(end ...[]byte) any {
 		return nil
 	}
 	if m.DecodeTyped {
-		if a == "true" {
-			return true
-		}
-		if a == "false" {
-			return false
-		}
-		if a == string(m.Null) {
-			return nil
-		}
-		if i, e := strconv.ParseInt(a, 10, 64); e == nil {
-			return int(i)
-		}
-		if f, e := strconv.ParseFloat(a, 64); e == nil {
-			return f
+		return m.decodeTyped(a)
+	}
+	return a
+}
+
+// decodeTyped returns the bool, null, int or float64 value
+// of the unquoted string provided, or the string if none
+func (m *Encoder) decodeTyped(a string) any {
+	if a == "true" {
+		return true
+	}
+	if a == "false" {
+		return false
+	}
+	if a == string(m.Null) {
+		return nil
+	}
+	if i, e := strconv.ParseInt(a, 10, 64); e == nil {
+		return int(i)
+	}
+	if f, e := strconv.ParseFloat(a, 64); e == nil {
+		return f
+	}
+	if m.TimeLayout != "" {
+		if tm, ok := decodeTime(a); ok {
+			return tm
 		}
 	}
 	return a
@@ -1122,21 +1426,82 @@ func (m *Encoder) decodeAny(end ...[]byte) any {
 func (m *Encoder) decodeQuote() string {
 	q := m.Buffer()[m.cursor]
 	m.Inc()
-	s := m.cursor
+	s, escaped := m.cursor, false
 	for m.cursor < m.Len() {
 		if m.isEscape() {
+			escaped = true
 			m.Inc(2)
 			continue
 		}
 		if m.ByteIs(q) {
-			m.Inc()
 			break
 		}
 		m.Inc()
 	}
+	if m.cursor >= m.Len() {
+		m.decodeError(expectedTokens([]byte{q}))
+	}
+	m.Inc()
+	if escaped {
+		return m.unescape(m.Buffer()[s : m.cursor-1])
+	}
 	return string(m.Buffer()[s : m.cursor-1])
 }
 
+// unescape returns the quoted string with the
+// escape sequences replaced by the chars escaped
+func (m *Encoder) unescape(b []byte) string {
+	u := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		if !InBytes(b[i], m.Escape) || i+1 == len(b) {
+			u = append(u, b[i])
+			continue
+		}
+		i++
+		switch b[i] {
+		case 'n':
+			u = append(u, '\n')
+		case 'r':
+			u = append(u, '\r')
+		case 't':
+			u = append(u, '\t')
+		case 'b':
+			u = append(u, '\b')
+		case 'f':
+			u = append(u, '\f')
+		case 'u':
+			if r, ok := unescapeHex(b[i+1:]); ok {
+				i += 4
+				// a surrogate pair is escaped as two utf-16 units
+				if utf16.IsSurrogate(r) && i+2 < len(b) && InBytes(b[i+1], m.Escape) && b[i+2] == 'u' {
+					if r2, ok := unescapeHex(b[i+3:]); ok {
+						if d := utf16.DecodeRune(r, r2); d != utf8.RuneError {
+							r = d
+							i += 6
+						}
+					}
+				}
+				u = utf8.AppendRune(u, r)
+				continue
+			}
+			u = append(u, b[i])
+		default:
+			u = append(u, b[i])
+		}
+	}
+	return string(u)
+}
+
+// unescapeHex returns the rune of the 4 hex digits
+// at the start of the bytes provided
+func unescapeHex(b []byte) (rune, bool) {
+	if len(b) < 4 {
+		return 0, false
+	}
+	r, err := strconv.ParseUint(string(b[:4]), 16, 32)
+	return rune(r), err == nil
+}
+
 func (m *Encoder) decodeNull() any {
 	m.Inc(len(m.Null))
 	return nil
@@ -1156,14 +1521,14 @@ func (m *Encoder) decodeSpace() string {
 func (m *Encoder) decodeCommentBlock() []byte {
 	if m.isBlockCommentStart() {
 		s := m.cursor
-		for m.cursor < m.Len() {
-			if m.isBlockCommentEnd() {
-				m.Inc(len(m.BlockCommentEnd))
-				break
-			}
+		for m.cursor < m.Len() && !m.isBlockCommentEnd() {
 			m.Inc()
 		}
-		return m.Buffer()[s : m.cursor+len(m.BlockCommentEnd)-1]
+		if m.cursor >= m.Len() {
+			m.decodeError(expectedTokens(m.BlockCommentEnd))
+		}
+		m.Inc(len(m.BlockCommentEnd))
+		return m.Buffer()[s:m.cursor]
 	}
 	return nil
 }
@@ -1289,15 +1654,15 @@ func (m *Encoder) ByteIs(b byte) bool {
 }
 
 func (m *Encoder) isSpace() bool {
-	return InBytes(m.Buffer()[m.cursor], m.Space)
+	return m.cursor < m.Len() && InBytes(m.Buffer()[m.cursor], m.Space)
 }
 
 func (m *Encoder) isQuote() bool {
-	return InBytes(m.Buffer()[m.cursor], m.Quote)
+	return m.cursor < m.Len() && InBytes(m.Buffer()[m.cursor], m.Quote)
 }
 
 func (m *Encoder) isEscape() bool {
-	return InBytes(m.Buffer()[m.cursor], m.Escape)
+	return m.cursor < m.Len() && InBytes(m.Buffer()[m.cursor], m.Escape)
 }
 
 func (m *Encoder) isNull() bool {
@@ -1320,7 +1685,7 @@ func (m *Encoder) isKey() bool {
 }
 
 func (m *Encoder) isKeyEnd() bool {
-	return InBytes(m.Buffer()[m.cursor], m.KeyEnd)
+	return m.cursor < m.Len() && InBytes(m.Buffer()[m.cursor], m.KeyEnd)
 }
 
 func (m *Encoder) isBlockCommentStart() bool {
@@ -17,12 +17,17 @@ package encoder
 import (
 	"bytes"
 	"encoding/json"
+	"io"
+	"math"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/jcdotter/go/errors"
 	str "github.com/jcdotter/go/strings"
 	"github.com/jcdotter/go/test"
 	"github.com/jcdotter/go/typ"
+	"github.com/jcdotter/go/uuid"
 )
 
 var config = &test.Config{
@@ -52,7 +57,7 @@ func TestEncoder(t *testing.T) {
 		},
 	}
 
-	json := `{"Name":"John Doe","Age":30,"Address":{"City":"New York","Country":"USA"}}`
+	json := `{"name":"John Doe","age":30,"address":{"city":"New York","country":"USA"}}`
 	yaml := "Name: \"John Doe\"\nAge: 30\nAddress: \n  City: \"New York\"\n  Country: USA"
 
 	jsonResult := Json.Encode(Struct).String()
@@ -125,3 +130,565 @@ func BenchmarkMarshaller(b *testing.B) {
 		})
 	}
 }
+
+func TestDecodeEmpty(t *testing.T) {
+	gt := test.New(t, config)
+	gt.Msg = "Testing Json.Decode(%s)"
+	gt.Equal([]any{}, Json.New().Decode([]byte(`[ ]`)).Slice(), "empty slice")
+	v := Json.New().Decode([]byte(`{"a":[],"b":{ },"c":["1"]}`)).Map()
+	gt.Equal(map[string]any{"a": []any{}, "b": map[string]any{}, "c": []any{"1"}}, v, "empty values")
+}
+
+func TestEscape(t *testing.T) {
+	gt := test.New(t, config)
+	gt.Msg = "Testing %s.Encode().Decode(escaped)"
+	v := map[string]any{"s": "a \"quoted\"\tline\nwith \\ escapes"}
+	for n, e := range map[string]*Encoder{"Json": Json, "Yaml": Yaml} {
+		b := e.New().Encode(v).Bytes()
+		gt.Equal(v, e.New().Decode(b).Map(), n)
+	}
+	gt.Equal(`{"s":"a \"quoted\"\tline\nwith \\ escapes"}`, Json.New().Encode(v).String(), "Json")
+	gt.Equal("é☃", Json.New().Decode([]byte(`["\u00e9\u2603"]`)).Slice()[0], "Json unicode")
+	gt.Equal("😀", Json.New().Decode([]byte(`["\ud83d\ude00"]`)).Slice()[0], "Json surrogate pair")
+	gt.Equal("\ufffdx", Json.New().Decode([]byte(`["\ud83dx"]`)).Slice()[0], "Json lone surrogate")
+}
+
+type decodeAddress struct {
+	City    string `json:"city"`
+	Country string `json:"country,omitempty"`
+}
+
+type decodeBase struct {
+	Id uuid.UUID `json:"id"`
+}
+
+type decodeUser struct {
+	decodeBase
+	Name     string                    `json:"name"`
+	Age      int                       `json:"age"`
+	Score    float64                   `json:"score"`
+	Active   bool                      `json:"active"`
+	Joined   time.Time                 `json:"joined"`
+	Address  *decodeAddress            `json:"address"`
+	Tags     []string                  `json:"tags"`
+	Homes    []decodeAddress           `json:"homes"`
+	Counts   map[string]int            `json:"counts"`
+	Extra    any                       `json:"extra"`
+	Secret   string                    `json:"-"`
+	Nickname string                    `json:",omitempty"`
+	Nested   map[string]map[string]int `json:"nested"`
+}
+
+func TestDecodeInto(t *testing.T) {
+	gt := test.New(t, config)
+	gt.Msg = "DecodeInto.%s"
+	u := decodeUser{
+		decodeBase: decodeBase{Id: uuid.Parse("8c5f2ad6-4a0e-4c3e-9d61-2b8e1fd0a1c7")},
+		Name:       "John Doe",
+		Age:        30,
+		Score:      9.5,
+		Active:     true,
+		Joined:     time.Date(2023, 5, 1, 12, 30, 0, 0, time.UTC),
+		Address:    &decodeAddress{City: "New York", Country: "USA"},
+		Tags:       []string{"a", "b"},
+		Homes:      []decodeAddress{{City: "Boston"}, {City: "Paris", Country: "France"}},
+		Counts:     map[string]int{"x": 1, "y": 2},
+		Extra:      map[string]any{"k": "v"},
+		Secret:     "secret",
+		Nickname:   "Johnny",
+		Nested:     map[string]map[string]int{"a": {"b": 1}},
+	}
+	for n, e := range map[string]*Encoder{"Json": Json, "Yaml": Yaml} {
+		b := e.New().Encode(u).Bytes()
+		var d decodeUser
+		gt.NoError(e.New().DecodeInto(&d, b), n)
+		gt.True(d.Joined.Equal(u.Joined), n+".time")
+		d.Joined, d.Secret = u.Joined, u.Secret
+		gt.Equal(u, d, n)
+	}
+	gt.False(strings.Contains(Json.New().Encode(u).String(), "secret"), "excluded")
+
+	// typed decoding, key matching and conversions
+	typed := Json.New()
+	typed.DecodeTyped = true
+	var d decodeUser
+	gt.NoError(typed.DecodeInto(&d, []byte(`{"NAME":"Jane","age":41,"score":3,"active":true,"joined":"2024-01-02T03:04:05Z","counts":{"z":"7"}}`)), "typed")
+	gt.Equal("Jane", d.Name, "typed.name")
+	gt.Equal(41, d.Age, "typed.age")
+	gt.Equal(3.0, d.Score, "typed.score")
+	gt.Equal(map[string]int{"z": 7}, d.Counts, "typed.counts")
+	gt.Equal(2024, d.Joined.Year(), "typed.time")
+
+	var nums []int
+	gt.NoError(Json.New().DecodeInto(&nums, []byte(`[1, 2, 3]`)), "slice")
+	gt.Equal([]int{1, 2, 3}, nums, "slice")
+	var keys map[int]bool
+	gt.NoError(Yaml.New().DecodeInto(&keys, []byte("1: true\n2: false")), "map keys")
+	gt.Equal(map[int]bool{1: true, 2: false}, keys, "map keys")
+	var p *decodeAddress
+	gt.NoError(Json.New().DecodeInto(&p, []byte(`{"city":"Rome"}`)), "pointer")
+	gt.Equal("Rome", p.City, "pointer")
+
+	// errors
+	err := Json.New().DecodeInto(&d, []byte(`{"age":"old"}`))
+	gt.Error(err, "error")
+	gt.True(strings.Contains(err.Error(), "age"), "error.path", err)
+	gt.Error(Json.New().DecodeInto(&d, []byte(`{"homes":[{"city":["x"]}]}`)), "error.nested")
+	gt.Error(Json.New().DecodeInto(&d, []byte(`{"id":"not a uuid"}`)), "error.uuid")
+	gt.Error(Json.New().DecodeInto(d, []byte(`{}`)), "error.pointer")
+}
+
+func TestTryDecode(t *testing.T) {
+	gt := test.New(t, config)
+	gt.Msg = "TryDecode.%s"
+	e := Json.New()
+	e.MaxDepth = 3
+	gt.NoError(e.TryDecode([]byte(`{"a":[1,2]}`)), "valid")
+	gt.Equal(map[string]any{"a": []any{"1", "2"}}, e.Map(), "valid")
+	for n, c := range map[string]struct {
+		in                   string
+		offset, line, column int
+		expected, found      string
+	}{
+		"unclosed map":     {`{"a":1`, 6, 1, 7, `"," or "}"`, "end of input"},
+		"unclosed slice":   {"[1,\n 2", 6, 2, 3, `"," or "]"`, "end of input"},
+		"missing value":    {`{"a":`, 5, 1, 6, "value", "end of input"},
+		"missing key end":  {`{"a"}`, 5, 1, 6, `":"`, "end of input"},
+		"unclosed quote":   {`["abc]`, 6, 1, 7, `"\""`, "end of input"},
+		"unclosed comment": {`/* x`, 4, 1, 5, `"*/"`, "end of input"},
+		"bad delimiter":    {"[\"a\"\n  \"b\"]", 7, 2, 3, `"," or "]"`, `'"'`},
+		"trailing data":    {`{"a":1} x`, 8, 1, 9, "end of input", `'x'`},
+		"too deep":         {`[[[{"a":1}]]]`, 3, 1, 4, "a nesting depth of at most 3", `'{'`},
+	} {
+		err := e.TryDecode([]byte(c.in))
+		gt.Error(err, n)
+		gt.Equal(errors.INVALID, err.(*errors.Status).Code(), n+".code")
+		var se *SyntaxError
+		gt.True(errors.As(err, &se), n+".syntax")
+		gt.Equal(c.offset, se.Offset, n+".offset")
+		gt.Equal(c.line, se.Line, n+".line")
+		gt.Equal(c.column, se.Column, n+".column")
+		gt.Equal(c.expected, se.Expected, n+".expected")
+		gt.Equal(c.found, se.Found, n+".found")
+		gt.Equal(nil, e.Value(), n+".value")
+	}
+	gt.Error(Yaml.New().TryDecode([]byte("a: \"b")), "yaml")
+	start := time.Now()
+	gt.Error(Json.New().TryDecode(bytes.Repeat([]byte("["), 5<<20)), "default max depth")
+	gt.True(time.Since(start) < time.Second, "default max depth time")
+	func() {
+		defer func() { gt.True(recover() != nil, "Decode.panic") }()
+		Json.New().Decode([]byte(`[1`))
+	}()
+}
+
+func TestTryEncodeInit(t *testing.T) {
+	gt := test.New(t, config)
+	gt.Msg = "TryEncodeInit.%s"
+	err := Json.New().TryEncode(make(chan int))
+	gt.Error(err, "TryEncode")
+	gt.Equal(errors.INVALID, err.(*errors.Status).Code(), "TryEncode.code")
+	gt.NoError(Json.New().TryEncode(map[string]int{"a": 1}), "TryEncode.valid")
+
+	gt.NoError(Json.New().TryInit(), "TryInit.valid")
+	gt.Error((&Encoder{Type: "x", KeyEnd: []byte(":")}).TryInit(), "TryInit.structure")
+	gt.Error((&Encoder{Type: "x", MapStart: []byte("{"), MapEnd: []byte("}"), SliceStart: []byte("["), SliceEnd: []byte("]")}).TryInit(), "TryInit.keyEnd")
+	y := Yaml.New()
+	y.Space = []byte(" \n")
+	err = y.TryInit()
+	gt.Error(err, "TryInit.space")
+	gt.Equal(errors.INVALID, err.(*errors.Status).Code(), "TryInit.code")
+}
+
+func TestStream(t *testing.T) {
+	gt := test.New(t, config)
+	gt.Msg = "Stream.%s"
+
+	// writer
+	var b bytes.Buffer
+	w := Json.NewWriter(&b)
+	gt.NoError(w.StartSlice(), "StartSlice")
+	gt.NoError(w.Encode(decodeAddress{City: "Boston"}), "Encode")
+	gt.NoError(w.StartMap(), "StartMap")
+	gt.NoError(w.Key("n"), "Key")
+	gt.NoError(w.Encode(1), "Encode")
+	gt.Error(w.Encode(2), "Encode.key")
+	gt.NoError(w.Key("s"), "Key")
+	gt.NoError(w.StartSlice(), "StartSlice")
+	gt.NoError(w.End(), "End")
+	gt.NoError(w.End(), "End")
+	gt.NoError(w.Encode(nil), "Encode")
+	gt.NoError(w.End(), "End")
+	gt.Error(w.End(), "End.outside")
+	gt.NoError(w.Encode("x"), "Encode.top")
+	gt.NoError(w.Flush(), "Flush")
+	gt.Equal("[{\"city\":\"Boston\"},{\"n\":1,\"s\":[]},null]\n\"x\"\n", b.String(), "Writer")
+	gt.Error(Yaml.NewWriter(&b).StartSlice(), "Writer.brackets")
+
+	// tokens
+	r := Json.NewReader(strings.NewReader(`{"a": [1, "x", null] /* c */, "b": {}} [2]`))
+	var kinds []TokenKind
+	var vals []any
+	for {
+		tok, err := r.Token()
+		if err == io.EOF {
+			break
+		}
+		gt.NoError(err, "Token")
+		kinds = append(kinds, tok.Kind)
+		if tok.Kind == KEY || tok.Kind == VALUE {
+			vals = append(vals, tok.Value)
+		}
+	}
+	gt.Equal([]TokenKind{MAPSTART, KEY, SLICESTART, VALUE, VALUE, VALUE, SLICEEND, KEY, MAPSTART, MAPEND, MAPEND, SLICESTART, VALUE, SLICEEND}, kinds, "Token.kinds")
+	gt.Equal([]any{"a", "1", "x", nil, "b", "2"}, vals, "Token.values")
+
+	// values of a streamed slice
+	r = Json.NewReader(&b)
+	tok, err := r.Token()
+	gt.NoError(err, "Token")
+	gt.Equal(SLICESTART, tok.Kind, "Token.start")
+	var a decodeAddress
+	gt.True(r.More(), "More")
+	gt.NoError(r.DecodeInto(&a), "DecodeInto")
+	gt.Equal("Boston", a.City, "DecodeInto")
+	v, err := r.Decode()
+	gt.NoError(err, "Decode")
+	gt.Equal(map[string]any{"n": "1", "s": []any{}}, v, "Decode")
+	v, err = r.Decode()
+	gt.NoError(err, "Decode.null")
+	gt.Equal(nil, v, "Decode.null")
+	gt.False(r.More(), "More.end")
+	tok, _ = r.Token()
+	gt.Equal(SLICEEND, tok.Kind, "Token.end")
+	gt.True(r.More(), "More.top")
+	v, _ = r.Decode()
+	gt.Equal("x", v, "Decode.top")
+	gt.False(r.More(), "More.eof")
+	_, err = r.Decode()
+	gt.Equal(io.EOF, err, "Decode.eof")
+
+	// bounded streaming of a large slice
+	pr, pw := io.Pipe()
+	go func() {
+		w := Json.NewWriter(pw)
+		w.StartSlice()
+		for i := 0; i < 20000; i++ {
+			w.Encode(i)
+		}
+		w.End()
+		pw.CloseWithError(w.Flush())
+	}()
+	r = Json.NewReader(pr)
+	r.MaxToken = 8
+	r.Token()
+	n := 0
+	for r.More() {
+		var i int
+		gt.NoError(r.DecodeInto(&i), "large")
+		if i != n {
+			gt.Equal(n, i, "large")
+		}
+		n++
+	}
+	gt.Equal(20000, n, "large")
+
+	// errors
+	r = Json.NewReader(strings.NewReader("[1,\n 2 x]"))
+	_, err = r.Decode()
+	var se *SyntaxError
+	gt.True(errors.As(err, &se), "Decode.syntax")
+	gt.Equal(2, se.Line, "Decode.syntax.line")
+	gt.Equal(4, se.Column, "Decode.syntax.column")
+	gt.Equal(`"," or "]"`, se.Expected, "Decode.syntax.expected")
+	_, err = r.Token()
+	gt.Error(err, "Token.sticky")
+	r = Json.NewReader(strings.NewReader(`["abcdefghij"]`))
+	r.MaxToken = 4
+	_, err = r.Decode()
+	gt.Equal(errors.EXHAUSTED, err.(*errors.Status).Code(), "MaxToken")
+	_, err = Json.NewReader(strings.NewReader(`[1, `)).Decode()
+	gt.Error(err, "Decode.unclosed")
+	e := Json.New()
+	e.MaxDepth = 2
+	_, err = e.NewReader(strings.NewReader(`[[[1]]]`)).Decode()
+	gt.True(errors.As(err, &se), "MaxDepth")
+	gt.Equal(2, se.Offset, "MaxDepth.offset")
+	v, err = e.NewReader(strings.NewReader(`[[1]]`)).Decode()
+	gt.NoError(err, "MaxDepth.within")
+	gt.Equal([]any{[]any{"1"}}, v, "MaxDepth.within")
+	r = Yaml.NewReader(strings.NewReader("a: 1\nb: 2\n"))
+	r.MaxToken = 4
+	_, err = r.Decode()
+	gt.Equal(errors.EXHAUSTED, err.(*errors.Status).Code(), "MaxToken.all")
+}
+
+func TestStreamLines(t *testing.T) {
+	gt := test.New(t, config)
+	gt.Msg = "StreamLines.%s"
+	for n, e := range map[string]*Encoder{"Json": Json, "Yaml": Yaml} {
+		var b bytes.Buffer
+		w := e.NewWriter(&b)
+		w.Lines = true
+		for i := 0; i < 3; i++ {
+			gt.NoError(w.Encode(map[string]int{"i": i + 1}), n+".Encode")
+		}
+		if e.Format {
+			gt.Error(w.Encode(map[string]int{"a": 1, "b": 2}), n+".multiline")
+		}
+		gt.NoError(w.Flush(), n+".Flush")
+		gt.Equal(3, strings.Count(b.String(), "\n"), n+".lines")
+		b.WriteString("\n")
+		r := e.NewReader(&b)
+		r.Lines = true
+		i := 0
+		for r.More() {
+			var v map[string]int
+			gt.NoError(r.DecodeInto(&v), n+".DecodeInto")
+			gt.Equal(map[string]int{"i": i + 1}, v, n+".DecodeInto")
+			i++
+		}
+		gt.Equal(3, i, n+".values")
+		_, err := r.Token()
+		gt.Error(err, n+".Token")
+	}
+	r := Json.NewReader(strings.NewReader("{\"a\":1}\n\n{\"a\":\n"))
+	r.Lines = true
+	_, err := r.Decode()
+	gt.NoError(err, "Decode")
+	_, err = r.Decode()
+	var se *SyntaxError
+	gt.True(errors.As(err, &se), "Decode.syntax")
+	gt.Equal(3, se.Line, "Decode.syntax.line")
+	gt.Equal(14, se.Offset, "Decode.syntax.offset")
+}
+
+type tomlServer struct {
+	Host  string   `toml:"host"`
+	Ports []int    `toml:"ports"`
+	Tags  []string `toml:"tags,omitempty"`
+}
+
+type tomlUser struct {
+	Name  string          `toml:"name"`
+	Roles map[string]bool `toml:"roles"`
+}
+
+type tomlConfig struct {
+	Title   string                `toml:"title"`
+	Notes   string                `toml:"notes"`
+	Updated time.Time             `toml:"updated"`
+	Ratio   float64               `toml:"ratio"`
+	Server  tomlServer            `toml:"server"`
+	Users   []tomlUser            `toml:"users"`
+	Limits  map[string]tomlServer `toml:"limits"`
+}
+
+func TestToml(t *testing.T) {
+	gt := test.New(t, config)
+	gt.Msg = "Toml.%s"
+	doc := `# config
+title = "TOML \"example\""
+literal = 'C:\Users\x'
+multi = """
+first
+second \
+   continued"""
+raw = '''
+no \escapes'''
+nums = [ 1_000, 0x1F, -3.5e2, +inf, # comment
+  0o17, ]
+dates = [1979-05-27T07:32:00Z, 1979-05-27 07:32:00-07:00, 1979-05-27, 07:32:00]
+site."google.com" = true
+point = { x = 1, y.z = 2 }
+
+[owner]
+name = "Tom"   # trailing comment
+
+[a.b]
+c = 'd'
+
+[[fruits]]
+name = "apple"
+[fruits.physical]
+color = "red"
+
+[[fruits]]
+name = "banana"
+`
+	e := Toml.New()
+	gt.NoError(e.TryDecode([]byte(doc)), "TryDecode")
+	v := e.Map()
+	gt.Equal(`TOML "example"`, v["title"], "basic")
+	gt.Equal(`C:\Users\x`, v["literal"], "literal")
+	gt.Equal("first\nsecond continued", v["multi"], "multi")
+	gt.Equal(`no \escapes`, v["raw"], "multi.literal")
+	nums := v["nums"].([]any)
+	gt.Equal([]any{1000, 31, -350.0}, nums[:3], "nums")
+	gt.Equal(15, nums[4], "nums.octal")
+	dates := v["dates"].([]any)
+	gt.Equal(4, len(dates), "dates")
+	gt.True(dates[0].(time.Time).Equal(time.Date(1979, 5, 27, 7, 32, 0, 0, time.UTC)), "dates.utc")
+	gt.True(dates[1].(time.Time).Equal(time.Date(1979, 5, 27, 14, 32, 0, 0, time.UTC)), "dates.offset")
+	gt.Equal(7, dates[3].(time.Time).Hour(), "dates.time")
+	gt.Equal(map[string]any{"google.com": true}, v["site"], "dotted")
+	gt.Equal(map[string]any{"x": 1, "y": map[string]any{"z": 2}}, v["point"], "inline")
+	gt.Equal(map[string]any{"name": "Tom"}, v["owner"], "table")
+	gt.Equal(map[string]any{"b": map[string]any{"c": "d"}}, v["a"], "table.nested")
+	gt.Equal([]any{
+		map[string]any{"name": "apple", "physical": map[string]any{"color": "red"}},
+		map[string]any{"name": "banana"},
+	}, v["fruits"], "tables")
+
+	c := tomlConfig{
+		Title:   "app",
+		Notes:   "line \"one\"\nline two",
+		Updated: time.Date(2024, 3, 1, 8, 30, 0, 0, time.UTC),
+		Ratio:   0.5,
+		Server:  tomlServer{Host: "local host", Ports: []int{80, 443}},
+		Users:   []tomlUser{{Name: "ann", Roles: map[string]bool{"admin": true}}, {Name: "bob"}},
+		Limits:  map[string]tomlServer{"b": {Host: "y"}, "a.b": {Host: "x", Tags: []string{"t"}}},
+	}
+	b := Toml.New().Encode(c).String()
+	gt.Equal(`title = "app"
+notes = """
+line \"one\"
+line two"""
+updated = 2024-03-01T08:30:00Z
+ratio = 0.5
+
+[server]
+host = "local host"
+ports = [80,443]
+
+[limits]
+
+[limits."a.b"]
+host = "x"
+tags = ["t"]
+
+[limits.b]
+host = "y"
+
+[[users]]
+name = "ann"
+
+[users.roles]
+admin = true
+
+[[users]]
+name = "bob"
+`, b, "Encode")
+	var d tomlConfig
+	gt.NoError(Toml.New().DecodeInto(&d, []byte(b)), "DecodeInto")
+	gt.True(d.Updated.Equal(c.Updated), "DecodeInto.time")
+	d.Updated = c.Updated
+	gt.Equal(c, d, "DecodeInto")
+	b = Toml.New().Encode(map[string]any{"ports": []int{0, 80, 0, 443}, "f": []float64{math.Inf(1), math.Inf(-1)}, "n": math.NaN(), "r": 2.0, "z": 0, "b": false, "s": "", "x": nil, "p": []any{map[string]any{"a": 0, "x": nil}}}).String()
+	gt.Equal("b = false\nf = [inf,-inf]\nn = nan\nports = [0,80,0,443]\nr = 2.0\ns = \"\"\nz = 0\n\n[[p]]\na = 0\n", b, "Encode.elements")
+	v = Toml.New().Decode([]byte(b)).Map()
+	gt.Equal([]any{0, 80, 0, 443}, v["ports"], "Decode.elements")
+	gt.Equal([]any{math.Inf(1), math.Inf(-1)}, v["f"], "Decode.inf")
+	gt.True(math.IsNaN(v["n"].(float64)), "Decode.nan")
+	gt.Equal(2.0, v["r"], "Decode.float")
+	gt.Equal(false, v["b"], "Decode.zeros")
+	err := Toml.New().TryEncode(map[string]any{"a": []any{nil, 1}})
+	gt.Equal(errors.INVALID, err.(*errors.Status).Code(), "TryEncode.nil")
+
+	for n, in := range map[string]string{
+		"duplicate":                 "a = 1\na = 2",
+		"line end":                  "a = 1 b = 2",
+		"unclosed":                  "a = \"\"\"x",
+		"literal":                   "a = 'x\ny'",
+		"value":                     "a = ",
+		"table":                     "a = 1\n[a.b]",
+		"key":                       "[a",
+		"bad scalar":                "a = yes",
+		"inline":                    "a = {x = 1",
+		"array":                     "a = [1 2]",
+		"array table":               "a = 1\n[[a]]",
+		"redefined":                 "[a]\n[a]",
+		"redefined after sub-table": "[a]\nx = 1\n[a.b]\n[a]",
+		"dotted":                    "a.b = 1\n[a]",
+		"array redefined":           "[[a]]\n[a]",
+		"null":                      "a = null",
+		"leading zero":              "a = 0755",
+		"leading zero float":        "a = -03.5",
+	} {
+		gt.Error(Toml.New().TryDecode([]byte(in)), n)
+	}
+	gt.Equal(map[string]any{"a": map[string]any{"x": 1, "b": map[string]any{}}}, Toml.New().Decode([]byte("[a.b]\n[a]\nx = 1")).Map(), "super-table")
+	gt.Error(Toml.New().TryEncode([]int{1}), "Encode.root")
+}
+
+type csvAddress struct {
+	City string `csv:"city"`
+	Zip  *int   `csv:"zip"`
+}
+
+type csvUser struct {
+	Name    string     `csv:"name"`
+	Age     int        `csv:"age"`
+	Admin   bool       `csv:"admin"`
+	Joined  time.Time  `csv:"joined"`
+	Address csvAddress `csv:"address"`
+	Secret  string     `csv:"-"`
+}
+
+func TestCsv(t *testing.T) {
+	gt := test.New(t, config)
+	gt.Msg = "Csv.%s"
+	zip := 78701
+	joined := time.Date(2024, 3, 1, 8, 30, 0, 0, time.UTC)
+	users := []csvUser{
+		{Name: "Doe, Jane", Age: 42, Admin: true, Joined: joined, Address: csvAddress{City: `"Big" Apple`, Zip: &zip}, Secret: "x"},
+		{Name: "line\nbreak", Joined: joined},
+	}
+	b := Csv.New().Encode(users).String()
+	gt.Equal("name,age,admin,joined,address.city,address.zip\r\n"+
+		"\"Doe, Jane\",42,true,2024-03-01T08:30:00Z,\"\"\"Big\"\" Apple\",78701\r\n"+
+		"\"line\nbreak\",0,false,2024-03-01T08:30:00Z,,\r\n", b, "Encode")
+	var d []csvUser
+	gt.NoError(Csv.New().DecodeInto(&d, []byte(b)), "DecodeInto")
+	users[0].Secret = ""
+	gt.Equal(users, d, "DecodeInto")
+
+	rows := []map[string]any{
+		{"b": 1, "a": map[string]any{"x": true}},
+		{"c": "tab\there", "b": 2.5},
+	}
+	b = Tsv.New().Encode(rows).String()
+	gt.Equal("a.x\tb\tc\ntrue\t1\t\n\t2.5\t\"tab\there\"\n", b, "Tsv.Encode")
+	e := Tsv.New()
+	gt.NoError(e.TryDecode([]byte(b)), "Tsv.Decode")
+	gt.Equal([]any{
+		map[string]any{"a": map[string]any{"x": "true"}, "b": "1", "c": nil},
+		map[string]any{"a": map[string]any{"x": nil}, "b": "2.5", "c": "tab\there"},
+	}, e.Slice(), "Tsv.Decode")
+
+	e = Csv.New()
+	e.ValEnd = []byte(";")
+	e.DecodeTyped = true
+	e.Init()
+	gt.Equal("age;name\n1;\"a;b\"\n", strings.ReplaceAll(e.Encode(map[string]any{"name": "a;b", "age": 1}).String(), "\r", ""), "Delimiter.Encode")
+	gt.NoError(e.TryDecode([]byte("name;age\r\n\"a;b\";1\r\n\r\nc;\"2\"")), "Delimiter.Decode")
+	gt.Equal([]any{
+		map[string]any{"name": "a;b", "age": 1},
+		map[string]any{"name": "c", "age": "2"},
+	}, e.Slice(), "Delimiter.Decode")
+
+	for n, in := range map[string]string{
+		"count":    "a,b\n1\n",
+		"unique":   "a,a\n1,2\n",
+		"parent":   "a,a.b\n1,2\n",
+		"quote":    "a\n\"1",
+		"after":    "a,b\n\"1\"x,2\n",
+		"too many": "a\n1,2\n",
+	} {
+		gt.Error(Csv.New().TryDecode([]byte(in)), n)
+	}
+	gt.Error(Csv.New().TryEncode([]int{1}), "Encode.row")
+	gt.Error(Csv.New().TryEncode([]map[string]any{{"a": []int{1}}}), "Encode.slice")
+	gt.Equal("name,age,admin,joined,address.city,address.zip\r\n", Csv.New().Encode([]csvUser{}).String(), "Encode.empty")
+	gt.Equal("city,zip\r\nx,\r\n", Csv.New().Encode([]*csvAddress{{City: "x"}}).String(), "Encode.nil column")
+}
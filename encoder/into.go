@@ -0,0 +1,360 @@
+// Copyright 2023 james dotter.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://github.com/jcdotter/go/LICENSE
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encoder
+
+import (
+	"encoding"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jcdotter/go/errors"
+	t "github.com/jcdotter/go/typ"
+	"github.com/jcdotter/go/uuid"
+)
+
+// ----------------------------------------------------------------------------
+// DECODE INTO
+// decodes into typed golang values rather than the generic
+// maps, slices and strings of Decode, converting the decoded
+// values to the types of the destination:
+//
+//	var u User
+//	err := Json.New().DecodeInto(&u, b)
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+	uuidType = reflect.TypeOf(uuid.UUID{})
+	textType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// timeLayouts are the layouts of the times decoded, the first
+// of which is the layout of the times encoded by the encoder
+var timeLayouts = []string{
+	"2006-01-02 15:04:05.999999999 -0700 MST",
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999 -0700",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999",
+	time.DateOnly,
+	"15:04:05.999999999",
+}
+
+// DecodeInto decodes the bytes provided, or the buffer of the encoder
+// when none are provided, into the value pointed to by dst, returning
+// a SyntaxError wrapped as an invalid error if malformed. The fields
+// of structs are decoded from the keys they are encoded with, which are
+// the names of their tags of the encoder type or their field names,
+// matching keys case insensitively when not found.
+func (m *Encoder) DecodeInto(dst any, bytes ...[]byte) error {
+	v, err := pointerTo(dst)
+	if err != nil {
+		return err
+	}
+	if err = m.TryDecode(bytes...); err != nil {
+		return err
+	}
+	return m.decodeInto(v.Elem(), m.value, "")
+}
+
+// pointerTo returns the value of dst, which must be a non-nil pointer
+func pointerTo(dst any) (reflect.Value, error) {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return v, errors.Invalid(fmt.Sprintf("encoder: cannot decode into %T, a non-nil pointer is required", dst))
+	}
+	return v, nil
+}
+
+// decodeInto sets the value provided to the decoded value a,
+// where path is the location of a in the decoded value
+func (m *Encoder) decodeInto(v reflect.Value, a any, path string) error {
+	if a == nil {
+		v.SetZero()
+		return nil
+	}
+	switch v.Type() {
+	case timeType:
+		if tm, ok := a.(time.Time); ok {
+			v.Set(reflect.ValueOf(tm))
+			return nil
+		}
+		if s, ok := a.(string); ok {
+			if tm, ok := decodeTime(s); ok {
+				v.Set(reflect.ValueOf(tm))
+				return nil
+			}
+		}
+		return m.decodeIntoError(v, a, path)
+	case uuidType:
+		if s, ok := a.(string); ok && isUuid(s) {
+			v.Set(reflect.ValueOf(uuid.Parse(s)))
+			return nil
+		}
+		return m.decodeIntoError(v, a, path)
+	}
+	if s, ok := a.(string); ok && v.Kind() != reflect.Pointer && v.CanAddr() && v.Addr().Type().Implements(textType) {
+		if err := v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s)); err != nil {
+			return errors.Invalid(fmt.Sprintf("encoder: cannot decode %q into %s%s: %s", s, v.Type(), atPath(path), err))
+		}
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return m.decodeInto(v.Elem(), a, path)
+	case reflect.Interface:
+		if r := reflect.ValueOf(a); r.Type().AssignableTo(v.Type()) {
+			v.Set(r)
+			return nil
+		}
+	case reflect.Bool:
+		if b, ok := decodeBool(a); ok {
+			v.SetBool(b)
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i, ok := decodeInt(a); ok && !v.OverflowInt(i) {
+			v.SetInt(i)
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if u, ok := decodeUint(a); ok && !v.OverflowUint(u) {
+			v.SetUint(u)
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		if f, ok := decodeFloat(a); ok && !v.OverflowFloat(f) {
+			v.SetFloat(f)
+			return nil
+		}
+	case reflect.String:
+		switch s := a.(type) {
+		case string:
+			v.SetString(s)
+			return nil
+		case bool, int, float64:
+			v.SetString(fmt.Sprint(s))
+			return nil
+		}
+	case reflect.Slice:
+		switch s := a.(type) {
+		case string:
+			if v.Type().Elem().Kind() == reflect.Uint8 {
+				v.Set(reflect.ValueOf([]byte(s)).Convert(v.Type()))
+				return nil
+			}
+		case []any:
+			n := reflect.MakeSlice(v.Type(), len(s), len(s))
+			for i, e := range s {
+				if err := m.decodeInto(n.Index(i), e, path+"["+strconv.Itoa(i)+"]"); err != nil {
+					return err
+				}
+			}
+			v.Set(n)
+			return nil
+		}
+	case reflect.Array:
+		if s, ok := a.([]any); ok {
+			for i := 0; i < v.Len(); i++ {
+				if i >= len(s) {
+					v.Index(i).SetZero()
+				} else if err := m.decodeInto(v.Index(i), s[i], path+"["+strconv.Itoa(i)+"]"); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case reflect.Map:
+		if h, ok := a.(map[string]any); ok {
+			if v.IsNil() {
+				v.Set(reflect.MakeMapWithSize(v.Type(), len(h)))
+			}
+			for k, e := range h {
+				kv := reflect.New(v.Type().Key()).Elem()
+				if err := m.decodeInto(kv, k, path); err != nil {
+					return err
+				}
+				ev := reflect.New(v.Type().Elem()).Elem()
+				if err := m.decodeInto(ev, e, joinPath(path, k)); err != nil {
+					return err
+				}
+				v.SetMapIndex(kv, ev)
+			}
+			return nil
+		}
+	case reflect.Struct:
+		if h, ok := a.(map[string]any); ok {
+			return m.decodeStruct(v, h, path)
+		}
+	}
+	return m.decodeIntoError(v, a, path)
+}
+
+// decodeStruct sets the fields of the struct provided to the
+// values of the decoded map with the keys of the fields
+func (m *Encoder) decodeStruct(v reflect.Value, h map[string]any, path string) error {
+	st := v.Type()
+	for i, k := range m.structKeys(t.FromReflectType(st)) {
+		f := st.Field(i)
+		// unexported structs are embedded with their exported fields
+		if k == "-" || !f.IsExported() && !(f.Anonymous && f.Type.Kind() == reflect.Struct) {
+			continue
+		}
+		a, ok := h[k]
+		if !ok {
+			for hk, ha := range h {
+				if strings.EqualFold(hk, k) {
+					a, ok = ha, true
+					break
+				}
+			}
+		}
+		if !ok {
+			// the fields of embedded structs may be decoded
+			// from the map of the struct they are embedded in
+			if f.Anonymous && f.Type.Kind() == reflect.Struct {
+				if err := m.decodeStruct(v.Field(i), h, path); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err := m.decodeInto(v.Field(i), a, joinPath(path, k)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Encoder) decodeIntoError(v reflect.Value, a any, path string) error {
+	kind := "value"
+	switch x := a.(type) {
+	case map[string]any:
+		kind = "map"
+	case []any:
+		kind = "slice"
+	case string:
+		kind = strconv.Quote(x)
+	case bool, int, float64:
+		kind = fmt.Sprint(x)
+	}
+	return errors.Invalid(fmt.Sprintf("encoder: cannot decode %s into %s%s", kind, v.Type(), atPath(path)))
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func atPath(path string) string {
+	if path == "" {
+		return ""
+	}
+	return " at " + path
+}
+
+func decodeBool(a any) (bool, bool) {
+	switch b := a.(type) {
+	case bool:
+		return b, true
+	case string:
+		p, err := strconv.ParseBool(b)
+		return p, err == nil
+	}
+	return false, false
+}
+
+func decodeInt(a any) (int64, bool) {
+	switch n := a.(type) {
+	case int:
+		return int64(n), true
+	case float64:
+		return int64(n), n == math.Trunc(n) && n >= math.MinInt64 && n < math.MaxInt64
+	case string:
+		i, err := strconv.ParseInt(n, 10, 64)
+		return i, err == nil
+	}
+	return 0, false
+}
+
+func decodeUint(a any) (uint64, bool) {
+	switch n := a.(type) {
+	case int:
+		return uint64(n), n >= 0
+	case float64:
+		return uint64(n), n == math.Trunc(n) && n >= 0 && n < math.MaxUint64
+	case string:
+		u, err := strconv.ParseUint(n, 10, 64)
+		return u, err == nil
+	}
+	return 0, false
+}
+
+func decodeFloat(a any) (float64, bool) {
+	switch n := a.(type) {
+	case int:
+		return float64(n), true
+	case float64:
+		return n, true
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// decodeTime parses a time in any of the time layouts,
+// excluding the monotonic clock reading of encoded times
+func decodeTime(s string) (time.Time, bool) {
+	if i := strings.Index(s, " m="); i >= 0 {
+		s = s[:i]
+	}
+	for _, l := range timeLayouts {
+		if tm, err := time.Parse(l, s); err == nil {
+			return tm, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// isUuid reports whether s is a UUID of 32 hex digits,
+// with or without hyphens
+func isUuid(s string) bool {
+	if len(s) != 32 && len(s) != 36 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if len(s) == 36 && (i == 8 || i == 13 || i == 18 || i == 23) {
+			if c != '-' {
+				return false
+			}
+			continue
+		}
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
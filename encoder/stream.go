@@ -0,0 +1,735 @@
+// Copyright 2023 james dotter.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://github.com/jcdotter/go/LICENSE
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encoder
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/jcdotter/go/errors"
+)
+
+// ----------------------------------------------------------------------------
+// STREAMS
+// encode values incrementally to an io.Writer and decode tokens and
+// values incrementally from an io.Reader, holding only the current
+// value or token in memory rather than the whole document. Slices
+// and maps of any size are streamed a token at a time, or values
+// are streamed one per line in lines mode (NDJSON):
+//
+//	w := Json.NewWriter(f)
+//	w.StartSlice()
+//	for _, row := range rows {
+//		w.Encode(row)
+//	}
+//	w.End()
+//	w.Flush()
+//
+//	r := Json.NewReader(f)
+//	r.Token() // SLICESTART
+//	for r.More() {
+//		err := r.DecodeInto(&row)
+//	}
+
+// TokenKind is the kind of a token of a stream
+type TokenKind byte
+
+const (
+	SLICESTART TokenKind = iota + 1 // the start of a slice
+	SLICEEND                        // the end of a slice
+	MAPSTART                        // the start of a map
+	MAPEND                          // the end of a map
+	KEY                             // a key of a map
+	VALUE                           // a string, number, bool or null value
+)
+
+// Token is a token of a stream, where Value is the
+// string of a KEY or the decoded value of a VALUE
+type Token struct {
+	Kind  TokenKind
+	Value any
+}
+
+// frame is a slice or map being streamed
+type frame struct {
+	kind TokenKind // SLICESTART or MAPSTART
+	n    int       // the number of elements streamed
+	key  bool      // true when a key awaits its value
+	next bool      // true when a delimiter awaits the next element
+}
+
+// ----------------------------------------------------------------------------
+// WRITER
+
+// Writer encodes values incrementally to an io.Writer
+type Writer struct {
+	// Lines writes each top level value on its own line,
+	// failing to encode values spanning multiple lines
+	Lines bool
+	m     *Encoder
+	w     *bufio.Writer
+	stack []*frame
+	n     int
+	err   error
+}
+
+// NewWriter returns a writer encoding values with the syntax
+// of the encoder to the writer provided. The values written
+// are buffered until flushed.
+func (m *Encoder) NewWriter(w io.Writer) *Writer {
+	return &Writer{m: m.New(), w: bufio.NewWriter(w)}
+}
+
+// Encode writes the value provided as the next element of the
+// slice or the value of the key of the map being written, or
+// as the next top level value
+func (w *Writer) Encode(v any) error {
+	if err := w.element(); err != nil {
+		return err
+	}
+	if err := w.m.TryEncode(v); err != nil {
+		return err
+	}
+	b := w.m.Bytes()
+	if w.Lines && len(w.stack) == 0 && bytes.IndexByte(b, '\n') >= 0 {
+		return errors.Invalid("encoder: value spans multiple lines")
+	}
+	w.write(b)
+	return w.complete()
+}
+
+// StartSlice starts writing a slice, whose elements are written
+// by Encode or as slices and maps until ended by End
+func (w *Writer) StartSlice() error {
+	return w.start(SLICESTART, w.m.SliceStart)
+}
+
+// StartMap starts writing a map, whose keys are written by Key
+// followed by their values until ended by End
+func (w *Writer) StartMap() error {
+	return w.start(MAPSTART, w.m.MapStart)
+}
+
+// Key writes the key provided of the map being written
+func (w *Writer) Key(k string) error {
+	if w.err != nil {
+		return w.err
+	}
+	if len(w.stack) == 0 || w.top().kind != MAPSTART || w.top().key {
+		return errors.Invalid("encoder: key written outside of a map or without a value")
+	}
+	f := w.top()
+	if f.n > 0 {
+		w.write(w.m.ValEnd)
+	}
+	w.m.Reset()
+	if w.m.QuotedKey {
+		w.m.writeQuotedString(k)
+	} else {
+		w.m.writeString(k)
+	}
+	w.write(w.m.Bytes())
+	w.write(w.m.KeyEnd)
+	f.key = true
+	return w.err
+}
+
+// End ends the slice or map being written
+func (w *Writer) End() error {
+	if w.err != nil {
+		return w.err
+	}
+	if len(w.stack) == 0 || w.top().key {
+		return errors.Invalid("encoder: end written outside of a slice or map or without a value")
+	}
+	f := w.top()
+	w.stack = w.stack[:len(w.stack)-1]
+	if f.kind == SLICESTART {
+		w.write(w.m.SliceEnd)
+	} else {
+		w.write(w.m.MapEnd)
+	}
+	return w.complete()
+}
+
+// Flush writes the values buffered to the underlying writer,
+// which may be flushed while slices and maps are being written
+func (w *Writer) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	w.err = w.w.Flush()
+	return w.err
+}
+
+// tokens reports whether slices and maps are
+// streamed a token at a time by the encoder
+func (m *Encoder) tokens() bool {
+	return m.hasBrackets && m.TableStart == nil
+}
+
+func (w *Writer) top() *frame {
+	return w.stack[len(w.stack)-1]
+}
+
+// start writes the start of a slice or map, which requires brackets
+func (w *Writer) start(kind TokenKind, start []byte) error {
+	if !w.m.tokens() {
+		return errors.Invalid("encoder: streaming slices and maps requires brackets without tables")
+	}
+	if err := w.element(); err != nil {
+		return err
+	}
+	w.write(start)
+	w.stack = append(w.stack, &frame{kind: kind})
+	return w.err
+}
+
+// element writes the delimiter before the next element
+func (w *Writer) element() error {
+	if w.err != nil {
+		return w.err
+	}
+	if len(w.stack) == 0 {
+		if w.n > 0 && !w.m.tokens() && !w.Lines {
+			return errors.Invalid("encoder: values without brackets or with tables cannot be delimited outside of lines mode")
+		}
+		return nil
+	}
+	switch f := w.top(); {
+	case f.kind == MAPSTART && !f.key:
+		return errors.Invalid("encoder: map value written without a key")
+	case f.kind == SLICESTART && f.n > 0:
+		w.write(w.m.ValEnd)
+	}
+	return w.err
+}
+
+// complete completes the element written, ending
+// each top level value with a line break
+func (w *Writer) complete() error {
+	if len(w.stack) == 0 {
+		w.n++
+		w.write([]byte{'\n'})
+		return w.err
+	}
+	f := w.top()
+	f.n++
+	f.key = false
+	return w.err
+}
+
+func (w *Writer) write(b []byte) {
+	if w.err == nil {
+		_, w.err = w.w.Write(b)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// READER
+
+// Reader decodes tokens and values incrementally from an io.Reader
+type Reader struct {
+	// Lines reads each top level value from its own line (NDJSON),
+	// where values are only read by Decode and DecodeInto
+	Lines bool
+	// MaxToken is the max bytes of a token, of a line in
+	// lines mode, or of the rest of the stream decoded as
+	// one value without tokens, defaulting to 16MB
+	MaxToken int
+	m        *Encoder
+	r        *bufio.Reader
+	stack    []*frame
+	tok      []byte
+	offset   int
+	line     int
+	column   int
+	err      error
+}
+
+// NewReader returns a reader decoding tokens and values
+// with the syntax of the encoder from the reader provided
+func (m *Encoder) NewReader(r io.Reader) *Reader {
+	return &Reader{MaxToken: 16 << 20, m: m.New(), r: bufio.NewReader(r), line: 1, column: 1}
+}
+
+// Token returns the next token of the stream, or io.EOF at the end of
+// the stream. Tokens are read from encoders with brackets and without
+// tables, such as Json, and are not read in lines mode.
+func (r *Reader) Token() (Token, error) {
+	if r.err != nil {
+		return Token{}, r.err
+	}
+	if r.Lines || !r.m.tokens() {
+		return Token{}, errors.Invalid("encoder: tokens are read from encoders with brackets without tables outside of lines mode")
+	}
+	tok, err := r.token()
+	if err != nil {
+		r.err = err
+	}
+	return tok, err
+}
+
+// More reports whether another element follows in
+// the slice or map being read, or another top level
+// value follows in the stream
+func (r *Reader) More() bool {
+	if r.err != nil {
+		return false
+	}
+	if r.Lines {
+		for {
+			b, err := r.r.Peek(1)
+			if err != nil {
+				return false
+			}
+			if !InBytes(b[0], r.m.Space) && b[0] != '\n' {
+				return true
+			}
+			r.next(1)
+		}
+	}
+	if err := r.skip(); err != nil {
+		return false
+	}
+	if len(r.stack) == 0 {
+		return !r.eof()
+	}
+	f := r.top()
+	if f.kind == SLICESTART {
+		return !r.match(r.m.SliceEnd)
+	}
+	return !r.match(r.m.MapEnd)
+}
+
+// Decode returns the next value of the stream, which is the next
+// element of the slice or value of the key of the map being read,
+// or the next top level value. Decoders without brackets or with
+// tables decode the rest of the stream, of at most MaxToken bytes,
+// as one value outside of lines mode.
+func (r *Reader) Decode() (any, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	var v any
+	var err error
+	switch {
+	case r.Lines:
+		v, err = r.decodeLine()
+	case !r.m.tokens():
+		v, err = r.decodeAll()
+	default:
+		var tok Token
+		if tok, err = r.token(); err == nil {
+			v, err = r.value(tok)
+		}
+	}
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return v, err
+}
+
+// DecodeInto decodes the next value of the stream into the value
+// pointed to by dst. See Decode and Encoder.DecodeInto.
+func (r *Reader) DecodeInto(dst any) error {
+	v, err := pointerTo(dst)
+	if err != nil {
+		return err
+	}
+	a, err := r.Decode()
+	if err != nil {
+		return err
+	}
+	return r.m.decodeInto(v.Elem(), a, "")
+}
+
+func (r *Reader) top() *frame {
+	return r.stack[len(r.stack)-1]
+}
+
+// value returns the value starting with the token provided
+func (r *Reader) value(tok Token) (any, error) {
+	switch tok.Kind {
+	case VALUE:
+		return tok.Value, nil
+	case SLICESTART:
+		s := []any{}
+		for {
+			t, err := r.token()
+			if err != nil {
+				return nil, r.unexpected(err)
+			}
+			if t.Kind == SLICEEND {
+				return s, nil
+			}
+			v, err := r.value(t)
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, v)
+		}
+	case MAPSTART:
+		h := map[string]any{}
+		for {
+			t, err := r.token()
+			if err != nil {
+				return nil, r.unexpected(err)
+			}
+			if t.Kind == MAPEND {
+				return h, nil
+			}
+			k := t.Value.(string)
+			if t, err = r.token(); err != nil {
+				return nil, r.unexpected(err)
+			}
+			if h[k], err = r.value(t); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return nil, r.syntaxError("value")
+}
+
+// unexpected returns the error of a stream ending within a value
+func (r *Reader) unexpected(err error) error {
+	if err == io.EOF {
+		return r.syntaxError("value")
+	}
+	return err
+}
+
+// token reads the next token of the stream
+func (r *Reader) token() (Token, error) {
+	if err := r.skip(); err != nil {
+		return Token{}, err
+	}
+	if len(r.stack) == 0 {
+		if r.eof() {
+			return Token{}, io.EOF
+		}
+		return r.element()
+	}
+	f := r.top()
+	end := r.m.SliceEnd
+	if f.kind == MAPSTART {
+		end = r.m.MapEnd
+	}
+	switch {
+	case f.key:
+		return r.element()
+	case r.match(end) && !f.next:
+		r.next(len(end))
+		r.stack = r.stack[:len(r.stack)-1]
+		r.complete()
+		if f.kind == MAPSTART {
+			return Token{Kind: MAPEND}, nil
+		}
+		return Token{Kind: SLICEEND}, nil
+	case f.n > 0 && !f.next:
+		if !r.match(r.m.ValEnd) {
+			return Token{}, r.syntaxError(expectedTokens(r.m.ValEnd, end))
+		}
+		r.next(len(r.m.ValEnd))
+		f.next = true
+		if err := r.skip(); err != nil {
+			return Token{}, err
+		}
+	}
+	f.next = false
+	if f.kind == MAPSTART {
+		return r.key()
+	}
+	return r.element()
+}
+
+// key reads the next key of the map being read with its key end
+func (r *Reader) key() (Token, error) {
+	var k string
+	if b, err := r.r.Peek(1); err == nil && InBytes(b[0], r.m.Quote) {
+		if k, err = r.quoted(); err != nil {
+			return Token{}, err
+		}
+	} else {
+		s, err := r.scalar()
+		if err != nil {
+			return Token{}, err
+		}
+		if s == "" {
+			return Token{}, r.syntaxError("key")
+		}
+		k = s
+	}
+	if err := r.skip(); err != nil {
+		return Token{}, err
+	}
+	if !r.match(r.m.KeyEnd) {
+		return Token{}, r.syntaxError(expectedTokens(r.m.KeyEnd))
+	}
+	r.next(len(r.m.KeyEnd))
+	r.top().key = true
+	return Token{Kind: KEY, Value: k}, nil
+}
+
+// element reads the next slice start, map start or value, where
+// slices and maps are nested at most the max depth of the encoder
+func (r *Reader) element() (Token, error) {
+	if max := r.m.maxDepth(); len(r.stack) >= max && (r.match(r.m.SliceStart) || r.match(r.m.MapStart)) {
+		return Token{}, r.syntaxError("a nesting depth of at most " + strconv.Itoa(max))
+	}
+	switch {
+	case r.match(r.m.SliceStart):
+		r.next(len(r.m.SliceStart))
+		r.stack = append(r.stack, &frame{kind: SLICESTART})
+		return Token{Kind: SLICESTART}, nil
+	case r.match(r.m.MapStart):
+		r.next(len(r.m.MapStart))
+		r.stack = append(r.stack, &frame{kind: MAPSTART})
+		return Token{Kind: MAPSTART}, nil
+	}
+	var v any
+	if b, err := r.r.Peek(1); err == nil && InBytes(b[0], r.m.Quote) {
+		if v, err = r.quoted(); err != nil {
+			return Token{}, err
+		}
+	} else {
+		s, err := r.scalar()
+		if err != nil {
+			return Token{}, err
+		}
+		switch {
+		case s == "":
+			return Token{}, r.syntaxError("value")
+		case s == string(r.m.Null):
+			v = nil
+		case r.m.DecodeTyped:
+			v = r.m.decodeTyped(s)
+		default:
+			v = s
+		}
+	}
+	r.complete()
+	return Token{Kind: VALUE, Value: v}, nil
+}
+
+// complete completes the element read
+func (r *Reader) complete() {
+	if len(r.stack) > 0 {
+		f := r.top()
+		f.n++
+		f.key = false
+	}
+}
+
+// quoted reads a quoted string
+func (r *Reader) quoted() (string, error) {
+	b, _ := r.r.ReadByte()
+	q, escaped := b, false
+	r.advance(b)
+	r.tok = r.tok[:0]
+	for {
+		c, err := r.r.ReadByte()
+		if err != nil {
+			return "", r.syntaxError(expectedTokens([]byte{q}))
+		}
+		r.advance(c)
+		switch {
+		case escaped:
+			escaped = false
+		case c == q:
+			return r.m.unescape(r.tok), nil
+		case InBytes(c, r.m.Escape):
+			escaped = true
+		}
+		if len(r.tok) == r.MaxToken {
+			return "", errors.Exhausted("encoder: token exceeds " + strconv.Itoa(r.MaxToken) + " bytes")
+		}
+		r.tok = append(r.tok, c)
+	}
+}
+
+// scalar reads an unquoted value until a space,
+// delimiter or the end of a slice or map
+func (r *Reader) scalar() (string, error) {
+	r.tok = r.tok[:0]
+	for !r.eof() {
+		// the byte is copied before matching, which may
+		// move the bytes buffered by peeking further
+		b, _ := r.r.Peek(1)
+		c := b[0]
+		if InBytes(c, r.m.Space) || c == '\n' || r.match(r.m.ValEnd) || r.match(r.m.KeyEnd) ||
+			r.match(r.m.SliceEnd) || r.match(r.m.MapEnd) || r.match(r.m.SliceStart) || r.match(r.m.MapStart) ||
+			r.match(r.m.LineCommentStart) || r.match(r.m.BlockCommentStart) {
+			break
+		}
+		if len(r.tok) == r.MaxToken {
+			return "", errors.Exhausted("encoder: token exceeds " + strconv.Itoa(r.MaxToken) + " bytes")
+		}
+		r.tok = append(r.tok, c)
+		r.next(1)
+	}
+	return string(r.tok), nil
+}
+
+// skip skips spaces and comments
+func (r *Reader) skip() error {
+	for !r.eof() {
+		b, _ := r.r.Peek(1)
+		switch c := b[0]; {
+		case InBytes(c, r.m.Space) || c == '\n':
+			r.next(1)
+		case r.match(r.m.LineCommentStart):
+			for !r.eof() && !r.match(r.m.LineCommentEnd) {
+				r.next(1)
+			}
+			r.next(len(r.m.LineCommentEnd))
+		case r.match(r.m.BlockCommentStart):
+			r.next(len(r.m.BlockCommentStart))
+			for !r.match(r.m.BlockCommentEnd) {
+				if r.eof() {
+					return r.syntaxError(expectedTokens(r.m.BlockCommentEnd))
+				}
+				r.next(1)
+			}
+			r.next(len(r.m.BlockCommentEnd))
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// eof reports whether the stream is read, setting
+// the error of the reader if the read fails
+func (r *Reader) eof() bool {
+	_, err := r.r.Peek(1)
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+	return err != nil
+}
+
+// match reports whether the next bytes of the stream are b
+func (r *Reader) match(b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+	p, err := r.r.Peek(len(b))
+	return err == nil && bytes.Equal(p, b)
+}
+
+// next discards the next n bytes of the stream
+func (r *Reader) next(n int) {
+	for ; n > 0; n-- {
+		b, err := r.r.ReadByte()
+		if err != nil {
+			return
+		}
+		r.advance(b)
+	}
+}
+
+// advance advances the position of the reader past the byte read
+func (r *Reader) advance(b byte) {
+	r.offset++
+	r.column++
+	if b == '\n' {
+		r.line++
+		r.column = 1
+	}
+}
+
+// syntaxError returns a syntax error at the position of the reader
+func (r *Reader) syntaxError(expected string) error {
+	e := &SyntaxError{Offset: r.offset, Line: r.line, Column: r.column, Expected: expected, Found: "end of input"}
+	if b, err := r.r.Peek(1); err == nil {
+		e.Found = strconv.QuoteRune(rune(b[0]))
+	}
+	return errors.Wrap(errors.INVALID, e)
+}
+
+// decodeLine decodes the next non empty line of the stream
+func (r *Reader) decodeLine() (any, error) {
+	for {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+		trimmed := bytes.Trim(line, string(r.m.Space)+"\n")
+		if len(trimmed) == 0 {
+			r.line++
+			r.offset += len(line)
+			continue
+		}
+		err = r.m.TryDecode(bytes.TrimRight(line, "\r\n"))
+		var se *SyntaxError
+		if errors.As(err, &se) {
+			se.Offset += r.offset
+			se.Line += r.line - 1
+			err = errors.Wrap(errors.INVALID, se)
+		}
+		r.line++
+		r.offset += len(line)
+		if err != nil {
+			return nil, err
+		}
+		return r.m.Value(), nil
+	}
+}
+
+// readLine reads the next line of the stream, including its line break
+func (r *Reader) readLine() ([]byte, error) {
+	r.tok = r.tok[:0]
+	for {
+		b, err := r.r.ReadSlice('\n')
+		if len(r.tok)+len(b) > r.MaxToken {
+			return nil, errors.Exhausted("encoder: line exceeds " + strconv.Itoa(r.MaxToken) + " bytes")
+		}
+		r.tok = append(r.tok, b...)
+		switch err {
+		case nil:
+			return r.tok, nil
+		case bufio.ErrBufferFull:
+			continue
+		case io.EOF:
+			if len(r.tok) > 0 {
+				return r.tok, nil
+			}
+		}
+		return nil, err
+	}
+}
+
+// decodeAll decodes the rest of the stream as one value, which
+// is read into memory as the encoder decodes from its buffer
+func (r *Reader) decodeAll() (any, error) {
+	b, err := io.ReadAll(io.LimitReader(r.r, int64(r.MaxToken)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > r.MaxToken {
+		return nil, errors.Exhausted("encoder: value exceeds " + strconv.Itoa(r.MaxToken) + " bytes")
+	}
+	if len(strings.Trim(string(b), string(r.m.Space)+"\n")) == 0 {
+		return nil, io.EOF
+	}
+	if err = r.m.TryDecode(b); err != nil {
+		return nil, err
+	}
+	return r.m.Value(), nil
+}
@@ -0,0 +1,616 @@
+// Copyright 2023 james dotter.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://github.com/jcdotter/go/LICENSE
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encoder
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jcdotter/go/errors"
+	t "github.com/jcdotter/go/typ"
+)
+
+// ----------------------------------------------------------------------------
+// TABLES
+// encodes maps as tables of key value lines headed by the dotted
+// key paths of the tables, and slices of maps as arrays of tables,
+// as in TOML, where the values of keys are encoded inline. Tables
+// are encoded and decoded by encoders with a TableStart, such as
+// the Toml preset:
+//
+//	title = "config"
+//
+//	[server.http]
+//	port = 8080
+//	hosts = ["a","b"]
+//
+//	[[users]]
+//	name = "admin"
+//	roles = {read=true}
+
+// tableEntry is a key value of a table
+type tableEntry struct {
+	key string
+	v   t.Value
+}
+
+// encodeTables encodes the map or struct provided as a root table
+func (m *Encoder) encodeTables(v t.Value) {
+	if v.Value.IsValid() {
+		v = tableValue(v)
+	}
+	if !v.Value.IsValid() || v.IsNil() {
+		return
+	}
+	if k := v.KindX(); k != t.MAP && k != t.STRUCT {
+		panic(errors.Invalid("encoder: cannot encode type '" + v.Type().String() + "' as a table"))
+	}
+	m.encodeTable(v, nil, nil, nil)
+}
+
+// encodeTable encodes the header of the table with the path provided,
+// its key values and then its tables and arrays of tables
+func (m *Encoder) encodeTable(v t.Value, path []string, start, end []byte) {
+	if start != nil {
+		if m.Len() > 0 {
+			m.write(m.LineBreak)
+		}
+		m.write(start)
+		for i, k := range path {
+			if i > 0 {
+				m.write(m.KeySep)
+			}
+			m.encodeTableKey(k)
+		}
+		m.write(end)
+		m.write(m.LineBreak)
+	}
+	var tables, arrays []tableEntry
+	for _, e := range m.tableEntries(v) {
+		switch {
+		case isTable(e.v):
+			tables = append(tables, e)
+		case isTableArray(e.v):
+			arrays = append(arrays, e)
+		default:
+			m.encodeTableKey(e.key)
+			m.write(m.Space[:1])
+			m.write(m.KeyEnd)
+			m.write(m.Space[:1])
+			if e.v.KindX() == t.STRING && m.MultiLineQuote != nil && strings.Contains(e.v.String(), "\n") {
+				m.encodeMultiLineString(e.v.String())
+			} else {
+				m.encode(e.v)
+			}
+			m.write(m.LineBreak)
+		}
+	}
+	for _, e := range tables {
+		m.encodeTable(e.v, append(path[:len(path):len(path)], e.key), m.TableStart, m.TableEnd)
+	}
+	for _, e := range arrays {
+		p := append(path[:len(path):len(path)], e.key)
+		e.v.Slice().ForEach(func(i int, v t.Value) (brake bool) {
+			m.encodeTable(tableValue(v), p, m.TableArrayStart, m.TableArrayEnd)
+			return
+		})
+	}
+}
+
+// tableEntries returns the key values of the map or struct provided,
+// excluding nils, and zeros when ExcludeZeros, where the keys of maps
+// are sorted
+func (m *Encoder) tableEntries(v t.Value) (entries []tableEntry) {
+	if v.KindX() == t.STRUCT {
+		keys := m.structKeys(v.Type())
+		v.Struct().ForEach(func(i int, f *t.FieldType, v t.Value) (brake bool) {
+			if v = tableValue(v); keys[i] != "-" && !v.IsNil() && !(m.ExcludeZeros && v.IsZero()) {
+				entries = append(entries, tableEntry{keys[i], v})
+			}
+			return
+		})
+		return
+	}
+	v.Map().ForEach(func(k, v t.Value) (brake bool) {
+		if v = tableValue(v); !v.IsNil() && !(m.ExcludeZeros && v.IsZero()) {
+			entries = append(entries, tableEntry{string(k.Bytes()), v})
+		}
+		return
+	})
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+	return
+}
+
+// encodeTableKey encodes a key, quoted unless a bare key
+func (m *Encoder) encodeTableKey(k string) {
+	if isBareKey(k) {
+		m.writeString(k)
+		return
+	}
+	m.writeQuotedString(k)
+}
+
+// encodeMultiLineString encodes a string with line breaks
+// as a multi-line string starting on the line after its quotes
+func (m *Encoder) encodeMultiLineString(s string) {
+	q := strings.Repeat(string(m.MultiLineQuote[0]), 3)
+	m.writeString(q)
+	m.write(m.LineBreak)
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == m.MultiLineQuote[0] || c == m.escape:
+			m.buffer.WriteByte(m.escape)
+			m.buffer.WriteByte(c)
+		case c == '\n' || c == '\t':
+			m.buffer.WriteByte(c)
+		case c == '\r':
+			m.buffer.WriteByte(m.escape)
+			m.buffer.WriteByte('r')
+		case c < 0x20 || c == 0x7f:
+			m.buffer.WriteByte(m.escape)
+			m.writeString(fmt.Sprintf("u%04x", c))
+		default:
+			m.buffer.WriteByte(c)
+		}
+	}
+	m.writeString(q)
+}
+
+// formatFloat formats a float, where table encoders format
+// infinities and nan as inf, -inf and nan, and integral
+// floats with a fraction, as in TOML
+func (m *Encoder) formatFloat(f float64) []byte {
+	b := []byte(strconv.FormatFloat(f, 'f', -1, 64))
+	if m.TableStart != nil {
+		switch {
+		case math.IsInf(f, 1):
+			return []byte("inf")
+		case math.IsInf(f, -1):
+			return []byte("-inf")
+		case math.IsNaN(f):
+			return []byte("nan")
+		case !strings.ContainsRune(string(b), '.'):
+			return append(b, ".0"...)
+		}
+	}
+	return b
+}
+
+// tableValue returns the value of pointers and interfaces
+func tableValue(v t.Value) t.Value {
+	for {
+		switch v.Kind() {
+		case t.POINTER:
+			if v.IsNil() {
+				return v
+			}
+			v = v.Elem()
+		case t.INTERFACE:
+			if v.IsNil() {
+				return v
+			}
+			if v = v.SetType(); v.Kind() == t.INTERFACE {
+				return v
+			}
+		default:
+			return v
+		}
+	}
+}
+
+// isTable reports whether the value is encoded as a table
+func isTable(v t.Value) bool {
+	k := v.KindX()
+	return k == t.MAP || k == t.STRUCT
+}
+
+// isTableArray reports whether the value is encoded as an array of
+// tables, which is a slice or array of only maps and structs
+func isTableArray(v t.Value) bool {
+	if k := v.KindX(); k != t.SLICE && k != t.ARRAY || v.Len() == 0 {
+		return false
+	}
+	is := true
+	v.Slice().ForEach(func(i int, v t.Value) (brake bool) {
+		is = isTable(tableValue(v))
+		return !is
+	})
+	return is
+}
+
+// isBareKey reports whether the key may be encoded without quotes
+func isBareKey(k string) bool {
+	for i := 0; i < len(k); i++ {
+		if !isBareChar(k[i]) {
+			return false
+		}
+	}
+	return k != ""
+}
+
+func isBareChar(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || isDigit(c) || c == '_' || c == '-'
+}
+
+// ----------------------------------------------------------------------------
+// TABLE DECODING
+
+// decodeTables decodes the key values and tables
+// of the buffer to the map of the root table
+func (m *Encoder) decodeTables() {
+	root := map[string]any{}
+	table := root
+	// defined are the tables defined by headers and dotted keys,
+	// which may not be defined again, unlike the tables created
+	// as the parents of the tables of headers
+	defined := map[uintptr]bool{}
+	define := func(h map[string]any, at int) {
+		p := reflect.ValueOf(h).Pointer()
+		if defined[p] {
+			m.cursor = at
+			m.decodeError("unique table")
+		}
+		defined[p] = true
+	}
+	for {
+		m.decodeTableSpace(true)
+		if m.cursor >= m.Len() {
+			break
+		}
+		at := m.cursor
+		switch {
+		case m.isMatch(m.TableArrayStart):
+			m.Inc(len(m.TableArrayStart))
+			keys := m.decodeKeyPath(m.TableArrayEnd)
+			parent := m.decodeTable(root, keys[:len(keys)-1], at)
+			k, h := keys[len(keys)-1], map[string]any{}
+			switch a := parent[k].(type) {
+			case nil:
+				parent[k] = []any{h}
+			case []any:
+				parent[k] = append(a, h)
+			default:
+				m.cursor = at
+				m.decodeError("array of tables")
+			}
+			define(h, at)
+			table = h
+		case m.isMatch(m.TableStart):
+			m.Inc(len(m.TableStart))
+			table = m.decodeTable(root, m.decodeKeyPath(m.TableEnd), at)
+			define(table, at)
+		default:
+			keys := m.decodeKeyPath(m.KeyEnd)
+			m.decodeTableSpace(false)
+			v := m.decodeTableValue()
+			parent := table
+			for _, k := range keys[:len(keys)-1] {
+				if parent = m.decodeTable(parent, []string{k}, at); !defined[reflect.ValueOf(parent).Pointer()] {
+					define(parent, at)
+				}
+			}
+			if _, ok := parent[keys[len(keys)-1]]; ok {
+				m.cursor = at
+				m.decodeError("unique key")
+			}
+			parent[keys[len(keys)-1]] = v
+		}
+		m.decodeTableLineEnd()
+	}
+	m.value = root
+}
+
+// decodeTable returns the table of the key path provided, creating
+// the tables missing, where the table of an array of tables is its
+// last table
+func (m *Encoder) decodeTable(table map[string]any, keys []string, at int) map[string]any {
+	for _, k := range keys {
+		switch v := table[k].(type) {
+		case nil:
+			h := map[string]any{}
+			table[k], table = h, h
+		case map[string]any:
+			table = v
+		case []any:
+			var h map[string]any
+			ok := len(v) > 0
+			if ok {
+				h, ok = v[len(v)-1].(map[string]any)
+			}
+			if !ok {
+				m.cursor = at
+				m.decodeError("table")
+			}
+			table = h
+		default:
+			m.cursor = at
+			m.decodeError("table")
+		}
+	}
+	return table
+}
+
+// decodeKeyPath decodes the dotted key ending with the end provided
+func (m *Encoder) decodeKeyPath(end []byte) (keys []string) {
+	for {
+		m.decodeTableSpace(false)
+		var k string
+		if m.isQuote() {
+			k = m.decodeTableString()
+		} else {
+			s := m.cursor
+			for m.cursor < m.Len() && isBareChar(m.Byte()) {
+				m.Inc()
+			}
+			if s == m.cursor {
+				m.decodeError("key")
+			}
+			k = string(m.Buffer()[s:m.cursor])
+		}
+		keys = append(keys, k)
+		m.decodeTableSpace(false)
+		switch {
+		case m.isMatch(m.KeySep):
+			m.Inc(len(m.KeySep))
+		case m.isMatch(end):
+			m.Inc(len(end))
+			return
+		default:
+			m.decodeError(expectedTokens(m.KeySep, end))
+		}
+	}
+}
+
+// decodeTableValue decodes a string, inline slice, inline map or scalar
+func (m *Encoder) decodeTableValue() any {
+	switch {
+	case m.isQuote():
+		return m.decodeTableString()
+	case m.isMatch(m.SliceStart):
+		m.Inc(len(m.SliceStart))
+		s := []any{}
+		for {
+			m.decodeTableSpace(true)
+			if m.isMatch(m.SliceEnd) {
+				m.Inc(len(m.SliceEnd))
+				return s
+			}
+			s = append(s, m.decodeTableValue())
+			m.decodeTableSpace(true)
+			switch {
+			case m.isMatch(m.ValEnd):
+				m.Inc(len(m.ValEnd))
+			case m.isMatch(m.SliceEnd):
+				m.Inc(len(m.SliceEnd))
+				return s
+			default:
+				m.decodeError(expectedTokens(m.ValEnd, m.SliceEnd))
+			}
+		}
+	case m.isMatch(m.MapStart):
+		m.Inc(len(m.MapStart))
+		h := map[string]any{}
+		if m.decodeTableSpace(false); m.isMatch(m.MapEnd) {
+			m.Inc(len(m.MapEnd))
+			return h
+		}
+		for {
+			at := m.cursor
+			keys := m.decodeKeyPath(m.KeyEnd)
+			m.decodeTableSpace(false)
+			v := m.decodeTableValue()
+			parent := m.decodeTable(h, keys[:len(keys)-1], at)
+			if _, ok := parent[keys[len(keys)-1]]; ok {
+				m.cursor = at
+				m.decodeError("unique key")
+			}
+			parent[keys[len(keys)-1]] = v
+			m.decodeTableSpace(false)
+			switch {
+			case m.isMatch(m.ValEnd):
+				m.Inc(len(m.ValEnd))
+			case m.isMatch(m.MapEnd):
+				m.Inc(len(m.MapEnd))
+				return h
+			default:
+				m.decodeError(expectedTokens(m.ValEnd, m.MapEnd))
+			}
+		}
+	}
+	return m.decodeTableScalar()
+}
+
+// decodeTableScalar decodes an unquoted bool, number or time, which are
+// typed when DecodeTyped, where numbers may have base prefixes and
+// underscores and a date may be separated from its time by a space
+func (m *Encoder) decodeTableScalar() any {
+	s := m.cursor
+	for m.cursor < m.Len() && !m.isSpace() && !m.isMatch(m.LineBreak) && m.Byte() != '\r' &&
+		!m.isMatch(m.ValEnd) && !m.isMatch(m.SliceEnd) && !m.isMatch(m.MapEnd) && !m.isLineCommentStart() {
+		m.Inc()
+		if m.cursor-s == 10 && m.cursor+1 < m.Len() && m.Byte() == ' ' && isDigit(m.Buffer()[m.cursor+1]) && isDate(m.Buffer()[s:m.cursor]) {
+			m.Inc()
+		}
+	}
+	a := string(m.Buffer()[s:m.cursor])
+	if a == "" || a == string(m.Null) || hasLeadingZero(a) {
+		m.cursor = s
+		m.decodeError("value")
+	}
+	if !m.DecodeTyped {
+		return a
+	}
+	if i, err := strconv.ParseInt(a, 0, 64); err == nil && (len(a) < 2 || a[0] != '0' || !isDigit(a[1])) {
+		return int(i)
+	}
+	if strings.ContainsAny(a, "0123456789") || strings.HasSuffix(a, "inf") || strings.HasSuffix(a, "nan") {
+		if f, err := strconv.ParseFloat(strings.ReplaceAll(a, "_", ""), 64); err == nil {
+			return f
+		}
+	}
+	if v := m.decodeTyped(a); v != any(a) {
+		return v
+	}
+	m.cursor = s
+	m.decodeError("value")
+	return nil
+}
+
+// decodeTableString decodes a basic, literal or multi-line string
+func (m *Encoder) decodeTableString() string {
+	q := m.Byte()
+	literal := InBytes(q, m.LiteralQuote)
+	if InBytes(q, m.MultiLineQuote) && m.isMatch([]byte{q, q, q}) {
+		return m.decodeMultiLineString(q, literal)
+	}
+	if !literal {
+		return m.decodeQuote()
+	}
+	m.Inc()
+	s := m.cursor
+	for m.cursor < m.Len() && m.Byte() != q && m.Byte() != '\n' {
+		m.Inc()
+	}
+	if m.cursor >= m.Len() || m.Byte() != q {
+		m.decodeError(expectedTokens([]byte{q}))
+	}
+	m.Inc()
+	return string(m.Buffer()[s : m.cursor-1])
+}
+
+// decodeMultiLineString decodes a string quoted by tripled quotes, trimming
+// the line break after its opening quotes, where basic strings are
+// unescaped and the line breaks after escapes ending lines are trimmed
+func (m *Encoder) decodeMultiLineString(q byte, literal bool) string {
+	end := []byte{q, q, q}
+	m.Inc(3)
+	if m.isMatch([]byte("\r\n")) {
+		m.Inc(2)
+	} else if m.Byte() == '\n' {
+		m.Inc()
+	}
+	var b []byte
+	for {
+		if m.cursor >= m.Len() {
+			m.decodeError(expectedTokens(end))
+		}
+		if m.isMatch(end) {
+			m.Inc(3)
+			// up to two quotes may end the string before its end
+			for i := 0; i < 2 && m.cursor < m.Len() && m.Byte() == q; i++ {
+				b = append(b, q)
+				m.Inc()
+			}
+			break
+		}
+		c := m.Byte()
+		if !literal && m.isEscape() {
+			// an escape ending a line trims the spaces and line breaks after
+			e := m.cursor + 1
+			for e < m.Len() && (m.Buffer()[e] == ' ' || m.Buffer()[e] == '\t') {
+				e++
+			}
+			if e < m.Len() && (m.Buffer()[e] == '\n' || m.Buffer()[e] == '\r') {
+				for e < m.Len() && InBytes(m.Buffer()[e], []byte(" \t\r\n")) {
+					e++
+				}
+				m.cursor = e
+				continue
+			}
+			b = append(b, c)
+			m.Inc()
+			if m.cursor < m.Len() {
+				b = append(b, m.Byte())
+				m.Inc()
+			}
+			continue
+		}
+		b = append(b, c)
+		m.Inc()
+	}
+	if literal {
+		return string(b)
+	}
+	return m.unescape(b)
+}
+
+// decodeTableSpace skips spaces, and line breaks and comments when lines
+func (m *Encoder) decodeTableSpace(lines bool) {
+	for m.cursor < m.Len() {
+		switch {
+		case m.isSpace():
+			m.Inc()
+		case lines && (m.Byte() == '\n' || m.Byte() == '\r'):
+			m.Inc()
+		case lines && m.isLineCommentStart():
+			for m.cursor < m.Len() && m.Byte() != '\n' {
+				m.Inc()
+			}
+		default:
+			return
+		}
+	}
+}
+
+// decodeTableLineEnd decodes the end of a line, after an optional comment
+func (m *Encoder) decodeTableLineEnd() {
+	m.decodeTableSpace(false)
+	if m.isLineCommentStart() {
+		for m.cursor < m.Len() && m.Byte() != '\n' {
+			m.Inc()
+		}
+	}
+	switch {
+	case m.cursor >= m.Len():
+	case m.Byte() == '\n':
+		m.Inc()
+	case m.isMatch([]byte("\r\n")):
+		m.Inc(2)
+	default:
+		m.decodeError("line break")
+	}
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// hasLeadingZero reports whether the scalar provided is a number
+// with leading zeros, which are only valid in dates and times
+func hasLeadingZero(a string) bool {
+	n := strings.TrimLeft(a, "+-")
+	if len(n) < 2 || n[0] != '0' || !isDigit(n[1]) {
+		return false
+	}
+	return !strings.Contains(n, ":") && !(len(n) >= 10 && isDate([]byte(n[:10])))
+}
+
+// isDate reports whether b is a date of the form 2006-01-02
+func isDate(b []byte) bool {
+	for i, c := range b {
+		if i == 4 || i == 7 {
+			if c != '-' {
+				return false
+			}
+		} else if !isDigit(c) {
+			return false
+		}
+	}
+	return len(b) == 10
+}
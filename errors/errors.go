@@ -90,6 +90,24 @@ var httpCode = map[Code]int{
 	UNAUTHENTICATED: http.StatusUnauthorized,
 }
 
+var httpStatus = map[int]Code{
+	http.StatusOK:                  OK,
+	http.StatusBadRequest:          INVALID,
+	http.StatusUnauthorized:        UNAUTHENTICATED,
+	http.StatusForbidden:           PERMISSION,
+	http.StatusNotFound:            NOTFOUND,
+	http.StatusRequestTimeout:      DEADLINE,
+	http.StatusConflict:            EXISTS,
+	http.StatusPreconditionFailed:  FAILED,
+	http.StatusTooManyRequests:     EXHAUSTED,
+	499:                            CANCELLED,
+	http.StatusInternalServerError: INTERNAL,
+	http.StatusNotImplemented:      UNIMPLEMENTED,
+	http.StatusBadGateway:          UNAVAILABLE,
+	http.StatusServiceUnavailable:  UNAVAILABLE,
+	http.StatusGatewayTimeout:      DEADLINE,
+}
+
 var postgresCode = map[string]Code{
 	"00":    OK,
 	"01":    ABORTED,
@@ -227,6 +245,7 @@ func Unauthenticated(message string) error {
 type Status struct {
 	code Code
 	msg  string
+	err  error
 }
 
 // NewStatus returns a new status with the supplied code and message.
@@ -244,6 +263,11 @@ func (e *Status) Code() Code {
 	return e.code
 }
 
+// Unwrap returns the error wrapped by the status, if any.
+func (e *Status) Unwrap() error {
+	return e.err
+}
+
 // Status returns the status code text.
 func (e *Status) Status() string {
 	return statusText[e.code]
@@ -278,6 +302,52 @@ func (e *Status) GrpcCode() codes.Code {
 	return e.Code().Grpc()
 }
 
+// -----------------------------------------------------------------------------
+// WRAPPED ERRORS
+
+// Wrap returns a status with the code supplied wrapping the error,
+// which has the message of the error and is returned by Unwrap.
+func Wrap(code Code, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Status{code: code, msg: err.Error(), err: err}
+}
+
+// -----------------------------------------------------------------------------
+// HTTP ERRORS
+
+// Http returns a status representing an HTTP response status code.
+// Unmapped 4xx codes are invalid and other codes are internal.
+func Http(code int, message string) error {
+	if c, ok := httpStatus[code]; ok {
+		return &Status{code: c, msg: message}
+	}
+	if code >= 400 && code < 500 {
+		return Invalid(message)
+	}
+	return Internal(message)
+}
+
+// -----------------------------------------------------------------------------
+// GRPC ERRORS
+
+// Grpc returns a status representing a gRPC error, or the
+// error provided if it is already a status. Errors which are
+// not gRPC errors are unknown.
+func Grpc(err error) error {
+	if err == nil {
+		return nil
+	}
+	if s, ok := err.(*Status); ok {
+		return s
+	}
+	if s, ok := status.FromError(err); ok {
+		return &Status{code: Code(s.Code()), msg: s.Message()}
+	}
+	return Unknown(err.Error())
+}
+
 // -----------------------------------------------------------------------------
 // DATABASE ERRORS
 
@@ -0,0 +1,54 @@
+// Copyright 2023 james dotter.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://github.com/jcdotter/go/LICENSE
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errors
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/jcdotter/go/test"
+)
+
+var config = &test.Config{
+	Trace:   true,
+	Detail:  true,
+	Require: true,
+	Msg:     "%s",
+}
+
+func TestHttp(t *testing.T) {
+	gt := test.New(t, config)
+	gt.Msg = "Http(%s)"
+	for code, want := range map[int]Code{
+		http.StatusBadRequest:              INVALID,
+		http.StatusUnauthorized:            UNAUTHENTICATED,
+		http.StatusForbidden:               PERMISSION,
+		http.StatusNotFound:                NOTFOUND,
+		http.StatusConflict:                EXISTS,
+		http.StatusTooManyRequests:         EXHAUSTED,
+		499:                                CANCELLED,
+		http.StatusInternalServerError:     INTERNAL,
+		http.StatusBadGateway:              UNAVAILABLE,
+		http.StatusServiceUnavailable:      UNAVAILABLE,
+		http.StatusGatewayTimeout:          DEADLINE,
+		http.StatusTeapot:                  INVALID,
+		http.StatusHTTPVersionNotSupported: INTERNAL,
+	} {
+		err := Http(code, "message")
+		gt.Equal(want, err.(*Status).Code(), strconv.Itoa(code))
+		gt.Equal("message", err.(*Status).msg, strconv.Itoa(code)+" message")
+	}
+}
@@ -201,6 +201,6 @@ func ReadWriterFiles(r io.ReadWriter) (fs []*os.File) {
 	return
 }
 
-//go:noescape
-//go:linkname copyBuffer io.copyBuffer
-func copyBuffer(io.Writer, io.Reader, []byte) (int64, error)
+func copyBuffer(w io.Writer, r io.Reader, buf []byte) (int64, error) {
+	return io.CopyBuffer(w, r, buf)
+}
@@ -0,0 +1,209 @@
+// Copyright 2023 james dotter.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://github.com/jcdotter/go/LICENSE
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pg
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"strconv"
+	"strings"
+
+	"github.com/jcdotter/go/errors"
+)
+
+// ----------------------------------------------------------------------------
+// AUTHENTICATION
+// the cleartext, md5 and SCRAM-SHA-256 password
+// authentication requested by the server at startup
+
+const (
+	authOk           = 0
+	authCleartext    = 3
+	authMD5          = 5
+	authSASL         = 10
+	authSASLContinue = 11
+	authSASLFinal    = 12
+)
+
+// authenticate responds to an authentication request
+func (c *Conn) authenticate(m *message) error {
+	switch t := m.int32(); t {
+	case authOk:
+		return nil
+	case authCleartext:
+		return c.password(c.config.Password)
+	case authMD5:
+		salt := m.next(4)
+		return c.password("md5" + md5Hex(md5Hex(c.config.Password+c.config.User)+string(salt)))
+	case authSASL:
+		for {
+			mech := m.string()
+			if mech == "" {
+				return errors.Unimplemented("pg: no supported sasl mechanism")
+			}
+			if mech == "SCRAM-SHA-256" {
+				return c.scram()
+			}
+		}
+	default:
+		return errors.Unimplemented("pg: unsupported authentication " + strconv.Itoa(t))
+	}
+}
+
+// password sends a password message
+func (c *Conn) password(p string) error {
+	c.w.start('p')
+	c.w.string(p)
+	c.w.finish()
+	return c.flush()
+}
+
+// scram authenticates with SCRAM-SHA-256 as specified by
+// RFC 7677, verifying the signature of the server
+func (c *Conn) scram() error {
+	nonce := make([]byte, 18)
+	rand.Read(nonce)
+	s := &scram{password: c.config.Password, clientNonce: base64.StdEncoding.EncodeToString(nonce)}
+	first := s.clientFirst()
+	c.w.start('p')
+	c.w.string("SCRAM-SHA-256")
+	c.w.int32(len(first))
+	c.w.bytes([]byte(first))
+	c.w.finish()
+	if err := c.flush(); err != nil {
+		return err
+	}
+	for step := authSASLContinue; step <= authSASLFinal; step++ {
+		t, m, err := c.receive()
+		if err != nil {
+			return err
+		}
+		if t == 'E' {
+			return m.error()
+		}
+		if t != 'R' || m.int32() != step {
+			return errors.Unauthenticated("pg: unexpected scram message")
+		}
+		if step == authSASLFinal {
+			return s.verify(string(m.b))
+		}
+		final, err := s.clientFinal(string(m.b))
+		if err != nil {
+			return err
+		}
+		c.w.start('p')
+		c.w.bytes([]byte(final))
+		c.w.finish()
+		if err = c.flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scram is the state of a SCRAM-SHA-256 exchange
+type scram struct {
+	password    string
+	clientNonce string
+	authMessage string
+	salted      []byte
+}
+
+// clientFirst returns the client first message, where the
+// user is empty as the server uses the user of the startup
+func (s *scram) clientFirst() string {
+	return "n,,n=,r=" + s.clientNonce
+}
+
+// clientFinal returns the client final message in response
+// to the server first message provided
+func (s *scram) clientFinal(serverFirst string) (string, error) {
+	var nonce, salt string
+	iter := 0
+	for _, a := range strings.Split(serverFirst, ",") {
+		if len(a) < 2 || a[1] != '=' {
+			continue
+		}
+		switch a[0] {
+		case 'r':
+			nonce = a[2:]
+		case 's':
+			salt = a[2:]
+		case 'i':
+			iter, _ = strconv.Atoi(a[2:])
+		}
+	}
+	b, err := base64.StdEncoding.DecodeString(salt)
+	if err != nil || iter < 1 || !strings.HasPrefix(nonce, s.clientNonce) || len(nonce) == len(s.clientNonce) {
+		return "", errors.Unauthenticated("pg: invalid scram server message")
+	}
+	s.salted = pbkdf2(sha256Hmac, []byte(s.password), b, iter, sha256.Size)
+	final := "c=biws,r=" + nonce
+	s.authMessage = "n=,r=" + s.clientNonce + "," + serverFirst + "," + final
+	clientKey := sha256Hmac(s.salted, []byte("Client Key"))
+	stored := sha256.Sum256(clientKey)
+	proof := sha256Hmac(stored[:], []byte(s.authMessage))
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+	return final + ",p=" + base64.StdEncoding.EncodeToString(proof), nil
+}
+
+// verify verifies the signature of the server final message
+func (s *scram) verify(serverFinal string) error {
+	if !strings.HasPrefix(serverFinal, "v=") {
+		return errors.Unauthenticated("pg: scram authentication failed")
+	}
+	sig, err := base64.StdEncoding.DecodeString(serverFinal[2:])
+	serverKey := sha256Hmac(s.salted, []byte("Server Key"))
+	if err != nil || !hmac.Equal(sig, sha256Hmac(serverKey, []byte(s.authMessage))) {
+		return errors.Unauthenticated("pg: invalid scram server signature")
+	}
+	return nil
+}
+
+func sha256Hmac(key, b []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(b)
+	return h.Sum(nil)
+}
+
+// pbkdf2 derives a key of the size provided from the
+// password and salt as specified by RFC 8018
+func pbkdf2(prf func(key, b []byte) []byte, password, salt []byte, iter, size int) []byte {
+	var key []byte
+	for block := uint32(1); len(key) < size; block++ {
+		u := prf(password, binary.BigEndian.AppendUint32(append([]byte(nil), salt...), block))
+		t := append([]byte(nil), u...)
+		for i := 1; i < iter; i++ {
+			u = prf(password, u)
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:size]
+}
+
+func md5Hex(s string) string {
+	h := md5.Sum([]byte(s))
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,1050 @@
+// Copyright 2023 james dotter.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://github.com/jcdotter/go/LICENSE
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pg
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/jcdotter/go/errors"
+	"github.com/jcdotter/go/typ"
+)
+
+// ----------------------------------------------------------------------------
+// QUERY BUILDER
+// composes SELECT, INSERT, UPDATE and DELETE statements, where
+// values are always bound as $n args of the statement. Structs
+// are inserted and updated by the columns of their fields, named
+// by the db tag of the field or the snake case of the field name:
+//
+//	sql, args, err := pg.Select("u.id", "u.name").
+//		From("users u").
+//		LeftJoin("orders o", "o.user_id = u.id").
+//		Where(pg.Eq("u.active", true), pg.Or(pg.Gt("u.age", 21), pg.IsNull("u.age"))).
+//		OrderBy("u.name").
+//		Limit(10).
+//		Build()
+//
+//	tag, err := pg.Insert("users").Struct(user).
+//		OnConflict("email").DoUpdate("name").
+//		Exec(ctx, pool)
+//
+// Fields tagged db:"-" are ignored, and fields tagged with the
+// omitempty option, such as db:"id,omitempty", are set to their
+// column default when zero.
+
+// Statement is a statement of the query builder,
+// which may be used as a subquery of another statement
+type Statement interface {
+	// Build returns the sql and args of the statement
+	Build() (string, []any, error)
+	build(b *builder)
+}
+
+// Execer runs statements, such as a Conn, PoolConn or Pool
+type Execer interface {
+	Exec(ctx context.Context, sql string, args ...any) (CommandTag, error)
+}
+
+// builder writes the sql and args of a statement
+type builder struct {
+	sql  strings.Builder
+	args []any
+	err  error
+}
+
+// write writes the sql provided
+func (b *builder) write(s ...string) {
+	for _, s := range s {
+		b.sql.WriteString(s)
+	}
+}
+
+// list writes the strings provided separated by commas
+func (b *builder) list(s []string) {
+	b.write(strings.Join(s, ", "))
+}
+
+// arg writes the placeholder of an arg, or the sql of an
+// expression or subquery with its args
+func (b *builder) arg(v any) {
+	switch v := v.(type) {
+	case *Expr:
+		v.build(b)
+	case Statement:
+		b.write("(")
+		v.build(b)
+		b.write(")")
+	default:
+		b.args = append(b.args, v)
+		b.write("$", strconv.Itoa(len(b.args)))
+	}
+}
+
+// fail records the first error of the statement
+func (b *builder) fail(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+// result returns the sql and args written
+func (b *builder) result() (string, []any, error) {
+	if b.err != nil {
+		return "", nil, b.err
+	}
+	return b.sql.String(), b.args, nil
+}
+
+// where writes the where clause of the conditions provided
+func (b *builder) where(conds []Cond) {
+	if len(conds) > 0 {
+		b.write(" WHERE ")
+		And(conds...).build(b)
+	}
+}
+
+// returning writes the returning clause of the columns provided
+func (b *builder) returning(cols []string) {
+	if len(cols) > 0 {
+		b.write(" RETURNING ")
+		b.list(cols)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// CONDITIONS
+// the conditions of where, having and join clauses, which
+// are composed with And, Or and Not. Raw expressions bind
+// their args to the ? placeholders of the expression, where
+// ?? is a literal ?, as in the jsonb operators of postgres:
+//
+//	pg.Raw("tags ?? ?", "admin") // tags ? $1
+
+// Cond is a condition of a statement
+type Cond interface {
+	build(b *builder)
+}
+
+// Expr is a raw sql expression
+type Expr struct {
+	sql  string
+	args []any
+}
+
+// Default is the default value of a column
+var Default = Raw("DEFAULT")
+
+// Raw returns the sql expression provided, binding the args
+// to the ? placeholders of the expression in order, where ??
+// is a literal ?. Raw expressions may be used as conditions
+// or as values.
+func Raw(sql string, args ...any) *Expr {
+	return &Expr{sql: sql, args: args}
+}
+
+func (r *Expr) build(b *builder) {
+	n, quoted := 0, false
+	start := 0
+	for i := 0; i < len(r.sql); i++ {
+		switch c := r.sql[i]; {
+		case c == '\'':
+			quoted = !quoted
+		case c == '?' && !quoted && i+1 < len(r.sql) && r.sql[i+1] == '?':
+			b.write(r.sql[start : i+1])
+			i++
+			start = i + 1
+		case c == '?' && !quoted:
+			b.write(r.sql[start:i])
+			start = i + 1
+			if n >= len(r.args) {
+				b.fail(errors.Invalid("pg: too few args of expression '" + r.sql + "'"))
+				return
+			}
+			b.arg(r.args[n])
+			n++
+		}
+	}
+	b.write(r.sql[start:])
+	if n != len(r.args) {
+		b.fail(errors.Invalid("pg: too many args of expression '" + r.sql + "'"))
+	}
+}
+
+// compare is a comparison of a column and a value
+type compare struct {
+	col string
+	op  string
+	v   any
+}
+
+func (c *compare) build(b *builder) {
+	b.write(c.col, " ", c.op, " ")
+	b.arg(c.v)
+}
+
+// Eq returns the condition col = v, or col IS NULL if v is nil
+func Eq(col string, v any) Cond {
+	if v == nil {
+		return IsNull(col)
+	}
+	return &compare{col, "=", v}
+}
+
+// Ne returns the condition col <> v, or col IS NOT NULL if v is nil
+func Ne(col string, v any) Cond {
+	if v == nil {
+		return NotNull(col)
+	}
+	return &compare{col, "<>", v}
+}
+
+// Lt returns the condition col < v
+func Lt(col string, v any) Cond { return &compare{col, "<", v} }
+
+// Le returns the condition col <= v
+func Le(col string, v any) Cond { return &compare{col, "<=", v} }
+
+// Gt returns the condition col > v
+func Gt(col string, v any) Cond { return &compare{col, ">", v} }
+
+// Ge returns the condition col >= v
+func Ge(col string, v any) Cond { return &compare{col, ">=", v} }
+
+// Like returns the condition col LIKE pattern
+func Like(col string, pattern string) Cond { return &compare{col, "LIKE", pattern} }
+
+// ILike returns the condition col ILIKE pattern
+func ILike(col string, pattern string) Cond { return &compare{col, "ILIKE", pattern} }
+
+// IsNull returns the condition col IS NULL
+func IsNull(col string) Cond { return Raw(col + " IS NULL") }
+
+// NotNull returns the condition col IS NOT NULL
+func NotNull(col string) Cond { return Raw(col + " IS NOT NULL") }
+
+// Between returns the condition col BETWEEN low AND high
+func Between(col string, low, high any) Cond {
+	return Raw(col+" BETWEEN ? AND ?", low, high)
+}
+
+// in is the condition of a column in a list of values or a subquery
+type in struct {
+	col  string
+	not  bool
+	vals []any
+}
+
+// In returns the condition col IN (vals...), where a single slice
+// is a list of values and a single statement is a subquery
+func In(col string, vals ...any) Cond {
+	return &in{col: col, vals: vals}
+}
+
+// NotIn returns the condition col NOT IN (vals...). See In.
+func NotIn(col string, vals ...any) Cond {
+	return &in{col: col, not: true, vals: vals}
+}
+
+func (c *in) build(b *builder) {
+	vals := c.vals
+	if len(vals) == 1 {
+		if s, ok := vals[0].(Statement); ok {
+			b.write(c.col, c.op(), "(")
+			s.build(b)
+			b.write(")")
+			return
+		}
+		if r := reflect.ValueOf(vals[0]); r.Kind() == reflect.Slice && r.Type().Elem().Kind() != reflect.Uint8 {
+			vals = make([]any, r.Len())
+			for i := range vals {
+				vals[i] = r.Index(i).Interface()
+			}
+		}
+	}
+	if len(vals) == 0 {
+		// nothing is in an empty list
+		if c.not {
+			b.write("TRUE")
+		} else {
+			b.write("FALSE")
+		}
+		return
+	}
+	b.write(c.col, c.op(), "(")
+	for i, v := range vals {
+		if i > 0 {
+			b.write(", ")
+		}
+		b.arg(v)
+	}
+	b.write(")")
+}
+
+func (c *in) op() string {
+	if c.not {
+		return " NOT IN "
+	}
+	return " IN "
+}
+
+// group is a group of conditions joined by an operator
+type group struct {
+	op    string
+	conds []Cond
+}
+
+// And returns the condition of all the conditions provided,
+// which is TRUE if there are no conditions
+func And(conds ...Cond) Cond { return &group{"AND", conds} }
+
+// Or returns the condition of any of the conditions provided,
+// which is FALSE if there are no conditions
+func Or(conds ...Cond) Cond { return &group{"OR", conds} }
+
+func (g *group) build(b *builder) {
+	switch len(g.conds) {
+	case 0:
+		if g.op == "AND" {
+			b.write("TRUE")
+		} else {
+			b.write("FALSE")
+		}
+		return
+	case 1:
+		g.conds[0].build(b)
+		return
+	}
+	for i, c := range g.conds {
+		if i > 0 {
+			b.write(" ", g.op, " ")
+		}
+		// groups and expressions may contain operators of lower
+		// precedence than the operator of the group
+		switch c.(type) {
+		case *compare, *in, *not:
+			c.build(b)
+		default:
+			b.write("(")
+			c.build(b)
+			b.write(")")
+		}
+	}
+}
+
+// not is the negation of a condition
+type not struct {
+	cond Cond
+}
+
+// Not returns the negation of the condition provided
+func Not(cond Cond) Cond { return &not{cond} }
+
+func (n *not) build(b *builder) {
+	b.write("NOT (")
+	n.cond.build(b)
+	b.write(")")
+}
+
+// ----------------------------------------------------------------------------
+// SELECT
+
+// SelectQuery is a SELECT statement
+type SelectQuery struct {
+	distinct bool
+	cols     []string
+	from     string
+	joins    []join
+	where    []Cond
+	groupBy  []string
+	having   []Cond
+	orderBy  []string
+	limit    *int
+	offset   *int
+	lock     string
+}
+
+type join struct {
+	kind  string
+	table string
+	on    Cond
+}
+
+// Select returns a select statement of the columns
+// provided, or of all columns if none are provided
+func Select(cols ...string) *SelectQuery {
+	return &SelectQuery{cols: cols}
+}
+
+// Distinct selects the distinct rows of the statement
+func (q *SelectQuery) Distinct() *SelectQuery {
+	q.distinct = true
+	return q
+}
+
+// From sets the table of the statement, such as "users u"
+func (q *SelectQuery) From(table string) *SelectQuery {
+	q.from = table
+	return q
+}
+
+// Join joins the table provided on the expression provided,
+// binding the args to the ? placeholders of the expression
+func (q *SelectQuery) Join(table, on string, args ...any) *SelectQuery {
+	return q.join("JOIN", table, on, args)
+}
+
+// LeftJoin left joins the table provided. See Join.
+func (q *SelectQuery) LeftJoin(table, on string, args ...any) *SelectQuery {
+	return q.join("LEFT JOIN", table, on, args)
+}
+
+// RightJoin right joins the table provided. See Join.
+func (q *SelectQuery) RightJoin(table, on string, args ...any) *SelectQuery {
+	return q.join("RIGHT JOIN", table, on, args)
+}
+
+// FullJoin full joins the table provided. See Join.
+func (q *SelectQuery) FullJoin(table, on string, args ...any) *SelectQuery {
+	return q.join("FULL JOIN", table, on, args)
+}
+
+func (q *SelectQuery) join(kind, table, on string, args []any) *SelectQuery {
+	q.joins = append(q.joins, join{kind, table, Raw(on, args...)})
+	return q
+}
+
+// Where adds the conditions provided to the
+// conditions of the statement, joined by AND
+func (q *SelectQuery) Where(conds ...Cond) *SelectQuery {
+	q.where = append(q.where, conds...)
+	return q
+}
+
+// GroupBy sets the group by columns of the statement
+func (q *SelectQuery) GroupBy(cols ...string) *SelectQuery {
+	q.groupBy = append(q.groupBy, cols...)
+	return q
+}
+
+// Having adds the conditions provided to the
+// having conditions of the statement, joined by AND
+func (q *SelectQuery) Having(conds ...Cond) *SelectQuery {
+	q.having = append(q.having, conds...)
+	return q
+}
+
+// OrderBy adds the order by columns of the
+// statement, such as "name" or "created_at DESC"
+func (q *SelectQuery) OrderBy(cols ...string) *SelectQuery {
+	q.orderBy = append(q.orderBy, cols...)
+	return q
+}
+
+// Limit sets the max number of rows of the statement
+func (q *SelectQuery) Limit(n int) *SelectQuery {
+	q.limit = &n
+	return q
+}
+
+// Offset sets the number of rows skipped by the statement
+func (q *SelectQuery) Offset(n int) *SelectQuery {
+	q.offset = &n
+	return q
+}
+
+// ForUpdate locks the rows selected for update
+func (q *SelectQuery) ForUpdate() *SelectQuery {
+	q.lock = "FOR UPDATE"
+	return q
+}
+
+// Build returns the sql and args of the statement
+func (q *SelectQuery) Build() (string, []any, error) {
+	b := &builder{}
+	q.build(b)
+	return b.result()
+}
+
+func (q *SelectQuery) build(b *builder) {
+	b.write("SELECT ")
+	if q.distinct {
+		b.write("DISTINCT ")
+	}
+	if len(q.cols) == 0 {
+		b.write("*")
+	} else {
+		b.list(q.cols)
+	}
+	if q.from != "" {
+		b.write(" FROM ", q.from)
+	}
+	for _, j := range q.joins {
+		b.write(" ", j.kind, " ", j.table, " ON ")
+		j.on.build(b)
+	}
+	b.where(q.where)
+	if len(q.groupBy) > 0 {
+		b.write(" GROUP BY ")
+		b.list(q.groupBy)
+	}
+	if len(q.having) > 0 {
+		b.write(" HAVING ")
+		And(q.having...).build(b)
+	}
+	if len(q.orderBy) > 0 {
+		b.write(" ORDER BY ")
+		b.list(q.orderBy)
+	}
+	if q.limit != nil {
+		b.write(" LIMIT ")
+		b.arg(*q.limit)
+	}
+	if q.offset != nil {
+		b.write(" OFFSET ")
+		b.arg(*q.offset)
+	}
+	if q.lock != "" {
+		b.write(" ", q.lock)
+	}
+}
+
+// Query runs the statement with the querier provided
+func (q *SelectQuery) Query(ctx context.Context, qr Querier) (*Rows, error) {
+	return query(ctx, qr, q)
+}
+
+// ----------------------------------------------------------------------------
+// INSERT
+
+// InsertQuery is an INSERT statement
+type InsertQuery struct {
+	table     string
+	cols      []string
+	fixed     bool
+	rows      [][]any
+	query     Statement
+	conflict  []string
+	action    string
+	updates   []string
+	sets      []set
+	returning []string
+	err       error
+}
+
+// set is a column set to a value
+type set struct {
+	col string
+	v   any
+}
+
+// Insert returns an insert statement of the table provided
+func Insert(table string) *InsertQuery {
+	return &InsertQuery{table: table}
+}
+
+// Columns sets the columns of the values of the statement,
+// which are the only columns set by structs when provided
+func (q *InsertQuery) Columns(cols ...string) *InsertQuery {
+	q.cols, q.fixed = cols, true
+	return q
+}
+
+// Values adds a row of the values provided, in the order of the columns
+func (q *InsertQuery) Values(vals ...any) *InsertQuery {
+	q.rows = append(q.rows, vals)
+	return q
+}
+
+// Struct adds a row of each struct provided, where a struct may be
+// a pointer to a struct or a slice of structs. The columns of the
+// statement are the columns of the fields of the structs, which are
+// set to their default value in rows where they are omitted.
+func (q *InsertQuery) Struct(structs ...any) *InsertQuery {
+	for _, s := range structs {
+		r := reflect.ValueOf(s)
+		if r.Kind() != reflect.Slice && r.Kind() != reflect.Array {
+			q.addStruct(s)
+			continue
+		}
+		for i := 0; i < r.Len(); i++ {
+			q.addStruct(r.Index(i).Interface())
+		}
+	}
+	return q
+}
+
+// addStruct adds the row of the struct provided, adding the
+// columns of the struct which are not columns of the statement
+func (q *InsertQuery) addStruct(s any) {
+	cols, err := structColumns(s)
+	if err != nil {
+		q.fail(err)
+		return
+	}
+	index := make(map[string]int, len(q.cols))
+	for i, c := range q.cols {
+		index[c] = i
+	}
+	row := make([]any, len(q.cols))
+	for i := range row {
+		row[i] = Default
+	}
+	for _, c := range cols {
+		i, ok := index[c.name]
+		if !ok {
+			if c.omit || q.fixed {
+				continue
+			}
+			// a column of the first row, or omitted by the previous rows
+			q.cols = append(q.cols, c.name)
+			for r := range q.rows {
+				q.rows[r] = append(q.rows[r], Default)
+			}
+			row = append(row, c.v)
+			continue
+		}
+		if !c.omit {
+			row[i] = c.v
+		}
+	}
+	q.rows = append(q.rows, row)
+}
+
+// Select sets the rows of the statement to the rows of the select provided
+func (q *InsertQuery) Select(s *SelectQuery) *InsertQuery {
+	q.query = s
+	return q
+}
+
+// OnConflict sets the conflict target of the statement, such as
+// the columns of a unique index, where DoNothing or DoUpdate sets
+// the action on conflict
+func (q *InsertQuery) OnConflict(target ...string) *InsertQuery {
+	q.conflict = target
+	return q
+}
+
+// DoNothing ignores the rows of the statement which conflict
+func (q *InsertQuery) DoNothing() *InsertQuery {
+	q.action = "NOTHING"
+	return q
+}
+
+// DoUpdate updates the columns provided of the rows which conflict
+// to the values of the statement, or all columns of the statement
+// other than the conflict target if none are provided
+func (q *InsertQuery) DoUpdate(cols ...string) *InsertQuery {
+	q.action = "UPDATE"
+	q.updates = append(q.updates, cols...)
+	return q
+}
+
+// DoUpdateSet sets the column provided to the value provided in
+// the rows which conflict, where a raw expression may reference
+// the row of the statement as EXCLUDED
+func (q *InsertQuery) DoUpdateSet(col string, v any) *InsertQuery {
+	q.action = "UPDATE"
+	q.sets = append(q.sets, set{col, v})
+	return q
+}
+
+// Returning sets the columns returned by the statement
+func (q *InsertQuery) Returning(cols ...string) *InsertQuery {
+	q.returning = cols
+	return q
+}
+
+func (q *InsertQuery) fail(err error) {
+	if q.err == nil {
+		q.err = err
+	}
+}
+
+// Build returns the sql and args of the statement
+func (q *InsertQuery) Build() (string, []any, error) {
+	b := &builder{}
+	q.build(b)
+	return b.result()
+}
+
+func (q *InsertQuery) build(b *builder) {
+	if q.err != nil {
+		b.fail(q.err)
+		return
+	}
+	b.write("INSERT INTO ", q.table)
+	if len(q.cols) > 0 {
+		b.write(" (")
+		b.list(q.cols)
+		b.write(")")
+	}
+	switch {
+	case q.query != nil:
+		b.write(" ")
+		q.query.build(b)
+	case len(q.rows) == 0:
+		b.fail(errors.Invalid("pg: insert into " + q.table + " has no values"))
+		return
+	case len(q.cols) == 0 && len(q.rows) == 1 && len(q.rows[0]) == 0:
+		b.write(" DEFAULT VALUES")
+	default:
+		b.write(" VALUES ")
+		for i, row := range q.rows {
+			if len(row) != len(q.cols) {
+				b.fail(errors.Invalid("pg: insert into " + q.table + " expected " + strconv.Itoa(len(q.cols)) + " values, got " + strconv.Itoa(len(row))))
+				return
+			}
+			if i > 0 {
+				b.write(", ")
+			}
+			b.write("(")
+			for j, v := range row {
+				if j > 0 {
+					b.write(", ")
+				}
+				b.arg(v)
+			}
+			b.write(")")
+		}
+	}
+	if q.conflict != nil || q.action != "" {
+		b.write(" ON CONFLICT")
+		if len(q.conflict) > 0 {
+			b.write(" (")
+			b.list(q.conflict)
+			b.write(")")
+		}
+		switch q.action {
+		case "", "NOTHING":
+			b.write(" DO NOTHING")
+		case "UPDATE":
+			if len(q.conflict) == 0 {
+				b.fail(errors.Invalid("pg: on conflict do update requires a conflict target"))
+				return
+			}
+			sets := q.sets
+			updates := q.updates
+			if len(updates) == 0 && len(sets) == 0 {
+				target := map[string]bool{}
+				for _, c := range q.conflict {
+					target[c] = true
+				}
+				for _, c := range q.cols {
+					if !target[c] {
+						updates = append(updates, c)
+					}
+				}
+			}
+			for _, c := range updates {
+				sets = append(sets, set{c, Raw("EXCLUDED." + c)})
+			}
+			b.write(" DO UPDATE SET ")
+			writeSets(b, sets)
+		}
+	}
+	b.returning(q.returning)
+}
+
+// Exec runs the statement with the execer provided
+func (q *InsertQuery) Exec(ctx context.Context, e Execer) (CommandTag, error) {
+	return exec(ctx, e, q)
+}
+
+// Query runs the statement with the querier provided,
+// returning the rows of the returning columns
+func (q *InsertQuery) Query(ctx context.Context, qr Querier) (*Rows, error) {
+	return query(ctx, qr, q)
+}
+
+// ----------------------------------------------------------------------------
+// UPDATE
+
+// UpdateQuery is an UPDATE statement
+type UpdateQuery struct {
+	table     string
+	sets      []set
+	from      string
+	where     []Cond
+	returning []string
+	err       error
+}
+
+// Update returns an update statement of the table provided
+func Update(table string) *UpdateQuery {
+	return &UpdateQuery{table: table}
+}
+
+// Set sets the column provided to the value provided,
+// which may be a raw expression such as Raw("n + ?", 1)
+func (q *UpdateQuery) Set(col string, v any) *UpdateQuery {
+	q.sets = append(q.sets, set{col, v})
+	return q
+}
+
+// Struct sets the columns of the fields of the struct provided,
+// or only the columns provided if any, where fields tagged with
+// the omitempty option are not set when zero
+func (q *UpdateQuery) Struct(s any, cols ...string) *UpdateQuery {
+	fields, err := structColumns(s)
+	if err != nil {
+		q.err = err
+		return q
+	}
+	only := map[string]bool{}
+	for _, c := range cols {
+		only[c] = true
+	}
+	for _, f := range fields {
+		if (len(only) > 0 && !only[f.name]) || (len(only) == 0 && f.omit) {
+			continue
+		}
+		q.sets = append(q.sets, set{f.name, f.v})
+	}
+	return q
+}
+
+// From sets the tables joined to the table of the statement
+func (q *UpdateQuery) From(tables string) *UpdateQuery {
+	q.from = tables
+	return q
+}
+
+// Where adds the conditions provided to the
+// conditions of the statement, joined by AND
+func (q *UpdateQuery) Where(conds ...Cond) *UpdateQuery {
+	q.where = append(q.where, conds...)
+	return q
+}
+
+// Returning sets the columns returned by the statement
+func (q *UpdateQuery) Returning(cols ...string) *UpdateQuery {
+	q.returning = cols
+	return q
+}
+
+// Build returns the sql and args of the statement
+func (q *UpdateQuery) Build() (string, []any, error) {
+	b := &builder{}
+	q.build(b)
+	return b.result()
+}
+
+func (q *UpdateQuery) build(b *builder) {
+	if q.err != nil {
+		b.fail(q.err)
+		return
+	}
+	if len(q.sets) == 0 {
+		b.fail(errors.Invalid("pg: update of " + q.table + " sets no columns"))
+		return
+	}
+	b.write("UPDATE ", q.table, " SET ")
+	writeSets(b, q.sets)
+	if q.from != "" {
+		b.write(" FROM ", q.from)
+	}
+	b.where(q.where)
+	b.returning(q.returning)
+}
+
+// Exec runs the statement with the execer provided
+func (q *UpdateQuery) Exec(ctx context.Context, e Execer) (CommandTag, error) {
+	return exec(ctx, e, q)
+}
+
+// Query runs the statement with the querier provided,
+// returning the rows of the returning columns
+func (q *UpdateQuery) Query(ctx context.Context, qr Querier) (*Rows, error) {
+	return query(ctx, qr, q)
+}
+
+// ----------------------------------------------------------------------------
+// DELETE
+
+// DeleteQuery is a DELETE statement
+type DeleteQuery struct {
+	table     string
+	using     string
+	where     []Cond
+	returning []string
+}
+
+// Delete returns a delete statement of the table provided
+func Delete(table string) *DeleteQuery {
+	return &DeleteQuery{table: table}
+}
+
+// Using sets the tables joined to the table of the statement
+func (q *DeleteQuery) Using(tables string) *DeleteQuery {
+	q.using = tables
+	return q
+}
+
+// Where adds the conditions provided to the
+// conditions of the statement, joined by AND
+func (q *DeleteQuery) Where(conds ...Cond) *DeleteQuery {
+	q.where = append(q.where, conds...)
+	return q
+}
+
+// Returning sets the columns returned by the statement
+func (q *DeleteQuery) Returning(cols ...string) *DeleteQuery {
+	q.returning = cols
+	return q
+}
+
+// Build returns the sql and args of the statement
+func (q *DeleteQuery) Build() (string, []any, error) {
+	b := &builder{}
+	q.build(b)
+	return b.result()
+}
+
+func (q *DeleteQuery) build(b *builder) {
+	b.write("DELETE FROM ", q.table)
+	if q.using != "" {
+		b.write(" USING ", q.using)
+	}
+	b.where(q.where)
+	b.returning(q.returning)
+}
+
+// Exec runs the statement with the execer provided
+func (q *DeleteQuery) Exec(ctx context.Context, e Execer) (CommandTag, error) {
+	return exec(ctx, e, q)
+}
+
+// Query runs the statement with the querier provided,
+// returning the rows of the returning columns
+func (q *DeleteQuery) Query(ctx context.Context, qr Querier) (*Rows, error) {
+	return query(ctx, qr, q)
+}
+
+// ----------------------------------------------------------------------------
+// HELPERS
+
+// writeSets writes the col = value pairs of the sets provided
+func writeSets(b *builder, sets []set) {
+	for i, s := range sets {
+		if i > 0 {
+			b.write(", ")
+		}
+		b.write(s.col, " = ")
+		b.arg(s.v)
+	}
+}
+
+func exec(ctx context.Context, e Execer, s Statement) (CommandTag, error) {
+	sql, args, err := s.Build()
+	if err != nil {
+		return "", err
+	}
+	return e.Exec(ctx, sql, args...)
+}
+
+func query(ctx context.Context, q Querier, s Statement) (*Rows, error) {
+	sql, args, err := s.Build()
+	if err != nil {
+		return nil, err
+	}
+	return q.Query(ctx, sql, args...)
+}
+
+// column is the column of a field of a struct
+type column struct {
+	name string
+	v    any
+	// omit is true if the field is zero and
+	// tagged with the omitempty option
+	omit bool
+}
+
+// structColumns returns the columns of the fields of the struct
+// provided, including the fields of embedded structs
+func structColumns(s any) ([]column, error) {
+	r := reflect.ValueOf(s)
+	for r.Kind() == reflect.Pointer {
+		if r.IsNil() {
+			return nil, errors.Invalid("pg: nil struct")
+		}
+		r = r.Elem()
+	}
+	if !r.IsValid() || !isStruct(r.Type()) {
+		return nil, errors.Invalid("pg: expected a struct, got " + reflect.TypeOf(s).String())
+	}
+	var cols []column
+	var walk func(s typ.Struct)
+	walk = func(s typ.Struct) {
+		t := s.Reflect().Type()
+		s.ForEach(func(i int, f *typ.FieldType, v typ.Value) (brake bool) {
+			sf := t.Field(i)
+			name, opts, _ := strings.Cut(reflect.StructTag(f.Tag()).Get("db"), ",")
+			if name == "-" {
+				return
+			}
+			if sf.Anonymous && name == "" {
+				e := v.Reflect()
+				if e.Kind() == reflect.Pointer {
+					if e.IsNil() {
+						return
+					}
+					e = e.Elem()
+				}
+				if isStruct(e.Type()) {
+					walk(typ.FromReflectValue(e).Struct())
+					return
+				}
+			}
+			if !sf.IsExported() {
+				return
+			}
+			if name == "" {
+				name = snakeCase(f.Name())
+			}
+			c := column{name: name, v: v.Interface()}
+			for _, o := range strings.Split(opts, ",") {
+				if o == "omitempty" && v.IsZero() {
+					c.omit = true
+				}
+			}
+			cols = append(cols, c)
+			return
+		})
+	}
+	walk(typ.FromReflectValue(r).Struct())
+	return cols, nil
+}
+
+// snakeCase returns the snake case of a field name,
+// such as created_at of CreatedAt or user_id of UserID
+func snakeCase(name string) string {
+	rs := []rune(name)
+	var b strings.Builder
+	for i, r := range rs {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(rs[i-1]) || unicode.IsDigit(rs[i-1]) ||
+				(i+1 < len(rs) && unicode.IsLower(rs[i+1]) && unicode.IsUpper(rs[i-1]))) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,203 @@
+// Copyright 2023 james dotter.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://github.com/jcdotter/go/LICENSE
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pg
+
+import (
+	"context"
+	"io"
+
+	"github.com/jcdotter/go/errors"
+)
+
+// ----------------------------------------------------------------------------
+// COPY
+// streams the data of COPY statements from a reader to the
+// server and from the server to a writer, in the format of
+// the statement, without buffering the data of the table:
+//
+//	tag, err := conn.CopyFrom(ctx, "COPY users (id, name) FROM STDIN WITH (FORMAT csv)", file)
+//	tag, err := conn.CopyTo(ctx, "COPY users TO STDOUT WITH (FORMAT csv, HEADER)", w)
+
+// copyChunk is the max size of the data messages sent
+const copyChunk = 64 << 10
+
+// CopyFrom runs the COPY ... FROM STDIN statement provided,
+// sending the data of the reader until it is exhausted. The
+// copy is aborted if the reader returns an error. The error
+// of a server failing the copy, as on invalid data, is read
+// once the data is sent, as the server drops the rest of it.
+func (c *Conn) CopyFrom(ctx context.Context, sql string, r io.Reader) (CommandTag, error) {
+	stop, err := c.begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	if err = c.copyStart(sql, 'G'); err != nil {
+		return "", c.end(ctx, stop, err)
+	}
+	buf := make([]byte, copyChunk)
+	var rerr error
+	for rerr == nil {
+		var n int
+		n, rerr = r.Read(buf)
+		if n > 0 {
+			c.w.start('d')
+			c.w.bytes(buf[:n])
+			c.w.finish()
+			if err = c.flush(); err != nil {
+				return "", c.end(ctx, stop, err)
+			}
+		}
+	}
+	if rerr == io.EOF {
+		c.w.start('c')
+	} else {
+		c.w.start('f')
+		c.w.string(rerr.Error())
+	}
+	c.w.finish()
+	if err = c.flush(); err != nil {
+		return "", c.end(ctx, stop, err)
+	}
+	tag, err := c.copyEnd()
+	if rerr != io.EOF {
+		err = errors.Aborted("pg: copy aborted: " + rerr.Error())
+	}
+	return tag, c.end(ctx, stop, err)
+}
+
+// CopyTo runs the COPY ... TO STDOUT statement provided, writing
+// the data received to the writer. The data of the statement is
+// discarded if the writer returns an error.
+func (c *Conn) CopyTo(ctx context.Context, sql string, w io.Writer) (CommandTag, error) {
+	stop, err := c.begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	if err = c.copyStart(sql, 'H'); err != nil {
+		return "", c.end(ctx, stop, err)
+	}
+	var werr error
+	for {
+		t, m, err := c.receive()
+		if err != nil {
+			return "", c.end(ctx, stop, err)
+		}
+		switch t {
+		case 'd':
+			if werr == nil {
+				_, werr = w.Write(m.b)
+			}
+		case 'c':
+			tag, err := c.copyEnd()
+			if err == nil && werr != nil {
+				err = errors.Aborted("pg: copy aborted: " + werr.Error())
+			}
+			return tag, c.end(ctx, stop, err)
+		case 'E':
+			return "", c.end(ctx, stop, c.sync(m.error()))
+		default:
+			if err = c.async(t, m); err != nil {
+				return "", c.end(ctx, stop, err)
+			}
+		}
+	}
+}
+
+// copyStart sends the copy statement provided and reads
+// messages until the copy response of the type provided
+func (c *Conn) copyStart(sql string, response byte) error {
+	c.w.start('Q')
+	c.w.string(sql)
+	c.w.finish()
+	if err := c.flush(); err != nil {
+		return err
+	}
+	for {
+		t, m, err := c.receive()
+		if err != nil {
+			return err
+		}
+		switch t {
+		case response:
+			return nil
+		case 'E':
+			return c.sync(m.error())
+		case 'G', 'H', 'W':
+			// the statement is not a copy of the direction expected,
+			// which is ended by the error of the server
+			c.w.start('f')
+			c.w.string("unexpected copy direction")
+			c.w.finish()
+			if err = c.flush(); err != nil {
+				return err
+			}
+			if _, err = c.copyEnd(); err == nil {
+				err = errors.Invalid("pg: statement is not a copy of the direction expected")
+			}
+			return err
+		case 'C', 'T', 'D', 'I':
+		case 'Z':
+			return errors.Invalid("pg: statement is not a copy statement")
+		default:
+			if err = c.async(t, m); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// copyEnd reads the command tag of a copy until the server is ready
+func (c *Conn) copyEnd() (CommandTag, error) {
+	var tag CommandTag
+	for {
+		t, m, err := c.receive()
+		if err != nil {
+			return "", err
+		}
+		switch t {
+		case 'C':
+			tag = CommandTag(m.string())
+		case 'E':
+			return "", c.sync(m.error())
+		case 'Z':
+			return tag, nil
+		case 'd', 'c':
+		default:
+			if err = c.async(t, m); err != nil {
+				return "", err
+			}
+		}
+	}
+}
+
+// CopyFrom acquires a connection and runs the copy provided. See Conn.CopyFrom.
+func (p *Pool) CopyFrom(ctx context.Context, sql string, r io.Reader) (CommandTag, error) {
+	c, err := p.Acquire(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer c.Release()
+	return c.CopyFrom(ctx, sql, r)
+}
+
+// CopyTo acquires a connection and runs the copy provided. See Conn.CopyTo.
+func (p *Pool) CopyTo(ctx context.Context, sql string, w io.Writer) (CommandTag, error) {
+	c, err := p.Acquire(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer c.Release()
+	return c.CopyTo(ctx, sql, w)
+}
@@ -0,0 +1,542 @@
+// Copyright 2023 james dotter.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://github.com/jcdotter/go/LICENSE
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pg
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jcdotter/go/cli"
+	"github.com/jcdotter/go/errors"
+	"github.com/jcdotter/go/path"
+)
+
+// ----------------------------------------------------------------------------
+// MIGRATIONS
+// versioned sql files of a directory, which are applied in
+// the order of their versions and recorded in a table of the
+// database. Each migration has an up file and a down file:
+//
+//	migrations/20230601120000_create_users.up.sql
+//	migrations/20230601120000_create_users.down.sql
+//
+// Each migration is run in a transaction, and runners hold an
+// advisory lock of the table while migrating, so that
+// concurrent runners apply each migration once:
+//
+//	m := pg.NewMigrator("migrations")
+//	applied, err := m.Up(ctx, conn, 0)
+
+// Migration is a versioned migration of a directory
+type Migration struct {
+	Version int64
+	Name    string
+	// Up and Down are the sql of the migration,
+	// which may contain multiple statements
+	Up   string
+	Down string
+}
+
+// MigrationStatus is the status of a migration
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	// Missing is true if the migration was
+	// applied but has no file in the directory
+	Missing bool
+}
+
+// Migrator applies the migrations of a directory
+type Migrator struct {
+	// Dir is the directory of the migrations, which is
+	// found relative to the working directory or else
+	// relative to the module of the working directory
+	Dir string
+	// Table is the table of the applied migrations,
+	// defaulting to schema_migrations
+	Table string
+	// Lock is the key of the advisory lock of the runners,
+	// defaulting to a hash of the table
+	Lock int64
+}
+
+// migrationFile matches the file names of migrations
+var migrationFile = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// migrationName matches valid migration names
+var migrationName = regexp.MustCompile(`^\w+$`)
+
+// migrationTable matches valid, optionally schema qualified, table names
+var migrationTable = regexp.MustCompile(`^[A-Za-z_]\w*(\.[A-Za-z_]\w*)?$`)
+
+// NewMigrator returns a migrator of the directory provided
+func NewMigrator(dir string) *Migrator {
+	return &Migrator{Dir: dir, Table: "schema_migrations"}
+}
+
+// Path returns the absolute path of the directory of the migrations
+func (m *Migrator) Path() string {
+	if path.IsAbs(m.Dir) {
+		return m.Dir
+	}
+	if path.Exists(m.Dir) || path.ModPath == "" {
+		return path.Abs(m.Dir)
+	}
+	if p := path.Join(path.ModPath, m.Dir); path.Exists(p) {
+		return p
+	}
+	return path.Abs(m.Dir)
+}
+
+// Migrations returns the migrations of the directory in the order
+// of their versions, returning an errors.Invalid status if a version
+// has multiple names or no up file
+func (m *Migrator) Migrations() ([]*Migration, error) {
+	dir := m.Path()
+	if !path.Exists(dir) {
+		return nil, errors.NotFound("pg: migrations directory '" + dir + "' not found")
+	}
+	versions := map[int64]*Migration{}
+	for _, f := range path.Files(dir) {
+		match := migrationFile.FindStringSubmatch(f)
+		if match == nil {
+			continue
+		}
+		v, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, errors.Invalid("pg: invalid migration version '" + match[1] + "'")
+		}
+		mg := versions[v]
+		if mg == nil {
+			mg = &Migration{Version: v, Name: match[2]}
+			versions[v] = mg
+		} else if mg.Name != match[2] {
+			return nil, errors.Invalid("pg: migration version " + match[1] + " has multiple names")
+		}
+		b, err := os.ReadFile(path.Join(dir, f))
+		if err != nil {
+			return nil, errors.Failed("pg: failed to read migration '" + f + "'")
+		}
+		if match[3] == "up" {
+			mg.Up = string(b)
+		} else {
+			mg.Down = string(b)
+		}
+	}
+	migrations := make([]*Migration, 0, len(versions))
+	for _, mg := range versions {
+		if strings.TrimSpace(mg.Up) == "" {
+			return nil, errors.Invalid("pg: migration " + mg.file("up") + " is missing or empty")
+		}
+		migrations = append(migrations, mg)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Up applies the pending migrations in the order of their
+// versions, or the first steps pending if steps > 0,
+// returning the migrations applied
+func (m *Migrator) Up(ctx context.Context, c *Conn, steps int) (applied []*Migration, err error) {
+	migrations, err := m.Migrations()
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := m.lock(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if e := unlock(); err == nil {
+			err = e
+		}
+	}()
+	done, err := m.applied(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	for _, mg := range migrations {
+		if _, ok := done[mg.Version]; ok {
+			continue
+		}
+		if steps > 0 && len(applied) == steps {
+			break
+		}
+		err = c.tx(ctx, func() error {
+			if _, err := c.Exec(ctx, mg.Up); err != nil {
+				return err
+			}
+			_, err := c.Exec(ctx, "INSERT INTO "+m.Table+" (version, name) VALUES ($1, $2)", mg.Version, mg.Name)
+			return err
+		})
+		if err != nil {
+			return applied, errors.Failed("pg: migration " + mg.file("up") + " failed: " + err.Error())
+		}
+		applied = append(applied, mg)
+	}
+	return applied, nil
+}
+
+// Down rolls back the last steps migrations applied, or the last
+// migration if steps <= 0, returning the migrations rolled back
+func (m *Migrator) Down(ctx context.Context, c *Conn, steps int) (reverted []*Migration, err error) {
+	if steps <= 0 {
+		steps = 1
+	}
+	migrations, err := m.Migrations()
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[int64]*Migration, len(migrations))
+	for _, mg := range migrations {
+		files[mg.Version] = mg
+	}
+	unlock, err := m.lock(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if e := unlock(); err == nil {
+			err = e
+		}
+	}()
+	done, err := m.applied(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]int64, 0, len(done))
+	for v := range done {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+	for _, v := range versions[:min(steps, len(versions))] {
+		mg := files[v]
+		if mg == nil {
+			return reverted, errors.NotFound("pg: migration " + strconv.FormatInt(v, 10) + " has no files")
+		}
+		if strings.TrimSpace(mg.Down) == "" {
+			return reverted, errors.Failed("pg: migration " + mg.file("down") + " is missing or empty")
+		}
+		err = c.tx(ctx, func() error {
+			if _, err := c.Exec(ctx, mg.Down); err != nil {
+				return err
+			}
+			_, err := c.Exec(ctx, "DELETE FROM "+m.Table+" WHERE version = $1", mg.Version)
+			return err
+		})
+		if err != nil {
+			return reverted, errors.Failed("pg: migration " + mg.file("down") + " failed: " + err.Error())
+		}
+		reverted = append(reverted, mg)
+	}
+	return reverted, nil
+}
+
+// Status returns the status of the migrations of the directory
+// and of the migrations applied without files, in the order
+// of their versions
+func (m *Migrator) Status(ctx context.Context, c *Conn) ([]*MigrationStatus, error) {
+	migrations, err := m.Migrations()
+	if err != nil {
+		return nil, err
+	}
+	if err = m.init(ctx, c); err != nil {
+		return nil, err
+	}
+	done, err := m.applied(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	status := make([]*MigrationStatus, 0, len(migrations))
+	for _, mg := range migrations {
+		s := &MigrationStatus{Version: mg.Version, Name: mg.Name}
+		if a, ok := done[mg.Version]; ok {
+			s.Applied, s.AppliedAt = true, a.AppliedAt
+			delete(done, mg.Version)
+		}
+		status = append(status, s)
+	}
+	for _, a := range done {
+		a.Missing = true
+		status = append(status, a)
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].Version < status[j].Version })
+	return status, nil
+}
+
+// Create writes the empty up and down files of a new migration
+// of the name provided, versioned by the current time in utc
+func (m *Migrator) Create(name string) (*Migration, error) {
+	name = strings.ToLower(strings.Join(strings.Fields(name), "_"))
+	if !migrationName.MatchString(name) {
+		return nil, errors.Invalid("pg: invalid migration name '" + name + "'")
+	}
+	dir := m.Path()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Failed("pg: failed to create migrations directory '" + dir + "'")
+	}
+	version, _ := strconv.ParseInt(time.Now().UTC().Format("20060102150405"), 10, 64)
+	if migrations, err := m.Migrations(); err == nil && len(migrations) > 0 {
+		// versions must increase when created within the same second
+		version = max(version, migrations[len(migrations)-1].Version+1)
+	}
+	mg := &Migration{Version: version, Name: name}
+	for _, d := range []string{"up", "down"} {
+		f := path.Join(dir, mg.file(d))
+		if err := os.WriteFile(f, []byte("-- "+mg.Name+" "+d+"\n"), 0644); err != nil {
+			return nil, errors.Failed("pg: failed to write migration '" + f + "'")
+		}
+	}
+	return mg, nil
+}
+
+// file returns the file name of the direction provided
+func (mg *Migration) file(dir string) string {
+	return strconv.FormatInt(mg.Version, 10) + "_" + mg.Name + "." + dir + ".sql"
+}
+
+// init creates the table of the applied migrations
+func (m *Migrator) init(ctx context.Context, c *Conn) error {
+	if m.Table == "" {
+		m.Table = "schema_migrations"
+	}
+	if !migrationTable.MatchString(m.Table) {
+		return errors.Invalid("pg: invalid migrations table '" + m.Table + "'")
+	}
+	_, err := c.Exec(ctx, "CREATE TABLE IF NOT EXISTS "+m.Table+" (version bigint PRIMARY KEY, name text NOT NULL, applied_at timestamptz NOT NULL DEFAULT now())")
+	return err
+}
+
+// lock creates the table of the applied migrations and acquires
+// the advisory lock of the migrator, waiting for other runners to
+// finish, returning a func which releases the lock
+func (m *Migrator) lock(ctx context.Context, c *Conn) (func() error, error) {
+	if err := m.init(ctx, c); err != nil {
+		return nil, err
+	}
+	key := m.Lock
+	if key == 0 {
+		h := fnv.New64a()
+		h.Write([]byte(m.Table))
+		key = int64(h.Sum64())
+	}
+	if _, err := c.Exec(ctx, "SELECT pg_advisory_lock($1)", key); err != nil {
+		return nil, err
+	}
+	return func() error {
+		_, err := c.Exec(ctx, "SELECT pg_advisory_unlock($1)", key)
+		return err
+	}, nil
+}
+
+// applied returns the status of the applied migrations by version
+func (m *Migrator) applied(ctx context.Context, c *Conn) (map[int64]*MigrationStatus, error) {
+	rows, err := c.Query(ctx, "SELECT version, name, applied_at FROM "+m.Table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	done := map[int64]*MigrationStatus{}
+	for rows.Next() {
+		s := &MigrationStatus{Applied: true}
+		if err = rows.Scan(&s.Version, &s.Name, &s.AppliedAt); err != nil {
+			return nil, err
+		}
+		done[s.Version] = s
+	}
+	return done, rows.Err()
+}
+
+// tx runs the func provided in a transaction, which
+// is committed if the func succeeds or rolled back
+func (c *Conn) tx(ctx context.Context, fn func() error) error {
+	if _, err := c.Exec(ctx, "BEGIN"); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		if !c.broken {
+			c.Exec(ctx, "ROLLBACK")
+		}
+		return err
+	}
+	_, err := c.Exec(ctx, "COMMIT")
+	return err
+}
+
+// ----------------------------------------------------------------------------
+// MIGRATION COMMANDS
+// cli commands of a migrator, which may be added to the
+// commands of a service:
+//
+//	root.AddCommand(pg.MigrateCommand(os.Getenv("DATABASE_URL"), "migrations"))
+//
+//	service migrate up --url postgres://localhost/db
+//	service migrate down --steps 2
+//	service migrate status
+//	service migrate create --name add_users_email
+
+// MigrateCommand returns the migrate command, with the up, down,
+// status and create sub commands, of the default connection url
+// and migrations directory provided, which may be set with the
+// --url and --dir flags of the sub commands
+func MigrateCommand(url, dir string) *cli.Command {
+	c := &cli.Command{
+		Name:  "migrate",
+		Short: cli.Msg("apply the migrations of the database"),
+		Use:   cli.Msg("migrate <up|down|status|create> [--url <url>] [--dir <dir>]"),
+	}
+	c.Flags().AddText("url", "u", "the connection url of the database", url).Persist()
+	c.Flags().AddText("dir", "d", "the directory of the migrations", dir).Persist()
+	c.AddCommand(MigrateUpCommand(), MigrateDownCommand(), MigrateStatusCommand(), MigrateCreateCommand())
+	return c
+}
+
+// MigrateUpCommand returns a command which applies the pending
+// migrations, or the number of migrations of the --steps flag
+func MigrateUpCommand() *cli.Command {
+	c := &cli.Command{
+		Name:  "up",
+		Short: cli.Msg("apply the pending migrations"),
+		Use:   cli.Msg("up [--steps <n>]"),
+		Run: func(cmd *cli.Command, args *cli.FlagSet) error {
+			return migrate(args, func(ctx context.Context, m *Migrator, c *Conn) error {
+				applied, err := m.Up(ctx, c, args.Get("steps").Int())
+				for _, mg := range applied {
+					fmt.Fprintf(cli.Stdout, "applied %s\n", mg.file("up"))
+				}
+				if err == nil && len(applied) == 0 {
+					fmt.Fprintln(cli.Stdout, "no pending migrations")
+				}
+				return err
+			})
+		},
+	}
+	c.Flags().AddInt("steps", "n", "the number of migrations to apply, or all if zero", 0)
+	return c
+}
+
+// MigrateDownCommand returns a command which rolls back the last
+// migration, or the number of migrations of the --steps flag
+func MigrateDownCommand() *cli.Command {
+	c := &cli.Command{
+		Name:  "down",
+		Short: cli.Msg("roll back the last migrations"),
+		Use:   cli.Msg("down [--steps <n>]"),
+		Run: func(cmd *cli.Command, args *cli.FlagSet) error {
+			return migrate(args, func(ctx context.Context, m *Migrator, c *Conn) error {
+				reverted, err := m.Down(ctx, c, args.Get("steps").Int())
+				for _, mg := range reverted {
+					fmt.Fprintf(cli.Stdout, "rolled back %s\n", mg.file("down"))
+				}
+				if err == nil && len(reverted) == 0 {
+					fmt.Fprintln(cli.Stdout, "no applied migrations")
+				}
+				return err
+			})
+		},
+	}
+	c.Flags().AddInt("steps", "n", "the number of migrations to roll back", 1)
+	return c
+}
+
+// MigrateStatusCommand returns a command which lists
+// the migrations and the time each was applied
+func MigrateStatusCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "status",
+		Short: cli.Msg("list the status of the migrations"),
+		Use:   cli.Msg("status"),
+		Run: func(cmd *cli.Command, args *cli.FlagSet) error {
+			return migrate(args, func(ctx context.Context, m *Migrator, c *Conn) error {
+				status, err := m.Status(ctx, c)
+				if err != nil {
+					return err
+				}
+				for _, s := range status {
+					state := "pending"
+					if s.Applied {
+						state = "applied " + s.AppliedAt.UTC().Format(time.DateTime)
+					}
+					if s.Missing {
+						state += " (missing)"
+					}
+					fmt.Fprintf(cli.Stdout, "%d %s %s\n", s.Version, s.Name, state)
+				}
+				return nil
+			})
+		},
+	}
+}
+
+// MigrateCreateCommand returns a command which
+// writes the files of a new migration
+func MigrateCreateCommand() *cli.Command {
+	c := &cli.Command{
+		Name:  "create",
+		Short: cli.Msg("create the files of a new migration"),
+		Use:   cli.Msg("create --name <name>"),
+		Run: func(cmd *cli.Command, args *cli.FlagSet) error {
+			name := args.Get("name").Text()
+			if name == "" {
+				return errors.Invalid("pg: --name required")
+			}
+			m := NewMigrator(flagText(args, "dir", "migrations"))
+			mg, err := m.Create(name)
+			if err != nil {
+				return err
+			}
+			for _, d := range []string{"up", "down"} {
+				fmt.Fprintf(cli.Stdout, "created %s\n", path.Join(m.Path(), mg.file(d)))
+			}
+			return nil
+		},
+	}
+	c.Flags().AddText("name", "n", "the name of the migration", "")
+	return c
+}
+
+// migrate connects to the database of the --url flag and
+// runs the func provided with the migrator of the --dir flag
+func migrate(args *cli.FlagSet, fn func(ctx context.Context, m *Migrator, c *Conn) error) error {
+	url := flagText(args, "url", "")
+	if url == "" {
+		return errors.Invalid("pg: --url required")
+	}
+	ctx := context.Background()
+	c, err := Connect(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return fn(ctx, NewMigrator(flagText(args, "dir", "migrations")), c)
+}
+
+// flagText returns the text of a flag, or the default
+// provided if the flag is not set or not defined
+func flagText(args *cli.FlagSet, name, dflt string) string {
+	if f := args.Get(name); f != nil && f.Text() != "" {
+		return f.Text()
+	}
+	return dflt
+}
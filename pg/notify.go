@@ -0,0 +1,382 @@
+// Copyright 2023 james dotter.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://github.com/jcdotter/go/LICENSE
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pg
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jcdotter/go/errors"
+)
+
+// ----------------------------------------------------------------------------
+// NOTIFICATIONS
+// notifications sent with NOTIFY to the channels a connection
+// listens to, which are received between queries and returned
+// by WaitForNotification. A listener listens to channels on a
+// dedicated connection, delivering the notifications received
+// and listening again after reconnecting when the connection
+// is lost:
+//
+//	l := pg.NewListener(config, "jobs")
+//	defer l.Close()
+//	for n := range l.Notifications() {
+//		fmt.Println(n.Channel, n.Payload)
+//	}
+
+// Notification is a notification sent to a channel
+type Notification struct {
+	// Pid is the process id of the server backend
+	// of the connection which sent the notification
+	Pid     uint32
+	Channel string
+	Payload string
+}
+
+// Listen listens to the channel provided
+func (c *Conn) Listen(ctx context.Context, channel string) error {
+	_, err := c.Exec(ctx, "LISTEN "+quoteIdent(channel))
+	return err
+}
+
+// Unlisten stops listening to the channel provided
+func (c *Conn) Unlisten(ctx context.Context, channel string) error {
+	_, err := c.Exec(ctx, "UNLISTEN "+quoteIdent(channel))
+	return err
+}
+
+// Notify sends the payload provided to the channel provided
+func (c *Conn) Notify(ctx context.Context, channel, payload string) error {
+	_, err := c.Exec(ctx, "SELECT pg_notify($1, $2)", channel, payload)
+	return err
+}
+
+// WaitForNotification returns the next notification of the
+// channels the connection listens to, waiting until one is
+// received or the context is done. The connection remains
+// usable when the context is done while waiting.
+func (c *Conn) WaitForNotification(ctx context.Context) (*Notification, error) {
+	return c.wait(ctx, nil)
+}
+
+// wait returns the next notification received, waiting until one
+// is received, the context is done or the wake channel receives,
+// where a nil notification is returned when woken
+func (c *Conn) wait(ctx context.Context, wake <-chan struct{}) (*Notification, error) {
+	if c.conn == nil || c.broken {
+		return nil, errors.Unavailable("pg: connection closed")
+	}
+	if c.busy {
+		return nil, errors.Failed("pg: connection busy, rows of the previous query must be closed")
+	}
+	for {
+		if len(c.notifications) > 0 {
+			n := c.notifications[0]
+			c.notifications = c.notifications[1:]
+			return n, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, netError(ctx, err)
+		}
+		// wait for a message without reading it, so that
+		// an interrupted wait leaves the connection usable
+		stop := c.interrupt(ctx, wake)
+		_, err := c.br.Peek(1)
+		if stop() {
+			c.conn.SetReadDeadline(time.Time{})
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				if ctx.Err() != nil {
+					return nil, netError(ctx, ctx.Err())
+				}
+				return nil, nil
+			}
+		}
+		if err != nil {
+			c.broken = true
+			return nil, netError(ctx, err)
+		}
+		unwatch := c.watch(ctx)
+		t, m, err := c.receive()
+		if err == nil {
+			if t == 'E' {
+				// the server terminated the connection
+				c.broken = true
+				err = m.error()
+			} else {
+				err = c.async(t, m)
+			}
+		}
+		if !unwatch() {
+			return nil, netError(ctx, ctx.Err())
+		}
+		if err != nil {
+			return nil, netError(ctx, err)
+		}
+	}
+}
+
+// interrupt interrupts the reads of the connection when the
+// context is done or the wake channel receives, returning a
+// func which stops watching and returns true if interrupted
+func (c *Conn) interrupt(ctx context.Context, wake <-chan struct{}) func() bool {
+	conn := c.conn
+	done := make(chan struct{})
+	interrupted := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-wake:
+		case <-done:
+			interrupted <- false
+			return
+		}
+		conn.SetReadDeadline(time.Unix(1, 0))
+		interrupted <- true
+	}()
+	return func() bool {
+		close(done)
+		return <-interrupted
+	}
+}
+
+// quoteIdent returns the quoted identifier provided
+func quoteIdent(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// ----------------------------------------------------------------------------
+// LISTENER
+
+// Listener delivers the notifications of the channels it listens to,
+// reconnecting with backoff when its connection is lost. The fields
+// of a listener must be set before it is started.
+type Listener struct {
+	config *Config
+	// MinReconnect and MaxReconnect are the min and max delays
+	// of reconnecting, which doubles after each failed attempt,
+	// defaulting to 100ms and 30s
+	MinReconnect time.Duration
+	MaxReconnect time.Duration
+	// OnReconnect is called after the listener reconnects and
+	// listens to its channels again, where notifications sent
+	// while disconnected are lost
+	OnReconnect func()
+	// OnError is called with the errors of the connection
+	OnError       func(err error)
+	mu            sync.Mutex
+	channels      map[string]bool
+	requests      []*listenRequest
+	wake          chan struct{}
+	notifications chan *Notification
+	ctx           context.Context
+	cancel        context.CancelFunc
+	done          chan struct{}
+	start         sync.Once
+}
+
+// listenRequest is a request to listen or stop listening to a channel
+type listenRequest struct {
+	sql  string
+	done chan error
+}
+
+// NewListener returns a listener of the channels provided,
+// which connects with the config provided when its
+// notifications are first requested or a channel is listened to
+func NewListener(config *Config, channels ...string) *Listener {
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &Listener{
+		config:        config,
+		MinReconnect:  100 * time.Millisecond,
+		MaxReconnect:  30 * time.Second,
+		channels:      map[string]bool{},
+		wake:          make(chan struct{}, 1),
+		notifications: make(chan *Notification, 64),
+		ctx:           ctx,
+		cancel:        cancel,
+		done:          make(chan struct{}),
+	}
+	for _, ch := range channels {
+		l.channels[ch] = true
+	}
+	return l
+}
+
+// Notifications returns the channel of the notifications received,
+// which is closed when the listener is closed
+func (l *Listener) Notifications() <-chan *Notification {
+	l.start.Do(func() { go l.run() })
+	return l.notifications
+}
+
+// Listen listens to the channel provided, waiting until the
+// listener is listening to the channel or the context is done
+func (l *Listener) Listen(ctx context.Context, channel string) error {
+	l.mu.Lock()
+	l.channels[channel] = true
+	l.mu.Unlock()
+	return l.request(ctx, "LISTEN "+quoteIdent(channel))
+}
+
+// Unlisten stops listening to the channel provided
+func (l *Listener) Unlisten(ctx context.Context, channel string) error {
+	l.mu.Lock()
+	delete(l.channels, channel)
+	l.mu.Unlock()
+	return l.request(ctx, "UNLISTEN "+quoteIdent(channel))
+}
+
+// Close closes the connection of the listener
+// and the channel of its notifications
+func (l *Listener) Close() error {
+	l.start.Do(func() { close(l.notifications); close(l.done) })
+	l.cancel()
+	<-l.done
+	return nil
+}
+
+// request runs the statement provided on the connection of the listener
+func (l *Listener) request(ctx context.Context, sql string) error {
+	l.start.Do(func() { go l.run() })
+	r := &listenRequest{sql: sql, done: make(chan error, 1)}
+	l.mu.Lock()
+	l.requests = append(l.requests, r)
+	l.mu.Unlock()
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+	select {
+	case err := <-r.done:
+		return err
+	case <-ctx.Done():
+		return netError(ctx, ctx.Err())
+	case <-l.done:
+		return errors.Unavailable("pg: listener closed")
+	}
+}
+
+// run connects, listens and delivers notifications until
+// the listener is closed, reconnecting when disconnected
+func (l *Listener) run() {
+	defer close(l.done)
+	defer close(l.notifications)
+	if l.MinReconnect <= 0 {
+		l.MinReconnect = 100 * time.Millisecond
+	}
+	l.MaxReconnect = max(l.MaxReconnect, l.MinReconnect)
+	delay := l.MinReconnect
+	connected := false
+	for {
+		c, err := l.connect()
+		if err != nil {
+			l.fail(err)
+			select {
+			case <-l.ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			delay = min(delay*2, l.MaxReconnect)
+			continue
+		}
+		delay = l.MinReconnect
+		if connected && l.OnReconnect != nil {
+			l.OnReconnect()
+		}
+		connected = true
+		err = l.serve(c)
+		c.Close()
+		if l.ctx.Err() != nil {
+			return
+		}
+		l.fail(err)
+	}
+}
+
+// connect opens a connection listening to the channels of the listener,
+// completing the requests made while disconnected
+func (l *Listener) connect() (*Conn, error) {
+	c, err := ConnectConfig(l.ctx, l.config)
+	if err != nil {
+		return nil, err
+	}
+	l.mu.Lock()
+	channels := make([]string, 0, len(l.channels))
+	for ch := range l.channels {
+		channels = append(channels, ch)
+	}
+	requests := l.requests
+	l.requests = nil
+	l.mu.Unlock()
+	for _, ch := range channels {
+		if err = c.Listen(l.ctx, ch); err != nil {
+			c.Close()
+			l.mu.Lock()
+			l.requests = append(requests, l.requests...)
+			l.mu.Unlock()
+			return nil, err
+		}
+	}
+	// the channels of the requests are listened to
+	for _, r := range requests {
+		r.done <- nil
+	}
+	return c, nil
+}
+
+// serve delivers the notifications of the connection and
+// runs the requests of the listener until disconnected
+func (l *Listener) serve(c *Conn) error {
+	for {
+		l.mu.Lock()
+		requests := l.requests
+		l.requests = nil
+		l.mu.Unlock()
+		for _, r := range requests {
+			_, err := c.Exec(l.ctx, r.sql)
+			if _, ok := err.(*Error); ok || err == nil {
+				r.done <- err
+				continue
+			}
+			// retried once reconnected
+			l.mu.Lock()
+			l.requests = append([]*listenRequest{r}, l.requests...)
+			l.mu.Unlock()
+			return err
+		}
+		n, err := c.wait(l.ctx, l.wake)
+		if err != nil {
+			return err
+		}
+		if n == nil {
+			continue
+		}
+		select {
+		case l.notifications <- n:
+		case <-l.ctx.Done():
+			return l.ctx.Err()
+		}
+	}
+}
+
+// fail reports an error of the connection of the listener
+func (l *Listener) fail(err error) {
+	if l.OnError != nil && err != nil && l.ctx.Err() == nil {
+		l.OnError(err)
+	}
+}
@@ -0,0 +1,1490 @@
+// Copyright 2023 james dotter.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://github.com/jcdotter/go/LICENSE
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pg
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jcdotter/go/buffer"
+	"github.com/jcdotter/go/cli"
+	"github.com/jcdotter/go/errors"
+	"github.com/jcdotter/go/logger"
+	"github.com/jcdotter/go/path"
+	"github.com/jcdotter/go/test"
+	"github.com/jcdotter/go/uuid"
+)
+
+// ----------------------------------------------------------------------------
+// FAKE SERVER
+// a postgres server speaking the v3 protocol, which
+// authenticates with the auth method provided and
+// answers queries with the results of its handler
+
+type fakeResult struct {
+	fields []Field
+	rows   [][]any
+	tag    string
+	err    *Error
+	// copy is the direction of a copy, in or out,
+	// where data is sent by the server on copy out
+	copy string
+	data []string
+	// reject is the data on which a copy in fails
+	reject string
+}
+
+type fakeServer struct {
+	ln       net.Listener
+	auth     string
+	password string
+	query    func(sql string, args []any) *fakeResult
+	mu       sync.Mutex
+	startup  map[string]string
+	conns    int
+	live     map[net.Conn]bool
+	copied   []byte
+}
+
+func newFakeServer(t *testing.T, auth string, query func(sql string, args []any) *fakeResult) *fakeServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{ln: ln, auth: auth, password: "secret", query: query, live: map[net.Conn]bool{}}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns++
+			s.mu.Unlock()
+			go s.serve(c)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return s
+}
+
+func (s *fakeServer) url(user, password string) string {
+	return "postgres://" + user + ":" + password + "@" + s.ln.Addr().String() + "/db?sslmode=prefer&application_name=test"
+}
+
+// notify sends a notification to the live connections of the server
+func (s *fakeServer) notify(channel, payload string) {
+	var w writer
+	w.start('A')
+	w.int32(99)
+	w.string(channel)
+	w.string(payload)
+	w.finish()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.live {
+		c.Write(w.b)
+	}
+}
+
+// drop closes the live connections of the server
+func (s *fakeServer) drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.live {
+		c.Close()
+		delete(s.live, c)
+	}
+}
+
+func (s *fakeServer) serve(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.live, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+	br := bufio.NewReader(conn)
+	var w writer
+	send := func() {
+		conn.Write(w.b)
+		w.b = w.b[:0]
+	}
+	fail := func(code, msg string) {
+		w.start('E')
+		for _, f := range [][2]string{{"S", "ERROR"}, {"V", "ERROR"}, {"C", code}, {"M", msg}} {
+			w.byte(f[0][0])
+			w.string(f[1])
+		}
+		w.byte(0)
+		w.finish()
+	}
+	ready := func() {
+		w.start('Z')
+		w.byte('I')
+		w.finish()
+	}
+	// startup
+	var m *message
+	for {
+		b := make([]byte, 4)
+		if _, err := io.ReadFull(br, b); err != nil {
+			return
+		}
+		b = make([]byte, binary.BigEndian.Uint32(b)-4)
+		io.ReadFull(br, b)
+		m = &message{b: b}
+		if m.int32() != 80877103 {
+			break
+		}
+		conn.Write([]byte{'N'})
+	}
+	params := map[string]string{}
+	for k := m.string(); k != ""; k = m.string() {
+		params[k] = m.string()
+	}
+	s.mu.Lock()
+	s.startup = params
+	s.mu.Unlock()
+	if !s.authenticate(br, &w, send, params["user"]) {
+		fail("28P01", "password authentication failed for user \""+params["user"]+"\"")
+		send()
+		return
+	}
+	w.start('R')
+	w.int32(authOk)
+	w.finish()
+	w.start('S')
+	w.string("server_version")
+	w.string("16.0")
+	w.finish()
+	w.start('K')
+	w.int32(42)
+	w.int32(7)
+	w.finish()
+	ready()
+	s.mu.Lock()
+	send()
+	s.live[conn] = true
+	s.mu.Unlock()
+	// queries
+	var sql string
+	var args []any
+	var formats []int
+	var res *fakeResult
+	skip := false
+	for {
+		t, b := fakeRead(br)
+		if t == 0 || t == 'X' {
+			return
+		}
+		m := &message{b: b}
+		if skip && t != 'S' {
+			continue
+		}
+		switch t {
+		case 'Q':
+			for _, q := range strings.Split(m.string(), ";") {
+				if res = s.query(strings.TrimSpace(q), nil); res.err != nil {
+					fail(res.err.Code, res.err.Message)
+					break
+				}
+				if res.copy != "" {
+					s.copy(br, &w, send, res)
+					break
+				}
+				s.rows(&w, res, nil)
+			}
+			ready()
+			s.mu.Lock()
+			send()
+			s.mu.Unlock()
+		case 'P':
+			m.string()
+			sql = m.string()
+			if res = s.query(sql, nil); res.err != nil {
+				fail(res.err.Code, res.err.Message)
+				skip = true
+				continue
+			}
+			w.start('1')
+			w.finish()
+		case 'D':
+			w.start('t')
+			w.int16(strings.Count(sql, "$"))
+			for i := 0; i < strings.Count(sql, "$"); i++ {
+				w.int32(0)
+			}
+			w.finish()
+			if len(res.fields) == 0 {
+				w.start('n')
+				w.finish()
+				continue
+			}
+			w.start('T')
+			w.int16(len(res.fields))
+			for _, f := range res.fields {
+				w.string(f.Name)
+				w.int32(0)
+				w.int16(0)
+				w.int32(int(f.Oid))
+				w.int16(-1)
+				w.int32(-1)
+				w.int16(0)
+			}
+			w.finish()
+		case 'B':
+			m.string()
+			m.string()
+			pf := make([]int, m.int16())
+			for i := range pf {
+				pf[i] = m.int16()
+			}
+			args = make([]any, m.int16())
+			for i := range args {
+				if v := m.next(m.int32()); v != nil {
+					args[i] = string(v)
+				}
+			}
+			formats = make([]int, m.int16())
+			for i := range formats {
+				formats[i] = m.int16()
+			}
+			w.start('2')
+			w.finish()
+		case 'E':
+			if res = s.query(sql, args); res.err != nil {
+				fail(res.err.Code, res.err.Message)
+				skip = true
+				continue
+			}
+			s.rows(&w, res, formats)
+		case 'S':
+			skip = false
+			ready()
+			s.mu.Lock()
+			send()
+			s.mu.Unlock()
+		}
+	}
+}
+
+// authenticate authenticates the user with the auth method of the server
+func (s *fakeServer) authenticate(br *bufio.Reader, w *writer, send func(), user string) bool {
+	password := func() string {
+		t, b := fakeRead(br)
+		if t != 'p' {
+			return ""
+		}
+		return (&message{b: b}).string()
+	}
+	switch s.auth {
+	case "cleartext":
+		w.start('R')
+		w.int32(authCleartext)
+		w.finish()
+		send()
+		return password() == s.password
+	case "md5":
+		w.start('R')
+		w.int32(authMD5)
+		w.bytes([]byte("salt"))
+		w.finish()
+		send()
+		return password() == "md5"+md5Hex(md5Hex(s.password+user)+"salt")
+	case "scram":
+		w.start('R')
+		w.int32(authSASL)
+		w.string("SCRAM-SHA-256-PLUS")
+		w.string("SCRAM-SHA-256")
+		w.byte(0)
+		w.finish()
+		send()
+		_, b := fakeRead(br)
+		m := &message{b: b}
+		if m.string() != "SCRAM-SHA-256" {
+			return false
+		}
+		first := string(m.next(m.int32()))
+		bare := strings.TrimPrefix(first, "n,,")
+		nonce := bare[strings.Index(bare, "r=")+2:] + "server"
+		salt := []byte("saltsalt")
+		serverFirst := "r=" + nonce + ",s=" + base64.StdEncoding.EncodeToString(salt) + ",i=16"
+		w.start('R')
+		w.int32(authSASLContinue)
+		w.bytes([]byte(serverFirst))
+		w.finish()
+		send()
+		_, b = fakeRead(br)
+		final := string(b)
+		i := strings.Index(final, ",p=")
+		auth := bare + "," + serverFirst + "," + final[:i]
+		salted := pbkdf2(sha256Hmac, []byte(s.password), salt, 16, sha256.Size)
+		clientKey := sha256Hmac(salted, []byte("Client Key"))
+		stored := sha256.Sum256(clientKey)
+		proof := sha256Hmac(stored[:], []byte(auth))
+		for i := range proof {
+			proof[i] ^= clientKey[i]
+		}
+		if final[i+3:] != base64.StdEncoding.EncodeToString(proof) {
+			return false
+		}
+		w.start('R')
+		w.int32(authSASLFinal)
+		w.bytes([]byte("v=" + base64.StdEncoding.EncodeToString(sha256Hmac(sha256Hmac(salted, []byte("Server Key")), []byte(auth)))))
+		w.finish()
+		return true
+	}
+	return true
+}
+
+// copy sends the data of a copy out, or receives the data of a copy in
+func (s *fakeServer) copy(br *bufio.Reader, w *writer, send func(), res *fakeResult) {
+	if res.copy == "out" {
+		w.start('H')
+		w.byte(0)
+		w.int16(0)
+		w.finish()
+		for _, d := range res.data {
+			w.start('d')
+			w.bytes([]byte(d))
+			w.finish()
+		}
+		w.start('c')
+		w.finish()
+		w.start('C')
+		w.string("COPY " + strconv.Itoa(len(res.data)))
+		w.finish()
+		return
+	}
+	w.start('G')
+	w.byte(0)
+	w.int16(0)
+	w.finish()
+	s.mu.Lock()
+	send()
+	s.copied = nil
+	s.mu.Unlock()
+	for {
+		t, b := fakeRead(br)
+		switch t {
+		case 'd':
+			s.mu.Lock()
+			s.copied = append(s.copied, b...)
+			s.mu.Unlock()
+			if res.reject != "" && strings.Contains(string(b), res.reject) {
+				w.start('E')
+				for _, f := range [][2]string{{"S", "ERROR"}, {"C", "22P02"}, {"M", "invalid input syntax"}} {
+					w.byte(f[0][0])
+					w.string(f[1])
+				}
+				w.byte(0)
+				w.finish()
+				return
+			}
+		case 'c':
+			s.mu.Lock()
+			n := strings.Count(string(s.copied), "\n")
+			s.mu.Unlock()
+			w.start('C')
+			w.string("COPY " + strconv.Itoa(n))
+			w.finish()
+			return
+		case 'f', 0:
+			m := &message{b: b}
+			w.start('E')
+			for _, f := range [][2]string{{"S", "ERROR"}, {"C", "57014"}, {"M", "COPY from stdin failed: " + m.string()}} {
+				w.byte(f[0][0])
+				w.string(f[1])
+			}
+			w.byte(0)
+			w.finish()
+			return
+		}
+	}
+}
+
+// rows writes the rows of the result in the formats provided
+func (s *fakeServer) rows(w *writer, res *fakeResult, formats []int) {
+	if formats == nil && len(res.fields) > 0 {
+		w.start('T')
+		w.int16(len(res.fields))
+		for _, f := range res.fields {
+			w.string(f.Name)
+			w.int32(0)
+			w.int16(0)
+			w.int32(int(f.Oid))
+			w.int16(-1)
+			w.int32(-1)
+			w.int16(0)
+		}
+		w.finish()
+	}
+	for _, r := range res.rows {
+		w.start('D')
+		w.int16(len(r))
+		for i, v := range r {
+			format := 0
+			if i < len(formats) {
+				format = formats[i]
+			} else if len(formats) == 1 {
+				format = formats[0]
+			}
+			b := fakeEncode(res.fields[i].Oid, format, v)
+			if b == nil {
+				w.int32(-1)
+				continue
+			}
+			w.int32(len(b))
+			w.bytes(b)
+		}
+		w.finish()
+	}
+	w.start('C')
+	w.string(res.tag)
+	w.finish()
+}
+
+func fakeRead(br *bufio.Reader) (byte, []byte) {
+	h := make([]byte, 5)
+	if _, err := io.ReadFull(br, h); err != nil {
+		return 0, nil
+	}
+	b := make([]byte, binary.BigEndian.Uint32(h[1:])-4)
+	io.ReadFull(br, b)
+	return h[0], b
+}
+
+// fakeEncode encodes a value of the oid provided as the server does
+func fakeEncode(oid uint32, format int, v any) []byte {
+	if v == nil {
+		return nil
+	}
+	if format == 0 {
+		switch v := v.(type) {
+		case bool:
+			if v {
+				return []byte("t")
+			}
+			return []byte("f")
+		case int:
+			return []byte(strconv.Itoa(v))
+		case float64:
+			return []byte(strconv.FormatFloat(v, 'g', -1, 64))
+		case []byte:
+			return []byte(`\x` + hex.EncodeToString(v))
+		case time.Time:
+			switch oid {
+			case DateOid:
+				return []byte(v.Format("2006-01-02"))
+			case TimestampOid:
+				return []byte(v.Format("2006-01-02 15:04:05.999999"))
+			}
+			return []byte(v.Format("2006-01-02 15:04:05.999999-07"))
+		case uuid.UUID:
+			return []byte(v.String())
+		}
+		return []byte(v.(string))
+	}
+	var b []byte
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return []byte{1}
+		}
+		return []byte{0}
+	case int:
+		switch oid {
+		case Int2Oid:
+			return binary.BigEndian.AppendUint16(b, uint16(v))
+		case Int4Oid, OidOid:
+			return binary.BigEndian.AppendUint32(b, uint32(v))
+		}
+		return binary.BigEndian.AppendUint64(b, uint64(v))
+	case float64:
+		if oid == Float4Oid {
+			return binary.BigEndian.AppendUint32(b, math.Float32bits(float32(v)))
+		}
+		return binary.BigEndian.AppendUint64(b, math.Float64bits(v))
+	case time.Time:
+		if oid == DateOid {
+			return binary.BigEndian.AppendUint32(b, uint32(v.Sub(epoch)/(24*time.Hour)))
+		}
+		return binary.BigEndian.AppendUint64(b, uint64(v.Sub(epoch)/time.Microsecond))
+	case uuid.UUID:
+		return v.Bytes()
+	case []byte:
+		return v
+	}
+	return []byte(v.(string))
+}
+
+// ----------------------------------------------------------------------------
+// TESTS
+
+func TestConfig(t *testing.T) {
+	gt := test.New(t)
+	gt.Msg = "Config.%s"
+	os.Setenv("PGPASSWORD", "envpass")
+	defer os.Unsetenv("PGPASSWORD")
+	c, err := ParseConfig("postgres://bob@db.local:6543/app?sslmode=disable&connect_timeout=3&search_path=api")
+	gt.NoError(err, "Parse")
+	gt.Equal("db.local:6543", c.Addr(), "Addr")
+	gt.Equal("bob", c.User, "User")
+	gt.Equal("envpass", c.Password, "Password")
+	gt.Equal("app", c.Database, "Database")
+	gt.Equal("disable", c.SslMode, "SslMode")
+	gt.Equal(3*time.Second, c.ConnectTimeout, "ConnectTimeout")
+	gt.Equal("api", c.Params["search_path"], "Params")
+	c, err = ParseConfig("postgresql://alice:pw@localhost")
+	gt.NoError(err, "Parse defaults")
+	gt.Equal(5432, c.Port, "Port default")
+	gt.Equal("alice", c.Database, "Database default")
+	gt.Equal("prefer", c.SslMode, "SslMode default")
+	_, err = ParseConfig("mysql://localhost")
+	gt.Error(err, "Parse scheme")
+	_, err = ParseConfig("postgres://localhost?sslmode=always")
+	gt.Error(err, "Parse sslmode")
+}
+
+func TestAuth(t *testing.T) {
+	gt := test.New(t)
+	gt.Msg = "Auth.%s"
+	ctx := context.Background()
+	for _, auth := range []string{"trust", "cleartext", "md5", "scram"} {
+		s := newFakeServer(t, auth, nil)
+		c, err := Connect(ctx, s.url("bob", "secret"))
+		if !gt.NoError(err, auth) {
+			continue
+		}
+		gt.Equal("16.0", c.Param("server_version"), auth+" param")
+		gt.Equal(uint32(42), c.Pid(), auth+" pid")
+		gt.Equal("test", s.startup["application_name"], auth+" startup params")
+		gt.Equal("db", s.startup["database"], auth+" database")
+		gt.NoError(c.Close(), auth+" close")
+		if auth == "trust" {
+			continue
+		}
+		_, err = Connect(ctx, s.url("bob", "wrong"))
+		e, ok := err.(*Error)
+		gt.True(ok, auth+" server error")
+		if ok {
+			gt.Equal("28P01", e.SqlState(), auth+" sqlstate")
+			gt.Equal(errors.UNAUTHENTICATED, errors.Postgres(e, "login failed").(*errors.Status).Code(), auth+" status")
+		}
+	}
+	// RFC 7914 PBKDF2-HMAC-SHA256 test vector
+	gt.Equal("55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783",
+		hex.EncodeToString(pbkdf2(sha256Hmac, []byte("passwd"), []byte("salt"), 1, 64)), "pbkdf2")
+	_, err := Connect(ctx, "postgres://bob@127.0.0.1:1/db?sslmode=disable")
+	gt.Error(err, "unavailable")
+}
+
+func TestQuery(t *testing.T) {
+	gt := test.New(t)
+	gt.Msg = "Query.%s"
+	ctx := context.Background()
+	id := uuid.New()
+	ts := time.Date(2023, 6, 1, 12, 30, 45, 123456000, time.UTC)
+	day := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	var mu sync.Mutex
+	var got []any
+	args := func() []any {
+		mu.Lock()
+		defer mu.Unlock()
+		return got
+	}
+	release := make(chan struct{})
+	s := newFakeServer(t, "md5", func(sql string, args []any) *fakeResult {
+		switch {
+		case sql == "" || strings.HasPrefix(sql, "SET"):
+			return &fakeResult{tag: "SET"}
+		case strings.HasPrefix(sql, "UPDATE"):
+			mu.Lock()
+			got = args
+			mu.Unlock()
+			return &fakeResult{tag: "UPDATE 3"}
+		case strings.HasPrefix(sql, "INSERT"):
+			return &fakeResult{err: &Error{Code: "23505", Message: "duplicate key value violates unique constraint"}}
+		case strings.HasPrefix(sql, "SELECT none"):
+			return &fakeResult{fields: []Field{{Name: "id", Oid: Int4Oid}}, tag: "SELECT 0"}
+		case strings.HasPrefix(sql, "SELECT pg_sleep"):
+			if args != nil {
+				<-release
+			}
+			return &fakeResult{tag: "SELECT 0"}
+		case strings.HasPrefix(sql, "SELECT"):
+			if args != nil {
+				mu.Lock()
+				got = args
+				mu.Unlock()
+			}
+			return &fakeResult{
+				fields: []Field{
+					{Name: "id", Oid: Int4Oid}, {Name: "big", Oid: Int8Oid}, {Name: "small", Oid: Int2Oid},
+					{Name: "score", Oid: Float8Oid}, {Name: "ok", Oid: BoolOid}, {Name: "name", Oid: TextOid},
+					{Name: "uid", Oid: UuidOid}, {Name: "at", Oid: TimestamptzOid}, {Name: "day", Oid: DateOid},
+					{Name: "data", Oid: ByteaOid}, {Name: "price", Oid: NumericOid}, {Name: "note", Oid: TextOid},
+				},
+				rows: [][]any{
+					{1, 1 << 40, -2, 1.5, true, "ann", id, ts, day, []byte{0, 1}, "9.99", nil},
+					{2, 0, 0, 0.0, false, "bo", id, ts, day, []byte{}, "0", "hi"},
+				},
+				tag: "SELECT 2",
+			}
+		}
+		return &fakeResult{err: &Error{Code: "42601", Message: "syntax error"}}
+	})
+	c, err := Connect(ctx, s.url("bob", "secret"))
+	if !gt.NoError(err, "Connect") {
+		return
+	}
+	defer c.Close()
+
+	tag, err := c.Exec(ctx, "SET a = 1; SET b = 2")
+	gt.NoError(err, "Exec simple")
+	gt.Equal(CommandTag("SET"), tag, "Exec simple tag")
+	tag, err = c.Exec(ctx, "UPDATE users SET name = $1, data = $2, at = $3 WHERE id = $4", "ann", []byte("x"), ts, nil)
+	gt.NoError(err, "Exec extended")
+	gt.Equal(int64(3), tag.RowsAffected(), "RowsAffected")
+	gt.Equal([]any{"ann", "x", "2023-06-01 12:30:45.123456Z", nil}, args(), "Exec args")
+	_, err = c.Exec(ctx, "UPDATE users SET name = $1", "ann", "bo")
+	gt.Error(err, "Exec args count")
+
+	rows, err := c.Query(ctx, "SELECT * FROM users WHERE id > $1", 0)
+	if !gt.NoError(err, "Query") {
+		return
+	}
+	gt.Equal("id", rows.Columns()[0], "Columns")
+	gt.Equal(1, rows.Fields()[0].Format, "binary format")
+	gt.Equal(0, rows.Fields()[10].Format, "text format")
+	type user struct {
+		id    int
+		big   int64
+		small int16
+		score float64
+		ok    bool
+		name  string
+		uid   uuid.UUID
+		at    time.Time
+		day   time.Time
+		data  []byte
+		price string
+		note  *string
+	}
+	var users []user
+	for rows.Next() {
+		var u user
+		gt.NoError(rows.Scan(&u.id, &u.big, &u.small, &u.score, &u.ok, &u.name, &u.uid, &u.at, &u.day, &u.data, &u.price, &u.note), "Scan")
+		users = append(users, u)
+	}
+	gt.NoError(rows.Err(), "Rows.Err")
+	gt.Equal([]any{"0"}, args(), "Query args")
+	gt.Equal(CommandTag("SELECT 2"), rows.Tag(), "Tag")
+	gt.Equal(2, len(users), "Next")
+	if len(users) == 2 {
+		u := users[0]
+		gt.Equal(1, u.id, "int4")
+		gt.Equal(int64(1<<40), u.big, "int8")
+		gt.Equal(int16(-2), u.small, "int2")
+		gt.Equal(1.5, u.score, "float8")
+		gt.True(u.ok, "bool")
+		gt.Equal("ann", u.name, "text")
+		gt.Equal(id, u.uid, "uuid")
+		gt.True(ts.Equal(u.at), "timestamptz")
+		gt.True(day.Equal(u.day), "date")
+		gt.Equal([]byte{0, 1}, u.data, "bytea")
+		gt.Equal("9.99", u.price, "numeric")
+		gt.True(u.note == nil, "null")
+		gt.Equal("hi", *users[1].note, "pointer")
+	}
+
+	var name string
+	gt.NoError(c.QueryRow(ctx, "SELECT name FROM users WHERE id = $1", 1).Scan(new(int), new(int64), new(int16), new(float64), new(bool), &name, new(uuid.UUID), new(time.Time), new(time.Time), new([]byte), new(string), new(string)), "QueryRow")
+	gt.Equal("ann", name, "QueryRow value")
+	err = c.QueryRow(ctx, "SELECT none").Scan(new(int))
+	st, ok := err.(*errors.Status)
+	gt.True(ok && st.Code() == errors.NOTFOUND, "QueryRow no rows")
+
+	_, err = c.Exec(ctx, "INSERT INTO users VALUES ($1)", 1)
+	e, ok := err.(*Error)
+	gt.True(ok, "server error")
+	if ok {
+		gt.Equal("23505", e.SqlState(), "sqlstate")
+		gt.Equal(errors.EXISTS, errors.Postgres(e, "user exists").(*errors.Status).Code(), "errors.Postgres")
+	}
+	_, err = c.Exec(ctx, "bad")
+	_, ok = err.(*Error)
+	gt.True(ok, "simple server error")
+	_, err = c.Query(ctx, "bad", 1)
+	_, ok = err.(*Error)
+	gt.True(ok, "extended server error")
+	gt.NoError(c.Ping(ctx), "usable after errors")
+
+	rows, err = c.Query(ctx, "SELECT * FROM users")
+	gt.NoError(err, "Query without args")
+	_, err = c.Exec(ctx, "SET a = 1")
+	gt.Error(err, "busy")
+	gt.NoError(rows.Close(), "Close")
+	gt.NoError(c.Ping(ctx), "usable after close")
+
+	cctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = c.Exec(cctx, "SELECT pg_sleep($1)", 1)
+	close(release)
+	st, ok = err.(*errors.Status)
+	gt.True(ok && st.Code() == errors.DEADLINE, "deadline")
+	gt.True(c.Broken(), "broken after deadline")
+	gt.Error(c.Ping(ctx), "closed after deadline")
+}
+
+func TestDecode(t *testing.T) {
+	gt := test.New(t)
+	gt.Msg = "Decode.%s"
+	id := uuid.New()
+	for _, c := range []struct {
+		oid  uint32
+		text string
+		want any
+	}{
+		{BoolOid, "t", true},
+		{Int4Oid, "-12", int64(-12)},
+		{Float8Oid, "Infinity", math.Inf(1)},
+		{ByteaOid, `\x0aff`, []byte{10, 255}},
+		{UuidOid, id.String(), id},
+		{DateOid, "2023-06-01", time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{TimestampOid, "2023-06-01 12:30:45.5", time.Date(2023, 6, 1, 12, 30, 45, 5e8, time.UTC)},
+		{JsonbOid, `{"a": 1}`, `{"a": 1}`},
+	} {
+		v, err := decode(c.oid, 0, []byte(c.text))
+		gt.NoError(err, c.text)
+		gt.Equal(c.want, v, c.text)
+	}
+	v, err := decode(TimestamptzOid, 0, []byte("2023-06-01 12:30:45+05:30"))
+	gt.NoError(err, "timestamptz")
+	gt.Equal(int64(1685602845), v.(time.Time).Unix(), "timestamptz offset")
+	_, err = decode(Int4Oid, 1, []byte{1})
+	gt.Error(err, "invalid binary")
+	_, err = decode(UuidOid, 0, []byte("nope"))
+	gt.Error(err, "invalid text")
+	v, err = decode(TextOid, 0, nil)
+	gt.NoError(err, "null")
+	gt.Nil(v, "null")
+}
+
+func TestPool(t *testing.T) {
+	gt := test.New(t)
+	gt.Msg = "Pool.%s"
+	ctx := context.Background()
+	s := newFakeServer(t, "md5", func(sql string, args []any) *fakeResult {
+		if strings.HasPrefix(sql, "SELECT") {
+			return &fakeResult{fields: []Field{{Name: "n", Oid: Int4Oid}}, rows: [][]any{{1}}, tag: "SELECT 1"}
+		}
+		return &fakeResult{tag: "SET"}
+	})
+	opened := func() int {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.conns
+	}
+
+	config, err := ParsePoolConfig(s.url("bob", "secret") + "&pool_min_conns=2&pool_max_conns=3&pool_idle_timeout=1m&pool_health_check_idle=1h")
+	gt.NoError(err, "ParsePoolConfig")
+	gt.Equal(2, config.MinConns, "MinConns")
+	gt.Equal(3, config.MaxConns, "MaxConns")
+	gt.Equal(time.Minute, config.IdleTimeout, "IdleTimeout")
+	_, ok := config.Params["pool_max_conns"]
+	gt.False(ok, "pool params not sent to server")
+	_, err = ParsePoolConfig(s.url("bob", "secret") + "&pool_max_lifetime=soon")
+	gt.Error(err, "invalid pool param")
+
+	p, err := NewPool(ctx, config)
+	if !gt.NoError(err, "NewPool") {
+		return
+	}
+	defer p.Close()
+	gt.Equal(2, opened(), "min conns")
+	gt.Equal(2, p.Stats().Idle, "idle")
+
+	// acquire until exhausted
+	var conns []*PoolConn
+	for i := 0; i < 3; i++ {
+		c, err := p.Acquire(ctx)
+		gt.NoError(err, "Acquire")
+		conns = append(conns, c)
+	}
+	st := p.Stats()
+	gt.Equal(3, st.InUse, "in use")
+	gt.Equal(3, st.Total, "total")
+	wctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	_, err = p.Acquire(wctx)
+	cancel()
+	e, ok := err.(*errors.Status)
+	gt.True(ok && e.Code() == errors.EXHAUSTED, "exhausted")
+	gt.Equal(int64(1), p.Stats().Exhausted, "exhausted stat")
+
+	// waiters are handed released conns
+	got := make(chan *PoolConn)
+	go func() {
+		c, _ := p.Acquire(ctx)
+		got <- c
+	}()
+	for p.Stats().Waiting == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	conns[0].Release()
+	c := <-got
+	gt.True(c != nil && c.Conn == conns[0].Conn, "waiter")
+	st = p.Stats()
+	gt.Equal(int64(1), st.Waits, "waits")
+	gt.True(st.WaitDuration > 0, "wait duration")
+	conns[0] = c
+
+	// broken conns are closed and their slot handed to waiters
+	go func() {
+		c, _ := p.Acquire(ctx)
+		got <- c
+	}()
+	for p.Stats().Waiting == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	conns[1].Conn.broken = true
+	conns[1].Release()
+	c = <-got
+	gt.True(c != nil && c.Conn != conns[1].Conn, "slot of broken conn")
+	gt.Equal(4, opened(), "reopened")
+	conns[1] = c
+	for _, c := range conns {
+		c.Release()
+	}
+	st = p.Stats()
+	gt.Equal(0, st.InUse, "released")
+	gt.Equal(3, st.Idle, "released idle")
+
+	// conns released twice are pooled once
+	c, _ = p.Acquire(ctx)
+	c.notifications = []*Notification{{Channel: "jobs"}}
+	c.Release()
+	gt.True(c.notifications == nil, "release drops notifications")
+	c.Release()
+	st = p.Stats()
+	gt.Equal(0, st.InUse, "double release in use")
+	gt.Equal(3, st.Idle, "double release idle")
+	c1, _ := p.Acquire(ctx)
+	c2, _ := p.Acquire(ctx)
+	gt.True(c1.Conn != c2.Conn, "double release distinct conns")
+	c.Release()
+	gt.Equal(2, p.Stats().InUse, "stale release ignored")
+	c1.Release()
+	c2.Release()
+
+	// health check on borrow
+	p.config.HealthCheckIdle = 0
+	checks := 0
+	p.config.HealthCheck = func(ctx context.Context, c *Conn) error {
+		checks++
+		if checks == 1 {
+			return errors.Unavailable("unhealthy")
+		}
+		return c.Ping(ctx)
+	}
+	c, err = p.Acquire(ctx)
+	gt.NoError(err, "Acquire after failed check")
+	gt.Equal(2, checks, "health checks")
+	gt.Equal(2, p.Stats().Total, "unhealthy conn closed")
+	c.Release()
+
+	// health check of conns handed to waiters
+	for i := range conns {
+		conns[i], err = p.Acquire(ctx)
+		gt.NoError(err, "Acquire for waiter check")
+	}
+	p.config.HealthCheck = func(ctx context.Context, c *Conn) error {
+		return errors.Unavailable("unhealthy")
+	}
+	go func() {
+		c, _ := p.Acquire(ctx)
+		got <- c
+	}()
+	for p.Stats().Waiting == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	conns[0].Release()
+	c = <-got
+	gt.True(c != nil && c.Conn != conns[0].Conn, "waiter unhealthy conn reopened")
+	gt.Equal(3, p.Stats().Total, "waiter unhealthy conn closed")
+	conns[0] = c
+	for _, c := range conns {
+		c.Release()
+	}
+	p.config.HealthCheckIdle = time.Hour
+
+	// pool queries release their conns
+	var n int
+	gt.NoError(p.QueryRow(ctx, "SELECT $1", 1).Scan(&n), "QueryRow")
+	gt.Equal(1, n, "QueryRow value")
+	_, err = p.Exec(ctx, "SET a = 1")
+	gt.NoError(err, "Exec")
+	gt.Equal(0, p.Stats().InUse, "queries released")
+
+	// idle and lifetime limits
+	p.config.IdleTimeout = time.Nanosecond
+	p.prune()
+	gt.Equal(2, p.Stats().Total, "idle timeout keeps min conns")
+	p.config.MaxLifetime = time.Nanosecond
+	c, err = p.Acquire(ctx)
+	gt.NoError(err, "Acquire expired")
+	c.Release()
+	gt.Equal(0, p.Stats().Idle, "max lifetime")
+	p.config.IdleTimeout, p.config.MaxLifetime = 0, 0
+	gt.NoError(p.fill(ctx), "fill")
+	gt.Equal(2, p.Stats().Idle, "refilled")
+
+	// stats are logged
+	b := buffer.New()
+	p.Log(logger.New().Writers(b).Build())
+	gt.True(strings.Contains(b.String(), `"in_use"`), "Log")
+
+	p.Close()
+	_, err = p.Acquire(ctx)
+	gt.Error(err, "closed")
+	gt.Equal(0, p.Stats().Total, "closed conns")
+}
+
+func TestScan(t *testing.T) {
+	gt := test.New(t)
+	gt.Msg = "Scan.%s"
+	ctx := context.Background()
+	ids := []uuid.UUID{uuid.New(), uuid.New()}
+	ts := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	s := newFakeServer(t, "trust", func(sql string, args []any) *fakeResult {
+		switch {
+		case strings.HasPrefix(sql, "SELECT id FROM"):
+			return &fakeResult{fields: []Field{{Name: "n", Oid: Int8Oid}}, rows: [][]any{{1}, {2}}, tag: "SELECT 2"}
+		case strings.HasPrefix(sql, "SELECT nothing"):
+			return &fakeResult{fields: []Field{{Name: "id", Oid: UuidOid}}, tag: "SELECT 0"}
+		case strings.HasPrefix(sql, "SELECT extra"):
+			return &fakeResult{fields: []Field{{Name: "extra", Oid: TextOid}}, rows: [][]any{{"x"}}, tag: "SELECT 1"}
+		}
+		return &fakeResult{
+			fields: []Field{
+				{Name: "id", Oid: UuidOid}, {Name: "name", Oid: TextOid}, {Name: "email", Oid: TextOid},
+				{Name: "created_at", Oid: TimestamptzOid}, {Name: "updated_by", Oid: TextOid}, {Name: "version", Oid: Int4Oid},
+			},
+			rows: [][]any{
+				{ids[0], "ann", "ann@mail.com", ts, "admin", 2},
+				{ids[1], "bo", nil, ts, "bo", 1},
+			},
+			tag: "SELECT 2",
+		}
+	})
+	c, err := Connect(ctx, s.url("bob", "secret"))
+	if !gt.NoError(err, "Connect") {
+		return
+	}
+	defer c.Close()
+	type Audit struct {
+		UpdatedBy string `db:"updated_by"`
+	}
+	type Meta struct {
+		Version int
+	}
+	type User struct {
+		Id      uuid.UUID `db:"id"`
+		Name    string
+		Email   *string
+		Created time.Time `db:"created_at"`
+		Ignored string    `db:"-"`
+		Audit
+		*Meta
+	}
+
+	u, err := QueryStruct[User](ctx, c, "SELECT * FROM users WHERE id = $1", ids[0])
+	gt.NoError(err, "QueryStruct")
+	gt.Equal(ids[0], u.Id, "uuid")
+	gt.Equal("ann", u.Name, "untagged")
+	gt.True(u.Email != nil && *u.Email == "ann@mail.com", "pointer")
+	gt.True(ts.Equal(u.Created), "time")
+	gt.Equal("admin", u.UpdatedBy, "embedded")
+	gt.True(u.Meta != nil && u.Version == 2, "embedded pointer")
+
+	users, err := QuerySlice[*User](ctx, c, "SELECT * FROM users")
+	gt.NoError(err, "QuerySlice")
+	gt.Equal(2, len(users), "QuerySlice rows")
+	if len(users) == 2 {
+		gt.Equal(ids[1], users[1].Id, "QuerySlice uuid")
+		gt.True(users[1].Email == nil, "null")
+	}
+	m, err := QueryMap(ctx, c, "SELECT * FROM users")
+	gt.NoError(err, "QueryMap")
+	gt.Equal("ann", m["name"], "QueryMap value")
+	gt.Equal(int64(2), m["version"], "QueryMap int")
+	ns, err := QuerySlice[int](ctx, c, "SELECT id FROM users")
+	gt.NoError(err, "QuerySlice scalar")
+	gt.Equal([]int{1, 2}, ns, "QuerySlice scalar values")
+
+	_, err = QueryStruct[User](ctx, c, "SELECT nothing")
+	st, ok := err.(*errors.Status)
+	gt.True(ok && st.Code() == errors.NOTFOUND, "no rows")
+	_, err = QueryStruct[User](ctx, c, "SELECT extra")
+	st, ok = err.(*errors.Status)
+	gt.True(ok && st.Code() == errors.INVALID, "unmapped column")
+	gt.NoError(c.Ping(ctx), "usable after scan errors")
+}
+
+func TestMigrate(t *testing.T) {
+	gt := test.New(t)
+	gt.Msg = "Migrate.%s"
+	ctx := context.Background()
+	type record struct {
+		name string
+		at   time.Time
+	}
+	var mu sync.Mutex
+	state := map[string]record{}
+	var tx map[string]*record
+	var sqls []string
+	locks := 0
+	s := newFakeServer(t, "trust", func(sql string, args []any) *fakeResult {
+		mu.Lock()
+		defer mu.Unlock()
+		if args != nil || !strings.Contains(sql, "$") {
+			sqls = append(sqls, sql)
+		}
+		switch {
+		case strings.HasPrefix(sql, "SELECT pg_advisory_lock"):
+			if args != nil {
+				locks++
+			}
+			return &fakeResult{fields: []Field{{Name: "pg_advisory_lock", Oid: TextOid}}, rows: [][]any{{""}}, tag: "SELECT 1"}
+		case strings.HasPrefix(sql, "SELECT pg_advisory_unlock"):
+			if args != nil {
+				locks--
+			}
+			return &fakeResult{fields: []Field{{Name: "pg_advisory_unlock", Oid: BoolOid}}, rows: [][]any{{true}}, tag: "SELECT 1"}
+		case strings.HasPrefix(sql, "SELECT version, name, applied_at FROM schema_migrations"):
+			res := &fakeResult{fields: []Field{{Name: "version", Oid: Int8Oid}, {Name: "name", Oid: TextOid}, {Name: "applied_at", Oid: TimestamptzOid}}}
+			for v, a := range state {
+				n, _ := strconv.Atoi(v)
+				res.rows = append(res.rows, []any{n, a.name, a.at})
+			}
+			res.tag = "SELECT " + strconv.Itoa(len(res.rows))
+			return res
+		case sql == "BEGIN":
+			tx = map[string]*record{}
+		case sql == "COMMIT":
+			for v, a := range tx {
+				if a == nil {
+					delete(state, v)
+				} else {
+					state[v] = *a
+				}
+			}
+			tx = nil
+		case sql == "ROLLBACK":
+			tx = nil
+		case strings.HasPrefix(sql, "INSERT INTO schema_migrations"):
+			if args != nil {
+				tx[args[0].(string)] = &record{args[1].(string), time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)}
+			}
+			return &fakeResult{tag: "INSERT 0 1"}
+		case strings.HasPrefix(sql, "DELETE FROM schema_migrations"):
+			if args != nil {
+				tx[args[0].(string)] = nil
+			}
+			return &fakeResult{tag: "DELETE 1"}
+		case strings.HasPrefix(sql, "BAD"):
+			return &fakeResult{err: &Error{Code: "42601", Message: "syntax error at or near \"BAD\""}}
+		}
+		return &fakeResult{tag: strings.ToUpper(strings.SplitN(sql, " ", 2)[0])}
+	})
+	c, err := Connect(ctx, s.url("bob", "secret"))
+	if !gt.NoError(err, "Connect") {
+		return
+	}
+	defer c.Close()
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"1_create_users.up.sql":     "CREATE TABLE users (id int);\nCREATE INDEX users_id ON users (id);",
+		"1_create_users.down.sql":   "DROP TABLE users;",
+		"2_add_email.up.sql":        "ALTER TABLE users ADD email text;",
+		"2_add_email.down.sql":      "ALTER TABLE users DROP email;",
+		"3_bad.up.sql":              "BAD SQL;",
+		"README.md":                 "not a migration",
+		"10_ignored_backup.sql.bak": "",
+	}
+	for f, sql := range files {
+		os.WriteFile(dir+"/"+f, []byte(sql), 0644)
+	}
+	m := NewMigrator(dir)
+	migrations, err := m.Migrations()
+	gt.NoError(err, "Migrations")
+	gt.Equal(3, len(migrations), "Migrations len")
+	gt.Equal(int64(2), migrations[1].Version, "Migrations order")
+
+	applied, err := m.Up(ctx, c, 2)
+	gt.NoError(err, "Up steps")
+	gt.Equal(2, len(applied), "Up steps applied")
+	gt.Equal(2, len(state), "Up steps state")
+	gt.Equal(0, locks, "Up unlocked")
+	gt.True(strings.Contains(strings.Join(sqls, "\n"), "BEGIN\nCREATE TABLE users (id int)\nCREATE INDEX users_id ON users (id)"), "Up transaction")
+
+	applied, err = m.Up(ctx, c, 0)
+	gt.Error(err, "Up failure")
+	gt.Equal(0, len(applied), "Up failure applied")
+	gt.Equal(2, len(state), "Up failure rolled back")
+	gt.Equal("ROLLBACK", sqls[len(sqls)-2], "Up failure rollback")
+	gt.Equal(0, locks, "Up failure unlocked")
+
+	status, err := m.Status(ctx, c)
+	gt.NoError(err, "Status")
+	gt.Equal(3, len(status), "Status len")
+	gt.True(status[0].Applied && status[1].Applied && !status[2].Applied, "Status applied")
+
+	reverted, err := m.Down(ctx, c, 0)
+	gt.NoError(err, "Down")
+	gt.Equal(1, len(reverted), "Down reverted")
+	gt.Equal(int64(2), reverted[0].Version, "Down last")
+	_, ok := state["2"]
+	gt.False(ok, "Down state")
+
+	os.Remove(dir + "/3_bad.up.sql")
+	mg, err := m.Create("Add Posts")
+	gt.NoError(err, "Create")
+	gt.Equal("add_posts", mg.Name, "Create name")
+	gt.True(path.Exists(dir+"/"+mg.file("up")) && path.Exists(dir+"/"+mg.file("down")), "Create files")
+	mg2, _ := m.Create("add_tags")
+	gt.True(mg2.Version > mg.Version, "Create version")
+	_, err = m.Create("bad-name")
+	gt.Error(err, "Create invalid")
+	os.WriteFile(dir+"/3_other.up.sql", []byte("SELECT 1"), 0644)
+	os.WriteFile(dir+"/3_dup.up.sql", []byte("SELECT 1"), 0644)
+	_, err = m.Migrations()
+	gt.Error(err, "Migrations duplicate")
+	os.Remove(dir + "/3_other.up.sql")
+	os.Remove(dir + "/3_dup.up.sql")
+
+	// commands
+	out, _ := os.CreateTemp(dir, "stdout")
+	stdout := cli.Stdout
+	cli.Stdout = out
+	defer func() { cli.Stdout = stdout }()
+	cmd := MigrateCommand(s.url("bob", "secret"), dir)
+	run := func(name string, flags ...string) error {
+		sub, _ := cmd.GetCommand(name)
+		for i := 0; i < len(flags); i += 2 {
+			sub.Flags().Get(flags[i]).SetValue(flags[i+1])
+		}
+		return sub.Run(sub, sub.Flags())
+	}
+	gt.NoError(run("up"), "Command up")
+	gt.NoError(run("status"), "Command status")
+	gt.NoError(run("down", "steps", "2"), "Command down")
+	gt.NoError(run("create", "name", "drop_users"), "Command create")
+	b, _ := os.ReadFile(out.Name())
+	gt.True(strings.Contains(string(b), "applied 2_add_email.up.sql"), "Command up output")
+	gt.True(strings.Contains(string(b), "1 create_users applied 2023-06-01 12:00:00"), "Command status output")
+	gt.True(strings.Contains(string(b), "rolled back "+strconv.FormatInt(mg2.Version, 10)+"_add_tags.down.sql"), "Command down output")
+	gt.True(strings.Contains(string(b), "_drop_users.up.sql"), "Command create output")
+}
+
+func TestBuilder(t *testing.T) {
+	gt := test.New(t)
+	gt.Msg = "Builder.%s"
+	sql, args, err := Select("u.id", "u.name").Distinct().
+		From("users u").
+		LeftJoin("orders o", "o.user_id = u.id AND o.status = ?", "paid").
+		Where(Eq("u.active", true), Or(Gt("u.age", 21), IsNull("u.age")), Not(In("u.role", []string{"bot", "test"}))).
+		Where(In("u.team_id", Select("id").From("teams").Where(Eq("name", "core")))).
+		GroupBy("u.id", "u.name").
+		Having(Raw("count(o.id) > ?", 2)).
+		OrderBy("u.name", "u.id DESC").
+		Limit(10).Offset(20).
+		Build()
+	gt.NoError(err, "Select")
+	gt.Equal("SELECT DISTINCT u.id, u.name FROM users u LEFT JOIN orders o ON o.user_id = u.id AND o.status = $1"+
+		" WHERE u.active = $2 AND (u.age > $3 OR (u.age IS NULL)) AND NOT (u.role IN ($4, $5)) AND u.team_id IN (SELECT id FROM teams WHERE name = $6)"+
+		" GROUP BY u.id, u.name HAVING count(o.id) > $7 ORDER BY u.name, u.id DESC LIMIT $8 OFFSET $9", sql, "Select sql")
+	gt.Equal([]any{"paid", true, 21, "bot", "test", "core", 2, 10, 20}, args, "Select args")
+	sql, _, _ = Select().From("users").Where(In("id"), Eq("deleted_at", nil), Raw("note = '?'")).Build()
+	gt.Equal("SELECT * FROM users WHERE FALSE AND (deleted_at IS NULL) AND (note = '?')", sql, "Select empty")
+	sql, _, _ = Select().From("users").Where(Eq("active", true), Raw("role = ? OR role = ?", "admin", "owner")).Build()
+	gt.Equal("SELECT * FROM users WHERE active = $1 AND (role = $2 OR role = $3)", sql, "Select raw in group")
+	sql, args, _ = Select().From("users").Where(Raw("tags ?? ? AND note <> '??'", "admin")).Build()
+	gt.Equal("SELECT * FROM users WHERE tags ? $1 AND note <> '??'", sql, "Select raw escape")
+	gt.Equal([]any{"admin"}, args, "Select raw escape args")
+	_, _, err = Select().From("users").Where(Raw("a = ? AND b = ?", 1)).Build()
+	gt.Error(err, "Select raw args")
+
+	type Audit struct {
+		UpdatedBy string
+	}
+	type User struct {
+		Id        int    `db:"id,omitempty"`
+		Name      string `db:"name"`
+		Email     string
+		CreatedAt time.Time `db:"created_at,omitempty"`
+		Secret    string    `db:"-"`
+		Audit
+		private string
+	}
+	ts := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	sql, args, err = Insert("users").Struct(&User{Name: "ann", Email: "ann@mail.com", Audit: Audit{"admin"}}).
+		OnConflict("email").DoUpdate().
+		Returning("id").
+		Build()
+	gt.NoError(err, "Insert")
+	gt.Equal("INSERT INTO users (name, email, updated_by) VALUES ($1, $2, $3) ON CONFLICT (email) DO UPDATE SET name = EXCLUDED.name, updated_by = EXCLUDED.updated_by RETURNING id", sql, "Insert sql")
+	gt.Equal([]any{"ann", "ann@mail.com", "admin"}, args, "Insert args")
+	sql, args, err = Insert("users").Struct([]User{{Name: "ann"}, {Id: 7, Name: "bo", CreatedAt: ts}}).OnConflict().DoNothing().Build()
+	gt.NoError(err, "Insert rows")
+	gt.Equal("INSERT INTO users (name, email, updated_by, id, created_at) VALUES ($1, $2, $3, DEFAULT, DEFAULT), ($4, $5, $6, $7, $8) ON CONFLICT DO NOTHING", sql, "Insert rows sql")
+	gt.Equal([]any{"ann", "", "", "bo", "", "", 7, ts}, args, "Insert rows args")
+	sql, _, _ = Insert("logs").Columns("msg", "at").Values("hi", Raw("now()")).Build()
+	gt.Equal("INSERT INTO logs (msg, at) VALUES ($1, now())", sql, "Insert values")
+	sql, _, _ = Insert("archive").Columns("id").Select(Select("id").From("users").Where(Lt("created_at", ts))).Build()
+	gt.Equal("INSERT INTO archive (id) SELECT id FROM users WHERE created_at < $1", sql, "Insert select")
+	_, _, err = Insert("users").Struct(1).Build()
+	gt.Error(err, "Insert non struct")
+	_, _, err = Insert("logs").Columns("msg", "at").Values("hi").Build()
+	gt.Error(err, "Insert values len")
+	sql, _, _ = Insert("users").Struct(User{}).Build()
+	gt.Equal("INSERT INTO users (name, email, updated_by) VALUES ($1, $2, $3)", sql, "Insert zero")
+	sql, _, _ = Insert("events").Struct(struct {
+		Id int `db:"id,omitempty"`
+	}{}).Build()
+	gt.Equal("INSERT INTO events DEFAULT VALUES", sql, "Insert default values")
+	_, _, err = Insert("users").Struct(User{}).DoUpdate().Build()
+	gt.Error(err, "Insert conflict target")
+
+	sql, args, err = Update("users").Struct(User{Id: 1, Name: "ann", Email: "a@b.c"}, "name", "email").
+		Set("version", Raw("version + ?", 1)).
+		Where(Eq("id", 1)).
+		Returning("version").
+		Build()
+	gt.NoError(err, "Update")
+	gt.Equal("UPDATE users SET name = $1, email = $2, version = version + $3 WHERE id = $4 RETURNING version", sql, "Update sql")
+	gt.Equal([]any{"ann", "a@b.c", 1, 1}, args, "Update args")
+	_, _, err = Update("users").Build()
+	gt.Error(err, "Update no sets")
+
+	sql, args, err = Delete("users").Where(Between("age", 1, 5), Like("name", "a%")).Returning("id").Build()
+	gt.NoError(err, "Delete")
+	gt.Equal("DELETE FROM users WHERE (age BETWEEN $1 AND $2) AND name LIKE $3 RETURNING id", sql, "Delete sql")
+	gt.Equal([]any{1, 5, "a%"}, args, "Delete args")
+	gt.Equal("user_id", snakeCase("UserID"), "snakeCase initialism")
+	gt.Equal("http_server", snakeCase("HTTPServer"), "snakeCase acronym")
+
+	var got []any
+	var mu sync.Mutex
+	s := newFakeServer(t, "trust", func(sql string, args []any) *fakeResult {
+		mu.Lock()
+		defer mu.Unlock()
+		if args != nil {
+			got = args
+		}
+		if strings.HasPrefix(sql, "SELECT") {
+			return &fakeResult{fields: []Field{{Name: "name", Oid: TextOid}}, rows: [][]any{{"ann"}}, tag: "SELECT 1"}
+		}
+		return &fakeResult{tag: "DELETE 3"}
+	})
+	ctx := context.Background()
+	c, err := Connect(ctx, s.url("bob", "secret"))
+	if !gt.NoError(err, "Connect") {
+		return
+	}
+	defer c.Close()
+	tag, err := Delete("users").Where(Eq("team", "core")).Exec(ctx, c)
+	gt.NoError(err, "Exec")
+	gt.Equal(int64(3), tag.RowsAffected(), "Exec tag")
+	rows, err := Select("name").From("users").Where(Eq("id", 1)).Query(ctx, c)
+	gt.NoError(err, "Query")
+	rows.Close()
+	mu.Lock()
+	gt.Equal([]any{"1"}, got, "Query args")
+	mu.Unlock()
+}
+
+func TestNotify(t *testing.T) {
+	gt := test.New(t)
+	gt.Msg = "Notify.%s"
+	ctx := context.Background()
+	var mu sync.Mutex
+	var listens []string
+	var s *fakeServer
+	s = newFakeServer(t, "trust", func(sql string, args []any) *fakeResult {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case strings.HasPrefix(sql, "LISTEN"), strings.HasPrefix(sql, "UNLISTEN"):
+			listens = append(listens, sql)
+			return &fakeResult{tag: strings.Fields(sql)[0]}
+		case strings.HasPrefix(sql, "SELECT pg_notify"):
+			if args != nil {
+				go s.notify(args[0].(string), args[1].(string))
+			}
+			return &fakeResult{fields: []Field{{Name: "pg_notify", Oid: TextOid}}, rows: [][]any{{""}}, tag: "SELECT 1"}
+		}
+		return &fakeResult{tag: "SELECT 0"}
+	})
+	c, err := Connect(ctx, s.url("bob", "secret"))
+	if !gt.NoError(err, "Connect") {
+		return
+	}
+	defer c.Close()
+	gt.NoError(c.Listen(ctx, `jobs"x`), "Listen")
+	gt.Equal(`LISTEN "jobs""x"`, listens[0], "Listen quoted")
+	gt.NoError(c.Notify(ctx, "jobs", "1"), "Notify")
+	n, err := c.WaitForNotification(ctx)
+	gt.NoError(err, "WaitForNotification")
+	gt.True(n != nil && n.Channel == "jobs" && n.Payload == "1" && n.Pid == 99, "WaitForNotification notification")
+	wctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	_, err = c.WaitForNotification(wctx)
+	cancel()
+	st, ok := err.(*errors.Status)
+	gt.True(ok && st.Code() == errors.DEADLINE, "WaitForNotification deadline")
+	gt.NoError(c.Ping(ctx), "usable after wait deadline")
+	// notifications received during queries are queued
+	s.notify("jobs", "2")
+	time.Sleep(10 * time.Millisecond)
+	gt.NoError(c.Ping(ctx), "Ping with notification")
+	n, err = c.WaitForNotification(ctx)
+	gt.True(err == nil && n.Payload == "2", "queued notification")
+	// the oldest notifications are dropped when the queue is full
+	for i := 0; i <= maxNotifications; i++ {
+		w := writer{}
+		w.int32(99)
+		w.string("jobs")
+		w.string(strconv.Itoa(i))
+		c.async('A', &message{b: w.b})
+	}
+	gt.Equal(maxNotifications, len(c.notifications), "queue max")
+	gt.Equal("1", c.notifications[0].Payload, "queue oldest dropped")
+	c.notifications = nil
+
+	config, _ := ParseConfig(s.url("bob", "secret"))
+	l := NewListener(config, "jobs")
+	l.MinReconnect = 5 * time.Millisecond
+	reconnects := make(chan struct{}, 1)
+	l.OnReconnect = func() { reconnects <- struct{}{} }
+	defer l.Close()
+	ch := l.Notifications()
+	gt.NoError(l.Listen(ctx, "events"), "Listener.Listen")
+	s.notify("events", "a")
+	select {
+	case n := <-ch:
+		gt.Equal("a", n.Payload, "Listener notification")
+	case <-time.After(time.Second):
+		gt.True(false, "Listener notification timeout")
+	}
+	mu.Lock()
+	listens = nil
+	mu.Unlock()
+	s.drop()
+	select {
+	case <-reconnects:
+	case <-time.After(time.Second):
+		gt.True(false, "Listener reconnect timeout")
+	}
+	mu.Lock()
+	gt.Equal(2, len(listens), "Listener listens again")
+	mu.Unlock()
+	gt.NoError(l.Unlisten(ctx, "events"), "Listener.Unlisten")
+	s.notify("jobs", "b")
+	select {
+	case n := <-ch:
+		gt.Equal("b", n.Payload, "Listener notification after reconnect")
+	case <-time.After(time.Second):
+		gt.True(false, "Listener notification after reconnect timeout")
+	}
+	l.Close()
+	_, open := <-ch
+	gt.False(open, "Listener closed")
+}
+
+func TestCopy(t *testing.T) {
+	gt := test.New(t)
+	gt.Msg = "Copy.%s"
+	ctx := context.Background()
+	var s *fakeServer
+	s = newFakeServer(t, "trust", func(sql string, args []any) *fakeResult {
+		switch {
+		case strings.HasPrefix(sql, "COPY users FROM STDIN"):
+			return &fakeResult{copy: "in", reject: "bad"}
+		case strings.HasPrefix(sql, "COPY users TO STDOUT"):
+			return &fakeResult{copy: "out", data: []string{"1,ann\n", "2,bo\n", "3,cy\n"}}
+		case strings.HasPrefix(sql, "COPY missing"):
+			return &fakeResult{err: &Error{Code: "42P01", Message: "relation \"missing\" does not exist"}}
+		}
+		return &fakeResult{tag: "SELECT 0"}
+	})
+	c, err := Connect(ctx, s.url("bob", "secret"))
+	if !gt.NoError(err, "Connect") {
+		return
+	}
+	defer c.Close()
+	data := strings.Repeat("1,ann\n", 20000)
+	tag, err := c.CopyFrom(ctx, "COPY users FROM STDIN WITH (FORMAT csv)", strings.NewReader(data))
+	gt.NoError(err, "CopyFrom")
+	gt.Equal(int64(20000), tag.RowsAffected(), "CopyFrom tag")
+	s.mu.Lock()
+	gt.Equal(data, string(s.copied), "CopyFrom data")
+	s.mu.Unlock()
+
+	_, err = c.CopyFrom(ctx, "COPY users FROM STDIN", io.MultiReader(strings.NewReader("1,ann\n"), failReader{}))
+	st, ok := err.(*errors.Status)
+	gt.True(ok && st.Code() == errors.ABORTED, "CopyFrom reader error")
+	gt.NoError(c.Ping(ctx), "usable after aborted copy")
+
+	// the server error is read once the data is sent
+	_, err = c.CopyFrom(ctx, "COPY users FROM STDIN", strings.NewReader(strings.Repeat("bad\n", 50000)))
+	_, ok = err.(*Error)
+	gt.True(ok, "CopyFrom server error")
+	gt.NoError(c.Ping(ctx), "usable after failed copy")
+
+	b := &strings.Builder{}
+	tag, err = c.CopyTo(ctx, "COPY users TO STDOUT WITH (FORMAT csv)", b)
+	gt.NoError(err, "CopyTo")
+	gt.Equal("1,ann\n2,bo\n3,cy\n", b.String(), "CopyTo data")
+	gt.Equal(int64(3), tag.RowsAffected(), "CopyTo tag")
+
+	_, err = c.CopyTo(ctx, "COPY missing TO STDOUT", b)
+	_, ok = err.(*Error)
+	gt.True(ok, "CopyTo server error")
+	_, err = c.CopyTo(ctx, "SELECT 1", b)
+	gt.Error(err, "CopyTo not a copy")
+	_, err = c.CopyTo(ctx, "COPY users FROM STDIN", b)
+	gt.Error(err, "CopyTo wrong direction")
+	gt.NoError(c.Ping(ctx), "usable after copy errors")
+}
+
+// failReader is a reader which fails
+type failReader struct{}
+
+func (failReader) Read([]byte) (int, error) { return 0, io.ErrUnexpectedEOF }
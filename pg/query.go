@@ -0,0 +1,446 @@
+// Copyright 2023 james dotter.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://github.com/jcdotter/go/LICENSE
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pg
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/jcdotter/go/errors"
+)
+
+// ----------------------------------------------------------------------------
+// QUERIES
+// statements without args are run with the simple query
+// protocol, which may run multiple statements separated by
+// a ';'. Queries and statements with args are run with the
+// extended query protocol, where args are bound to the $n
+// placeholders of the statement and the common types are
+// received in the binary format:
+//
+//	rows, err := conn.Query(ctx, "SELECT id, name FROM users WHERE age > $1", 21)
+//	if err != nil {
+//		return err
+//	}
+//	defer rows.Close()
+//	for rows.Next() {
+//		var id int
+//		var name string
+//		if err := rows.Scan(&id, &name); err != nil {
+//			return err
+//		}
+//	}
+//	return rows.Err()
+
+// CommandTag is the tag of a completed statement, such as
+// "INSERT 0 1" or "UPDATE 5"
+type CommandTag string
+
+// RowsAffected returns the number of rows
+// affected by the statement of the tag
+func (t CommandTag) RowsAffected() int64 {
+	s := string(t)
+	n, _ := strconv.ParseInt(s[strings.LastIndexByte(s, ' ')+1:], 10, 64)
+	return n
+}
+
+// Field is the description of a column of a query
+type Field struct {
+	Name string
+	// Table is the oid of the table of the column,
+	// or zero if the column is not of a table
+	Table uint32
+	// Column is the attribute number of the column in
+	// the table, or zero if the column is not of a table
+	Column int
+	// Oid is the oid of the type of the column
+	Oid  uint32
+	Size int
+	Mod  int
+	// Format is the format the column is received in,
+	// which is 0 for text and 1 for binary
+	Format int
+}
+
+// Exec runs the statement provided, returning the command tag
+// of the last statement completed. Statements without args may
+// contain multiple statements separated by a ';'.
+func (c *Conn) Exec(ctx context.Context, sql string, args ...any) (CommandTag, error) {
+	if len(args) > 0 {
+		rows, err := c.Query(ctx, sql, args...)
+		if err != nil {
+			return "", err
+		}
+		rows.Close()
+		return rows.tag, rows.err
+	}
+	stop, err := c.begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	c.w.start('Q')
+	c.w.string(sql)
+	c.w.finish()
+	if err = c.flush(); err != nil {
+		return "", c.end(ctx, stop, err)
+	}
+	var tag CommandTag
+	for err == nil {
+		t, m, e := c.receive()
+		if e != nil {
+			return "", c.end(ctx, stop, e)
+		}
+		switch t {
+		case 'C':
+			tag = CommandTag(m.string())
+		case 'E':
+			return "", c.end(ctx, stop, c.sync(m.error()))
+		case 'Z':
+			return tag, c.end(ctx, stop, nil)
+		case 'T', 'D', 'I':
+		default:
+			err = c.async(t, m)
+		}
+	}
+	return "", c.end(ctx, stop, err)
+}
+
+// Query runs the query provided, returning the rows of the
+// query, which must be closed before the next query is run
+func (c *Conn) Query(ctx context.Context, sql string, args ...any) (*Rows, error) {
+	stop, err := c.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := c.prepare(sql, len(args))
+	if err != nil {
+		return nil, c.end(ctx, stop, err)
+	}
+	if err = c.bind(fields, args); err != nil {
+		return nil, c.end(ctx, stop, err)
+	}
+	c.w.start('E')
+	c.w.string("")
+	c.w.int32(0)
+	c.w.finish()
+	c.w.start('S')
+	c.w.finish()
+	if err = c.flush(); err != nil {
+		return nil, c.end(ctx, stop, err)
+	}
+	return &Rows{ctx: ctx, conn: c, stop: stop, fields: fields}, nil
+}
+
+// QueryRow runs the query provided, returning the first row of the
+// query, where the error of the query is returned by Row.Scan
+func (c *Conn) QueryRow(ctx context.Context, sql string, args ...any) *Row {
+	rows, err := c.Query(ctx, sql, args...)
+	return &Row{rows: rows, err: err}
+}
+
+// begin starts a query, returning a func which stops watching the
+// context, or an error if the connection is closed or in use
+func (c *Conn) begin(ctx context.Context) (func() bool, error) {
+	if c.conn == nil || c.broken {
+		return nil, errors.Unavailable("pg: connection closed")
+	}
+	if c.busy {
+		return nil, errors.Failed("pg: connection busy, rows of the previous query must be closed")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, netError(ctx, err)
+	}
+	c.busy = true
+	return c.watch(ctx), nil
+}
+
+// end ends a query, returning the error of the query or
+// the error of the context when the query was interrupted
+func (c *Conn) end(ctx context.Context, stop func() bool, err error) error {
+	c.busy = false
+	if !stop() && err != nil {
+		return netError(ctx, ctx.Err())
+	}
+	if err != nil {
+		return netError(ctx, err)
+	}
+	return nil
+}
+
+// sync reads messages until the server is ready after an error
+func (c *Conn) sync(e *Error) error {
+	for {
+		t, _, err := c.receive()
+		if err != nil {
+			return err
+		}
+		if t == 'Z' {
+			return e
+		}
+	}
+}
+
+// prepare parses the unnamed statement of the query provided,
+// returning the fields of the rows of the statement
+func (c *Conn) prepare(sql string, args int) ([]Field, error) {
+	c.w.start('P')
+	c.w.string("")
+	c.w.string(sql)
+	c.w.int16(0)
+	c.w.finish()
+	c.w.start('D')
+	c.w.byte('S')
+	c.w.string("")
+	c.w.finish()
+	c.w.start('S')
+	c.w.finish()
+	if err := c.flush(); err != nil {
+		return nil, err
+	}
+	var fields []Field
+	params := -1
+	for {
+		t, m, e := c.receive()
+		if e != nil {
+			return nil, e
+		}
+		switch t {
+		case '1', 'n':
+		case 't':
+			params = m.int16()
+		case 'T':
+			fields = make([]Field, m.int16())
+			for i := range fields {
+				f := &fields[i]
+				f.Name = m.string()
+				f.Table = m.uint32()
+				f.Column = m.int16()
+				f.Oid = m.uint32()
+				f.Size = m.int16()
+				f.Mod = m.int32()
+				m.int16()
+				if binaryOids[f.Oid] {
+					f.Format = 1
+				}
+			}
+		case 'E':
+			return nil, c.sync(m.error())
+		case 'Z':
+			if params >= 0 && params != args {
+				return nil, errors.Invalid("pg: expected " + strconv.Itoa(params) + " args, got " + strconv.Itoa(args))
+			}
+			return fields, nil
+		default:
+			if e = c.async(t, m); e != nil {
+				return nil, e
+			}
+		}
+	}
+}
+
+// bind binds the args provided to the unnamed statement,
+// requesting the fields in the formats provided
+func (c *Conn) bind(fields []Field, args []any) error {
+	vals := make([][]byte, len(args))
+	formats := make([]int, len(args))
+	for i, a := range args {
+		var err error
+		if vals[i], formats[i], err = encode(a); err != nil {
+			return err
+		}
+	}
+	c.w.start('B')
+	c.w.string("")
+	c.w.string("")
+	c.w.int16(len(formats))
+	for _, f := range formats {
+		c.w.int16(f)
+	}
+	c.w.int16(len(vals))
+	for _, v := range vals {
+		if v == nil {
+			c.w.int32(-1)
+			continue
+		}
+		c.w.int32(len(v))
+		c.w.bytes(v)
+	}
+	c.w.int16(len(fields))
+	for _, f := range fields {
+		c.w.int16(f.Format)
+	}
+	c.w.finish()
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+// ROWS
+
+// Rows are the rows of a query
+type Rows struct {
+	ctx    context.Context
+	conn   *Conn
+	stop   func() bool
+	fields []Field
+	values []any
+	tag    CommandTag
+	err    error
+	done   bool
+	// release releases the connection of a pool
+	// once the rows are done
+	release func()
+}
+
+// Next reads the next row, returning false when there
+// are no more rows or an error occurred, after which
+// the rows are closed and Err returns the error
+func (r *Rows) Next() bool {
+	if r.done {
+		return false
+	}
+	c := r.conn
+	for {
+		t, m, err := c.receive()
+		if err != nil {
+			r.finish(err)
+			return false
+		}
+		switch t {
+		case 'D':
+			if r.err != nil {
+				continue
+			}
+			if r.values == nil {
+				r.values = make([]any, len(r.fields))
+			}
+			n := m.int16()
+			for i := 0; i < n && i < len(r.fields); i++ {
+				f := r.fields[i]
+				if r.values[i], err = decode(f.Oid, f.Format, m.next(m.int32())); err != nil {
+					r.err = err
+					break
+				}
+			}
+			if r.err == nil {
+				return true
+			}
+		case 'C':
+			r.tag = CommandTag(m.string())
+		case '2', 'I', 's':
+		case 'E':
+			if r.err == nil {
+				r.err = m.error()
+			}
+		case 'Z':
+			r.finish(r.err)
+			return false
+		default:
+			if err = c.async(t, m); err != nil {
+				r.finish(err)
+				return false
+			}
+		}
+	}
+}
+
+// finish ends the query of the rows
+func (r *Rows) finish(err error) {
+	r.done = true
+	r.err = r.conn.end(r.ctx, r.stop, err)
+	if r.release != nil {
+		r.release()
+	}
+}
+
+// Values returns the values of the current row,
+// which are valid until the next row is read
+func (r *Rows) Values() []any {
+	return r.values
+}
+
+// Scan sets the values of the current row to the pointers
+// provided. See assign for the conversions supported.
+func (r *Rows) Scan(dst ...any) error {
+	if r.values == nil {
+		return errors.Failed("pg: scan called without a row")
+	}
+	if len(dst) != len(r.values) {
+		return errors.Invalid("pg: expected " + strconv.Itoa(len(r.values)) + " scan destinations, got " + strconv.Itoa(len(dst)))
+	}
+	for i, d := range dst {
+		if err := assign(d, r.values[i]); err != nil {
+			return errors.Invalid("pg: column '" + r.fields[i].Name + "': " + err.Error())
+		}
+	}
+	return nil
+}
+
+// Fields returns the descriptions of the columns of the rows
+func (r *Rows) Fields() []Field {
+	return r.fields
+}
+
+// Columns returns the names of the columns of the rows
+func (r *Rows) Columns() []string {
+	cols := make([]string, len(r.fields))
+	for i, f := range r.fields {
+		cols[i] = f.Name
+	}
+	return cols
+}
+
+// Tag returns the command tag of the query,
+// which is set once the rows are closed
+func (r *Rows) Tag() CommandTag {
+	return r.tag
+}
+
+// Err returns the error of the query, if any
+func (r *Rows) Err() error {
+	return r.err
+}
+
+// Close reads the remaining rows, releasing the connection
+func (r *Rows) Close() error {
+	for r.Next() {
+	}
+	return r.err
+}
+
+// Row is the first row of a query
+type Row struct {
+	rows *Rows
+	err  error
+}
+
+// Scan sets the values of the row to the pointers provided,
+// returning an errors.NotFound status if there are no rows
+func (r *Row) Scan(dst ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+	defer r.rows.Close()
+	if !r.rows.Next() {
+		if err := r.rows.Err(); err != nil {
+			return err
+		}
+		return errors.NotFound("pg: no rows")
+	}
+	if err := r.rows.Scan(dst...); err != nil {
+		return err
+	}
+	return r.rows.Close()
+}
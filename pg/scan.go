@@ -0,0 +1,254 @@
+// Copyright 2023 james dotter.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://github.com/jcdotter/go/LICENSE
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pg
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/jcdotter/go/errors"
+	"github.com/jcdotter/go/typ"
+)
+
+// ----------------------------------------------------------------------------
+// STRUCT SCANNING
+// scans the columns of rows into the fields of structs, where
+// a column maps to the field with its name in the db tag of the
+// field, or to the field with its name when untagged, ignoring
+// case and underscores. Column values are converted to the types
+// of their fields and copied to the struct with typ.Map.Scan.
+// Exported embedded structs are mapped as fields of the struct,
+// fields tagged db:"-" are ignored, and pointer fields are nil
+// when the column is null:
+//
+//	type User struct {
+//		Id      uuid.UUID `db:"id"`
+//		Name    string
+//		Email   *string   // null emails are nil
+//		Created time.Time `db:"created_at"`
+//		Audit             // fields of Audit are columns of User
+//	}
+//
+//	user, err := pg.QueryStruct[User](ctx, pool, "SELECT * FROM users WHERE id = $1", id)
+//	users, err := pg.QuerySlice[*User](ctx, pool, "SELECT * FROM users")
+
+// Querier runs queries, such as a Conn, PoolConn or Pool
+type Querier interface {
+	Query(ctx context.Context, sql string, args ...any) (*Rows, error)
+}
+
+// QueryStruct returns the first row of the query as a T, which
+// may be a struct, a pointer to a struct, a map[string]any or a
+// single column value. Returns an errors.NotFound status if the
+// query has no rows.
+func QueryStruct[T any](ctx context.Context, q Querier, sql string, args ...any) (v T, err error) {
+	rows, err := q.Query(ctx, sql, args...)
+	if err != nil {
+		return v, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return v, err
+		}
+		return v, errors.NotFound("pg: no rows")
+	}
+	if err = rows.scanInto(&v); err != nil {
+		return v, err
+	}
+	return v, rows.Close()
+}
+
+// QuerySlice returns the rows of the query as a slice of T. See QueryStruct.
+func QuerySlice[T any](ctx context.Context, q Querier, sql string, args ...any) ([]T, error) {
+	rows, err := q.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	s := []T{}
+	for rows.Next() {
+		var v T
+		if err = rows.scanInto(&v); err != nil {
+			return nil, err
+		}
+		s = append(s, v)
+	}
+	return s, rows.Err()
+}
+
+// QueryMap returns the first row of the query as a map of
+// the column values. Returns an errors.NotFound status if
+// the query has no rows.
+func QueryMap(ctx context.Context, q Querier, sql string, args ...any) (map[string]any, error) {
+	return QueryStruct[map[string]any](ctx, q, sql, args...)
+}
+
+// Map returns the current row as a map of the column values
+func (r *Rows) Map() map[string]any {
+	m := make(map[string]any, len(r.fields))
+	for i, f := range r.fields {
+		m[f.Name] = r.values[i]
+	}
+	return m
+}
+
+// ScanStruct sets the values of the current row to the fields of
+// the struct pointer provided, returning an errors.Invalid status
+// if a column does not map to a field of the struct
+func (r *Rows) ScanStruct(dst any) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return errors.Invalid("pg: scan destination must be a pointer to a struct")
+	}
+	if r.values == nil {
+		return errors.Failed("pg: scan called without a row")
+	}
+	v = v.Elem()
+	s := structFields(v.Type())
+	vals := make([]map[string]any, len(s.owners))
+	for i, f := range r.fields {
+		c, ok := s.cols[columnKey(f.Name)]
+		if !ok {
+			return errors.Invalid("pg: column '" + f.Name + "' has no field in " + v.Type().String())
+		}
+		fv := reflect.New(c.typ).Elem()
+		if err := assignValue(fv, r.values[i]); err != nil {
+			return errors.Invalid("pg: column '" + f.Name + "': " + err.Error())
+		}
+		if vals[c.owner] == nil {
+			vals[c.owner] = map[string]any{}
+		}
+		vals[c.owner][c.name] = fv.Interface()
+	}
+	for i, m := range vals {
+		if m != nil {
+			typ.MapOf(m).Scan(structByIndex(v, s.owners[i]).Addr().Interface())
+		}
+	}
+	return nil
+}
+
+// scanInto scans the current row into the pointer provided
+// according to the kind of value it points to
+func (r *Rows) scanInto(dst any) error {
+	v := reflect.ValueOf(dst).Elem()
+	t := v.Type()
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == reflect.TypeOf(map[string]any(nil)):
+		v.Set(reflect.ValueOf(r.Map()))
+		return nil
+	case isStruct(t):
+		if v.Kind() == reflect.Pointer {
+			v.Set(reflect.New(t))
+			return r.ScanStruct(v.Interface())
+		}
+		return r.ScanStruct(dst)
+	}
+	return r.Scan(dst)
+}
+
+// structFields caches the columns of struct types
+var structFieldsCache sync.Map
+
+// structColumn is the field of a column by its name in
+// the struct declaring it, its owner, and its type
+type structColumn struct {
+	owner int
+	name  string
+	typ   reflect.Type
+}
+
+// structScan is the columns of a struct type by their keys
+// and the index paths of the owners of their fields, where
+// the struct itself is the first owner
+type structScan struct {
+	owners [][]int
+	cols   map[string]structColumn
+}
+
+// structFields returns the columns of the struct type provided
+// by the key of the column of each field, where the exported
+// fields of embedded structs are mapped unless shadowed by a
+// field of the struct
+func structFields(t reflect.Type) *structScan {
+	if s, ok := structFieldsCache.Load(t); ok {
+		return s.(*structScan)
+	}
+	s := &structScan{cols: map[string]structColumn{}}
+	depths := map[string]int{}
+	var walk func(t reflect.Type, index []int)
+	walk = func(t reflect.Type, index []int) {
+		owner := len(s.owners)
+		s.owners = append(s.owners, index)
+		typ.FromReflectType(t).ForFields(func(i int, f *typ.FieldType) (brake bool) {
+			sf := t.Field(i)
+			name, _, _ := strings.Cut(reflect.StructTag(f.Tag()).Get("db"), ",")
+			if name == "-" || !sf.IsExported() {
+				return
+			}
+			ft := sf.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if sf.Anonymous && name == "" && isStruct(ft) {
+				walk(ft, append(append([]int{}, index...), i))
+				return
+			}
+			if name == "" {
+				name = f.Name()
+			}
+			k := columnKey(name)
+			if d, ok := depths[k]; !ok || len(index) < d {
+				s.cols[k], depths[k] = structColumn{owner, sf.Name, sf.Type}, len(index)
+			}
+			return
+		})
+	}
+	walk(t, nil)
+	structFieldsCache.Store(t, s)
+	return s
+}
+
+// isStruct returns true if the type is a struct other
+// than a time.Time, which is scanned as a column value
+func isStruct(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && typ.FromReflectType(t).KindX() != typ.TIME
+}
+
+// columnKey returns the key of a column or field name,
+// ignoring case and underscores
+func columnKey(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, "_", ""))
+}
+
+// structByIndex returns the struct of the index path provided,
+// allocating nil pointers to embedded structs along the path
+func structByIndex(v reflect.Value, path []int) reflect.Value {
+	for _, x := range path {
+		if v = v.Field(x); v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+	}
+	return v
+}
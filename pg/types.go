@@ -0,0 +1,364 @@
+// Copyright 2023 james dotter.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://github.com/jcdotter/go/LICENSE
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pg
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jcdotter/go/encoder"
+	"github.com/jcdotter/go/errors"
+	"github.com/jcdotter/go/uuid"
+)
+
+// ----------------------------------------------------------------------------
+// TYPES
+// the oids of the common postgres types, which are decoded
+// from the text or binary format of the server as:
+//
+//	bool                         bool
+//	bytea                        []byte
+//	int2, int4, int8, oid        int64
+//	float4, float8               float64
+//	date, timestamp, timestamptz time.Time
+//	uuid                         uuid.UUID
+//	other types                  string
+//
+// Timestamps without a time zone are decoded in UTC.
+
+const (
+	BoolOid        = 16
+	ByteaOid       = 17
+	NameOid        = 19
+	Int8Oid        = 20
+	Int2Oid        = 21
+	Int4Oid        = 23
+	TextOid        = 25
+	OidOid         = 26
+	JsonOid        = 114
+	Float4Oid      = 700
+	Float8Oid      = 701
+	BpcharOid      = 1042
+	VarcharOid     = 1043
+	DateOid        = 1082
+	TimeOid        = 1083
+	TimestampOid   = 1114
+	TimestamptzOid = 1184
+	NumericOid     = 1700
+	UuidOid        = 2950
+	JsonbOid       = 3802
+)
+
+// binaryOids are the types requested in the binary format
+var binaryOids = map[uint32]bool{
+	BoolOid:        true,
+	ByteaOid:       true,
+	Int8Oid:        true,
+	Int2Oid:        true,
+	Int4Oid:        true,
+	OidOid:         true,
+	Float4Oid:      true,
+	Float8Oid:      true,
+	DateOid:        true,
+	TimestampOid:   true,
+	TimestamptzOid: true,
+	UuidOid:        true,
+}
+
+// epoch is the epoch of binary dates and timestamps
+var epoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// decode returns the value of a column in the
+// format provided, or nil if the column is null
+func decode(oid uint32, format int, b []byte) (any, error) {
+	if b == nil {
+		return nil, nil
+	}
+	var v any
+	var ok bool
+	if format == 1 {
+		v, ok = decodeBinary(oid, b)
+	} else {
+		v, ok = decodeText(oid, string(b))
+	}
+	if !ok {
+		return nil, errors.Invalid("pg: invalid value of type oid " + strconv.Itoa(int(oid)))
+	}
+	return v, nil
+}
+
+// binarySize is the size of the fixed size binary types
+var binarySize = map[uint32]int{
+	BoolOid:        1,
+	Int2Oid:        2,
+	Int4Oid:        4,
+	OidOid:         4,
+	Int8Oid:        8,
+	Float4Oid:      4,
+	Float8Oid:      8,
+	DateOid:        4,
+	TimestampOid:   8,
+	TimestamptzOid: 8,
+	UuidOid:        16,
+}
+
+func decodeBinary(oid uint32, b []byte) (any, bool) {
+	if n, ok := binarySize[oid]; ok && len(b) != n {
+		return nil, false
+	}
+	switch oid {
+	case BoolOid:
+		return b[0] == 1, true
+	case ByteaOid:
+		return append([]byte{}, b...), true
+	case Int2Oid:
+		return int64(int16(binary.BigEndian.Uint16(b))), true
+	case Int4Oid:
+		return int64(int32(binary.BigEndian.Uint32(b))), true
+	case OidOid:
+		return int64(binary.BigEndian.Uint32(b)), true
+	case Int8Oid:
+		return int64(binary.BigEndian.Uint64(b)), true
+	case Float4Oid:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), true
+	case Float8Oid:
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), true
+	case DateOid:
+		return epoch.AddDate(0, 0, int(int32(binary.BigEndian.Uint32(b)))), true
+	case TimestampOid, TimestamptzOid:
+		return epoch.Add(time.Duration(int64(binary.BigEndian.Uint64(b))) * time.Microsecond), true
+	case UuidOid:
+		return uuid.Parse(b), true
+	}
+	return string(b), true
+}
+
+func decodeText(oid uint32, s string) (any, bool) {
+	switch oid {
+	case BoolOid:
+		return s == "t", s == "t" || s == "f"
+	case ByteaOid:
+		if !strings.HasPrefix(s, `\x`) {
+			return []byte(s), true
+		}
+		b, err := hex.DecodeString(s[2:])
+		return b, err == nil
+	case Int2Oid, Int4Oid, Int8Oid, OidOid:
+		n, err := strconv.ParseInt(s, 10, 64)
+		return n, err == nil
+	case Float4Oid, Float8Oid:
+		f, err := strconv.ParseFloat(s, 64)
+		return f, err == nil
+	case DateOid:
+		t, err := time.Parse("2006-01-02", s)
+		return t, err == nil
+	case TimestampOid:
+		t, err := time.Parse("2006-01-02 15:04:05.999999999", s)
+		return t, err == nil
+	case TimestamptzOid:
+		for _, l := range []string{"-07", "-07:00", "-07:00:00"} {
+			if t, err := time.Parse("2006-01-02 15:04:05.999999999"+l, s); err == nil {
+				return t, true
+			}
+		}
+		return nil, false
+	case UuidOid:
+		u, ok := parseUUID(s)
+		return u, ok
+	}
+	return s, true
+}
+
+// parseUUID parses the hex uuid provided, with or without dashes
+func parseUUID(s string) (u uuid.UUID, ok bool) {
+	b, err := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+	if err != nil || len(b) != 16 {
+		return u, false
+	}
+	copy(u[:], b)
+	return u, true
+}
+
+// encode returns the value of a param and its format,
+// where []byte is sent in the binary format and other
+// values in the text format. Nil values are sent as null,
+// and maps, slices and structs are sent as json.
+func encode(v any) ([]byte, int, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, 0, nil
+	case []byte:
+		if v == nil {
+			return nil, 1, nil
+		}
+		return v, 1, nil
+	case string:
+		return []byte(v), 0, nil
+	case bool:
+		if v {
+			return []byte("t"), 0, nil
+		}
+		return []byte("f"), 0, nil
+	case time.Time:
+		return []byte(v.Format("2006-01-02 15:04:05.999999999Z07:00")), 0, nil
+	case uuid.UUID:
+		return []byte(v.String()), 0, nil
+	}
+	r := reflect.ValueOf(v)
+	switch r.Kind() {
+	case reflect.Pointer:
+		if r.IsNil() {
+			return nil, 0, nil
+		}
+		return encode(r.Elem().Interface())
+	case reflect.String:
+		return []byte(r.String()), 0, nil
+	case reflect.Bool:
+		return encode(r.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.AppendInt(nil, r.Int(), 10), 0, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.AppendUint(nil, r.Uint(), 10), 0, nil
+	case reflect.Float32:
+		return strconv.AppendFloat(nil, r.Float(), 'g', -1, 32), 0, nil
+	case reflect.Float64:
+		return strconv.AppendFloat(nil, r.Float(), 'g', -1, 64), 0, nil
+	case reflect.Map, reflect.Slice, reflect.Array, reflect.Struct:
+		if (r.Kind() == reflect.Map || r.Kind() == reflect.Slice) && r.IsNil() {
+			return nil, 0, nil
+		}
+		return encoder.Json.New().Encode(v).Bytes(), 0, nil
+	}
+	return nil, 0, errors.Invalid("pg: unsupported param type " + r.Type().String())
+}
+
+// assign sets the value of a column to the pointer provided,
+// converting between numeric types, strings and bytes, uuids
+// and strings, where null sets the pointer to its zero value
+func assign(dst, v any) error {
+	switch d := dst.(type) {
+	case *any:
+		*d = v
+		return nil
+	case nil:
+		return nil
+	}
+	p := reflect.ValueOf(dst)
+	if p.Kind() != reflect.Pointer || p.IsNil() {
+		return errors.Invalid("pg: scan destination must be a non-nil pointer")
+	}
+	return assignValue(p.Elem(), v)
+}
+
+func assignValue(d reflect.Value, v any) error {
+	if v == nil {
+		d.SetZero()
+		return nil
+	}
+	if d.Kind() == reflect.Pointer {
+		if d.IsNil() {
+			d.Set(reflect.New(d.Type().Elem()))
+		}
+		return assignValue(d.Elem(), v)
+	}
+	r := reflect.ValueOf(v)
+	if r.Type().AssignableTo(d.Type()) {
+		d.Set(r)
+		return nil
+	}
+	switch d.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		switch r.Kind() {
+		case reflect.Int64:
+			if d.OverflowInt(r.Int()) {
+				break
+			}
+			d.SetInt(r.Int())
+			return nil
+		case reflect.String:
+			n, err := strconv.ParseInt(r.String(), 10, 64)
+			if err == nil && !d.OverflowInt(n) {
+				d.SetInt(n)
+				return nil
+			}
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if r.Kind() == reflect.Int64 && r.Int() >= 0 && !d.OverflowUint(uint64(r.Int())) {
+			d.SetUint(uint64(r.Int()))
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		switch r.Kind() {
+		case reflect.Int64:
+			d.SetFloat(float64(r.Int()))
+			return nil
+		case reflect.Float64:
+			d.SetFloat(r.Float())
+			return nil
+		case reflect.String:
+			if f, err := strconv.ParseFloat(r.String(), 64); err == nil {
+				d.SetFloat(f)
+				return nil
+			}
+		}
+	case reflect.String:
+		switch v := v.(type) {
+		case []byte:
+			d.SetString(string(v))
+			return nil
+		case uuid.UUID:
+			d.SetString(v.String())
+			return nil
+		case int64, float64, bool:
+			d.SetString(formatValue(v))
+			return nil
+		}
+	case reflect.Slice:
+		if s, ok := v.(string); ok && d.Type().Elem().Kind() == reflect.Uint8 {
+			d.SetBytes([]byte(s))
+			return nil
+		}
+	case reflect.Array:
+		if s, ok := v.(string); ok && d.Type() == reflect.TypeOf(uuid.UUID{}) {
+			if u, ok := parseUUID(s); ok {
+				d.Set(reflect.ValueOf(u))
+				return nil
+			}
+		}
+	}
+	if r.Type().ConvertibleTo(d.Type()) && r.Kind() == d.Kind() {
+		d.Set(r.Convert(d.Type()))
+		return nil
+	}
+	return errors.Invalid("pg: cannot scan " + r.Type().String() + " into " + d.Type().String())
+}
+
+// formatValue returns the string of a scalar value
+func formatValue(v any) string {
+	switch v := v.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return ""
+}
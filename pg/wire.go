@@ -0,0 +1,215 @@
+// Copyright 2023 james dotter.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://github.com/jcdotter/go/LICENSE
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/jcdotter/go/errors"
+)
+
+// ----------------------------------------------------------------------------
+// WIRE PROTOCOL
+// messages of the postgres v3 protocol, which begin with a
+// type byte, except for the startup messages, followed by
+// the int32 length of the message including itself
+
+// maxMessage is the max size of a message received
+const maxMessage = 1 << 30
+
+// writer buffers the messages sent to the server
+type writer struct {
+	b   []byte
+	pos int
+}
+
+// start begins a message of the type provided
+func (w *writer) start(t byte) {
+	w.b = append(w.b, t, 0, 0, 0, 0)
+	w.pos = len(w.b) - 4
+}
+
+// startup begins a startup message, which has no type
+func (w *writer) startup() {
+	w.b = append(w.b, 0, 0, 0, 0)
+	w.pos = len(w.b) - 4
+}
+
+// finish sets the length of the current message
+func (w *writer) finish() {
+	binary.BigEndian.PutUint32(w.b[w.pos:], uint32(len(w.b)-w.pos))
+}
+
+func (w *writer) byte(b byte) {
+	w.b = append(w.b, b)
+}
+
+func (w *writer) int16(n int) {
+	w.b = binary.BigEndian.AppendUint16(w.b, uint16(n))
+}
+
+func (w *writer) int32(n int) {
+	w.b = binary.BigEndian.AppendUint32(w.b, uint32(n))
+}
+
+// string writes a null terminated string
+func (w *writer) string(s string) {
+	w.b = append(append(w.b, s...), 0)
+}
+
+func (w *writer) bytes(b []byte) {
+	w.b = append(w.b, b...)
+}
+
+// flush sends the buffered messages to the server
+func (c *Conn) flush() error {
+	_, err := c.conn.Write(c.w.b)
+	c.w.b = c.w.b[:0]
+	if err != nil {
+		c.broken = true
+	}
+	return err
+}
+
+// receive reads the next message from the server,
+// which is valid until the next message is read
+func (c *Conn) receive() (byte, *message, error) {
+	var h [5]byte
+	if _, err := io.ReadFull(c.br, h[:]); err != nil {
+		c.broken = true
+		return 0, nil, err
+	}
+	n := int(binary.BigEndian.Uint32(h[1:])) - 4
+	if n < 0 || n > maxMessage {
+		c.broken = true
+		return 0, nil, errors.Internal("pg: invalid message length")
+	}
+	if cap(c.buf) < n {
+		c.buf = make([]byte, n)
+	}
+	c.buf = c.buf[:n]
+	if _, err := io.ReadFull(c.br, c.buf); err != nil {
+		c.broken = true
+		return 0, nil, err
+	}
+	return h[0], &message{b: c.buf}, nil
+}
+
+// message reads the fields of a message received,
+// returning zero values once the message is consumed
+type message struct {
+	b []byte
+}
+
+func (m *message) byte() byte {
+	if len(m.b) < 1 {
+		return 0
+	}
+	b := m.b[0]
+	m.b = m.b[1:]
+	return b
+}
+
+func (m *message) int16() int {
+	if len(m.b) < 2 {
+		m.b = nil
+		return 0
+	}
+	n := int(int16(binary.BigEndian.Uint16(m.b)))
+	m.b = m.b[2:]
+	return n
+}
+
+func (m *message) int32() int {
+	return int(int32(m.uint32()))
+}
+
+func (m *message) uint32() uint32 {
+	if len(m.b) < 4 {
+		m.b = nil
+		return 0
+	}
+	n := binary.BigEndian.Uint32(m.b)
+	m.b = m.b[4:]
+	return n
+}
+
+// string reads a null terminated string
+func (m *message) string() string {
+	i := bytes.IndexByte(m.b, 0)
+	if i < 0 {
+		s := string(m.b)
+		m.b = nil
+		return s
+	}
+	s := string(m.b[:i])
+	m.b = m.b[i+1:]
+	return s
+}
+
+// next reads n bytes, or nil if n is negative
+func (m *message) next(n int) []byte {
+	if n < 0 {
+		return nil
+	}
+	if n > len(m.b) {
+		n = len(m.b)
+	}
+	b := m.b[:n:n]
+	m.b = m.b[n:]
+	return b
+}
+
+// error reads the fields of an error or notice response
+func (m *message) error() *Error {
+	e := &Error{}
+	for {
+		f := m.byte()
+		if f == 0 {
+			return e
+		}
+		v := m.string()
+		switch f {
+		case 'V':
+			e.Severity = v
+		case 'S':
+			if e.Severity == "" {
+				e.Severity = v
+			}
+		case 'C':
+			e.Code = v
+		case 'M':
+			e.Message = v
+		case 'D':
+			e.Detail = v
+		case 'H':
+			e.Hint = v
+		case 'P':
+			e.Position = v
+		case 'W':
+			e.Where = v
+		case 's':
+			e.Schema = v
+		case 't':
+			e.Table = v
+		case 'c':
+			e.Column = v
+		case 'n':
+			e.Constraint = v
+		}
+	}
+}
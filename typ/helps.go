@@ -14,7 +14,10 @@
 
 package typ
 
-import "unsafe"
+import (
+	"reflect"
+	"unsafe"
+)
 
 func offset(p unsafe.Pointer, offset uintptr) unsafe.Pointer {
 	return unsafe.Pointer(uintptr(p) + offset)
@@ -43,3 +46,20 @@ func prepScanDest(v Value, dest any) Value {
 	}
 	return d
 }
+
+// setValue sets the value provided to the settable value d,
+// unwrapping interfaces and converting the value to the type
+// of d if not assignable, where a nil value sets d to zero
+func setValue(d, v reflect.Value) {
+	if v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	switch {
+	case !v.IsValid():
+		d.SetZero()
+	case v.Type().AssignableTo(d.Type()):
+		d.Set(v)
+	default:
+		d.Set(v.Convert(d.Type()))
+	}
+}
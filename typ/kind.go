@@ -105,7 +105,8 @@ const (
 	flagIndir       flag = 1 << 7
 	flagAddr        flag = 1 << 8
 
-	tflagUncommon tflag = 1 << 0
+	tflagUncommon    tflag = 1 << 0
+	tflagDirectIface tflag = 1 << 5
 )
 
 type flag uintptr
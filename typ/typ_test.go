@@ -17,6 +17,8 @@ package typ
 import (
 	"fmt"
 	"testing"
+
+	"github.com/jcdotter/go/test"
 )
 
 func TestTest(t *testing.T) {
@@ -28,3 +30,43 @@ func TestTest(t *testing.T) {
 	fmt.Println(ValueOf([]byte{116, 101, 115, 116}).Binary())
 	fmt.Println(ValueOf([]rune{116, 101, 115, 116}).Binary())
 }
+
+func TestTags(t *testing.T) {
+	gt := test.New(t, &test.Config{Trace: true, Detail: true, Require: true, Msg: "Tags.%s"})
+	typ := TypeOf(struct {
+		A string `json:"a,omitempty" yaml:"alpha" db:"col_a"`
+		B string `json:"b"  db:"col_b"`
+		C string `json:"-"`
+		D string
+	}{})
+	a := typ.Field(0)
+	gt.Equal("a,omitempty", a.TagValue("json"), "TagValue.first")
+	gt.Equal("alpha", a.TagValue("yaml"), "TagValue.second")
+	gt.Equal("col_a", a.TagValue("db"), "TagValue.last")
+	gt.Equal("", a.TagValue("toml"), "TagValue.missing")
+	gt.Equal(map[string]string{"json": "a,omitempty", "yaml": "alpha", "db": "col_a"}, a.Tags(), "Tags")
+	b := typ.Field(1)
+	gt.Equal("b", b.TagValue("json"), "TagValue.spaces")
+	gt.Equal("col_b", b.TagValue("db"), "TagValue.spaces.second")
+	gt.Equal(map[string]string{"json": "b", "db": "col_b"}, b.Tags(), "Tags.spaces")
+	gt.Equal("-", typ.Field(2).TagValue("json"), "TagValue.skip")
+	gt.Equal("", typ.Field(3).TagValue("json"), "TagValue.untagged")
+	gt.Equal(0, len(typ.Field(3).Tags()), "Tags.untagged")
+}
+
+func TestMapScan(t *testing.T) {
+	gt := test.New(t, &test.Config{Trace: true, Detail: true, Msg: "MapScan.%s"})
+	type S struct {
+		A string
+		B *int
+		C int64
+		D any
+	}
+	n := 2
+	s := S{D: "x"}
+	MapOf(map[string]any{"A": "a", "B": &n, "C": 3, "D": nil}).Scan(&s)
+	gt.Equal("a", s.A, "interface")
+	gt.True(s.B == &n, "pointer")
+	gt.Equal(int64(3), s.C, "converted")
+	gt.True(s.D == nil, "nil")
+}
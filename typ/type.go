@@ -95,7 +95,7 @@ func (t *Type) PtrType() *Type {
 
 // IfaceIndir returns true if the Type is an indirect value
 func (t *Type) IfaceIndir() bool {
-	return t.kind&KindDirectIface == 0
+	return t.kind&KindDirectIface == 0 && t.tflag&tflagDirectIface == 0
 }
 
 // flag returns the flag of the Type
@@ -679,7 +679,7 @@ func (n name) tags() (tags map[string]string) {
 	var val bool
 	for i := 0; i < l; i++ {
 		if name, i, val = n.parseTagNameAt(t, i); !val {
-			if name == t {
+			if name != "" {
 				tags[name] = "true"
 			}
 			goto next
@@ -692,12 +692,15 @@ func (n name) tags() (tags map[string]string) {
 }
 
 func (n name) parseTagNameAt(tag string, at int) (name string, end int, val bool) {
+	for at < len(tag) && tag[at] == ' ' {
+		at++
+	}
 	for end = at; end < len(tag); end++ {
 		switch tag[end] {
 		case ':':
 			val = true
 			goto out
-		case ',':
+		case ',', ' ':
 			goto out
 		}
 	}
@@ -707,7 +710,10 @@ out:
 }
 
 func (n name) parseTagValueAt(tag string, at int) (value string, end int) {
-	if tag[at] == '"' {
+	if at < len(tag) && tag[at] == ':' {
+		at++
+	}
+	if at < len(tag) && tag[at] == '"' {
 		at++
 		for end = at; end < len(tag); end++ {
 			if tag[end] == '"' && tag[end-1] != '\\' {
@@ -715,7 +721,6 @@ func (n name) parseTagValueAt(tag string, at int) (value string, end int) {
 			}
 		}
 		value = tag[at:end]
-		end++
 		return
 	}
 	return "", at
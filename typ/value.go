@@ -377,7 +377,7 @@ func (m Map) Scan(dest any, tag ...string) {
 				m.ForEach(func(k, v Value) (brake bool) {
 					for i, tag := range tags {
 						if k.String() == tag {
-							d.Field(i).Set(v.Reflect())
+							setValue(d.Field(i), v.Reflect())
 							break
 						}
 					}
@@ -387,7 +387,7 @@ func (m Map) Scan(dest any, tag ...string) {
 			}
 		}
 		m.ForEach(func(k, v Value) (brake bool) {
-			d.FieldByName(k.String()).Set(v.Reflect())
+			setValue(d.FieldByName(k.String()), v.Reflect())
 			return
 		})
 	}